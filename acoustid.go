@@ -0,0 +1,167 @@
+package avsplit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+var acoustIDURL = "https://api.acoustid.org/v2/lookup"
+
+// acoustIDMinScore is the lowest match score trusted to name a track.
+const acoustIDMinScore = 0.8
+
+// fingerprintLength is how much of each track is fingerprinted, enough for
+// AcoustID to match on.
+const fingerprintLength = 120
+
+var acoustIDClient = &http.Client{Timeout: 30 * time.Second, Transport: lookupTransport}
+
+type acoustIDResponse struct {
+	Status string `json:"status"`
+	Error  struct {
+		Message string `json:"message"`
+	} `json:"error"`
+	Results []struct {
+		Score      float64 `json:"score"`
+		Recordings []struct {
+			Title   string `json:"title"`
+			Artists []struct {
+				Name       string `json:"name"`
+				JoinPhrase string `json:"joinphrase"`
+			} `json:"artists"`
+		} `json:"recordings"`
+	} `json:"results"`
+}
+
+// fingerprint extracts the start of a track from the audio file and returns
+// its Chromaprint fingerprint, computed by fpcalc.
+func fingerprint(ctx context.Context, audioFile string, t Track) (string, error) {
+	tmp, err := os.CreateTemp("", "avsplit-*.wav")
+	if err != nil {
+		return "", err
+	}
+	tmp.Close()
+	defer os.Remove(tmp.Name())
+
+	args := []string{"-nostdin", "-y", "-loglevel", "error", "-ss", t.Start}
+	if t.End != "" {
+		args = append(args, "-to", t.End)
+	}
+	args = append(args, "-i", argPath(audioFile), "-vn", "-t", fmt.Sprint(fingerprintLength), tmp.Name())
+	if err := execCommand(ctx, "ffmpeg", args...); err != nil {
+		return "", err
+	}
+
+	out, err := commandOutput(ctx, "fpcalc", "-json", "-length", fmt.Sprint(fingerprintLength), tmp.Name())
+	if err != nil {
+		return "", fmt.Errorf("fpcalc: %v", strings.TrimSpace(err.Error()))
+	}
+
+	var fp struct {
+		Fingerprint string `json:"fingerprint"`
+	}
+	if err := json.Unmarshal([]byte(out), &fp); err != nil || fp.Fingerprint == "" {
+		return "", fmt.Errorf("fpcalc: no fingerprint for track %d", t.Number)
+	}
+	return fp.Fingerprint, nil
+}
+
+// lookupAcoustID returns the best matching recording's artist and title, and
+// its score.
+func lookupAcoustID(ctx context.Context, key, fp string, length time.Duration) (string, string, float64, error) {
+	query := url.Values{}
+	query.Set("client", key)
+	query.Set("meta", "recordings")
+	query.Set("duration", fmt.Sprint(int(length.Seconds())))
+	query.Set("fingerprint", fp)
+
+	req, err := http.NewRequestWithContext(ctx, "POST", acoustIDURL, strings.NewReader(query.Encode()))
+	if err != nil {
+		return "", "", 0, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("User-Agent", musicBrainzUserAgent)
+
+	res, err := acoustIDClient.Do(req)
+	if err != nil {
+		return "", "", 0, fmt.Errorf("acoustid: %v", err)
+	}
+	defer res.Body.Close()
+
+	var r acoustIDResponse
+	if err := json.NewDecoder(res.Body).Decode(&r); err != nil {
+		return "", "", 0, fmt.Errorf("acoustid: %v", res.Status)
+	}
+
+	if r.Status != "ok" {
+		return "", "", 0, fmt.Errorf("acoustid: %v", r.Error.Message)
+	}
+
+	// Results come best first
+	for _, result := range r.Results {
+		for _, rec := range result.Recordings {
+			if rec.Title == "" {
+				continue
+			}
+
+			var artist string
+			for _, a := range rec.Artists {
+				artist += a.Name + a.JoinPhrase
+			}
+			return artist, rec.Title, result.Score, nil
+		}
+	}
+
+	return "", "", 0, nil
+}
+
+// identifyTracks fingerprints each track and names it after its AcoustID
+// match, when the match is confident. Tracks without one keep their title.
+func identifyTracks(ctx context.Context, opts Options, tracks []Track) error {
+	if _, err := exec.LookPath("fpcalc"); err != nil {
+		return fmt.Errorf("fpcalc not found, install chromaprint to identify tracks")
+	}
+
+	// Without the source length the last track can't be looked up
+	total, _ := probeDuration(ctx, opts.Filename)
+
+	for i := range tracks {
+		t := &tracks[i]
+		length, err := t.duration(total)
+		if err != nil || length <= 0 {
+			opts.logf("warning: track %d has no known length, not identifying it\n", t.Number)
+			continue
+		}
+
+		opts.logf("identifying track %d\n", t.Number)
+		fp, err := fingerprint(ctx, opts.Filename, *t)
+		if err != nil {
+			return err
+		}
+
+		artist, title, score, err := lookupAcoustID(ctx, opts.AcoustIDKey, fp, length)
+		if err != nil {
+			return err
+		}
+
+		if title == "" || score < acoustIDMinScore {
+			opts.logf("warning: no confident match for track %d, keeping \"%v\"\n", t.Number, t.Title)
+			continue
+		}
+
+		opts.logf("track %d is \"%v\" by %v (score %.2f)\n", t.Number, title, artist, score)
+		t.Title = title
+		if artist != "" {
+			t.Artist = artist
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,216 @@
+package avsplit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// applauseWindow is how much of each end of a track trimApplause looks
+// at for crowd noise.
+const applauseWindow = 90 * time.Second
+
+// applauseMin is how long crowd noise has to go on for to be trimmed,
+// shorter runs being a cheer over the music or a quiet moment of a song.
+const applauseMin = 3 * time.Second
+
+// applauseFlatness is the spectral flatness, from 0 for a pure tone to 1
+// for white noise, above which a step of audio sounds like a crowd rather
+// than music.
+const applauseFlatness = 0.3
+
+// applauseGap is how long a run of crowd noise may break off for, say for
+// a shout or a lull in the clapping, and still count as one.
+const applauseGap = time.Second
+
+// applauseLevel is the loudness and spectral flatness of a snapStep of
+// audio.
+type applauseLevel struct {
+	at       time.Duration
+	db       float64
+	flatness float64
+}
+
+// parseApplauseLevels returns the RMS levels and spectral flatness that
+// ffmpeg's astats and aspectralstats printed through ametadata, from start.
+// Each value is printed after a line with the time of its step.
+func parseApplauseLevels(output string, start time.Duration) []applauseLevel {
+	var levels []applauseLevel
+	byTime := make(map[time.Duration]int)
+	var at time.Duration
+
+	step := func() *applauseLevel {
+		i, ok := byTime[at]
+		if !ok {
+			i = len(levels)
+			byTime[at] = i
+			levels = append(levels, applauseLevel{at: at, db: math.Inf(-1)})
+		}
+		return &levels[i]
+	}
+
+	s := bufio.NewScanner(strings.NewReader(output))
+	for s.Scan() {
+		line := s.Text()
+
+		if i := strings.Index(line, "pts_time:"); i >= 0 {
+			v, err := strconv.ParseFloat(strings.Fields(line[i+len("pts_time:"):])[0], 64)
+			if err == nil {
+				at = start + time.Duration(v*float64(time.Second))
+			}
+			continue
+		}
+
+		if i := strings.Index(line, "lavfi.astats.Overall.RMS_level="); i >= 0 {
+			v, err := strconv.ParseFloat(strings.TrimSpace(line[i+len("lavfi.astats.Overall.RMS_level="):]), 64)
+			if err != nil {
+				// "-inf", digital silence
+				v = math.Inf(-1)
+			}
+			step().db = v
+			continue
+		}
+
+		if i := strings.Index(line, "lavfi.aspectralstats.1.flatness="); i >= 0 {
+			v, err := strconv.ParseFloat(strings.TrimSpace(line[i+len("lavfi.aspectralstats.1.flatness="):]), 64)
+			if err == nil && !math.IsNaN(v) {
+				step().flatness = v
+			}
+		}
+	}
+	return levels
+}
+
+// measureApplause returns the loudness and spectral flatness of each
+// snapStep of the audio file from start for length, mixed down to mono.
+func measureApplause(ctx context.Context, file string, start, length time.Duration) ([]applauseLevel, error) {
+	rate := 16000
+	filter := fmt.Sprintf("aresample=%d,aformat=channel_layouts=mono,asetnsamples=n=%d:p=0,astats=metadata=1:reset=1:measure_perchannel=none:measure_overall=RMS_level,"+
+		"aspectralstats=win_size=1024:measure=flatness,"+
+		"ametadata=mode=print:key=lavfi.astats.Overall.RMS_level,ametadata=mode=print:key=lavfi.aspectralstats.1.flatness",
+		rate, int(snapStep.Seconds()*float64(rate)))
+	args := []string{"-nostdin", "-hide_banner", "-ss", formatTimecode(start), "-t", formatTimecode(length), "-i", argPath(file)}
+	args = append(args, audioMapArgs(ctx)...)
+	args = append(args, "-af", filter, "-f", "null", "-")
+
+	var stderr bytes.Buffer
+	err := runCommand(ctx, "ffmpeg", args, nil, &stderr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, toolError("ffmpeg", stderr.String())
+	}
+	return parseApplauseLevels(stderr.String(), start), nil
+}
+
+// crowdRun returns how long the crowd noise at the start of levels goes on
+// for, 0 when they don't start with any. A step is crowd noise when it is
+// louder than threshold and as flat as applauseFlatness, with breaks of up
+// to applauseGap in between.
+func crowdRun(levels []applauseLevel, threshold float64) time.Duration {
+	var run, gap time.Duration
+	for _, l := range levels {
+		if l.db > threshold && l.flatness >= applauseFlatness {
+			run += gap + snapStep
+			gap = 0
+			continue
+		}
+
+		if run == 0 {
+			// Music from the start
+			break
+		}
+		if gap += snapStep; gap > applauseGap {
+			break
+		}
+	}
+	return run
+}
+
+// trimApplause trims runs of crowd noise at the start and end of each track
+// down to keep, so a live album's tracks don't open or close on a minute
+// of clapping. Crowd noise is told from music by being loud and as flat as
+// noise across the spectrum. Each trim is logged with the stretch of the
+// source it removed, so it can be checked with preview. Tracks cut on
+// samples are left alone.
+func trimApplause(ctx context.Context, opts Options, tracks Tracklist, keep, total time.Duration) error {
+	threshold, err := noiseLevel(opts.SilenceNoise)
+	if err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	var trimmed time.Duration
+	var count int
+	for i := range tracks {
+		t := &tracks[i]
+		if t.cutsOnSamples() {
+			continue
+		}
+
+		start, err := parseDuration(t.Start)
+		if err != nil {
+			return err
+		}
+		length, err := t.duration(total)
+		if err != nil {
+			return err
+		}
+		if length <= 0 {
+			opts.logf("warning: track %d: the length of the source is unknown, not trimming its applause\n", t.Number)
+			continue
+		}
+
+		window := applauseWindow
+		if window > length/2 {
+			window = length / 2
+		}
+
+		head, err := measureApplause(ctx, opts.Filename, start, window)
+		if err != nil {
+			return err
+		}
+		if run := crowdRun(head, threshold); run >= applauseMin && run > keep {
+			cut := run - keep
+			from := formatTimecode(start)
+			t.Start = formatTimecode((start + cut).Round(time.Millisecond))
+			opts.logf("track %d: trimmed %v of applause from its start, %v to %v\n", t.Number, cut.Round(100*time.Millisecond), from, t.Start)
+			trimmed += cut
+			count++
+			start += cut
+			length -= cut
+		}
+
+		end := start + length
+		if window > length {
+			window = length
+		}
+		tail, err := measureApplause(ctx, opts.Filename, end-window, window)
+		if err != nil {
+			return err
+		}
+		for l, r := 0, len(tail)-1; l < r; l, r = l+1, r-1 {
+			tail[l], tail[r] = tail[r], tail[l]
+		}
+		if run := crowdRun(tail, threshold); run >= applauseMin && run > keep {
+			cut := run - keep
+			if cut >= length {
+				continue
+			}
+			t.End = formatTimecode((end - cut).Round(time.Millisecond))
+			opts.logf("track %d: trimmed %v of applause from its end, %v to %v\n", t.Number, cut.Round(100*time.Millisecond), t.End, formatTimecode(end))
+			trimmed += cut
+			count++
+		}
+	}
+
+	if count > 0 {
+		opts.logf("trimmed %v of applause in %d places\n", trimmed.Round(100*time.Millisecond), count)
+	}
+	return nil
+}
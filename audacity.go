@@ -0,0 +1,142 @@
+package avsplit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// audacityLabelTitle keeps a title on its own line of a label file.
+var audacityLabelTitle = strings.NewReplacer("\t", " ", "\r", " ", "\n", " ")
+
+// writeAudacityLabels writes the tracks as an Audacity label file that
+// readAudacityLabels reads back as the same tracks.
+func writeAudacityLabels(filename string, tracks []Track) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("cannot create audacity labels file")
+	}
+	defer f.Close()
+
+	if err := printAudacityLabels(f, tracks); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// printAudacityLabels writes the tracks to w as writeAudacityLabels does.
+func printAudacityLabels(w io.Writer, tracks []Track) error {
+	for _, t := range tracks {
+		start, err := parseDuration(t.Start)
+		if err != nil {
+			return err
+		}
+
+		// The last track has no end so write it as a point label
+		end := start
+		if t.End != "" {
+			end, err = parseDuration(t.End)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = fmt.Fprintf(w, "%.6f\t%.6f\t%v\n", start.Seconds(), end.Seconds(), audacityLabelTitle.Replace(t.Title))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func readAudacityLabels(opts Options) (Tracklist, error) {
+	data, err := readText(opts, opts.Audacity)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read audacity labels file")
+	}
+	return parseAudacityLabels(bytes.NewReader(data), opts)
+}
+
+// parseAudacityLabels reads an Audacity label track export, one
+// "start<TAB>end<TAB>label" line per track in seconds. A region label ends
+// its track, a point label runs to the next label.
+func parseAudacityLabels(r io.Reader, opts Options) (Tracklist, error) {
+	opts = opts.withDefaults()
+	allowUntitled := opts.AutoTitle != "" || opts.looksUpRelease()
+
+	var tracks Tracklist
+	var ends []string
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		text := strings.TrimRight(s.Text(), "\r")
+
+		// Spectral selections follow their label on a line of their own
+		if text == "" || strings.HasPrefix(text, "\\") {
+			continue
+		}
+
+		fields := strings.SplitN(text, "\t", 3)
+		if len(fields) < 2 {
+			return nil, lineErrorf(line, "invalid audacity label on line %d", line)
+		}
+
+		// Written with a decimal comma in some locales
+		start, err := parseSeconds(decimalPoint(fields[0]))
+		if err != nil || start < 0 {
+			return nil, lineErrorf(line, "invalid audacity label on line %d", line)
+		}
+
+		end, err := parseSeconds(decimalPoint(fields[1]))
+		if err != nil || end < start {
+			return nil, lineErrorf(line, "invalid audacity label on line %d", line)
+		}
+
+		title := ""
+		if len(fields) == 3 {
+			title = strings.TrimSpace(fields[2])
+		}
+		if title == "" && !allowUntitled {
+			return nil, lineErrorf(line, "audacity label on line %d has no title", line)
+		}
+
+		tracks = append(tracks, Track{
+			Number:      len(tracks) + 1,
+			Line:        line,
+			Title:       title,
+			Start:       formatTimecode(start.Round(time.Millisecond)),
+			Artist:      opts.Artist,
+			AlbumArtist: opts.Artist,
+			Album:       opts.Album,
+		})
+
+		if end > start {
+			ends = append(ends, formatTimecode(end.Round(time.Millisecond)))
+		} else {
+			ends = append(ends, "")
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read audacity labels: %v", err)
+	}
+
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("no labels found")
+	}
+
+	tracks.SetEnds()
+	for i := range tracks {
+		tracks[i].Total = len(tracks)
+		if ends[i] != "" {
+			tracks[i].End = ends[i]
+		}
+	}
+
+	return tracks, nil
+}
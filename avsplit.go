@@ -0,0 +1,1524 @@
+// Package avsplit splits a video or audio file into tracks by timecodes using
+// ffmpeg, and tags the tracks.
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Track is a single output track cut from the source between Start and End.
+// An empty End reads to the end of the source.
+type Track struct {
+	Number      int
+	Total       int
+	Title       string
+	Start       string
+	End         string
+	Artist      string
+	AlbumArtist string
+	Album       string
+	Composer    string
+	Year        string
+	Genre       string
+	Disc        int
+	DiscTotal   int
+	Comment     string
+	Dir         string
+	Ext         string
+	Reencode    bool
+	Video       bool
+	Codec       string
+	Bitrate     string
+	Quality     string
+	Filter      string
+	Speed       string
+	FadeIn      time.Duration
+	FadeOut     time.Duration
+	Metadata    bool
+	Cover       string
+	ASCII       bool
+	Output      string
+	MultiDisc   bool
+	PadWidth    int
+
+	// Artists are the artists Artist credits one by one, when there are
+	// several, for the tag families that keep each of them
+	Artists []string
+
+	// FileTitle names the output file in place of Title when set, such as
+	// the romanization of a title tagged in its own script
+	FileTitle string
+
+	// Lyrics are tagged, SyncedLyrics written to an .lrc file
+	Lyrics       string
+	SyncedLyrics string
+
+	// Format and Encode, like Codec, Bitrate, Quality and Video, are the
+	// track's own output settings over the run's when it is prepared
+	Format string
+	Encode bool
+
+	// MusicBrainz IDs of the release, recording and release track, when
+	// the tracks were matched to a release
+	MBAlbumID        string
+	MBTrackID        string
+	MBReleaseTrackID string
+
+	// Label and CatalogNumber are the record label of the release and its
+	// catalog number there
+	Label         string
+	CatalogNumber string
+
+	// ISRC is the International Standard Recording Code of the recording
+	ISRC string
+
+	// InputArgs and ExtraArgs are passed to ffmpeg before the input and
+	// before the output file
+	InputArgs []string
+	ExtraArgs []string
+
+	// Stream is the specifier of the audio stream to extract, the default
+	// one when empty
+	Stream string
+
+	// Line is the line of the tracklist the track was read from, 0 when
+	// it didn't come from a file
+	Line int
+
+	// Work is the classical work the track is a movement of, numbered
+	// Movement of Movements
+	Work      string
+	Movement  int
+	Movements int
+
+	// StartSample and EndSample are the exact samples at SampleRate the
+	// track is cut on, as read from a FLAC cuesheet, EndSample 0 running to
+	// the end of the source. SampleRate is 0 when it is cut on its Start
+	// and End.
+	StartSample int64
+	EndSample   int64
+	SampleRate  int
+
+	// Bitexact leaves ffmpeg's version and the time out of the output
+	// file, for the same track to come out the same every time
+	Bitexact bool
+
+	// Length is how long the track plays for once split, tagged as TLEN,
+	// 0 when it isn't known, as for the last track of a source ffprobe
+	// can't tell the length of
+	Length time.Duration
+}
+
+// Tracklist is the ordered list of tracks cut from a source.
+type Tracklist []Track
+
+// SetEnds ends each track where the next one starts. The last track runs to
+// the end of the source.
+func (tl Tracklist) SetEnds() {
+	for i := range tl {
+		if i < len(tl)-1 {
+			tl[i].End = tl[i+1].Start
+		} else {
+			tl[i].End = ""
+		}
+	}
+}
+
+// Options configures a run. The zero value of each field is either unset or
+// its default.
+type Options struct {
+	Filename        string
+	Filenames       []string
+	Timecodes       string
+	Artist          string
+	Album           string
+	AudacityLabels  string
+	AutoTitle       string
+	VTTOut          string
+	TagJobs         int
+	PreserveMtime   bool
+	TagsCSV         string
+	Quarantine      bool
+	DirTemplate     string
+	SanityCheck     bool
+	Strict          bool
+	AddChapters     string
+	MaxLineBytes    int
+	Script          string
+	Cue             string
+	Audacity        string
+	Tagger          string
+	Jobs            int
+	Format          string
+	DryRun          bool
+	DetectSilence   bool
+	SilenceNoise    string
+	SilenceDuration time.Duration
+	MBRelease       string
+	MBSearch        bool
+	DiscogsRelease  string
+	Cover           string
+	FromChapters    bool
+	Progress        bool
+	OutputTemplate  string
+	VA              bool
+	Encode          bool
+	Codec           string
+	Bitrate         string
+	Quality         string
+	YouTube         bool
+	Force           bool
+	JSON            bool
+	Video           bool
+	Normalize       string
+	Year            string
+	Genre           string
+	Disc            int
+	DiscTotal       int
+	Comment         string
+	Offset          time.Duration
+	PadStart        time.Duration
+	PadEnd          time.Duration
+	Accurate        bool
+	Verify          bool
+	Checksum        string
+	OutputDir       string
+	ASCII           bool
+	Pregap          string
+	HTOA            bool
+	Sidecars        bool
+	Tracks          string
+	Images          string
+	FFmpegPath      string
+	FFprobePath     string
+	TitleTemplate   string
+	Vars            map[string]string
+	AcoustIDKey     string
+	Output          string
+	Retries         int
+	FailFast        bool
+	TagOnly         bool
+	TimecodesFormat string
+	CrossfadeSplit  time.Duration
+	CacheDir        string
+	Stream          bool
+	FromURL         string
+	Discs           string
+	DiscDirs        bool
+	Collisions      string
+	Lyrics          string
+	LyricsProvider  string
+	Plan            string
+	SavePlan        string
+	TrackStart      int
+	PadWidth        int
+	Numbering       string
+	Beets           bool
+	WatchState      string
+	WatchInterval   time.Duration
+	SourceTags      string
+
+	// FFmpegArgs are extra arguments for the output of each track's ffmpeg
+	// command and FFmpegInputArgs for its input, split like a shell would.
+	FFmpegArgs      string
+	FFmpegInputArgs string
+
+	// AudioStream selects the audio stream of a file with several, by its
+	// number among them from 0 or its language, such as "eng".
+	AudioStream string
+
+	// JobsDir is where Serve and Web keep the state and files of each job,
+	// avsplit-jobs by default. With it SplitBatch keeps each entry as a job
+	// too, to resume the batch where it stopped.
+	JobsDir string
+
+	// SourceRoot is the directory the sources of Serve and Web jobs given
+	// by path must be in. Without it a job's audio file must be uploaded.
+	SourceRoot string
+
+	// FixOverlaps sorts the tracks, drops duplicates and cuts overlapping
+	// ends instead of failing on them, merging into the track before those
+	// starting less than MergeWithin after it.
+	FixOverlaps bool
+	MergeWithin time.Duration
+
+	// PreviewLength is how much Preview plays before and after each split
+	// point, 5 seconds by default.
+	PreviewLength time.Duration
+
+	// TitleFilters lists the rules cleaning up the titles of the tracklist,
+	// such as "number,timestamp,suffix", or "all,-case" for all but one.
+	TitleFilters string
+
+	// Tempo plays the tracks this many times as fast, such as 0.96 for a
+	// vinyl rip 4% too fast, and Pitch shifts them by semitones, each
+	// without changing the other. The timecodes of a tracklist are those of
+	// the corrected tracks and are scaled to the audio file's, except
+	// boundaries found in the file itself by DetectSilence, FromChapters or
+	// FLACCue.
+	Tempo float64
+	Pitch float64
+
+	// FLACCue reads the tracks from the CUESHEET block embedded in a FLAC
+	// source and cuts them on its exact samples, losslessly.
+	FLACCue bool
+
+	// ToChapters writes the audio to this one file with the tracks as its
+	// chapters instead of splitting it, such as an .m4b audiobook. Unlike
+	// AddChapters the audio is re-encoded when its codec doesn't fit the
+	// container, and the file is tagged as the release.
+	ToChapters string
+
+	// TagDir, with TagOnly, tags the audio files already in this directory
+	// instead of the tracks' own output files, without the source. TagMatch
+	// matches them to the tracks: "name" by how alike their names are to
+	// the titles, "order" in order, or "auto" by name and then in order.
+	TagDir   string
+	TagMatch string
+
+	// Nice, IONice and CPULimit throttle each ffmpeg run on a shared
+	// machine: run under nice at this niceness, under ionice with this I/O
+	// class such as "idle" or "best-effort:7", and with at most this many
+	// threads.
+	Nice     int
+	IONice   string
+	CPULimit int
+
+	// Reproducible makes a split write the same bytes every time it is run,
+	// without ffmpeg's version or the time it wrote the tracks. Date, when
+	// not zero, is the modification time of the tracks, which the bytes
+	// don't include.
+	Reproducible bool
+	Date         time.Time
+
+	// PostCmd is a shell command run after each track is written, and
+	// PostAlbumCmd one run once every track is, with the output files and
+	// tags in AVSPLIT_ environment variables, say to upload them or have a
+	// music server rescan its library.
+	PostCmd      string
+	PostAlbumCmd string
+
+	// Receipt writes an avsplit-receipt.json into each output directory
+	// after a split, recording the source and tracklist checksums, the tool
+	// versions, the commands run and each track's checksum and length. It
+	// can be given as a Plan to split or tag the tracks again.
+	Receipt bool
+
+	// SnapWindow moves each split point of the tracklist to the nearest
+	// silence within this far of it, quieter than SilenceNoise, or failing
+	// that to the quietest moment there, for tracklists written by ear.
+	SnapWindow time.Duration
+
+	// FetchCover downloads the front cover of the release, when no Cover is
+	// given, from the Cover Art Archive for a MusicBrainz release ("caa"),
+	// an iTunes search for the artist and album ("itunes"), or the first
+	// that has it ("auto"). It is embedded like Cover and saved as cover.jpg
+	// into each output directory. CoverSize is the size in pixels asked
+	// for, 1200 by default.
+	FetchCover string
+	CoverSize  int
+
+	// VerifyLossless decodes each stream-copied track after the split and
+	// compares it sample for sample with where it came from in the source,
+	// failing on any difference and on any gap or overlap between tracks
+	// the keyframes or frames they were cut on left.
+	VerifyLossless bool
+
+	// Metadata looks up the release to fill in titles and tags from, after
+	// any MusicBrainz or Discogs release given above, by ReleaseID or
+	// without one by the artist and album. See ChainProviders and
+	// CacheProvider for asking several providers and asking each once.
+	Metadata  MetadataProvider
+	ReleaseID string
+
+	// The responses to lookups of MusicBrainz, Discogs, cover art, lyrics
+	// and AcoustID are kept under CacheDir for LookupCacheTTL, a week by
+	// default, or not at all if negative. Offline answers them from that
+	// cache only, however old, failing those it has no response to, and
+	// uses only audio files downloaded already. LookupInterval is the
+	// least time between two requests to a host, over the limits of the
+	// services that have them, and LookupRetries how many times a request
+	// that fails is sent again, 3 by default or none if negative.
+	LookupCacheTTL time.Duration
+	Offline        bool
+	LookupInterval time.Duration
+	LookupRetries  int
+
+	// AltTitles reads the second title of each track, after " | " on a
+	// timecodes line or the alt-title of a YAML or TOML one, into the file
+	// names ("filenames"), keeping the first in the tags, or the other way
+	// round ("tags"). Romanize gives the tracks without a second title
+	// their title romanized from kana, Hangul, Cyrillic or Greek, into the
+	// file names unless AltTitles says otherwise.
+	AltTitles string
+	Romanize  bool
+
+	// Overwrite is what happens to output files that exist already:
+	// "never" fails naming them, "always" writes over them and "ask" asks
+	// AskOverwrite about each, keeping those it answers false for. Without
+	// it tracks an earlier run wrote in full are kept and the rest written
+	// over, see Force. AskOverwrite is set with PromptOverwrite to ask on a
+	// terminal.
+	Overwrite    string
+	AskOverwrite func(file string) (bool, error)
+
+	// SourceLyrics is an .lrc, .srt or .vtt file of the whole recording
+	// whose lines are split between the tracks, timed from the start of
+	// each, into .lrc files next to them. "auto" uses the one next to the
+	// audio file with its name, if there is one.
+	SourceLyrics string
+
+	// TrimApplause trims the crowd noise at the start and end of each track
+	// of a live recording down to ApplauseKeep, logging what it removed.
+	// It has to go on for a few seconds to be trimmed, and is told from the
+	// music by being loud and noisy, louder than SilenceNoise.
+	TrimApplause bool
+	ApplauseKeep time.Duration
+
+	// MinTrackLength fails a tracklist with a track shorter than it, most
+	// likely a typo in one of its timecodes.
+	MinTrackLength time.Duration
+
+	// DirMode and FileMode are the permissions of the directories and
+	// files a run creates, set over the umask. Without a DirMode they are
+	// created 0755 less the umask, and without a FileMode files are left
+	// as the umask makes them. Owner and Group, names or numeric IDs, are
+	// given them too when set.
+	DirMode  os.FileMode
+	FileMode os.FileMode
+	Owner    string
+	Group    string
+
+	// RecordingStart is the wall-clock time the recording started, which
+	// the song changes of a timeline tracklist are counted from when it
+	// doesn't give its own. Without either they are counted from the first.
+	RecordingStart time.Time
+
+	// Profiles split the tracks into several formats in one run, each into
+	// a directory of the output directory named after it. The source is
+	// decoded once for all those that re-encode. They take the place of
+	// Format, Codec, Bitrate and Quality.
+	Profiles []Profile
+	// decoded is the source decoded once for the profiles, set by
+	// splitProfiles
+	decoded string
+
+	// Quiet logs only warnings, Verbose adds debug messages such as the
+	// commands run and what they wrote to stderr.
+	Quiet   bool
+	Verbose bool
+
+	// Review is called with the tracks before they are split and returns
+	// the tracks to split, see ReviewTracks.
+	Review func(Tracklist) (Tracklist, error)
+
+	// Events, when set, is called as each track starts, gets further, is
+	// done or fails, and once the run completes, see Event. It is called
+	// one event at a time and the split waits on it, so it should return
+	// quickly.
+	Events func(Event)
+
+	// Log receives progress messages, warnings and the dry run plan. Nothing
+	// is written when it is nil.
+	Log io.Writer
+
+	// Executor runs ffmpeg and the other tools, with os/exec when it is nil.
+	Executor Executor
+}
+
+func (o Options) withDefaults() Options {
+	if o.TagJobs == 0 {
+		o.TagJobs = 1
+	}
+
+	if o.Jobs == 0 {
+		o.Jobs = 1
+	}
+
+	if o.Tagger == "" {
+		o.Tagger = "eyed3"
+	}
+
+	if o.FFmpegPath == "" {
+		o.FFmpegPath = "ffmpeg"
+	}
+
+	if o.FFprobePath == "" {
+		o.FFprobePath = "ffprobe"
+	}
+
+	if o.Pregap == "" {
+		o.Pregap = "append"
+	}
+
+	if o.Collisions == "" {
+		o.Collisions = "number"
+	}
+
+	if o.Numbering == "" {
+		o.Numbering = "original"
+	}
+
+	if o.TagMatch == "" {
+		o.TagMatch = "auto"
+	}
+
+	if o.LyricsProvider == "" {
+		o.LyricsProvider = "lrclib"
+	}
+
+	if o.MaxLineBytes == 0 {
+		o.MaxLineBytes = 1024 * 1024
+	}
+
+	if o.Checksum == "" {
+		o.Checksum = "sha256"
+	}
+
+	if o.SilenceNoise == "" {
+		o.SilenceNoise = "-30dB"
+	}
+
+	if o.SilenceDuration == 0 {
+		o.SilenceDuration = 2 * time.Second
+	}
+
+	return o
+}
+
+func (o Options) logWriter() io.Writer {
+	if o.Log == nil {
+		return io.Discard
+	}
+	return o.Log
+}
+
+func (o Options) logf(format string, a ...interface{}) {
+	if o.Log == nil {
+		return
+	}
+
+	if o.Quiet && !strings.HasPrefix(format, "warning: ") {
+		return
+	}
+
+	if o.JSON {
+		o.emitLog(fmt.Sprintf(format, a...))
+		return
+	}
+	fmt.Fprintf(o.Log, format, a...)
+}
+
+func (o Options) debugf(format string, a ...interface{}) {
+	if o.Log == nil || !o.Verbose {
+		return
+	}
+
+	if o.JSON {
+		o.emit(jsonEvent{Event: "debug", Message: fmt.Sprintf(format, a...)})
+		return
+	}
+	fmt.Fprintf(o.Log, "debug: "+format, a...)
+}
+
+// looksUpRelease reports whether the titles and tags of the tracks are
+// looked up, so the tracklist may leave them out.
+func (o Options) looksUpRelease() bool {
+	return o.MBRelease != "" || o.MBSearch || o.DiscogsRelease != "" || o.Metadata != nil
+}
+
+// exportOnly reports whether the run writes a tracklist export instead of
+// splitting the audio file.
+func (o Options) exportOnly() bool {
+	return o.AudacityLabels != "" || o.VTTOut != "" || o.AddChapters != "" || o.ToChapters != ""
+}
+
+// Split reads the tracklist described by opts and splits the audio file into
+// tracks, or writes the export, plan or script opts asks for instead. With
+// opts.JSON the error is also written to opts.Log as an error event.
+func Split(ctx context.Context, opts Options) error {
+	opts = opts.withDefaults()
+
+	err := split(opts.withOptions(ctx), opts)
+	if err != nil && opts.JSON {
+		opts.emit(jsonEvent{Event: "error", Message: err.Error()})
+	}
+	opts.event(Event{Kind: RunCompleted, Err: err})
+	return err
+}
+
+func split(ctx context.Context, opts Options) error {
+	if err := checkTools(ctx, opts); err != nil {
+		return withKind(ErrMissingTool, err)
+	}
+
+	if _, err := parseAudioStream(opts.AudioStream); err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	if err := checkThrottle(opts); err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	if opts.TagOnly && opts.TagDir != "" {
+		// The files are there already, the source isn't read
+		return tagDir(ctx, opts)
+	}
+
+	if opts.FromURL != "" {
+		audioFile, err := fetchYtDlp(ctx, opts)
+		if err != nil {
+			return err
+		}
+		opts.Filename, opts.Filenames = audioFile, nil
+
+		info, err := readYtDlpInfo(opts)
+		if err != nil {
+			return err
+		}
+		ytDlpTags(info, &opts)
+	}
+
+	var plan *planFile
+	if opts.Plan != "" {
+		p, err := readPlan(opts.Plan)
+		if err != nil {
+			return err
+		}
+		plan = p
+
+		// The sources can be moved, say to another machine, and given again
+		if len(opts.Filenames) == 0 && opts.Filename == "" {
+			opts.Filenames = plan.Sources
+		}
+		opts.Tagger, opts.Normalize = plan.Tagger, plan.Normalize
+	}
+
+	if len(opts.Filenames) == 0 && opts.Filename != "" {
+		opts.Filenames = []string{opts.Filename}
+	}
+	sources := opts.Filenames
+
+	if !opts.Stream {
+		// Downloaded once instead of fetched again for every track
+		files := make([]string, len(opts.Filenames))
+		for i, f := range opts.Filenames {
+			p, err := cachedDownload(ctx, opts, f)
+			if err != nil {
+				return err
+			}
+			files[i] = p
+		}
+		opts.Filenames = files
+	}
+
+	if len(opts.Filenames) == 1 {
+		opts.Filename = opts.Filenames[0]
+	}
+
+	if len(opts.Filenames) > 1 {
+		joined, err := concatFiles(ctx, opts, opts.Filenames)
+		if err != nil {
+			return err
+		}
+		defer os.Remove(joined)
+		opts.Filename = joined
+	}
+	if isURL(opts.Filename) {
+		// ffmpeg reads the URL directly but has to seek into it again for
+		// every track
+		opts.logf("warning: reading from a URL, seeking for each track may be slow\n")
+	} else if _, err := os.Stat(opts.Filename); err != nil {
+		return withKind(ErrInvalidInput, fmt.Errorf("audio file not found"))
+	} else if err := preflightSource(ctx, opts); err != nil {
+		return err
+	}
+
+	if opts.PreserveMtime && isURL(opts.Filename) {
+		return withKind(ErrInvalidInput, fmt.Errorf("preserve-mtime requires a local audio file"))
+	}
+
+	if opts.PreserveMtime && !opts.Date.IsZero() {
+		return withKind(ErrInvalidInput, fmt.Errorf("date and preserve-mtime can't be used together"))
+	}
+
+	if plan != nil {
+		return splitPlan(ctx, opts, plan)
+	}
+
+	if opts.SourceTags != "" && opts.Cover == "" && !opts.DryRun && opts.Script == "" {
+		fields, err := parseSourceTags(opts.SourceTags)
+		if err != nil {
+			return err
+		}
+
+		if fields["art"] {
+			art, err := extractSourceArt(ctx, opts.Filename)
+			if err != nil {
+				return err
+			}
+			if art != "" {
+				defer os.Remove(art)
+				opts.Cover = art
+			}
+		}
+	}
+
+	tracks, err := ReadTracklist(ctx, opts)
+	if err != nil {
+		return withKind(ErrParse, err)
+	}
+
+	if !opts.exportOnly() && (tracks[0].AlbumArtist == "" || tracks[0].Album == "") {
+		return withKind(ErrInvalidInput, fmt.Errorf("artist and album are required"))
+	}
+
+	switch opts.FetchCover {
+	case "", "caa", "itunes", "auto":
+	default:
+		return withKind(ErrInvalidInput, fmt.Errorf("unknown fetch-cover %v, must be caa, itunes or auto", opts.FetchCover))
+	}
+
+	if opts.CoverSize < 0 {
+		return withKind(ErrInvalidInput, fmt.Errorf("cover-size must not be negative"))
+	}
+
+	if opts.FetchCover != "" && opts.Cover == "" && !opts.exportOnly() && !opts.DryRun && opts.Script == "" {
+		cover, err := fetchCover(ctx, opts, tracks)
+		if err != nil {
+			return err
+		}
+		if cover != "" {
+			defer os.Remove(cover)
+			opts.Cover = cover
+		}
+	}
+
+	if opts.TagsCSV != "" {
+		tags, err := readTagsCSV(opts.TagsCSV)
+		if err != nil {
+			return err
+		}
+
+		if err := applyTagsCSV(tracks, tags); err != nil {
+			return err
+		}
+	}
+
+	if opts.AcoustIDKey != "" && !opts.exportOnly() {
+		if err := identifyTracks(ctx, opts, tracks); err != nil {
+			return err
+		}
+	}
+
+	if opts.TitleTemplate != "" {
+		if err := applyTitleTemplate(tracks, opts.TitleTemplate, opts.Filename, opts.Vars); err != nil {
+			return err
+		}
+	}
+
+	if opts.Review != nil && !opts.exportOnly() {
+		tracks, err = opts.Review(tracks)
+		if err != nil {
+			return err
+		}
+	}
+
+	if opts.DirTemplate != "" {
+		if err := applyDirTemplate(tracks, opts.DirTemplate, opts.ASCII); err != nil {
+			return err
+		}
+	}
+
+	if err := checkTempo(opts.Tempo, opts.Pitch); err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	// Before anything is written with them
+	if _, _, err := opts.ownerIDs(); err != nil {
+		return err
+	}
+
+	if opts.Tempo != 0 && opts.Tempo != 1 && !opts.DetectSilence && !opts.FromChapters && !opts.FLACCue {
+		// The tracklist times the corrected audio, slower or faster
+		if err := scaleTracks(tracks, opts.Tempo); err != nil {
+			return err
+		}
+	}
+
+	if opts.Offset != 0 {
+		if err := shiftTracks(tracks, opts.Offset); err != nil {
+			return err
+		}
+	}
+
+	if opts.SnapWindow < 0 {
+		return withKind(ErrInvalidInput, fmt.Errorf("snap-window must not be negative"))
+	}
+
+	if opts.SnapWindow > 0 && !opts.DetectSilence && !opts.FromChapters && !opts.FLACCue {
+		// Found in the file already otherwise
+		if err := snapTracks(ctx, opts, tracks, opts.SnapWindow); err != nil {
+			return err
+		}
+	}
+
+	if opts.FixOverlaps {
+		var changes []string
+		tracks, changes, err = fixOverlaps(tracks, opts.MergeWithin)
+		if err != nil {
+			return withKind(ErrInvalidInput, err)
+		}
+
+		for _, c := range changes {
+			opts.logf("%v\n", c)
+		}
+	}
+
+	// Checked against the length of the source when ffprobe can tell it
+	duration, err := probeDuration(ctx, opts.Filename)
+	if err != nil {
+		opts.logf("warning: %v, not checking the tracks against its length\n", err)
+		duration = 0
+	}
+
+	warnings, err := validateTracks(tracks, duration)
+	if err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	for _, w := range warnings {
+		opts.logf("warning: %v\n", w)
+	}
+
+	if opts.Strict && len(warnings) > 0 {
+		return withKind(ErrInvalidInput, fmt.Errorf("validation failed"))
+	}
+
+	if err := checkMinLength(tracks, duration, opts.MinTrackLength); err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	if opts.SanityCheck {
+		if duration == 0 {
+			return withKind(ErrInvalidInput, fmt.Errorf("sanity check needs the length of the audio file"))
+		}
+
+		warnings, err := sanityCheck(tracks, duration)
+		if err != nil {
+			return err
+		}
+
+		for _, w := range warnings {
+			opts.logf("warning: %v\n", w)
+		}
+
+		if opts.Strict && len(warnings) > 0 {
+			return withKind(ErrInvalidInput, fmt.Errorf("sanity check failed"))
+		}
+	}
+
+	if opts.exportOnly() {
+		// A dry run prints each export under the name of its file
+		if opts.AudacityLabels != "" && opts.DryRun {
+			fmt.Fprintf(opts.logWriter(), "# %v\n", opts.AudacityLabels)
+			if err := printAudacityLabels(opts.logWriter(), tracks); err != nil {
+				return err
+			}
+		} else if opts.AudacityLabels != "" {
+			if err := writeAudacityLabels(opts.AudacityLabels, tracks); err != nil {
+				return err
+			}
+		}
+
+		if opts.VTTOut != "" {
+			duration, err := probeDuration(ctx, opts.Filename)
+			if err != nil {
+				return err
+			}
+
+			if opts.DryRun {
+				fmt.Fprintf(opts.logWriter(), "# %v\n", opts.VTTOut)
+				err = printVTT(opts.logWriter(), tracks, duration)
+			} else {
+				err = writeVTT(opts.VTTOut, tracks, duration)
+			}
+			if err != nil {
+				return err
+			}
+		}
+
+		if opts.AddChapters != "" {
+			if err := addChapters(ctx, opts, tracks); err != nil {
+				return err
+			}
+		}
+
+		if opts.ToChapters != "" {
+			if err := toChapters(ctx, opts, tracks); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}
+
+	if opts.TrimApplause {
+		if opts.ApplauseKeep < 0 {
+			return withKind(ErrInvalidInput, fmt.Errorf("applause-keep must not be negative"))
+		}
+		if err := trimApplause(ctx, opts, tracks, opts.ApplauseKeep, duration); err != nil {
+			return err
+		}
+	}
+
+	// After validation, padded tracks overlap on purpose
+	if opts.PadStart != 0 || opts.PadEnd != 0 {
+		if err := padTracks(tracks, opts.PadStart, opts.PadEnd); err != nil {
+			return err
+		}
+	}
+
+	// Selected from the full list so numbering and boundaries stay the same,
+	// unless the tracks left are numbered again
+	if opts.Tracks != "" {
+		tracks, err = selectTracks(tracks, opts.Tracks)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := renumberTracks(tracks, opts.Numbering, opts.TrackStart); err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	// Once padded and trimmed, and as long as they play at the tempo
+	setLengths(tracks, duration, opts.Tempo)
+
+	if opts.Output != "" && len(tracks) != 1 {
+		return withKind(ErrInvalidInput, fmt.Errorf("output needs a single track, choose one with -tracks"))
+	}
+
+	if opts.Beets && !opts.DryRun && opts.Script == "" && opts.OutputDir == "" {
+		// Staged apart from everything else, for beets to import and move
+		dir, err := os.MkdirTemp("", "avsplit-beets-")
+		if err != nil {
+			return err
+		}
+		opts.OutputDir = dir
+	}
+
+	return execute(ctx, NewSplitter(opts), sources, tracks)
+}
+
+// splitPlan splits the tracks of a saved plan as they are, only checking
+// them again in case the plan was edited.
+func splitPlan(ctx context.Context, opts Options, plan *planFile) error {
+	tracks, err := plan.tracklist()
+	if err != nil {
+		return err
+	}
+
+	if opts.Reproducible {
+		for i := range tracks {
+			tracks[i].Bitexact = true
+		}
+	}
+
+	duration, err := probeDuration(ctx, opts.Filename)
+	if err != nil {
+		opts.logf("warning: %v, not checking the tracks against its length\n", err)
+		duration = 0
+	}
+
+	warnings, err := validateTracks(tracks, duration)
+	if err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	for _, w := range warnings {
+		opts.logf("warning: %v\n", w)
+	}
+
+	if opts.Strict && len(warnings) > 0 {
+		return withKind(ErrInvalidInput, fmt.Errorf("validation failed"))
+	}
+
+	if err := checkMinLength(tracks, duration, opts.MinTrackLength); err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	if opts.Tracks != "" {
+		tracks, err = selectTracks(tracks, opts.Tracks)
+		if err != nil {
+			return err
+		}
+	}
+
+	if err := renumberTracks(tracks, opts.Numbering, opts.TrackStart); err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	s := NewSplitter(opts)
+	s.planned = true
+	return execute(ctx, s, plan.Sources, tracks)
+}
+
+// execute splits the tracks with s, or writes the plan or script its
+// options ask for instead.
+func execute(ctx context.Context, s *Splitter, sources []string, tracks Tracklist) error {
+	opts := s.opts
+	if opts.DryRun || opts.Script != "" {
+		if err := s.prepare(ctx, tracks); err != nil {
+			return withKind(ErrInvalidInput, err)
+		}
+
+		// prepare settles the tagger
+		opts = s.opts
+
+		if opts.SavePlan != "" {
+			if err := writePlan(opts.SavePlan, opts, sources, tracks); err != nil {
+				return err
+			}
+			opts.logf("wrote the plan to %v\n", opts.SavePlan)
+			return nil
+		}
+
+		if opts.DryRun && opts.JSON {
+			opts.emitPlan(tracks)
+			return nil
+		}
+
+		if opts.DryRun {
+			return printPlan(opts.logWriter(), opts, tracks)
+		}
+		return writeScript(opts.Script, opts, tracks)
+	}
+
+	if opts.TagOnly {
+		return s.Tag(ctx, tracks)
+	}
+
+	return s.Split(ctx, tracks)
+}
+
+// Splitter extracts and tags the tracks of a tracklist.
+type Splitter struct {
+	opts Options
+
+	// planned tracks come from a saved plan and are only given a tagger
+	planned bool
+}
+
+// NewSplitter returns a Splitter that runs with opts.
+func NewSplitter(opts Options) *Splitter {
+	return &Splitter{opts: opts.withDefaults()}
+}
+
+// prepare sets the output format and cover art of each track.
+func (s *Splitter) prepare(ctx context.Context, tracks Tracklist) error {
+	if s.planned {
+		// Whichever tagger is available here, not where it was planned
+		tagger, err := selectTagger(s.opts, tracks[0].Ext)
+		if err != nil {
+			return err
+		}
+		s.opts.Tagger = tagger
+		return nil
+	}
+
+	ext, reencode, err := s.outputFormat(ctx, s.opts.Format, s.opts.Codec, s.opts.Encode || s.opts.Bitrate != "" || s.opts.Quality != "", s.opts.Video)
+	if err != nil {
+		return err
+	}
+
+	filter := ""
+	if s.opts.Normalize != "" {
+		if s.opts.Normalize != "track" && s.opts.Normalize != "album" {
+			return fmt.Errorf("unknown normalization %v, must be track or album", s.opts.Normalize)
+		}
+
+		// Filtered audio can't be stream copied
+		reencode = true
+
+		filter = loudnormFilter(nil, "")
+	}
+
+	if _, ok := imageFilters[s.opts.Images]; s.opts.Images != "" && !ok {
+		return fmt.Errorf("unknown images %v, must be waveform or spectrogram", s.opts.Images)
+	}
+
+	if s.opts.PadWidth < 0 {
+		return fmt.Errorf("pad-width must not be negative")
+	}
+
+	if s.opts.CrossfadeSplit < 0 {
+		return fmt.Errorf("crossfade-split must not be negative")
+	}
+
+	inputArgs, err := splitArgs(s.opts.FFmpegInputArgs)
+	if err != nil {
+		return fmt.Errorf("invalid ffmpeg-input-args: %v", err)
+	}
+
+	extraArgs, err := splitArgs(s.opts.FFmpegArgs)
+	if err != nil {
+		return fmt.Errorf("invalid ffmpeg-args: %v", err)
+	}
+
+	stream, err := parseAudioStream(s.opts.AudioStream)
+	if err != nil {
+		return err
+	}
+
+	if s.opts.CrossfadeSplit > 0 {
+		// Faded audio can't be stream copied either
+		reencode = true
+	}
+
+	speed := ""
+	if s.opts.Tempo != 0 && s.opts.Tempo != 1 || s.opts.Pitch != 0 {
+		rate := ""
+		if s.opts.Pitch != 0 {
+			if rate, err = probeSampleRate(ctx, s.opts.Filename); err != nil {
+				return err
+			}
+		}
+
+		if speed, err = tempoFilter(s.opts.Tempo, s.opts.Pitch, rate); err != nil {
+			return err
+		}
+		reencode = true
+	}
+
+	samples := false
+	for i := range tracks {
+		t := &tracks[i]
+		if t.SampleRate > 0 && !t.cutsOnSamples() {
+			s.opts.logf("warning: the boundaries of track %d were moved, it is cut on its timecodes instead of the cuesheet's samples\n", t.Number)
+			t.SampleRate = 0
+		}
+		samples = samples || t.SampleRate > 0
+	}
+
+	if samples {
+		// Trimmed to the exact sample, which FLAC survives losslessly
+		reencode = true
+	}
+
+	if s.opts.Accurate && !reencode {
+		// Decoding lets ffmpeg cut on the exact sample instead of the
+		// nearest frame
+		reencode = true
+		if !losslessExts[ext] {
+			s.opts.logf("warning: accurate splitting re-encodes the %v tracks, see -codec, -bitrate and -quality\n", ext)
+		}
+	}
+
+	// Filtered, faded and accurately cut tracks are re-encoded whatever
+	// their format
+	decoded := filter != "" || speed != "" || s.opts.CrossfadeSplit > 0 || s.opts.Accurate || samples
+
+	cover := ""
+	if s.opts.Cover != "" {
+		cover, err = findCover(s.opts.Filename, s.opts.Cover)
+		if err != nil {
+			return err
+		}
+
+		if cover != "" && s.opts.Cover == "auto" {
+			s.opts.logf("using cover art %v\n", cover)
+		}
+	}
+
+	for i := range tracks {
+		own := tracks[i]
+		tracks[i].Ext = ext
+		tracks[i].Reencode = reencode
+		tracks[i].Video = s.opts.Video
+		tracks[i].Codec = s.opts.Codec
+		tracks[i].Bitrate = s.opts.Bitrate
+		tracks[i].Quality = s.opts.Quality
+		tracks[i].Filter = filter
+		tracks[i].Speed = speed
+		tracks[i].Bitexact = s.opts.Reproducible
+
+		if own.Format != "" || own.Codec != "" || own.Bitrate != "" || own.Quality != "" || own.Encode || own.Video {
+			if err := s.ownOutput(ctx, &tracks[i], own, decoded); err != nil {
+				return err
+			}
+		}
+		tracks[i].Cover = cover
+		if own.Cover != "" {
+			// The track's own artwork, from a YAML or TOML tracklist
+			tracks[i].Cover = own.Cover
+		}
+		tracks[i].ASCII = s.opts.ASCII
+		tracks[i].PadWidth = s.opts.PadWidth
+		tracks[i].InputArgs = inputArgs
+		tracks[i].ExtraArgs = extraArgs
+		tracks[i].Stream = stream
+
+		if s.opts.DiscDirs && tracks[i].MultiDisc {
+			tracks[i].Dir = filepath.Join(tracks[i].dir(), fmt.Sprintf("Disc %d", tracks[i].Disc))
+			tracks[i].MultiDisc = false
+		}
+
+		// Only boundaries with another track are faded, not the start and
+		// end of the mix
+		if tracks[i].Number > 1 {
+			tracks[i].FadeIn = s.opts.CrossfadeSplit
+		}
+		if tracks[i].End != "" {
+			tracks[i].FadeOut = s.opts.CrossfadeSplit
+		}
+
+		if s.opts.OutputTemplate != "" {
+			out, err := renderOutputTemplate(s.opts.OutputTemplate, tracks[i].outputFields(s.opts.Filename, s.opts.Vars), s.opts.ASCII)
+			if err != nil {
+				return err
+			}
+			tracks[i].Output = out
+		}
+
+		if s.opts.Output != "" {
+			// A single track, written exactly where asked
+			tracks[i].Output = s.opts.Output
+			continue
+		}
+
+		if s.opts.OutputDir != "" {
+			out := tracks[i].outputFilename(s.opts.Filename)
+			if !filepath.IsAbs(out) {
+				out = filepath.Join(s.opts.OutputDir, out)
+			}
+			tracks[i].Output = out
+		}
+	}
+
+	if s.opts.Output == "" {
+		if err := resolveCollisions(tracks, s.opts.Filename, s.opts.Collisions); err != nil {
+			return err
+		}
+	}
+
+	if err := s.preparePCM(ctx, tracks); err != nil {
+		return err
+	}
+
+	tagger, err := selectTagger(s.opts, ext)
+	if err != nil {
+		return err
+	}
+
+	if s.opts.Output == "-" {
+		// There is no file to tag afterwards, only the tags ffmpeg writes
+		tagger = "ffmpeg"
+	}
+	s.opts.Tagger = tagger
+
+	// ffmpeg writes the basic tags of every format while extracting, the
+	// tagger adds to them
+	if !taggers[tagger].Supports(ext) && cover != "" {
+		s.opts.logf("warning: the %v tagger cannot tag %v files, cover art will not be embedded\n", tagger, ext)
+	}
+
+	for i := range tracks {
+		tracks[i].Metadata = true
+	}
+
+	return nil
+}
+
+// outputFormat returns the extension of tracks with the given format, encoder
+// and video settings, and whether they are re-encoded.
+func (s *Splitter) outputFormat(ctx context.Context, format, codec string, encode, video bool) (string, bool, error) {
+	ext, reencode, err := outputFormat(ctx, s.opts.Filename, format)
+	if err != nil {
+		return "", false, err
+	}
+
+	if codec != "" && format == "" {
+		ext = encoderExt(codec)
+	}
+
+	if video {
+		// Clips stay in the source's container, which holds its streams
+		// without re-encoding
+		ext, reencode = sourceExt(s.opts.Filename), false
+		if ext == "" {
+			ext = ".mkv"
+		}
+	}
+
+	if encode || codec != "" {
+		reencode = true
+	}
+	return ext, reencode, nil
+}
+
+// ownOutput sets the output of a track whose directives choose its own
+// format or encoder settings over the run's.
+func (s *Splitter) ownOutput(ctx context.Context, t *Track, own Track, decoded bool) error {
+	if own.Codec != "" {
+		t.Codec = own.Codec
+	}
+	if own.Bitrate != "" {
+		t.Bitrate = own.Bitrate
+	}
+	if own.Quality != "" {
+		t.Quality = own.Quality
+	}
+	t.Video = t.Video || own.Video
+
+	format := own.Format
+	if format == "" {
+		format = s.opts.Format
+	}
+
+	encode := s.opts.Encode || own.Encode || t.Bitrate != "" || t.Quality != ""
+	ext, reencode, err := s.outputFormat(ctx, format, t.Codec, encode, t.Video)
+	if err != nil {
+		return fmt.Errorf("track %d: %v", t.Number, err)
+	}
+
+	t.Ext, t.Reencode = ext, reencode || decoded
+	return nil
+}
+
+// Split extracts and tags each track of the tracklist.
+func (s *Splitter) Split(ctx context.Context, tracks Tracklist) error {
+	if len(s.opts.Profiles) > 0 {
+		return s.splitProfiles(ctx, tracks)
+	}
+
+	ctx = s.opts.withOptions(ctx)
+	if err := s.prepare(ctx, tracks); err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	if s.opts.JSON {
+		s.opts.emitPlan(tracks)
+	}
+
+	// A run of the same source and tracklist to the same place is skipped,
+	// so batches and watched directories can be run again
+	record := s.opts.Output != "-" && !isURL(s.opts.Filename)
+	var source, sum string
+	if record {
+		var err error
+		source, sum, err = splitSums(s.opts, tracks)
+		if err != nil {
+			return err
+		}
+
+		if !s.opts.overwrites() && alreadySplit(s.opts, tracks, source, sum) {
+			s.opts.logf("%v was already split with these tracks, skipping it (see -force)\n", s.opts.Filename)
+			return nil
+		}
+	}
+
+	if s.opts.Normalize != "" {
+		if err := s.measureLoudness(ctx, tracks); err != nil {
+			return err
+		}
+	}
+
+	if s.opts.Lyrics != "" {
+		if err := fetchLyrics(ctx, s.opts, tracks); err != nil {
+			return err
+		}
+	}
+
+	sourceLyrics := sourceLyricsFile(s.opts)
+	if sourceLyrics != "" {
+		if err := applySourceLyrics(s.opts, tracks); err != nil {
+			return withKind(ErrInvalidInput, err)
+		}
+	}
+
+	if s.opts.Output == "-" {
+		return streamTrack(ctx, s.opts, tracks[0], os.Stdout)
+	}
+
+	outputs := make([]string, len(tracks))
+	for i, t := range tracks {
+		outputs[i] = t.outputFilename(s.opts.Filename)
+	}
+	kept, err := checkOverwrite(s.opts, outputs)
+	if err != nil {
+		return err
+	}
+
+	// Those kept are left as they are, not even tagged again
+	split := tracks
+	if len(kept) > 0 {
+		split = nil
+		for i, t := range tracks {
+			if !kept[outputs[i]] {
+				split = append(split, t)
+			}
+		}
+	}
+
+	if len(split) > 0 {
+		if err := splitTracks(ctx, s.opts, split); err != nil {
+			return err
+		}
+	}
+
+	if s.opts.Lyrics == "lrc" || s.opts.Lyrics == "both" || sourceLyrics != "" {
+		if err := writeLyricsFiles(s.opts, tracks); err != nil {
+			return err
+		}
+	}
+
+	if s.opts.Images != "" {
+		if err := renderTrackImages(ctx, s.opts, tracks); err != nil {
+			return err
+		}
+	}
+
+	if s.opts.Verify {
+		if err := verifyTracks(ctx, s.opts, tracks); err != nil {
+			return err
+		}
+	}
+
+	if s.opts.VerifyLossless {
+		if err := verifyLossless(ctx, s.opts, tracks); err != nil {
+			return err
+		}
+	}
+
+	if record {
+		if err := recordSplit(s.opts, tracks, source, sum); err != nil {
+			return err
+		}
+	}
+
+	if s.opts.Receipt {
+		if err := writeReceipts(ctx, s.opts, tracks, source); err != nil {
+			return err
+		}
+	}
+
+	if s.opts.Sidecars {
+		if err := writeSidecars(ctx, s.opts, tracks); err != nil {
+			return err
+		}
+	}
+
+	if s.opts.FetchCover != "" && s.opts.Cover != "" && s.opts.Cover != "auto" {
+		if err := saveCover(s.opts, tracks, s.opts.Cover); err != nil {
+			return err
+		}
+	}
+
+	if s.opts.Beets {
+		if err := writeBeetsManifest(s.opts, tracks); err != nil {
+			return err
+		}
+	}
+
+	if s.opts.PostAlbumCmd != "" {
+		return runHook(ctx, s.opts, "post-album-cmd", s.opts.PostAlbumCmd, albumHookEnv(s.opts, tracks))
+	}
+	return nil
+}
+
+// Tag writes the tags of tracks already split, say after fixing a title,
+// without extracting them again.
+func (s *Splitter) Tag(ctx context.Context, tracks Tracklist) error {
+	ctx = s.opts.withOptions(ctx)
+	if err := s.prepare(ctx, tracks); err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	if s.opts.Tagger == "ffmpeg" {
+		return fmt.Errorf("cannot retag %v tracks, ffmpeg only tags them while extracting", tracks[0].ext(s.opts.Filename))
+	}
+
+	if s.opts.Lyrics != "" {
+		if err := fetchLyrics(ctx, s.opts, tracks); err != nil {
+			return err
+		}
+	}
+
+	sourceLyrics := sourceLyricsFile(s.opts)
+	if sourceLyrics != "" {
+		if err := applySourceLyrics(s.opts, tracks); err != nil {
+			return withKind(ErrInvalidInput, err)
+		}
+	}
+
+	var missing []string
+	for _, t := range tracks {
+		out := t.outputFilename(s.opts.Filename)
+		if _, err := os.Stat(out); err != nil {
+			missing = append(missing, out)
+			continue
+		}
+
+		unlock, err := lockOutput(ctx, s.opts, out)
+		if err != nil {
+			return err
+		}
+
+		s.opts.logf("tagging track \"%v\"\n", out)
+		err = tagTrack(ctx, s.opts, t)
+		unlock()
+		if err != nil {
+			return trackError{t, err}
+		}
+	}
+
+	if len(missing) > 0 {
+		return withKind(failedKind(len(missing), len(tracks)), fmt.Errorf("%d tracks not found:\n%v", len(missing), strings.Join(missing, "\n")))
+	}
+
+	if s.opts.Lyrics == "lrc" || s.opts.Lyrics == "both" || sourceLyrics != "" {
+		return writeLyricsFiles(s.opts, tracks)
+	}
+	return nil
+}
+
+// measureLoudness runs the first loudnorm pass and sets the filter of each
+// track to normalize it. Album normalization measures all the tracks as one,
+// so every track gets the same gain and keeps its level relative to the rest.
+func (s *Splitter) measureLoudness(ctx context.Context, tracks Tracklist) error {
+	rate, err := probeSampleRate(ctx, s.opts.Filename)
+	if err != nil {
+		rate = ""
+	}
+
+	if s.opts.Normalize == "album" {
+		s.opts.logf("measuring album loudness\n")
+		m, err := measureLoudness(ctx, s.opts.Filename, tracks[0].Start, tracks[len(tracks)-1].End)
+		if err != nil {
+			return err
+		}
+
+		for i := range tracks {
+			tracks[i].Filter = loudnormFilter(m, rate)
+		}
+		return nil
+	}
+
+	for i := range tracks {
+		s.opts.logf("measuring loudness of track \"%v\"\n", tracks[i].outputFilename(s.opts.Filename))
+		m, err := measureLoudness(ctx, s.opts.Filename, tracks[i].Start, tracks[i].End)
+		if err != nil {
+			return err
+		}
+		tracks[i].Filter = loudnormFilter(m, rate)
+	}
+
+	return nil
+}
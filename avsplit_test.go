@@ -0,0 +1,280 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestTracklistSetEnds(t *testing.T) {
+	tests := []struct {
+		name   string
+		starts []string
+		want   []string
+	}{
+		{"one track", []string{"00:00:00"}, []string{""}},
+		{"two tracks", []string{"00:00:00", "00:03:10"}, []string{"00:03:10", ""}},
+		{
+			"many tracks",
+			[]string{"00:00:00", "00:03:10", "00:07:45", "01:00:00"},
+			[]string{"00:03:10", "00:07:45", "01:00:00", ""},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tl Tracklist
+			for _, s := range tt.starts {
+				// A stale End on the last track has to be cleared
+				tl = append(tl, Track{Start: s, End: "99:00:00"})
+			}
+
+			tl.SetEnds()
+
+			for i, want := range tt.want {
+				if tl[i].End != want {
+					t.Errorf("track %d: End = %q, want %q", i+1, tl[i].End, want)
+				}
+			}
+		})
+	}
+}
+
+func TestSanityCheck(t *testing.T) {
+	tests := []struct {
+		name   string
+		starts []string
+		total  time.Duration
+		want   []int
+	}{
+		{"one track", []string{"00:00:00"}, time.Hour, nil},
+		{"two tracks", []string{"00:00:00", "00:04:00"}, 8 * time.Minute, nil},
+		// The median of both would be over half the long track
+		{"two tracks, one implausible", []string{"00:00:00", "00:00:10"}, time.Hour, []int{2}},
+		{"first of two implausible", []string{"00:00:00", "00:59:50"}, time.Hour, []int{1}},
+		{
+			"many tracks",
+			[]string{"00:00:00", "00:04:00", "00:08:00", "00:12:00"},
+			3 * time.Hour,
+			[]int{4},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var tl Tracklist
+			for i, s := range tt.starts {
+				tl = append(tl, Track{Number: i + 1, Start: s})
+			}
+			tl.SetEnds()
+
+			warnings, err := sanityCheck(tl, tt.total)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var got []int
+			for _, w := range warnings {
+				var n int
+				fmt.Sscanf(w, "track %d", &n)
+				got = append(got, n)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("warned about tracks %v, want %v: %q", got, tt.want, warnings)
+			}
+		})
+	}
+}
+
+func TestParseTimecodesEnds(t *testing.T) {
+	tests := []struct {
+		name  string
+		input string
+		want  []string
+	}{
+		{"one track", "00:00:00 Intro\n", []string{""}},
+		{"two tracks", "00:00:00 Intro\n00:03:10 Outro\n", []string{"00:03:10", ""}},
+		{
+			"many tracks",
+			"00:00:00 One\n00:03:10 Two\n00:07:45 Three\n01:00:00 Four\n",
+			[]string{"00:03:10", "00:07:45", "01:00:00", ""},
+		},
+		{
+			"skipped stretches",
+			"00:00:00 One\n00:03:10 --\n00:04:00 Two\n00:07:45 [skip]\n00:09:00 Three\n",
+			[]string{"00:03:10", "00:07:45", ""},
+		},
+		{
+			"counted from the end",
+			"00:00:00 One\n-04:30 Bonus\n",
+			[]string{"-00:04:30", ""},
+		},
+		{
+			"works",
+			"# WORK Symphony No. 5\n00:00:00 I. Allegro\n00:07:30 II. Andante\n# WORK\n00:17:00 Encore\n",
+			[]string{"00:07:30", "00:17:00", ""},
+		},
+		{
+			"header",
+			"ARTIST: Miles Davis\nALBUM: Kind of Blue\nDATE: 1959\n\n00:00:00 So What\n00:09:22 Freddie Freeloader\n",
+			[]string{"00:09:22", ""},
+		},
+		{
+			"tabs, indents and dashes",
+			"  00:00:00\tOne\n\t\n00:03:10 \u2013 Two\n00:05:00\u201300:06:00 \u2014 Three\n",
+			[]string{"00:03:10", "00:05:00", "00:06:00"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tracks, err := ParseTimecodes(strings.NewReader(tt.input), Options{})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(tracks) != len(tt.want) {
+				t.Fatalf("got %d tracks, want %d", len(tracks), len(tt.want))
+			}
+
+			for i, want := range tt.want {
+				if tracks[i].End != want {
+					t.Errorf("track %d: End = %q, want %q", i+1, tracks[i].End, want)
+				}
+			}
+		})
+	}
+}
+
+func TestWriteVTTEscapes(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "chapters.vtt")
+	tracks := []Track{
+		{Number: 1, Start: "00:00", End: "01:00", Title: "Intro --> Verse"},
+		{Number: 2, Start: "01:00", Title: "Rock & Roll\n\n<Live>"},
+	}
+	if err := writeVTT(file, tracks, 2*time.Minute); err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "WEBVTT\n" +
+		"\n1\n00:00:00.000 --> 00:01:00.000\nIntro --&gt; Verse\n" +
+		"\n2\n00:01:00.000 --> 00:02:00.000\nRock &amp; Roll &lt;Live&gt;\n"
+	if string(got) != want {
+		t.Errorf("writeVTT() wrote\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestSelectTracks(t *testing.T) {
+	tracks := Tracklist{{Number: 1}, {Number: 2}, {Number: 3}, {Number: 4}}
+
+	got, err := selectTracks(tracks, "1,3-4")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(got) != 3 || got[0].Number != 1 || got[1].Number != 3 || got[2].Number != 4 {
+		t.Errorf("selectTracks(1,3-4) = %v, want tracks 1, 3 and 4", got)
+	}
+
+	// A huge range is never expanded, and named by its first missing track
+	if _, err := selectTracks(tracks, "2-999999999"); err == nil || err.Error() != "no track 5, there are 4 tracks" {
+		t.Errorf("selectTracks(2-999999999) = %v, want no track 5", err)
+	}
+	if _, err := selectTracks(tracks, "4-2"); err == nil {
+		t.Errorf("selectTracks(4-2) succeeded, want an error")
+	}
+}
+
+func TestParseTimecodesMaxLineBytes(t *testing.T) {
+	long := "00:00 " + strings.Repeat("x", 500) + "\n"
+
+	_, err := ParseTimecodes(strings.NewReader("00:00 Intro\n"+long), Options{MaxLineBytes: 100})
+	if err == nil || err.Error() != "line 2 is longer than 100 bytes, see -max-line-bytes" {
+		t.Errorf("ParseTimecodes() of a 506 byte line = %v, want it longer than 100 bytes", err)
+	}
+
+	if _, err := ParseTimecodes(strings.NewReader(long), Options{MaxLineBytes: 1000}); err != nil {
+		t.Errorf("ParseTimecodes() of a 506 byte line under 1000 = %v", err)
+	}
+}
+
+func TestLockOutputOtherProcess(t *testing.T) {
+	out := filepath.Join(t.TempDir(), "01 - Intro.mp3")
+	lock := filepath.Join(filepath.Dir(out), ".avsplit-01 - Intro.mp3.lock")
+
+	// Held by another run
+	if err := os.WriteFile(lock, []byte("1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*outputLockPoll)
+	defer cancel()
+	if _, err := lockOutput(ctx, Options{}, out); err != context.DeadlineExceeded {
+		t.Fatalf("lockOutput() of a held file = %v, want it to wait", err)
+	}
+
+	// Left by a run that was killed
+	old := time.Now().Add(-2 * outputLockStale)
+	if err := os.Chtimes(lock, old, old); err != nil {
+		t.Fatal(err)
+	}
+	unlock, err := lockOutput(context.Background(), Options{}, out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(lock); err != nil || time.Since(info.ModTime()) > time.Minute {
+		t.Errorf("lock file not claimed: %v", err)
+	}
+	unlock()
+	if _, err := os.Stat(lock); !os.IsNotExist(err) {
+		t.Errorf("lock file left after unlock: %v", err)
+	}
+}
+
+func TestApplyTagsCSVDiscs(t *testing.T) {
+	tracks := func() []Track {
+		return []Track{{Number: 1, Disc: 1, Title: "A"}, {Number: 2, Disc: 1, Title: "B"}, {Number: 1, Disc: 2, Title: "C"}}
+	}
+	csv := func(content string) map[tagsCSVKey]map[string]string {
+		file := filepath.Join(t.TempDir(), "tags.csv")
+		if err := os.WriteFile(file, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+		tags, err := readTagsCSV(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tags
+	}
+
+	got := tracks()
+	if err := applyTagsCSV(got, csv("disc,number,title\n2,1,Disc Two\n1,1,Disc One\n")); err != nil {
+		t.Fatal(err)
+	}
+	if got[0].Title != "Disc One" || got[1].Title != "B" || got[2].Title != "Disc Two" {
+		t.Errorf("titles = %q, %q, %q, want Disc One, B, Disc Two", got[0].Title, got[1].Title, got[2].Title)
+	}
+
+	// Track 1 of which disc
+	if err := applyTagsCSV(tracks(), csv("number,title\n1,Either\n")); err == nil {
+		t.Error("applyTagsCSV() by number alone on two discs succeeded")
+	}
+	if err := applyTagsCSV(tracks(), csv("disc,number,title\n3,1,None\n")); err == nil {
+		t.Error("applyTagsCSV() of a missing disc succeeded")
+	}
+
+	file := filepath.Join(t.TempDir(), "tags.csv")
+	if err := os.WriteFile(file, []byte("number,title\n1,A\n1,B\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readTagsCSV(file); err == nil {
+		t.Error("readTagsCSV() of a repeated track succeeded")
+	}
+}
@@ -0,0 +1,283 @@
+package avsplit
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// manifestKeys sets the option of each manifest key from its value.
+var manifestKeys = map[string]func(o *Options, v string) error{
+	"filename":        func(o *Options, v string) error { o.Filename, o.Filenames = v, nil; return nil },
+	"timecodes":       func(o *Options, v string) error { o.Timecodes, o.Cue, o.Audacity = v, "", ""; return nil },
+	"cue":             func(o *Options, v string) error { o.Cue, o.Timecodes, o.Audacity = v, "", ""; return nil },
+	"audacity":        func(o *Options, v string) error { o.Audacity, o.Timecodes, o.Cue = v, "", ""; return nil },
+	"artist":          func(o *Options, v string) error { o.Artist = v; return nil },
+	"album":           func(o *Options, v string) error { o.Album = v; return nil },
+	"year":            func(o *Options, v string) error { o.Year = v; return nil },
+	"genre":           func(o *Options, v string) error { o.Genre = v; return nil },
+	"comment":         func(o *Options, v string) error { o.Comment = v; return nil },
+	"cover":           func(o *Options, v string) error { o.Cover = v; return nil },
+	"tags-csv":        func(o *Options, v string) error { o.TagsCSV = v; return nil },
+	"dir-template":    func(o *Options, v string) error { o.DirTemplate = v; return nil },
+	"output-template": func(o *Options, v string) error { o.OutputTemplate = v; return nil },
+	"mb-release":      func(o *Options, v string) error { o.MBRelease = v; return nil },
+	"discogs-release": func(o *Options, v string) error { o.DiscogsRelease = v; return nil },
+	"disc":            func(o *Options, v string) error { return manifestInt(&o.Disc, v) },
+	"disc-total":      func(o *Options, v string) error { return manifestInt(&o.DiscTotal, v) },
+	"va":              func(o *Options, v string) error { return manifestBool(&o.VA, v) },
+	"youtube":         func(o *Options, v string) error { return manifestBool(&o.YouTube, v) },
+}
+
+// manifestPaths are the keys holding files, which are relative to the
+// manifest.
+var manifestPaths = map[string]bool{
+	"filename":  true,
+	"timecodes": true,
+	"cue":       true,
+	"audacity":  true,
+	"tags-csv":  true,
+	"cover":     true,
+}
+
+func manifestInt(p *int, v string) error {
+	n, err := strconv.Atoi(v)
+	if err != nil {
+		return fmt.Errorf("invalid number %v", v)
+	}
+	*p = n
+	return nil
+}
+
+func manifestBool(p *bool, v string) error {
+	b, err := strconv.ParseBool(v)
+	if err != nil {
+		return fmt.Errorf("invalid boolean %v", v)
+	}
+	*p = b
+	return nil
+}
+
+// parseManifest reads the subset of YAML a manifest needs: a list of
+// entries, each a mapping of keys to plain, single or double quoted scalars.
+//
+//   - filename: 2019-06-01.mkv
+//     timecodes: 2019-06-01.txt
+//     album: "Live at the Roxy"
+func parseManifest(r io.Reader) ([]map[string]string, error) {
+	var entries []map[string]string
+
+	s := bufio.NewScanner(r)
+	line := 0
+	for s.Scan() {
+		line++
+		text := strings.TrimRight(s.Text(), " \t\r")
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "-") {
+			entries = append(entries, map[string]string{})
+			trimmed = strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))
+			if trimmed == "" {
+				continue
+			}
+		} else if len(entries) == 0 || text[0] != ' ' && text[0] != '\t' {
+			return nil, fmt.Errorf("manifest: line %d: expected a \"- \" entry", line)
+		}
+
+		kv := strings.SplitN(trimmed, ":", 2)
+		if len(kv) != 2 {
+			return nil, fmt.Errorf("manifest: line %d: expected \"key: value\"", line)
+		}
+
+		key := strings.TrimSpace(kv[0])
+		value, err := manifestScalar(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return nil, fmt.Errorf("manifest: line %d: %v", line, err)
+		}
+
+		if _, ok := manifestKeys[key]; !ok {
+			return nil, fmt.Errorf("manifest: line %d: unknown key %v", line, key)
+		}
+		entries[len(entries)-1][key] = value
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read manifest: %v", err)
+	}
+
+	if len(entries) == 0 {
+		return nil, fmt.Errorf("manifest: no entries found")
+	}
+
+	return entries, nil
+}
+
+func manifestScalar(v string) (string, error) {
+	rest := ""
+	switch {
+	case strings.HasPrefix(v, `"`):
+		q, err := strconv.QuotedPrefix(v)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted value %v", v)
+		}
+		rest = v[len(q):]
+		v, _ = strconv.Unquote(q)
+	case strings.HasPrefix(v, "'"):
+		end := 1
+		for {
+			i := strings.Index(v[end:], "'")
+			if i < 0 {
+				return "", fmt.Errorf("invalid quoted value %v", v)
+			}
+			end += i + 1
+			if !strings.HasPrefix(v[end:], "'") {
+				break
+			}
+			end++
+		}
+		rest = v[end:]
+		v = strings.ReplaceAll(v[1:end-1], "''", "'")
+	default:
+		// A comment has to follow whitespace, so "#" in a plain value stays
+		if i := strings.Index(v, " #"); i >= 0 {
+			v = strings.TrimSpace(v[:i])
+		}
+		return v, nil
+	}
+
+	if rest = strings.TrimSpace(rest); rest != "" && !strings.HasPrefix(rest, "#") {
+		return "", fmt.Errorf("unexpected %v after quoted value", rest)
+	}
+	return v, nil
+}
+
+// SplitBatch splits each audio file listed in the manifest file. Every entry
+// starts from opts and overrides it with its own settings. The entries that
+// fail are reported together once the rest have been split.
+//
+// With opts.JobsDir each entry is kept as a job there, for "avsplit jobs" to
+// list, cancel and retry: running the manifest again skips the entries done
+// or canceled, and those canceled but retried since are split again.
+func SplitBatch(ctx context.Context, manifest string, opts Options) error {
+	f, err := os.Open(manifest)
+	if err != nil {
+		return fmt.Errorf("cannot read manifest")
+	}
+	defer f.Close()
+
+	entries, err := parseManifest(f)
+	if err != nil {
+		return err
+	}
+
+	dir := filepath.Dir(manifest)
+
+	var failed []string
+	for i, entry := range entries {
+		if ctx.Err() != nil {
+			return fmt.Errorf("interrupted after %d of %d manifest entries", i, len(entries))
+		}
+
+		entryOpts := opts
+		keys := make([]string, 0, len(entry))
+		for k := range entry {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+
+		for _, k := range keys {
+			v := entry[k]
+			if manifestPaths[k] && v != "" && v != "auto" && !isURL(v) && !filepath.IsAbs(v) {
+				v = filepath.Join(dir, v)
+			}
+
+			if err := manifestKeys[k](&entryOpts, v); err != nil {
+				return fmt.Errorf("manifest: entry %d: %v: %v", i+1, k, err)
+			}
+		}
+
+		if entryOpts.Filename == "" {
+			return fmt.Errorf("manifest: entry %d has no filename", i+1)
+		}
+
+		if opts.JobsDir != "" {
+			if err := splitBatchJob(ctx, manifest, i+1, len(entries), entryOpts); err != nil {
+				entryOpts.logf("error: %v: %v\n", entryOpts.Filename, err)
+				failed = append(failed, entryOpts.Filename)
+			}
+			continue
+		}
+
+		entryOpts.logf("splitting %v (%d of %d)\n", entryOpts.Filename, i+1, len(entries))
+		if err := Split(ctx, entryOpts); err != nil {
+			entryOpts.logf("error: %v: %v\n", entryOpts.Filename, err)
+			failed = append(failed, entryOpts.Filename)
+		}
+	}
+
+	if len(failed) > 0 {
+		return withKind(failedKind(len(failed), len(entries)), fmt.Errorf("%d of %d manifest entries failed: %v", len(failed), len(entries), strings.Join(failed, ", ")))
+	}
+
+	return nil
+}
+
+// splitBatchJob splits entry n of total of the manifest as a job under
+// opts.JobsDir, unless it is done or canceled already.
+func splitBatchJob(ctx context.Context, manifest string, n, total int, opts Options) error {
+	j, err := batchJob(opts.JobsDir, manifest, n, opts.Filename)
+	if err != nil {
+		return err
+	}
+
+	retry := j.takeMarker(retryMarker)
+	if j.takeMarker(cancelMarker) {
+		j.State = "canceled"
+		retry = false
+	}
+	if (j.State == "done" || j.State == "canceled") && !retry {
+		opts.logf("skipping %v (%d of %d), job %v is %v\n", opts.Filename, n, total, j.ID, j.State)
+		return j.save()
+	}
+
+	j.requeue()
+	j.State = "running"
+	if err := j.save(); err != nil {
+		return err
+	}
+
+	opts.logf("splitting %v (%d of %d), job %v\n", opts.Filename, n, total, j.ID)
+	jobCtx, cancel := j.watchCancel(ctx)
+	defer cancel()
+	err = Split(jobCtx, opts)
+
+	now := time.Now().UTC()
+	j.Finished = &now
+	switch {
+	case ctx.Err() != nil:
+		// Interrupted, the next run of the batch resumes it
+		j.State, j.Finished = "queued", nil
+	case jobCtx.Err() != nil:
+		opts.logf("canceled %v, job %v\n", opts.Filename, j.ID)
+		j.State, err = "canceled", nil
+	case err != nil:
+		j.State, j.Error = "failed", err.Error()
+	default:
+		j.State = "done"
+	}
+
+	if serr := j.save(); err == nil {
+		err = serr
+	}
+	return err
+}
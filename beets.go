@@ -0,0 +1,107 @@
+package avsplit
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+)
+
+// beetsManifestName is the file the beets manifest is written to, in the
+// staging directory.
+const beetsManifestName = "avsplit-beets.json"
+
+// beetsManifest describes the albums staged for beet import. The field names
+// are those of beets, so the IDs can be passed on as they are.
+type beetsManifest struct {
+	Dir    string       `json:"dir"`
+	Albums []beetsAlbum `json:"albums"`
+}
+
+type beetsAlbum struct {
+	Path        string      `json:"path"`
+	AlbumArtist string      `json:"albumartist"`
+	Album       string      `json:"album"`
+	Year        string      `json:"year,omitempty"`
+	MBAlbumID   string      `json:"mb_albumid,omitempty"`
+	Import      []string    `json:"import"`
+	Items       []beetsItem `json:"items"`
+}
+
+type beetsItem struct {
+	Path             string `json:"path"`
+	Title            string `json:"title"`
+	Artist           string `json:"artist"`
+	Track            int    `json:"track"`
+	TrackTotal       int    `json:"tracktotal"`
+	Disc             int    `json:"disc,omitempty"`
+	MBTrackID        string `json:"mb_trackid,omitempty"`
+	MBReleaseTrackID string `json:"mb_releasetrackid,omitempty"`
+}
+
+// writeBeetsManifest writes the manifest of the split tracks to the output
+// directory, one album per directory with the beet import command for it.
+func writeBeetsManifest(opts Options, tracks Tracklist) error {
+	m := beetsManifest{Dir: opts.OutputDir}
+	albums := make(map[string]*beetsAlbum)
+	for _, t := range tracks {
+		out := t.outputFilename(opts.Filename)
+		dir := filepath.Dir(out)
+
+		a, ok := albums[dir]
+		if !ok {
+			a = &beetsAlbum{
+				Path:        dir,
+				AlbumArtist: t.AlbumArtist,
+				Album:       t.Album,
+				Year:        t.Year,
+				MBAlbumID:   t.MBAlbumID,
+			}
+			albums[dir] = a
+		}
+
+		a.Items = append(a.Items, beetsItem{
+			Path:             out,
+			Title:            t.Title,
+			Artist:           t.Artist,
+			Track:            t.Number,
+			TrackTotal:       t.Total,
+			Disc:             t.Disc,
+			MBTrackID:        t.MBTrackID,
+			MBReleaseTrackID: t.MBReleaseTrackID,
+		})
+	}
+
+	var dirs []string
+	for dir := range albums {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		a := albums[dir]
+		a.Import = []string{"beet", "import"}
+		if a.MBAlbumID != "" {
+			// Matched to the same release without asking
+			a.Import = append(a.Import, "--search-id", a.MBAlbumID)
+		}
+		a.Import = append(a.Import, dir)
+		m.Albums = append(m.Albums, *a)
+	}
+
+	b, err := json.MarshalIndent(m, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	path := filepath.Join(opts.OutputDir, beetsManifestName)
+	if err := opts.writeFile(path, append(b, '\n')); err != nil {
+		return fmt.Errorf("cannot write beets manifest: %v", err)
+	}
+
+	opts.logf("staged for beets in %v, see %v\n", opts.OutputDir, path)
+	for _, a := range m.Albums {
+		opts.logf("  %v\n", shellCommand(a.Import[0], a.Import[1:]...))
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"text/tabwriter"
+	"time"
+)
+
+// benchLength and benchTracks are the length of the synthetic recording Bench
+// splits without an audio file, and how many tracks it and a file without a
+// tracklist are split into.
+const (
+	benchLength = 10 * time.Minute
+	benchTracks = 12
+)
+
+// BenchResult is how long Bench took to split the source one way.
+type BenchResult struct {
+	// Setting is "copy" for a stream copy, "encode" for the encoder
+	// settings of the options
+	Setting string
+	Jobs    int
+	Tracks  int
+	Wall    time.Duration
+	// Audio is how much audio was split, Bytes how big the source is
+	Audio time.Duration
+	Bytes int64
+}
+
+// Speed is how many times faster than real time the split ran.
+func (r BenchResult) Speed() float64 {
+	return r.Audio.Seconds() / r.Wall.Seconds()
+}
+
+// Throughput is how many megabytes of the source were split a second.
+func (r BenchResult) Throughput() float64 {
+	return float64(r.Bytes) / 1e6 / r.Wall.Seconds()
+}
+
+// Bench splits the audio file, or without one a synthetic recording made
+// with ffmpeg, by stream copy and with the encoder settings of opts, each
+// with 1 job and doubling up to opts.Jobs or as many as there are CPUs,
+// and returns how long each took. The tracks are those of the tracklist
+// opts gives or else evenly spaced ones, and are written to a temporary
+// directory removed after each run.
+func Bench(ctx context.Context, opts Options) ([]BenchResult, error) {
+	opts = opts.withDefaults()
+	ctx = opts.withOptions(ctx)
+	if err := checkTools(ctx, opts); err != nil {
+		return nil, withKind(ErrMissingTool, err)
+	}
+
+	tmp, err := os.MkdirTemp("", "avsplit-bench-*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.RemoveAll(tmp)
+
+	if len(opts.Filenames) > 0 {
+		opts.Filename = opts.Filenames[0]
+	}
+	if opts.Filename == "" {
+		opts.Filename = filepath.Join(tmp, "bench.mp3")
+		opts.logf("making a %v synthetic recording to split\n", benchLength)
+		err := execCommand(ctx, "ffmpeg", "-nostdin", "-y", "-loglevel", "error",
+			"-f", "lavfi", "-i", fmt.Sprintf("sine=frequency=440:sample_rate=44100:duration=%d", int(benchLength.Seconds())),
+			"-ac", "2", "-c:a", "libmp3lame", "-b:a", "192k", opts.Filename)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	info, err := os.Stat(opts.Filename)
+	if err != nil {
+		return nil, withKind(ErrInvalidInput, fmt.Errorf("audio file not found"))
+	}
+	total, err := probeDuration(ctx, opts.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks Tracklist
+	if opts.Timecodes != "" || opts.Cue != "" || opts.Audacity != "" || opts.DetectSilence || opts.FromChapters || opts.FLACCue {
+		if tracks, err = ReadTracklist(ctx, opts); err != nil {
+			return nil, err
+		}
+	} else {
+		for i := 0; i < benchTracks; i++ {
+			tracks = append(tracks, Track{
+				Number: i + 1,
+				Total:  benchTracks,
+				Title:  fmt.Sprintf("Track %d", i+1),
+				Start:  formatTimecode(total * time.Duration(i) / benchTracks),
+			})
+		}
+		tracks.SetEnds()
+	}
+
+	maxJobs := opts.Jobs
+	if maxJobs <= 1 {
+		maxJobs = runtime.NumCPU()
+	}
+	var jobs []int
+	for n := 1; n < maxJobs; n *= 2 {
+		jobs = append(jobs, n)
+	}
+	jobs = append(jobs, maxJobs)
+
+	// Only the timing is wanted, not the extras of a real split
+	opts.Force = true
+	opts.Verify, opts.VerifyLossless, opts.Receipt = false, false, false
+	opts.PostCmd, opts.PostAlbumCmd = "", ""
+
+	var results []BenchResult
+	for _, setting := range []string{"copy", "encode"} {
+		run := opts
+		run.Quiet = true
+		if setting == "copy" {
+			run.Encode, run.Format, run.Codec, run.Bitrate, run.Quality = false, "", "", "", ""
+		} else {
+			run.Encode = true
+		}
+
+		for _, n := range jobs {
+			run.Jobs = n
+			run.OutputDir = filepath.Join(tmp, fmt.Sprintf("%v-%d", setting, n))
+			opts.logf("splitting by %v with -jobs %d\n", setting, n)
+
+			started := time.Now()
+			if err := NewSplitter(run).Split(ctx, append(Tracklist(nil), tracks...)); err != nil {
+				return nil, fmt.Errorf("%v with -jobs %d: %v", setting, n, err)
+			}
+			results = append(results, BenchResult{
+				Setting: setting,
+				Jobs:    n,
+				Tracks:  len(tracks),
+				Wall:    time.Since(started),
+				Audio:   total,
+				Bytes:   info.Size(),
+			})
+
+			os.RemoveAll(run.OutputDir)
+		}
+	}
+	return results, nil
+}
+
+// WriteBench writes the results of Bench as a table, fastest of each
+// setting marked.
+func WriteBench(w io.Writer, results []BenchResult) error {
+	fastest := make(map[string]int)
+	for i, r := range results {
+		if k, ok := fastest[r.Setting]; !ok || r.Wall < results[k].Wall {
+			fastest[r.Setting] = i
+		}
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "SETTING\tJOBS\tTRACKS\tWALL\tSPEED\tMB/S\t")
+	for i, r := range results {
+		mark := ""
+		if fastest[r.Setting] == i {
+			mark = "fastest"
+		}
+		fmt.Fprintf(tw, "%v\t%d\t%d\t%v\t%.1fx\t%.1f\t%v\n", r.Setting, r.Jobs, r.Tracks, r.Wall.Round(time.Millisecond), r.Speed(), r.Throughput(), mark)
+	}
+	return tw.Flush()
+}
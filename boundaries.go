@@ -0,0 +1,209 @@
+package avsplit
+
+import (
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// moveTimecode returns tc moved by d, stopping at the start of the file. An
+// empty tc, the end of the file, stays empty.
+func moveTimecode(tc string, d time.Duration) (string, error) {
+	if tc == "" {
+		return "", nil
+	}
+
+	v, err := parseDuration(tc)
+	if err != nil {
+		return "", err
+	}
+
+	v += d
+	if v < 0 {
+		v = 0
+	}
+	return formatTimecode(v), nil
+}
+
+// shiftTracks moves every track boundary by offset, for tracklists that are
+// consistently early or late.
+func shiftTracks(tracks []Track, offset time.Duration) error {
+	for i := range tracks {
+		if t := &tracks[i]; t.cutsOnSamples() {
+			// Still cut on samples, moved by as many of them
+			shift := int64(math.Round(offset.Seconds() * float64(t.SampleRate)))
+			if t.StartSample += shift; t.StartSample < 0 {
+				t.StartSample = 0
+			}
+			if t.EndSample > 0 {
+				if t.EndSample += shift; t.EndSample < 1 {
+					t.EndSample = 1
+				}
+			}
+			t.setSampleTimes()
+			continue
+		}
+
+		var err error
+		if tracks[i].Start, err = moveTimecode(tracks[i].Start, offset); err != nil {
+			return err
+		}
+
+		if tracks[i].End, err = moveTimecode(tracks[i].End, offset); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// scaleTracks multiplies every track boundary by factor, to turn timecodes
+// of audio played at another speed into those of the file.
+func scaleTracks(tracks []Track, factor float64) error {
+	scale := func(tc string) (string, error) {
+		if tc == "" {
+			return "", nil
+		}
+
+		v, err := parseDuration(tc)
+		if err != nil {
+			return "", err
+		}
+		return formatTimecode(time.Duration(float64(v) * factor).Round(time.Millisecond)), nil
+	}
+
+	for i := range tracks {
+		var err error
+		if tracks[i].Start, err = scale(tracks[i].Start); err != nil {
+			return err
+		}
+
+		if tracks[i].End, err = scale(tracks[i].End); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// padTracks starts each track padStart earlier and ends it padEnd later, so
+// neighbouring tracks overlap by the margin instead of cutting anything off.
+func padTracks(tracks []Track, padStart, padEnd time.Duration) error {
+	for i := range tracks {
+		var err error
+		if tracks[i].Start, err = moveTimecode(tracks[i].Start, -padStart); err != nil {
+			return err
+		}
+
+		if tracks[i].End, err = moveTimecode(tracks[i].End, padEnd); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// fixOverlaps repairs the boundaries of tracklists pasted together: it sorts
+// the tracks by their start, drops those starting with another, merges those
+// starting less than mergeWithin after the one before into it, and cuts
+// ends running into the next track. Ends that are only the start of the
+// next line follow the tracks. The tracks are numbered again, and what was
+// changed is returned to be reported.
+func fixOverlaps(tracks Tracklist, mergeWithin time.Duration) (Tracklist, []string, error) {
+	type entry struct {
+		t       Track
+		start   time.Duration
+		implied bool
+	}
+
+	entries := make([]entry, len(tracks))
+	for i, t := range tracks {
+		start, err := parseDuration(t.Start)
+		if err != nil {
+			return nil, nil, lineErrorf(t.Line, "track %d: %v", t.Number, err)
+		}
+		implied := t.End == "" || (i < len(tracks)-1 && t.End == tracks[i+1].Start)
+		entries[i] = entry{t, start, implied}
+	}
+
+	var changes []string
+	if !sort.SliceIsSorted(entries, func(i, k int) bool { return entries[i].start < entries[k].start }) {
+		sort.SliceStable(entries, func(i, k int) bool { return entries[i].start < entries[k].start })
+		changes = append(changes, "sorted the tracks by their start")
+	}
+
+	var fixed []entry
+	for _, e := range entries {
+		if len(fixed) == 0 {
+			fixed = append(fixed, e)
+			continue
+		}
+
+		last := &fixed[len(fixed)-1]
+		prev := &last.t
+		gap := e.start - last.start
+		switch {
+		case gap == 0:
+			changes = append(changes, fmt.Sprintf("dropped track %d \"%v\", it starts with track %d at %v", e.t.Number, e.t.Title, prev.Number, e.t.Start))
+			if prev.Title == "" {
+				prev.Title = e.t.Title
+			}
+			continue
+		case gap < mergeWithin:
+			changes = append(changes, fmt.Sprintf("merged track %d \"%v\" into track %d, it starts %v after it", e.t.Number, e.t.Title, prev.Number, gap))
+			prev.End, last.implied = e.t.End, e.implied
+			continue
+		}
+		fixed = append(fixed, e)
+	}
+
+	for i := range fixed {
+		t := &fixed[i].t
+		if fixed[i].implied {
+			t.End = ""
+			if i < len(fixed)-1 {
+				t.End = fixed[i+1].t.Start
+			}
+			continue
+		}
+		if i == len(fixed)-1 {
+			continue
+		}
+
+		next := fixed[i+1]
+
+		end, err := parseDuration(t.End)
+		if err != nil {
+			return nil, nil, lineErrorf(t.Line, "track %d: %v", t.Number, err)
+		}
+		if end > next.start {
+			changes = append(changes, fmt.Sprintf("cut track %d \"%v\" to end at %v where track %d starts, not %v", t.Number, t.Title, next.t.Start, next.t.Number, t.End))
+			t.End = next.t.Start
+		}
+	}
+
+	if len(changes) == 0 {
+		return tracks, nil, nil
+	}
+
+	result := make(Tracklist, len(fixed))
+	for i, e := range fixed {
+		result[i] = e.t
+	}
+
+	if multiDisc(result) {
+		numberDiscs(result)
+		return result, changes, nil
+	}
+
+	// Numbered from the first track's number, which -track-start may set
+	first := tracks[0].Number
+	for _, t := range tracks {
+		if t.Number < first {
+			first = t.Number
+		}
+	}
+	for i := range result {
+		result[i].Number = first + i
+		result[i].Total = first + len(result) - 1
+	}
+	return result, changes, nil
+}
@@ -0,0 +1,306 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// chapterFormats lists the output extensions whose ffmpeg muxers can store
+// chapters.
+var chapterFormats = map[string]bool{
+	".m4a":  true,
+	".m4b":  true,
+	".mp4":  true,
+	".mov":  true,
+	".mkv":  true,
+	".mka":  true,
+	".webm": true,
+	".ogg":  true,
+	".opus": true,
+	".mp3":  true,
+}
+
+var ffmetadataEscaper = strings.NewReplacer(
+	"\\", "\\\\",
+	"=", "\\=",
+	";", "\\;",
+	"#", "\\#",
+	"\n", "\\\n",
+)
+
+// ffmetadata renders the tracks as chapters in ffmpeg's FFMETADATA1 format.
+func ffmetadata(tracks []Track, total time.Duration) (string, error) {
+	var b strings.Builder
+	b.WriteString(";FFMETADATA1\n")
+
+	for _, t := range tracks {
+		start, err := parseDuration(t.Start)
+		if err != nil {
+			return "", err
+		}
+
+		d, err := t.duration(total)
+		if err != nil {
+			return "", err
+		}
+
+		fmt.Fprintf(
+			&b,
+			"[CHAPTER]\nTIMEBASE=1/1000\nSTART=%d\nEND=%d\ntitle=%v\n",
+			start.Milliseconds(),
+			(start + d).Milliseconds(),
+			ffmetadataEscaper.Replace(t.Title),
+		)
+	}
+
+	return b.String(), nil
+}
+
+// addChapters writes a copy of the audio file to opts.AddChapters with the
+// tracks as chapters, stream copying everything else. With opts.DryRun the
+// chapters and the ffmpeg command are only printed.
+func addChapters(ctx context.Context, opts Options, tracks []Track) error {
+	audioFile, outputFile := opts.Filename, opts.AddChapters
+	ext := strings.ToLower(filepath.Ext(outputFile))
+	if !chapterFormats[ext] {
+		return fmt.Errorf("output format %v does not support chapters", ext)
+	}
+
+	kept, err := checkOverwrite(opts, []string{outputFile})
+	if err != nil || kept[outputFile] {
+		return err
+	}
+
+	total, err := probeDuration(ctx, audioFile)
+	if err != nil {
+		return err
+	}
+
+	meta, err := ffmetadata(tracks, total)
+	if err != nil {
+		return err
+	}
+
+	metaFile := "chapters.ffmeta"
+	if !opts.DryRun {
+		if metaFile, err = writeTempFile(meta); err != nil {
+			return err
+		}
+		defer os.Remove(metaFile)
+	}
+
+	args := []string{
+		"-nostdin",
+		opts.overwriteFlag(),
+		"-loglevel", "error",
+		"-i", argPath(audioFile),
+		"-i", metaFile,
+		"-map", "0",
+		"-map_metadata", "0",
+		"-map_chapters", "1",
+		"-c", "copy",
+		argPath(outputFile),
+	}
+
+	if opts.DryRun {
+		fmt.Fprintf(opts.logWriter(), "# %v\n%v\n", metaFile, meta)
+		fmt.Fprintln(opts.logWriter(), shellCommand(opts.FFmpegPath, args...))
+		return nil
+	}
+
+	if err = execCommand(ctx, "ffmpeg", args...); err != nil {
+		return err
+	}
+	return opts.setFilePerms(outputFile)
+}
+
+// writeTempFile writes FFMETADATA to a temporary file and returns its name.
+func writeTempFile(meta string) (string, error) {
+	f, err := os.CreateTemp("", "avsplit-*.ffmeta")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := f.WriteString(meta); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// chapterCodecs lists the audio codecs toChapters copies into each
+// container as they are. Matroska holds any codec.
+var chapterCodecs = map[string][]string{
+	".m4a":  {"aac", "alac"},
+	".m4b":  {"aac", "alac"},
+	".mp4":  {"aac", "alac", "mp3"},
+	".mov":  {"aac", "alac"},
+	".ogg":  {"vorbis", "opus", "flac"},
+	".opus": {"opus"},
+	".webm": {"opus", "vorbis"},
+	".mp3":  {"mp3"},
+}
+
+// chapterEncoders are the encoders toChapters re-encodes into each
+// container with when the source's codec doesn't fit it.
+var chapterEncoders = map[string]string{
+	".m4a":  "aac",
+	".m4b":  "aac",
+	".mp4":  "aac",
+	".mov":  "aac",
+	".ogg":  "libvorbis",
+	".opus": "libopus",
+	".webm": "libopus",
+	".mp3":  "libmp3lame",
+}
+
+// chapterCoverExts lists the containers toChapters embeds cover art in.
+var chapterCoverExts = map[string]bool{
+	".m4a": true,
+	".m4b": true,
+	".mp4": true,
+	".mov": true,
+	".mp3": true,
+}
+
+// toChapters writes the audio of the source to opts.ToChapters, one file
+// with the tracks as its chapters, such as an audiobook. The audio is
+// stream copied when its codec fits the container and re-encoded with
+// opts.Codec, Bitrate and Quality otherwise, and the file is tagged with the
+// release's tags and cover art.
+func toChapters(ctx context.Context, opts Options, tracks []Track) error {
+	ext := strings.ToLower(filepath.Ext(opts.ToChapters))
+	if !chapterFormats[ext] {
+		return withKind(ErrInvalidInput, fmt.Errorf("output format %v does not support chapters", ext))
+	}
+
+	kept, err := checkOverwrite(opts, []string{opts.ToChapters})
+	if err != nil || kept[opts.ToChapters] {
+		return err
+	}
+
+	total, err := probeDuration(ctx, opts.Filename)
+	if err != nil {
+		return err
+	}
+
+	meta, err := ffmetadata(tracks, total)
+	if err != nil {
+		return err
+	}
+
+	codec := opts.Codec
+	if codec == "" {
+		source, err := probeAudioCodec(ctx, opts.Filename)
+		if err != nil {
+			return fmt.Errorf("cannot determine the audio codec: %v", strings.TrimSpace(err.Error()))
+		}
+
+		encoder, ok := chapterEncoders[ext]
+		if !ok {
+			// Matroska holds the source's codec, re-encoded only when asked
+			encoder = "libopus"
+		}
+
+		fits := !ok
+		for _, c := range chapterCodecs[ext] {
+			fits = fits || c == source
+		}
+
+		codec = "copy"
+		if !fits || opts.Encode || opts.Bitrate != "" || opts.Quality != "" {
+			codec = encoder
+		}
+	}
+
+	cover := ""
+	if opts.Cover != "" {
+		if cover, err = findCover(opts.Filename, opts.Cover); err != nil {
+			return err
+		}
+		if cover != "" && !chapterCoverExts[ext] {
+			opts.logf("warning: cover art cannot be embedded in %v files\n", ext)
+			cover = ""
+		}
+	}
+
+	stream, err := parseAudioStream(opts.AudioStream)
+	if err != nil {
+		return err
+	}
+	if stream == "" {
+		stream = "a:0"
+	}
+
+	metaFile := "chapters.ffmeta"
+	if !opts.DryRun {
+		if metaFile, err = writeTempFile(meta); err != nil {
+			return err
+		}
+		defer os.Remove(metaFile)
+	}
+
+	args := []string{"-nostdin", opts.overwriteFlag(), "-loglevel", "error", "-i", argPath(opts.Filename), "-i", metaFile}
+	if cover != "" {
+		args = append(args, "-i", argPath(cover))
+	}
+	args = append(args, "-map", "0:"+stream, "-map_metadata", "0", "-map_chapters", "1", "-c:a", codec)
+	if opts.Bitrate != "" {
+		args = append(args, "-b:a", opts.Bitrate)
+	}
+	if opts.Quality != "" {
+		args = append(args, "-q:a", opts.Quality)
+	}
+	if cover != "" {
+		args = append(args, "-map", "2:v", "-c:v", "copy", "-disposition:v", "attached_pic")
+	}
+
+	// The release is the file, the album its title
+	t := tracks[0]
+	for _, m := range [][2]string{
+		{"title", t.Album},
+		{"album", t.Album},
+		{"artist", t.AlbumArtist},
+		{"album_artist", t.AlbumArtist},
+		{"composer", t.Composer},
+		{"date", t.Year},
+		{"genre", t.Genre},
+		{"comment", t.Comment},
+	} {
+		if m[1] != "" {
+			args = append(args, "-metadata", m[0]+"="+m[1])
+		}
+	}
+	args = append(args, argPath(opts.ToChapters))
+
+	if opts.DryRun {
+		fmt.Fprintf(opts.logWriter(), "# %v\n%v\n", metaFile, meta)
+		fmt.Fprintln(opts.logWriter(), shellCommand(opts.FFmpegPath, args...))
+		return nil
+	}
+
+	if dir := filepath.Dir(opts.ToChapters); dir != "." {
+		if err := opts.mkdirAll(dir); err != nil {
+			return err
+		}
+	}
+
+	if err := execCommand(ctx, "ffmpeg", args...); err != nil {
+		return err
+	}
+	if err := opts.setFilePerms(opts.ToChapters); err != nil {
+		return err
+	}
+	opts.logf("wrote %d chapters to %v\n", len(tracks), opts.ToChapters)
+	return nil
+}
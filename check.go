@@ -0,0 +1,288 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Diagnostic is a problem Check found in a tracklist or the setup to split
+// it. Line is the line of the tracklist it is about, 0 when it isn't about
+// a line.
+type Diagnostic struct {
+	Severity string `json:"severity"`
+	File     string `json:"file,omitempty"`
+	Line     int    `json:"line,omitempty"`
+	Track    int    `json:"track,omitempty"`
+	Message  string `json:"message"`
+
+	kind ErrorKind
+}
+
+func (d Diagnostic) String() string {
+	s := ""
+	if d.File != "" {
+		s = d.File + ":"
+		if d.Line > 0 {
+			s += strconv.Itoa(d.Line) + ":"
+		}
+		s += " "
+	}
+	return s + d.Severity + ": " + d.Message
+}
+
+// tracklistFile returns the tracklist file opts reads the tracks from, ""
+// when they come from somewhere else.
+func (o Options) tracklistFile() string {
+	switch {
+	case o.Cue != "":
+		return o.Cue
+	case o.Audacity != "":
+		return o.Audacity
+	}
+	return o.Timecodes
+}
+
+// Check validates a split with opts without reading the audio: the tools it
+// needs, the syntax of the tracklist file, the order of its timecodes, its
+// titles and whether output files collide. It returns what it found, and an
+// error of the kind of the first error found, or of any warning with
+// opts.Strict. The changes opts.FixOverlaps would make are notes.
+func Check(ctx context.Context, opts Options) ([]Diagnostic, error) {
+	opts = opts.withDefaults()
+	ctx = opts.withOptions(ctx)
+
+	var diags []Diagnostic
+	file := opts.tracklistFile()
+	report := func(severity string, kind ErrorKind, line, track int, format string, a ...interface{}) {
+		d := Diagnostic{Severity: severity, Line: line, Track: track, Message: fmt.Sprintf(format, a...), kind: kind}
+		if line > 0 {
+			// The line is given apart, not again in the message
+			d.File = file
+			d.Message = strings.TrimPrefix(d.Message, fmt.Sprintf("line %d: ", line))
+		}
+		diags = append(diags, d)
+	}
+
+	checkToolsDiagnostics(ctx, opts, report)
+
+	if opts.Filename != "" && !isURL(opts.Filename) {
+		if _, err := os.Stat(opts.Filename); err != nil {
+			report("error", ErrInvalidInput, 0, 0, "audio file %v not found", opts.Filename)
+		}
+	}
+
+	if file == "" || opts.DetectSilence || opts.FromChapters {
+		report("error", ErrInvalidInput, 0, 0, "check reads the tracks from a -timecodes, -cue or -audacity file")
+		return diags, checkResult(diags, opts.Strict)
+	}
+
+	var tracks Tracklist
+	var err error
+	switch {
+	case opts.Cue != "":
+		tracks, err = readCue(opts)
+	case opts.Audacity != "":
+		tracks, err = readAudacityLabels(opts)
+	default:
+		tracks, err = readTimecodes(opts)
+	}
+	if err != nil {
+		report("error", ErrParse, errorLine(err), 0, "%v", err)
+		return diags, checkResult(diags, opts.Strict)
+	}
+
+	if !opts.exportOnly() && (tracks[0].AlbumArtist == "" || tracks[0].Album == "") {
+		report("error", ErrInvalidInput, 0, 0, "artist and album are required")
+	}
+
+	fromEnd := false
+	for _, t := range tracks {
+		fromEnd = fromEnd || strings.HasPrefix(t.Start, "-") || strings.HasPrefix(t.End, "-")
+	}
+
+	if fromEnd {
+		report("warning", ErrInvalidInput, 0, 0, "timecodes counted from the end need the length of the audio file, not checking their order")
+	} else {
+		if opts.Offset != 0 {
+			if err := shiftTracks(tracks, opts.Offset); err != nil {
+				report("error", ErrInvalidInput, errorLine(err), 0, "%v", err)
+			}
+		}
+
+		if opts.FixOverlaps {
+			fixed, changes, err := fixOverlaps(tracks, opts.MergeWithin)
+			if err != nil {
+				report("error", ErrInvalidInput, errorLine(err), 0, "%v", err)
+			} else {
+				tracks = fixed
+			}
+			for _, c := range changes {
+				report("note", ErrInvalidInput, 0, 0, "%v", c)
+			}
+		}
+
+		warnings, err := validateTracks(tracks, 0)
+		if err != nil {
+			report("error", ErrInvalidInput, errorLine(err), 0, "%v", err)
+		}
+		if err := checkMinLength(tracks, 0, opts.MinTrackLength); err != nil {
+			report("error", ErrInvalidInput, errorLine(err), 0, "%v", err)
+		}
+		for _, w := range warnings {
+			report("warning", ErrInvalidInput, 0, 0, "%v", w)
+		}
+	}
+
+	checkTitles(opts, tracks, report)
+	if err := checkOutputs(opts, tracks, report); err != nil {
+		report("error", ErrInvalidInput, 0, 0, "%v", err)
+	}
+
+	return diags, checkResult(diags, opts.Strict)
+}
+
+// reportFunc adds a diagnostic to the result of Check.
+type reportFunc func(severity string, kind ErrorKind, line, track int, format string, a ...interface{})
+
+// checkToolsDiagnostics reports each missing tool, and a tagger that isn't
+// installed, a warning as another one is used instead.
+func checkToolsDiagnostics(ctx context.Context, opts Options, report reportFunc) {
+	if err := checkTool(ctx, "ffmpeg", "ffmpeg-path", opts.FFmpegPath); err != nil {
+		report("error", ErrMissingTool, 0, 0, "%v", err)
+	}
+	if err := checkTool(ctx, "ffprobe", "ffprobe-path", opts.FFprobePath); err != nil {
+		report("error", ErrMissingTool, 0, 0, "%v", err)
+	}
+	if _, err := exec.LookPath("yt-dlp"); opts.FromURL != "" && err != nil {
+		report("error", ErrMissingTool, 0, 0, "yt-dlp not found, install it to use -from-url")
+	}
+
+	if tg, ok := taggers[opts.Tagger]; !ok && opts.Tagger != "auto" {
+		report("error", ErrInvalidInput, 0, 0, "unknown tagger %v", strconv.Quote(opts.Tagger))
+	} else if ok && !tg.Available() {
+		report("warning", ErrMissingTool, 0, 0, "tagger %v is not installed, another one will tag the tracks", opts.Tagger)
+	}
+}
+
+func validTimecode(s string) bool {
+	_, err := normalizeTimecode(s)
+	return err == nil
+}
+
+// checkTitles reports tracks without a title, titles that look like the
+// timecode of another line and titles shared by several tracks.
+func checkTitles(opts Options, tracks Tracklist, report reportFunc) {
+	untitled := opts.AutoTitle != "" || opts.looksUpRelease()
+	seen := make(map[string]int)
+	for _, t := range tracks {
+		title := strings.TrimSpace(t.Title)
+		if title == "" {
+			if !untitled {
+				report("warning", ErrInvalidInput, t.Line, t.Number, "track %d has no title", t.Number)
+			}
+			continue
+		}
+
+		if first := strings.Fields(title)[0]; strings.Contains(first, ":") && validTimecode(first) {
+			report("warning", ErrInvalidInput, t.Line, t.Number, "title of track %d \"%v\" starts with a timecode, are two lines joined?", t.Number, title)
+		}
+
+		key := strings.ToLower(title)
+		if first, ok := seen[key]; ok {
+			report("warning", ErrInvalidInput, t.Line, t.Number, "track %d has the same title as track %d, \"%v\"", t.Number, first, title)
+			continue
+		}
+		seen[key] = t.Number
+	}
+}
+
+// checkOutputs works out the output file of each track as a split would,
+// with the source's extension unless opts.Format chooses one, and reports
+// files written by several tracks.
+func checkOutputs(opts Options, tracks Tracklist, report reportFunc) error {
+	tracks = append(Tracklist(nil), tracks...)
+	if opts.TitleTemplate != "" {
+		if err := applyTitleTemplate(tracks, opts.TitleTemplate, opts.Filename, opts.Vars); err != nil {
+			return err
+		}
+	}
+
+	if opts.DirTemplate != "" {
+		if err := applyDirTemplate(tracks, opts.DirTemplate, opts.ASCII); err != nil {
+			return err
+		}
+	}
+
+	for i := range tracks {
+		t := &tracks[i]
+		t.ASCII = opts.ASCII
+		t.PadWidth = opts.PadWidth
+		if opts.Format != "" {
+			t.Ext = "." + strings.ToLower(strings.TrimPrefix(opts.Format, "."))
+		}
+
+		if opts.OutputTemplate != "" {
+			out, err := renderOutputTemplate(opts.OutputTemplate, t.outputFields(opts.Filename, opts.Vars), opts.ASCII)
+			if err != nil {
+				return err
+			}
+			t.Output = out
+		}
+
+		if opts.OutputDir != "" {
+			out := t.outputFilename(opts.Filename)
+			if !filepath.IsAbs(out) {
+				out = filepath.Join(opts.OutputDir, out)
+			}
+			t.Output = out
+		}
+	}
+
+	if opts.Output != "" {
+		return nil
+	}
+
+	seen := make(map[string]int)
+	for _, t := range tracks {
+		out := t.outputFilename(opts.Filename)
+		first, ok := seen[collisionKey(out)]
+		if !ok {
+			seen[collisionKey(out)] = t.Number
+			continue
+		}
+
+		if opts.Collisions == "fail" {
+			report("error", ErrInvalidInput, t.Line, t.Number, "tracks %d and %d are both written to %v", first, t.Number, out)
+		} else {
+			report("warning", ErrInvalidInput, t.Line, t.Number, "tracks %d and %d are both written to %v, track %d will be renamed, see -collisions", first, t.Number, out, t.Number)
+		}
+	}
+	return nil
+}
+
+// checkResult returns the error Check returns for diags.
+func checkResult(diags []Diagnostic, strict bool) error {
+	errs := 0
+	kind := ErrOther
+	for _, d := range diags {
+		if d.Severity == "error" || (strict && d.Severity == "warning") {
+			if errs == 0 {
+				kind = d.kind
+			}
+			errs++
+		}
+	}
+
+	switch errs {
+	case 0:
+		return nil
+	case 1:
+		return &Error{kind, fmt.Errorf("check found 1 problem")}
+	}
+	return &Error{kind, fmt.Errorf("check found %d problems", errs)}
+}
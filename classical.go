@@ -0,0 +1,50 @@
+package avsplit
+
+import (
+	"regexp"
+	"strings"
+)
+
+// classicalMarker matches a "# WORK Symphony No. 5" or "# COMPOSER Brahms"
+// line of a timecodes file, which sets the work or composer of the tracks
+// after it. A "# WORK" line without a name ends the work.
+var classicalMarker = regexp.MustCompile(`^#\s*(?i:(work|composer))(?:\s+(.*))?$`)
+
+// parseClassicalMarker returns the field, work or composer, and the value a
+// line sets, if it is a work or composer marker.
+func parseClassicalMarker(line string) (string, string, bool) {
+	m := classicalMarker.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return "", "", false
+	}
+
+	field := strings.ToLower(m[1])
+	if field == "composer" && strings.TrimSpace(m[2]) == "" {
+		return "", "", false
+	}
+	return field, strings.TrimSpace(m[2]), true
+}
+
+// numberMovements numbers the tracks of each work as its movements, the
+// tracks in a row with the same work. A movement given already, as with
+// "@movement=3", is kept.
+func numberMovements(tracks Tracklist) {
+	for i := 0; i < len(tracks); {
+		j := i + 1
+		for j < len(tracks) && tracks[j].Work == tracks[i].Work {
+			j++
+		}
+
+		if tracks[i].Work != "" {
+			for k := i; k < j; k++ {
+				if tracks[k].Movement == 0 {
+					tracks[k].Movement = k - i + 1
+				}
+				if tracks[k].Movements == 0 {
+					tracks[k].Movements = j - i
+				}
+			}
+		}
+		i = j
+	}
+}
@@ -0,0 +1,212 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/berryp/avsplit"
+)
+
+// commands are the subcommands, split when none is given.
+var commands = map[string]string{
+	"split":   "Split the audio file into tracks (the default)",
+	"plan":    "Print the tracks and the commands a split would run",
+	"tag":     "Write the tags of tracks split earlier again",
+	"detect":  "Find the tracks by silence and print them as a timecodes file",
+	"probe":   "Print the length, codec and chapters of the audio file",
+	"watch":   "Split the audio files that appear in a directory: watch [flags] dir",
+	"check":   "Check the tracklist, output files and tools without reading the audio",
+	"preview": "Play the audio around each split point, or those of -tracks, with ffplay",
+	"serve":   "Run an HTTP API to submit splits and fetch their tracks",
+	"web":     "Run a web page to split a file from a browser",
+	"jobs":    "List the jobs of serve, web and -batch, or cancel or retry one: jobs [list|cancel id|retry id]",
+	"rename":  "Move the tracks of a -plan or -dir to the names the output flags give them now",
+	"join":    "Join the tracks of a -plan or -dir back into the one -output file, with a chapter for each",
+	"bench":   "Time splits of the audio file, or a synthetic one, by copy and encode with 1 to -jobs jobs",
+}
+
+var commandOrder = []string{"split", "plan", "tag", "rename", "join", "detect", "probe", "watch", "check", "preview", "serve", "web", "jobs", "bench"}
+
+// The exit codes, for scripts to tell failures apart.
+const (
+	exitError        = 1
+	exitInvalidInput = 2
+	exitParse        = 3
+	exitMissingTool  = 4
+	exitPartial      = 5
+	exitFailed       = 6
+)
+
+var exitCodes = map[avsplit.ErrorKind]int{
+	avsplit.ErrOther:        exitError,
+	avsplit.ErrInvalidInput: exitInvalidInput,
+	avsplit.ErrParse:        exitParse,
+	avsplit.ErrMissingTool:  exitMissingTool,
+	avsplit.ErrPartial:      exitPartial,
+	avsplit.ErrFailed:       exitFailed,
+}
+
+// exitCode returns the exit code of a run that failed with err.
+func exitCode(err error) int {
+	return exitCodes[avsplit.KindOf(err)]
+}
+
+func usage() {
+	w := flag.CommandLine.Output()
+	fmt.Fprintf(w, "Usage: avsplit [command] [flags]\n\nCommands:\n")
+	for _, c := range commandOrder {
+		fmt.Fprintf(w, "  %-8v%v\n", c, commands[c])
+	}
+	fmt.Fprintf(w, `
+Exit codes:
+  %d  error
+  %d  invalid flags or input, or tracks that fail -strict checks
+  %d  the tracklist cannot be read
+  %d  ffmpeg or another required tool is missing
+  %d  some tracks failed, the rest were split
+  %d  every track failed
+`, exitError, exitInvalidInput, exitParse, exitMissingTool, exitPartial, exitFailed)
+	fmt.Fprintf(w, "\nFlags:\n")
+	flag.PrintDefaults()
+}
+
+// splitCommand returns the subcommand at the start of args, and the
+// arguments after it.
+func splitCommand(args []string) (string, []string) {
+	if len(args) > 0 && commands[args[0]] != "" {
+		return args[0], args[1:]
+	}
+	return "split", args
+}
+
+func runDetect(ctx context.Context, opts avsplit.Options, w io.Writer) error {
+	opts.Filename = opts.Filenames[0]
+	opts.DetectSilence = true
+	opts.Timecodes, opts.Cue, opts.Audacity, opts.FromChapters, opts.FLACCue = "", "", "", false, false
+
+	tracks, err := avsplit.ReadTracklist(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return avsplit.WriteTimecodes(w, tracks)
+}
+
+func runProbe(ctx context.Context, opts avsplit.Options, w io.Writer) error {
+	opts.Filename = opts.Filenames[0]
+	info, err := avsplit.Probe(ctx, opts)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(w, "file:        %v\n", opts.Filename)
+	fmt.Fprintf(w, "duration:    %v\n", info.Duration.Round(time.Millisecond))
+	fmt.Fprintf(w, "codec:       %v\n", info.Codec)
+	if info.SampleRate != "" {
+		fmt.Fprintf(w, "sample rate: %v Hz\n", strings.TrimSpace(info.SampleRate))
+	}
+	fmt.Fprintf(w, "chapters:    %d\n", len(info.Chapters))
+	if len(info.Chapters) > 0 {
+		return avsplit.WriteTimecodes(w, info.Chapters)
+	}
+	return nil
+}
+
+// runCheck prints the problems found with the tracklist, one per line or
+// as JSON lines.
+func runCheck(ctx context.Context, opts avsplit.Options, w io.Writer) error {
+	if len(opts.Filenames) == 1 {
+		opts.Filename = opts.Filenames[0]
+	}
+
+	diags, err := avsplit.Check(ctx, opts)
+	for _, d := range diags {
+		if opts.JSON {
+			b, _ := json.Marshal(d)
+			fmt.Fprintln(w, string(b))
+			continue
+		}
+		fmt.Fprintln(w, d)
+	}
+
+	if err == nil && !opts.JSON && !opts.Quiet {
+		fmt.Fprintln(w, "ok")
+	}
+	return err
+}
+
+func runBench(ctx context.Context, opts avsplit.Options, w io.Writer) error {
+	results, err := avsplit.Bench(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return avsplit.WriteBench(w, results)
+}
+
+// validJobsArgs reports whether args are those of the jobs command.
+func validJobsArgs(args []string) bool {
+	switch {
+	case len(args) == 0:
+		return true
+	case args[0] == "list":
+		return len(args) == 1
+	case args[0] == "cancel" || args[0] == "retry":
+		return len(args) == 2
+	}
+	return false
+}
+
+// runJobs lists the jobs in dir, or cancels or retries the one args name.
+func runJobs(dir string, args []string, w io.Writer) error {
+	if dir == "" {
+		dir = "avsplit-jobs"
+	}
+
+	if len(args) < 2 {
+		return avsplit.WriteJobs(w, dir)
+	}
+
+	if args[0] == "cancel" {
+		return avsplit.CancelJob(dir, args[1])
+	}
+	return avsplit.RetryJob(dir, args[1])
+}
+
+// lookupProviders builds the provider of -lookup, each of those it lists
+// asked once for each release and in turn.
+func lookupProviders(list string) (avsplit.MetadataProvider, error) {
+	var providers []avsplit.MetadataProvider
+	for _, name := range strings.Split(list, ",") {
+		var p avsplit.MetadataProvider
+		switch name = strings.TrimSpace(name); name {
+		case "musicbrainz":
+			p = avsplit.MusicBrainzProvider()
+		case "discogs":
+			p = avsplit.DiscogsProvider()
+		default:
+			if fi, err := os.Stat(name); err != nil || !fi.IsDir() {
+				return nil, fmt.Errorf("invalid lookup %v, must be musicbrainz, discogs or a directory", name)
+			}
+			p = avsplit.LocalProvider(name)
+		}
+		providers = append(providers, avsplit.CacheProvider(p))
+	}
+
+	if len(providers) == 1 {
+		return providers[0], nil
+	}
+	return avsplit.ChainProviders(providers...), nil
+}
+
+// singleFile checks that the command was given one audio file.
+func singleFile(command string, filenames []string) {
+	if len(filenames) != 1 {
+		fmt.Fprintf(os.Stderr, "error: %v takes a single -filename\n", command)
+		os.Exit(exitInvalidInput)
+	}
+}
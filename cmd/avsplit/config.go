@@ -0,0 +1,138 @@
+package main
+
+import (
+	"bufio"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// configPath returns the config file named by -config on the command line,
+// or the default one if it exists. explicit is false for the default.
+func configPath(args []string) (path string, explicit bool) {
+	for i, a := range args {
+		name := strings.TrimLeft(a, "-")
+		if a == "--" || !strings.HasPrefix(a, "-") {
+			break
+		}
+
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config="), true
+		}
+
+		if name == "config" && i+1 < len(args) {
+			return args[i+1], true
+		}
+	}
+
+	dir, err := os.UserConfigDir()
+	if err != nil {
+		return "", false
+	}
+
+	for _, name := range []string{"config.toml", "config.yaml", "config.yml"} {
+		p := filepath.Join(dir, "avsplit", name)
+		if _, err := os.Stat(p); err == nil {
+			return p, false
+		}
+	}
+	return "", false
+}
+
+// loadConfig sets the flag defaults from a config file. Its keys are flag
+// names, set as "key = value" in TOML files or "key: value" in YAML files.
+// Flags given on the command line still override them.
+func loadConfig(path string) error {
+	return readSettings(path, "config", func(key, value string) error {
+		// TOML keys are often written with underscores
+		key = strings.ReplaceAll(key, "_", "-")
+		if key == "config" || flag.Lookup(key) == nil {
+			return fmt.Errorf("unknown setting %v", key)
+		}
+
+		if err := flag.Set(key, value); err != nil {
+			return fmt.Errorf("invalid %v: %v", key, err)
+		}
+		return nil
+	})
+}
+
+// loadVars reads the template variables in a vars file, in the same TOML or
+// YAML form as a config file.
+func loadVars(path string, vars map[string]string) error {
+	return readSettings(path, "vars", func(key, value string) error {
+		vars[key] = value
+		return nil
+	})
+}
+
+// readSettings calls set with each key and value of a TOML or YAML settings
+// file, told apart by its extension. kind names the file in errors.
+func readSettings(path, kind string, set func(key, value string) error) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return fmt.Errorf("cannot read %v file: %v", kind, err)
+	}
+	defer f.Close()
+
+	sep := ":"
+	if strings.ToLower(filepath.Ext(path)) == ".toml" {
+		sep = "="
+	}
+
+	s := bufio.NewScanner(f)
+	line := 0
+	for s.Scan() {
+		line++
+		text := strings.TrimSpace(s.Text())
+		if text == "" || strings.HasPrefix(text, "#") || text == "---" {
+			continue
+		}
+
+		kv := strings.SplitN(text, sep, 2)
+		if len(kv) != 2 {
+			return fmt.Errorf("%v:%d: expected \"key %v value\"", path, line, sep)
+		}
+
+		value, err := configValue(strings.TrimSpace(kv[1]))
+		if err != nil {
+			return fmt.Errorf("%v:%d: %v", path, line, err)
+		}
+
+		if err := set(strings.TrimSpace(kv[0]), value); err != nil {
+			return fmt.Errorf("%v:%d: %v", path, line, err)
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return fmt.Errorf("cannot read %v file: %v", kind, err)
+	}
+
+	return nil
+}
+
+func configValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, `"`):
+		q, err := strconv.QuotedPrefix(v)
+		if err != nil {
+			return "", fmt.Errorf("invalid quoted value %v", v)
+		}
+		s, _ := strconv.Unquote(q)
+		return s, nil
+	case strings.HasPrefix(v, "'"):
+		end := strings.Index(v[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("invalid quoted value %v", v)
+		}
+		return v[1 : end+1], nil
+	}
+
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return v, nil
+}
@@ -0,0 +1,678 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/berryp/avsplit"
+)
+
+func main() {
+	var filenames []string
+	flag.Func("filename", "Path or http(s) URL to the audio file, repeat or use a glob to join several files in order", func(v string) error {
+		files := []string{v}
+		if strings.ContainsAny(v, "*?[") {
+			matches, err := filepath.Glob(v)
+			if err != nil {
+				return err
+			}
+			if len(matches) == 0 {
+				return fmt.Errorf("no files match %v", v)
+			}
+			files = matches
+		}
+		filenames = append(filenames, files...)
+		return nil
+	})
+	flag.String("config", "", "Config file of flag defaults (default ~/.config/avsplit/config.toml or config.yaml)")
+	batch := flag.String("batch", "", "Split every audio file listed in a YAML manifest, with the other flags as shared settings")
+	fromURL := flag.String("from-url", "", "Fetch the audio of a video with yt-dlp and split it by its chapters or the tracklist in its description")
+	cacheDir := flag.String("cache-dir", "", "Directory to keep audio files downloaded from a URL and the responses to lookups in (default avsplit in the user cache directory)")
+	stream := flag.Bool("stream", false, "Read an audio file URL directly with ffmpeg instead of downloading it first")
+	timecodes := flag.String("timecodes", "", "Path to the timecodes file, or - to read it from stdin")
+	timecodesFormat := flag.String("timecodes-format", "", "Format of the timecodes file: timecodes, youtube, cue, chapters (ffprobe JSON), audacity, timeline (now playing JSON), yaml or toml (default detected from its name and contents)")
+	youtube := flag.Bool("youtube", false, "Read the timecodes file as a pasted YouTube description, ignoring lines without a timecode")
+	fromChapters := flag.Bool("from-chapters", false, "Use the chapters embedded in the audio file as the tracks")
+	detectSilence := flag.Bool("detect-silence", false, "Find the tracks by detecting silence instead of reading a timecodes file")
+	silenceNoise := flag.String("silence-noise", "-30dB", "Noise level below which audio counts as silence")
+	silenceDuration := flag.Duration("silence-duration", 2*time.Second, "Minimum length of a silence between tracks")
+	mbRelease := flag.String("mb-release", "", "MusicBrainz release ID to fill in titles and tags from")
+	mbSearch := flag.Bool("mb-search", false, "Search MusicBrainz for the artist and album to fill in titles and tags")
+	discogsRelease := flag.String("discogs-release", "", "Discogs release ID or URL to fill in titles, artists, label, catalog number and year from")
+	lyrics := flag.String("lyrics", "", "Look up each track's lyrics and \"tag\" them, write synced lyrics to an \"lrc\" file next to it, or \"both\"")
+	lyricsProvider := flag.String("lyrics-provider", "lrclib", "Where to look up lyrics")
+	acoustIDKey := flag.String("acoustid-key", "", "AcoustID API key, to identify each track by its fingerprint and fill in its title and artist (needs fpcalc)")
+	sourceTags := flag.String("source-tags", "", "Carry these tags of the audio file over into tracks without them: all, or some of artist, album, date, genre, composer, comment and art")
+	ffmpegArgs := flag.String("ffmpeg-args", "", "Extra arguments for each track's ffmpeg command, before the output file, e.g. \"-map 0:a:1\" or \"-loglevel warning\"")
+	audioStream := flag.String("audio-stream", "", "Audio stream to split from a file with several, by its number from 0 or its language, e.g. 1 or eng (default the first)")
+	ffmpegInputArgs := flag.String("ffmpeg-input-args", "", "Extra arguments for each track's ffmpeg command, before the input, e.g. \"-c:a libopus\" to force a decoder")
+	cover := flag.String("cover", "", "Cover art to embed in each track, or \"auto\" to use cover.jpg or folder.jpg next to the audio file")
+	tagger := flag.String("tagger", "eyed3", "Tagger to run over the tags ffmpeg writes: eyed3, native, ffmpeg (none) or auto, falling back to another when not installed or unable to tag the output format")
+	cue := flag.String("cue", "", "Path to a CUE sheet to read the tracks from instead of a timecodes file")
+	audacity := flag.String("audacity", "", "Path to an Audacity label file to read the tracks from instead of a timecodes file")
+	pregap := flag.String("pregap", "append", "Where a CUE track's pregap goes: \"append\" to the track before, \"prepend\" to its own track, or \"discard\"")
+	htoa := flag.Bool("htoa", false, "Extract the audio hidden before the first track of a CUE sheet as track 0")
+	artist := flag.String("artist", "", "Album artist, over an ARTIST: line at the top of the timecodes file")
+	va := flag.Bool("va", false, "Compilation: read \"Artist - Title\" lines and tag the album artist separately")
+	album := flag.String("album", "", "Album name, over an ALBUM: line at the top of the timecodes file")
+	year := flag.String("year", "", "Release year to tag every track with")
+	genre := flag.String("genre", "", "Genre to tag every track with")
+	disc := flag.Int("disc", 0, "Disc number to tag every track with")
+	discs := flag.String("discs", "", "Number of tracks on each disc of a box set, e.g. \"12,10\", numbering the tracks from 1 on each disc")
+	discDirs := flag.Bool("disc-dirs", false, "Put the tracks of each disc in a \"Disc N\" directory instead of numbering them like 2-01")
+	discTotal := flag.Int("disc-total", 0, "Number of discs in the release")
+	comment := flag.String("comment", "", "Comment to tag every track with")
+	audacityLabels := flag.String("audacity-labels", "", "Write the tracks as an Audacity label file instead of splitting, to adjust them in Audacity and read them back with -audacity")
+	addChapters := flag.String("add-chapters", "", "Write a copy of the audio file with the tracks as chapters instead of splitting")
+	vttOut := flag.String("vtt-out", "", "Write the tracks as a WebVTT chapters file instead of splitting")
+	sanityCheck := flag.Bool("sanity-check", false, "Warn about tracks that are implausibly long compared to the rest")
+	strict := flag.Bool("strict", false, "Treat warnings as errors, exiting with code 2")
+	outputDir := flag.String("output-dir", "", "Directory to write the tracks under instead of the current directory")
+	ascii := flag.Bool("ascii", false, "Transliterate output file and directory names to ASCII")
+	outputTemplate := flag.String("output-template", "", "Template for the output path, e.g. \"{artist}/{album}/{track:02d} - {title}.{ext}\"")
+	titleTemplate := flag.String("title-template", "", "Template for every title, e.g. \"{title} (Live at {venue}, {date})\" with -var venue=...")
+	vars := make(map[string]string)
+	flag.Func("var", "Template variable as name=value for -title-template and -output-template, repeatable", func(v string) error {
+		kv := strings.SplitN(v, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("expected name=value")
+		}
+		vars[kv[0]] = kv[1]
+		return nil
+	})
+	flag.Func("vars", "TOML or YAML file of template variables", func(v string) error {
+		return loadVars(v, vars)
+	})
+	dirTemplate := flag.String("dir-template", "", "Template for the output directory (default \"{{.AlbumArtist}}/{{.Album}}\")")
+	retries := flag.Int("retries", 0, "Times to retry a track that fails to extract or tag, waiting longer each time")
+	failFast := flag.Bool("fail-fast", false, "Stop starting new tracks after the first failure instead of splitting the rest")
+	quarantine := flag.Bool("quarantine", false, "Move tracks that fail into a .failed directory with their error")
+	tagsCSV := flag.String("tags-csv", "", "CSV file of per-track tags keyed by track number, and disc with a disc column")
+	preserveMtime := flag.Bool("preserve-mtime", false, "Set each track's modification time to the audio file's")
+	progress := flag.Bool("progress", false, "Show a progress bar for each track, with the time left and speed of the whole split")
+	verbose := flag.Bool("verbose", false, "Also log the commands run and what they write to stderr")
+	quiet := flag.Bool("quiet", false, "Log only warnings and errors")
+	logFile := flag.String("log-file", "", "Append the log to this file as well as printing it")
+	jsonOut := flag.Bool("json", false, "Write progress, the track plan, created files and errors as JSON lines")
+	offset := flag.Duration("offset", 0, "Shift every timecode by this much, e.g. 3s or -1.5s")
+	padStart := flag.Duration("pad-start", 0, "Start each track this much earlier")
+	padEnd := flag.Duration("pad-end", 0, "End each track this much later")
+	interactive := flag.Bool("interactive", false, "Review and edit the tracks before splitting")
+	trackSel := flag.String("tracks", "", "Only extract these tracks, e.g. \"3,5-9\"")
+	flag.Func("track", "Only extract this track, the same as -tracks with one number", func(v string) error {
+		return flag.Set("tracks", v)
+	})
+	output := flag.String("output", "", "Write the single selected track to this file, or - for stdout; with join, the file to join the tracks into")
+	dryRun := flag.Bool("dry-run", false, "Print the tracks and the commands that would run without running them")
+	script := flag.String("script", "", "Write the commands to a shell script instead of running them")
+	savePlan := flag.String("save", "", "With plan, save the tracks, output files and encoder settings to a JSON plan file instead of printing them")
+	beets := flag.Bool("beets", false, "Stage the tracks in a temporary directory, or -output-dir, with a manifest and the beet import command for them")
+	watchState := flag.String("watch-state", "", "With watch, the file recording the audio files split (default .avsplit-watch.json in the directory)")
+	listen := flag.String("listen", "localhost:8090", "With serve and web, the address to serve the HTTP API or web page on")
+	fixOverlaps := flag.Bool("fix-overlaps", false, "Sort the tracks, drop duplicates and cut ends running into the next track instead of failing, reporting what was changed")
+	mergeWithin := flag.Duration("merge-within", 0, "With -fix-overlaps, merge tracks starting less than this after the one before into it, e.g. 5s")
+	titleFilters := flag.String("title-filters", "", "Clean up the titles with these rules: space, number (01. prefixes), timestamp (trailing timecodes), suffix ((Official Video) and such), case (title case), or all, e.g. all,-case")
+	tempo := flag.Float64("tempo", 1, "Play the tracks this many times as fast without changing their pitch, e.g. 0.96 for a rip 4% too fast; the timecodes are of the corrected tracks")
+	pitch := flag.Float64("pitch", 0, "Shift the pitch of the tracks by this many semitones without changing their tempo, e.g. -0.7")
+	flacCue := flag.Bool("flac-cue", false, "Use the cuesheet embedded in the FLAC file as the tracks, cut losslessly on its exact samples")
+	toChapters := flag.String("to-chapters", "", "Write the audio to this one file with the tracks as chapters instead of splitting, e.g. book.m4b, re-encoding when its codec doesn't fit and tagging the release")
+	tagDir := flag.String("dir", "", "With tag, tag the audio files in this directory instead of the tracks' output files, without the audio file; with rename, rename them by their tags; with join, join them in the order of their tags")
+	tagMatch := flag.String("match", "auto", "With tag -dir, match the files to the tracks by name (alike titles), order, or auto (by name, then the rest in order)")
+	nice := flag.Int("nice", 0, "Run ffmpeg under nice at this niceness, e.g. 10, so long jobs leave the CPU to others")
+	ionice := flag.String("ionice", "", "Run ffmpeg under ionice with this I/O class: idle, best-effort or realtime, with an optional level as in best-effort:7 (Linux)")
+	cpuLimit := flag.Int("cpu-limit", 0, "Let each ffmpeg use at most this many threads (default as many as it likes)")
+	reproducible := flag.Bool("reproducible", false, "Write the same bytes on every run, without ffmpeg's version or the encoding time")
+	date := flag.String("date", "", "Set each track's modification time to this date, YYYY-MM-DD or RFC 3339")
+	postCmd := flag.String("post-cmd", "", "Shell command to run after each track is written, with its file and tags in AVSPLIT_FILE, AVSPLIT_TITLE and other AVSPLIT_ variables")
+	postAlbumCmd := flag.String("post-album-cmd", "", "Shell command to run once every track is written, with the files one per line in AVSPLIT_FILES")
+	receipt := flag.Bool("receipt", false, "Write an avsplit-receipt.json into each output directory recording the source, tracklist, tool versions, commands and track checksums, usable as a -plan")
+	numbering := flag.String("numbering", "original", "Numbering of the tracks left by -tracks: original keeps the numbers and total of the full list, renumber counts them from 1")
+	snapWindow := flag.Duration("snap-window", 0, "Move each split point to the nearest silence, or else the quietest moment, within this far of it, e.g. 5s")
+	fetchCover := flag.String("fetch-cover", "", "Download the release's front cover, embed it and save it as cover.jpg: caa from the Cover Art Archive for a -mb-release or -mb-search release, itunes from an iTunes search, or auto for either")
+	coverSize := flag.Int("cover-size", 1200, "With fetch-cover, the size in pixels of the cover to download")
+	verifyLossless := flag.Bool("verify-lossless", false, "After a stream-copied split, decode each track and compare it sample for sample with the source, reporting any difference, gap or overlap")
+	lookup := flag.String("lookup", "", "Look the release up to fill in titles and tags from with each of these in turn until one has it, comma separated: musicbrainz, discogs or a directory of YAML or TOML tracklists")
+	releaseID := flag.String("release-id", "", "With lookup, the ID of the release at the providers, or a tracklist's file name in a directory, instead of searching for the artist and album")
+	offline := flag.Bool("offline", false, "Answer MusicBrainz, Discogs, cover art, lyrics and AcoustID lookups only from the cache of earlier runs, and use only audio files downloaded already")
+	lookupCacheTTL := flag.Duration("lookup-cache-ttl", 7*24*time.Hour, "How long to keep the responses to lookups in the cache directory, 0 not to cache them")
+	lookupInterval := flag.Duration("lookup-interval", 0, "The least time between two lookup requests to one service, on top of the limits of those that have them")
+	lookupRetries := flag.Int("lookup-retries", 3, "How many times to send a lookup request again while it fails or the service is busy")
+	altTitles := flag.String("alt-titles", "", "Read a second title after \" | \" on each tracklist line and use it in the file names (filenames) or in the tags (tags), the first title going in the other")
+	romanize := flag.Bool("romanize", false, "Romanize the Japanese kana, Korean, Cyrillic or Greek titles without a second title, for the file names unless alt-titles is tags")
+	cpuProfile := flag.String("cpuprofile", "", "Write a pprof CPU profile of avsplit itself, not of ffmpeg, to this file")
+	memProfile := flag.String("memprofile", "", "Write a pprof heap profile to this file once the run is done")
+	overwrite := flag.String("overwrite", "", "What to do with output files that exist already: \"never\" to fail naming them, \"always\" to write over them or \"ask\" about each; by default tracks an earlier run wrote in full are kept")
+	sourceLyrics := flag.String("source-lyrics", "auto", "An .lrc, .srt or .vtt file of the whole recording to split into an .lrc file for each track, by default the one next to the audio file with its name; empty for none")
+	trimApplause := flag.Bool("trim-applause", false, "Trim the applause and crowd noise at the start and end of each track of a live recording, logging what was removed")
+	applauseKeep := flag.Duration("applause-keep", 2*time.Second, "With trim-applause, how much of the applause to keep at each end")
+	var profiles []avsplit.Profile
+	flag.Func("profile", "An output profile as format, optional bitrate or qN quality and name, e.g. \"flac archive\" or \"opus 128k phone\", each split into a directory of its name; repeatable, decoding the source once", func(v string) error {
+		p, err := avsplit.ParseProfile(v)
+		if err != nil {
+			return err
+		}
+		profiles = append(profiles, p)
+		return nil
+	})
+	minTrackLength := flag.Duration("min-track-length", 0, "Fail when a track of the tracklist is shorter than this, e.g. 10s to catch a mistyped timecode")
+	recordingStart := flag.String("recording-start", "", "With a timeline tracklist, the RFC 3339 time the recording started, its song changes being counted from it (default the timeline's recording_start or its first song)")
+	dirMode := flag.String("dir-mode", "", "Octal permissions of the directories created, e.g. 0775 (default 0755 less the umask)")
+	fileMode := flag.String("file-mode", "", "Octal permissions of the tracks and other files written, e.g. 0664 (default as the umask makes them)")
+	owner := flag.String("owner", "", "User, by name or ID, to give the directories and files created to")
+	group := flag.String("group", "", "Group, by name or ID, to give the directories and files created to")
+	previewLength := flag.Duration("preview-length", 5*time.Second, "With preview, how much to play before and after each split point")
+	jobsDir := flag.String("jobs-dir", "", "The directory to keep the jobs of serve and web in, each with its state, upload and tracks, and of -batch to resume it (default avsplit-jobs with serve, web and jobs)")
+	sourceRoot := flag.String("source-root", "", "With serve and web, the directory the sources jobs name by path must be in (default only uploaded audio files are split)")
+	watchInterval := flag.Duration("watch-interval", 30*time.Second, "With watch, how often to look for new audio files")
+	plan := flag.String("plan", "", "Split the tracks of a JSON plan file saved by plan -save, possibly edited since")
+	maxLineBytes := flag.Int("max-line-bytes", 1024*1024, "Maximum length of a line in the timecodes file")
+	format := flag.String("format", "", "Output format, e.g. mp3 or flac (default matches the source, re-encodes when different)")
+	video := flag.Bool("video", false, "Keep the video stream and split into clips in the source's container")
+	normalize := flag.String("normalize", "", "Normalize loudness with a two-pass loudnorm per \"track\" or for the whole \"album\"")
+	crossfadeSplit := flag.Duration("crossfade-split", 0, "Fade each track in and out over this long where it meets the next, re-encoding, so continuous mixes don't cut mid-blend")
+	accurate := flag.Bool("accurate", false, "Re-encode so tracks start and end exactly on their timecodes instead of the nearest frame")
+	encode := flag.Bool("encode", false, "Re-encode the tracks instead of stream copying")
+	codec := flag.String("codec", "", "Audio encoder to re-encode with, e.g. libmp3lame or libopus")
+	bitrate := flag.String("bitrate", "", "Audio bitrate to re-encode at, e.g. 320k")
+	quality := flag.String("quality", "", "Audio quality to re-encode at, passed to -q:a")
+	verify := flag.Bool("verify", false, "Check each track decodes and has the expected length, and write a checksum manifest")
+	checksum := flag.String("checksum", "sha256", "Checksum for the -verify manifest: md5 or sha256")
+	sidecars := flag.Bool("sidecars", false, "Write a CUE sheet, m3u8 playlist and album.nfo of the split tracks into each output directory")
+	images := flag.String("images", "", "Render a \"waveform\" or \"spectrogram\" PNG next to each track to check the splits")
+	collisions := flag.String("collisions", "number", "What to do when tracks would be written to the same file: add a \"number\" or the \"timecode\" to the name, or \"fail\"")
+	force := flag.Bool("force", false, "Split again and extract every track, even when an earlier run split the same source and tracks")
+	ffmpegPath := flag.String("ffmpeg-path", "ffmpeg", "Path to the ffmpeg binary")
+	ffprobePath := flag.String("ffprobe-path", "ffprobe", "Path to the ffprobe binary")
+	jobs := flag.Int("jobs", 1, "Number of tracks to extract at once")
+	trackStart := flag.Int("track-start", 1, "Number of the first track, to carry on the numbering of an earlier disc")
+	padWidth := flag.Int("pad-width", 0, "Digits of the track numbers in file names (default the digits of the track count, at least 2)")
+	tagJobs := flag.Int("tag-jobs", 1, "Number of tagging jobs to run at once")
+	autoTitle := flag.String("auto-title", "", "Template for tracks without a title, e.g. \"Chapter {{.Number}}\"")
+
+	flag.Usage = usage
+	command, args := splitCommand(os.Args[1:])
+
+	if path, _ := configPath(args); path != "" {
+		if err := loadConfig(path); err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(exitInvalidInput)
+		}
+	}
+
+	flag.CommandLine.Parse(args)
+
+	sources := 0
+	for _, set := range []bool{*timecodes != "", *cue != "", *audacity != "", *detectSilence, *fromChapters, *flacCue} {
+		if set {
+			sources++
+		}
+	}
+
+	// A MusicBrainz or Discogs release can provide the tracks on its own
+	mb := *mbRelease != "" || *mbSearch || *discogsRelease != "" || *lookup != ""
+	// Batch entries name their own file and tracks, a video fetched with
+	// yt-dlp brings its own file and usually its tracks
+	missing := len(filenames) == 0 || (sources == 0 && !mb)
+	if *fromURL != "" {
+		missing = len(filenames) > 0
+	}
+	if *plan != "" {
+		// The plan has the tracks and names its own files
+		missing = sources > 0 || *fromURL != ""
+	}
+	switch command {
+	case "detect", "probe":
+		singleFile(command, filenames)
+	case "preview":
+		singleFile(command, filenames)
+		if sources > 1 || (sources == 0 && !mb) {
+			flag.Usage()
+			os.Exit(exitInvalidInput)
+		}
+	case "check":
+		// The audio file is optional, nothing reads it
+		if len(filenames) > 1 || sources != 1 || *detectSilence || *fromChapters || *flacCue {
+			flag.Usage()
+			os.Exit(exitInvalidInput)
+		}
+	case "tag":
+		if *tagDir != "" {
+			// The files to tag are there already, no audio file is read
+			missing = len(filenames) > 0 || (sources == 0 && !mb)
+		}
+		if sources > 1 || (missing && *batch == "") {
+			flag.Usage()
+			os.Exit(exitInvalidInput)
+		}
+	case "rename":
+		// The tracks are in the plan or the files' tags
+		if len(filenames) > 0 || sources > 0 || (*plan == "") == (*tagDir == "") {
+			flag.Usage()
+			os.Exit(exitInvalidInput)
+		}
+	case "join":
+		// The tracks are in the plan or the files' tags
+		if len(filenames) > 0 || sources > 0 || (*plan == "") == (*tagDir == "") || *output == "" {
+			flag.Usage()
+			os.Exit(exitInvalidInput)
+		}
+	case "serve", "web":
+		// Each job brings its own file and tracklist
+		if len(filenames) > 0 || sources > 0 || flag.NArg() > 0 {
+			flag.Usage()
+			os.Exit(exitInvalidInput)
+		}
+	case "jobs":
+		if len(filenames) > 0 || sources > 0 || !validJobsArgs(flag.Args()) {
+			flag.Usage()
+			os.Exit(exitInvalidInput)
+		}
+	case "bench":
+		// Without an audio file a synthetic one is split
+		if len(filenames) > 1 || sources > 1 || flag.NArg() > 0 {
+			flag.Usage()
+			os.Exit(exitInvalidInput)
+		}
+	case "watch":
+		// Each audio file brings its own tracklist
+		if flag.NArg() != 1 || len(filenames) > 0 || sources > 0 {
+			flag.Usage()
+			os.Exit(exitInvalidInput)
+		}
+	default:
+		if sources > 1 || (missing && *batch == "") {
+			flag.Usage()
+			os.Exit(exitInvalidInput)
+		}
+	}
+
+	if *tagDir != "" && command != "tag" && command != "rename" && command != "join" {
+		fmt.Println("error: dir is only for the tag, rename and join commands")
+		os.Exit(exitInvalidInput)
+	}
+
+	if *savePlan != "" && command != "plan" {
+		fmt.Println("error: save is only for the plan command")
+		os.Exit(exitInvalidInput)
+	}
+
+	if *maxLineBytes < 1 {
+		fmt.Println("error: max-line-bytes must be at least 1")
+		os.Exit(exitInvalidInput)
+	}
+
+	if *tagger != "eyed3" && *tagger != "native" && *tagger != "ffmpeg" && *tagger != "auto" {
+		fmt.Println("error: tagger must be eyed3, native, ffmpeg or auto")
+		os.Exit(exitInvalidInput)
+	}
+
+	if *trackStart < 1 {
+		fmt.Println("error: track-start must be at least 1")
+		os.Exit(exitInvalidInput)
+	}
+
+	if *padWidth < 0 {
+		fmt.Println("error: pad-width can't be negative")
+		os.Exit(exitInvalidInput)
+	}
+
+	if *jobs < 1 {
+		fmt.Println("error: jobs must be at least 1")
+		os.Exit(exitInvalidInput)
+	}
+
+	if *retries < 0 {
+		fmt.Println("error: retries can't be negative")
+		os.Exit(exitInvalidInput)
+	}
+
+	if *tagJobs < 1 {
+		fmt.Println("error: tag-jobs must be at least 1")
+		os.Exit(exitInvalidInput)
+	}
+
+	if *timecodes == "-" && *interactive {
+		fmt.Println("error: timecodes - and interactive both read stdin, they can't be used together")
+		os.Exit(exitInvalidInput)
+	}
+
+	switch *overwrite {
+	case "", "never", "always", "ask":
+	default:
+		fmt.Println("error: overwrite must be never, always or ask")
+		os.Exit(exitInvalidInput)
+	}
+
+	if *overwrite == "ask" && *timecodes == "-" {
+		fmt.Println("error: timecodes - and overwrite ask both read stdin, they can't be used together")
+		os.Exit(exitInvalidInput)
+	}
+
+	if len(profiles) > 0 && (*format != "" || *codec != "" || *bitrate != "" || *quality != "" || *output != "") {
+		fmt.Println("error: profile sets the format and encoder of its tracks, it can't be used with format, codec, bitrate, quality or output")
+		os.Exit(exitInvalidInput)
+	}
+
+	if *fetchCover != "" && *cover != "" {
+		fmt.Println("error: cover and fetch-cover can't be used together")
+		os.Exit(exitInvalidInput)
+	}
+
+	if *releaseID != "" && *lookup == "" {
+		fmt.Println("error: release-id requires lookup")
+		os.Exit(exitInvalidInput)
+	}
+
+	// Options take 0 for the defaults, the flags for none
+	cacheTTL, resends := *lookupCacheTTL, *lookupRetries
+	if cacheTTL < 0 || resends < 0 {
+		fmt.Println("error: lookup-cache-ttl and lookup-retries can't be negative")
+		os.Exit(exitInvalidInput)
+	}
+	if cacheTTL == 0 {
+		cacheTTL = -1
+	}
+	if resends == 0 {
+		resends = -1
+	}
+
+	var metadata avsplit.MetadataProvider
+	if *lookup != "" {
+		var err error
+		if metadata, err = lookupProviders(*lookup); err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(exitInvalidInput)
+		}
+	}
+
+	if *verbose && *quiet {
+		fmt.Println("error: verbose and quiet can't be used together")
+		os.Exit(exitInvalidInput)
+	}
+
+	var mtime time.Time
+	if *date != "" {
+		var err error
+		if mtime, err = time.Parse("2006-01-02", *date); err != nil {
+			if mtime, err = time.Parse(time.RFC3339, *date); err != nil {
+				fmt.Printf("error: invalid date %v, must be YYYY-MM-DD or RFC 3339\n", *date)
+				os.Exit(exitInvalidInput)
+			}
+		}
+	}
+
+	modes := make([]os.FileMode, 2)
+	for i, m := range []struct{ name, value string }{{"dir-mode", *dirMode}, {"file-mode", *fileMode}} {
+		if m.value == "" {
+			continue
+		}
+		n, err := strconv.ParseUint(m.value, 8, 32)
+		if err != nil || n == 0 || n > 0777 {
+			fmt.Printf("error: invalid %v %v, must be octal permissions such as 0755\n", m.name, m.value)
+			os.Exit(exitInvalidInput)
+		}
+		modes[i] = os.FileMode(n)
+	}
+
+	var started time.Time
+	if *recordingStart != "" {
+		var err error
+		if started, err = time.Parse(time.RFC3339, *recordingStart); err != nil {
+			fmt.Printf("error: invalid recording-start %v, must be RFC 3339\n", *recordingStart)
+			os.Exit(exitInvalidInput)
+		}
+	}
+
+	var log io.Writer = os.Stdout
+	if *output == "-" || command == "detect" {
+		// Stdout carries the track or the timecodes
+		log = os.Stderr
+	}
+	if *logFile != "" {
+		f, err := os.OpenFile(*logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+		if err != nil {
+			fmt.Printf("error: %v\n", err)
+			os.Exit(exitError)
+		}
+		defer f.Close()
+		log = io.MultiWriter(log, f)
+	}
+
+	opts := avsplit.Options{
+		Filenames:       filenames,
+		Timecodes:       *timecodes,
+		Artist:          *artist,
+		Album:           *album,
+		AudacityLabels:  *audacityLabels,
+		AutoTitle:       *autoTitle,
+		VTTOut:          *vttOut,
+		TagJobs:         *tagJobs,
+		PreserveMtime:   *preserveMtime,
+		TagsCSV:         *tagsCSV,
+		Quarantine:      *quarantine,
+		DirTemplate:     *dirTemplate,
+		SanityCheck:     *sanityCheck,
+		Strict:          *strict,
+		AddChapters:     *addChapters,
+		MaxLineBytes:    *maxLineBytes,
+		Script:          *script,
+		Cue:             *cue,
+		Audacity:        *audacity,
+		Tagger:          *tagger,
+		Jobs:            *jobs,
+		Format:          *format,
+		DryRun:          *dryRun,
+		DetectSilence:   *detectSilence,
+		SilenceNoise:    *silenceNoise,
+		SilenceDuration: *silenceDuration,
+		MBRelease:       *mbRelease,
+		MBSearch:        *mbSearch,
+		DiscogsRelease:  *discogsRelease,
+		Cover:           *cover,
+		FromChapters:    *fromChapters,
+		Progress:        *progress,
+		OutputTemplate:  *outputTemplate,
+		VA:              *va,
+		Encode:          *encode,
+		Codec:           *codec,
+		Bitrate:         *bitrate,
+		Quality:         *quality,
+		YouTube:         *youtube,
+		Force:           *force,
+		JSON:            *jsonOut,
+		Video:           *video,
+		Normalize:       *normalize,
+		Year:            *year,
+		Genre:           *genre,
+		Disc:            *disc,
+		DiscTotal:       *discTotal,
+		Comment:         *comment,
+		Offset:          *offset,
+		PadStart:        *padStart,
+		PadEnd:          *padEnd,
+		Accurate:        *accurate,
+		Verify:          *verify,
+		Checksum:        *checksum,
+		OutputDir:       *outputDir,
+		ASCII:           *ascii,
+		Pregap:          *pregap,
+		HTOA:            *htoa,
+		Sidecars:        *sidecars,
+		Tracks:          *trackSel,
+		Images:          *images,
+		FFmpegPath:      *ffmpegPath,
+		FFprobePath:     *ffprobePath,
+		TitleTemplate:   *titleTemplate,
+		Vars:            vars,
+		AcoustIDKey:     *acoustIDKey,
+		Output:          *output,
+		Retries:         *retries,
+		FailFast:        *failFast,
+		TimecodesFormat: *timecodesFormat,
+		CrossfadeSplit:  *crossfadeSplit,
+		CacheDir:        *cacheDir,
+		Stream:          *stream,
+		FromURL:         *fromURL,
+		Discs:           *discs,
+		DiscDirs:        *discDirs,
+		Collisions:      *collisions,
+		Lyrics:          *lyrics,
+		LyricsProvider:  *lyricsProvider,
+		Plan:            *plan,
+		SavePlan:        *savePlan,
+		TrackStart:      *trackStart,
+		PadWidth:        *padWidth,
+		Numbering:       *numbering,
+		Beets:           *beets,
+		WatchState:      *watchState,
+		WatchInterval:   *watchInterval,
+		SourceTags:      *sourceTags,
+		FFmpegArgs:      *ffmpegArgs,
+		FFmpegInputArgs: *ffmpegInputArgs,
+		AudioStream:     *audioStream,
+		JobsDir:         *jobsDir,
+		SourceRoot:      *sourceRoot,
+		FixOverlaps:     *fixOverlaps,
+		MergeWithin:     *mergeWithin,
+		PreviewLength:   *previewLength,
+		TitleFilters:    *titleFilters,
+		Tempo:           *tempo,
+		Pitch:           *pitch,
+		FLACCue:         *flacCue,
+		ToChapters:      *toChapters,
+		TagDir:          *tagDir,
+		TagMatch:        *tagMatch,
+		Nice:            *nice,
+		IONice:          *ionice,
+		CPULimit:        *cpuLimit,
+		Reproducible:    *reproducible,
+		Date:            mtime,
+		PostCmd:         *postCmd,
+		PostAlbumCmd:    *postAlbumCmd,
+		Receipt:         *receipt,
+		SnapWindow:      *snapWindow,
+		FetchCover:      *fetchCover,
+		CoverSize:       *coverSize,
+		VerifyLossless:  *verifyLossless,
+		Metadata:        metadata,
+		ReleaseID:       *releaseID,
+		LookupCacheTTL:  cacheTTL,
+		Offline:         *offline,
+		LookupInterval:  *lookupInterval,
+		LookupRetries:   resends,
+		AltTitles:       *altTitles,
+		Romanize:        *romanize,
+		Overwrite:       *overwrite,
+		SourceLyrics:    *sourceLyrics,
+		TrimApplause:    *trimApplause,
+		ApplauseKeep:    *applauseKeep,
+		Profiles:        profiles,
+		MinTrackLength:  *minTrackLength,
+		RecordingStart:  started,
+		DirMode:         modes[0],
+		FileMode:        modes[1],
+		Owner:           *owner,
+		Group:           *group,
+		Quiet:           *quiet,
+		Verbose:         *verbose,
+		Log:             log,
+	}
+
+	if *interactive {
+		opts.Review = func(tracks avsplit.Tracklist) (avsplit.Tracklist, error) {
+			return avsplit.ReviewTracks(os.Stdin, os.Stdout, tracks)
+		}
+	}
+
+	if *overwrite == "ask" {
+		opts.AskOverwrite = avsplit.PromptOverwrite(os.Stdin, os.Stdout)
+	}
+
+	// The first Ctrl-C stops the run and kills ffmpeg, a second one exits
+	// straight away
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	switch command {
+	case "plan":
+		opts.DryRun = true
+	case "tag":
+		opts.TagOnly = true
+	}
+
+	run := avsplit.Split
+	switch {
+	case command == "detect":
+		run = func(ctx context.Context, opts avsplit.Options) error {
+			return runDetect(ctx, opts, os.Stdout)
+		}
+	case command == "probe":
+		run = func(ctx context.Context, opts avsplit.Options) error {
+			return runProbe(ctx, opts, os.Stdout)
+		}
+	case command == "check":
+		run = func(ctx context.Context, opts avsplit.Options) error {
+			return runCheck(ctx, opts, os.Stdout)
+		}
+	case command == "serve":
+		run = func(ctx context.Context, opts avsplit.Options) error {
+			return avsplit.Serve(ctx, *listen, opts)
+		}
+	case command == "web":
+		run = func(ctx context.Context, opts avsplit.Options) error {
+			return avsplit.Web(ctx, *listen, opts)
+		}
+	case command == "rename":
+		run = avsplit.Rename
+	case command == "join":
+		run = avsplit.Join
+	case command == "preview":
+		run = func(ctx context.Context, opts avsplit.Options) error {
+			opts.Filename = opts.Filenames[0]
+			return avsplit.Preview(ctx, opts)
+		}
+	case command == "jobs":
+		run = func(ctx context.Context, opts avsplit.Options) error {
+			return runJobs(opts.JobsDir, flag.Args(), os.Stdout)
+		}
+	case command == "bench":
+		run = func(ctx context.Context, opts avsplit.Options) error {
+			return runBench(ctx, opts, os.Stdout)
+		}
+	case command == "watch":
+		run = func(ctx context.Context, opts avsplit.Options) error {
+			return avsplit.Watch(ctx, flag.Arg(0), opts)
+		}
+	case *batch != "":
+		run = func(ctx context.Context, opts avsplit.Options) error {
+			return avsplit.SplitBatch(ctx, *batch, opts)
+		}
+	}
+
+	stopProfiles, err := startProfiles(*cpuProfile, *memProfile)
+	if err != nil {
+		fmt.Fprintf(log, "error: %v\n", err)
+		os.Exit(exitError)
+	}
+	err = run(ctx, opts)
+	stopProfiles()
+	if err != nil {
+		// Already written as an error event, except by detect and probe
+		if (!*jsonOut || command == "detect" || command == "probe") && command != "check" {
+			fmt.Fprintf(log, "error: %v\n", err)
+		}
+		os.Exit(exitCode(err))
+	}
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"runtime/pprof"
+)
+
+// startProfiles starts the CPU profile of -cpuprofile and returns the func
+// that stops it and writes the heap profile of -memprofile, warning if it
+// can't.
+func startProfiles(cpuProfile, memProfile string) (func(), error) {
+	var cpu *os.File
+	if cpuProfile != "" {
+		var err error
+		if cpu, err = os.Create(cpuProfile); err != nil {
+			return nil, fmt.Errorf("cannot write the CPU profile: %v", err)
+		}
+		if err := pprof.StartCPUProfile(cpu); err != nil {
+			cpu.Close()
+			return nil, fmt.Errorf("cannot write the CPU profile: %v", err)
+		}
+	}
+
+	return func() {
+		if cpu != nil {
+			pprof.StopCPUProfile()
+			cpu.Close()
+		}
+
+		if memProfile == "" {
+			return
+		}
+		f, err := os.Create(memProfile)
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "warning: cannot write the heap profile: %v\n", err)
+			return
+		}
+		defer f.Close()
+
+		// Up to date with what the run left
+		runtime.GC()
+		if err := pprof.WriteHeapProfile(f); err != nil {
+			fmt.Fprintf(os.Stderr, "warning: cannot write the heap profile: %v\n", err)
+		}
+	}, nil
+}
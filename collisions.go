@@ -0,0 +1,67 @@
+package avsplit
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// collisionKey is how two output files are compared, ignoring case as
+// macOS and Windows file systems do.
+func collisionKey(p string) string {
+	return strings.ToLower(filepath.Clean(p))
+}
+
+// resolveCollisions gives the tracks that would be written over an earlier
+// track's output file a name of their own. With policy "number" a " (2)"
+// is added to the name, with "timecode" the track's start, and with "fail"
+// the collisions are returned as an error instead.
+func resolveCollisions(tracks Tracklist, audioFile, policy string) error {
+	if policy != "number" && policy != "timecode" && policy != "fail" {
+		return fmt.Errorf("unknown collisions %v, must be number, timecode or fail", policy)
+	}
+
+	seen := make(map[string]int)
+	taken := func(p string) bool {
+		_, ok := seen[collisionKey(p)]
+		return ok
+	}
+
+	var collisions []string
+	for i := range tracks {
+		t := &tracks[i]
+		out := t.outputFilename(audioFile)
+		if !taken(out) {
+			seen[collisionKey(out)] = t.Number
+			continue
+		}
+
+		if policy == "fail" {
+			first := seen[collisionKey(out)]
+			collisions = append(collisions, fmt.Sprintf("tracks %d and %d are both written to %v", first, t.Number, out))
+			continue
+		}
+
+		ext := filepath.Ext(out)
+		base := strings.TrimSuffix(filepath.Base(out), ext)
+		name := func(suffix string) string {
+			return filepath.Join(filepath.Dir(out), sanitizeName(base+" ("+suffix+")"+ext, t.ASCII))
+		}
+
+		renamed := ""
+		if policy == "timecode" {
+			renamed = name(t.Start)
+		}
+		for n := 2; renamed == "" || taken(renamed); n++ {
+			renamed = name(fmt.Sprint(n))
+		}
+
+		t.Output = renamed
+		seen[collisionKey(renamed)] = t.Number
+	}
+
+	if len(collisions) > 0 {
+		return fmt.Errorf("output files collide, see -collisions:\n%v", strings.Join(collisions, "\n"))
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// concatFiles joins the files in order into one file next to the first, so
+// tracks can run across them. It returns the joined file, which the caller
+// removes.
+func concatFiles(ctx context.Context, opts Options, files []string) (string, error) {
+	var offset time.Duration
+	for i, f := range files {
+		d, err := probeDuration(ctx, f)
+		if err != nil {
+			return "", err
+		}
+
+		// Logged so timecodes for the joined timeline are easy to work out
+		opts.logf("part %d %v starts at %v\n", i+1, f, formatTimecode(offset))
+		offset += d
+	}
+
+	list, err := concatList(files)
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(list)
+
+	out, err := os.CreateTemp(filepath.Dir(files[0]), ".avsplit-concat-*"+filepath.Ext(files[0]))
+	if err != nil {
+		return "", err
+	}
+	out.Close()
+
+	opts.logf("joining %d files\n", len(files))
+	err = execCommand(
+		ctx,
+		"ffmpeg",
+		"-nostdin",
+		"-y",
+		"-loglevel", "error",
+		"-f", "concat",
+		"-safe", "0",
+		"-i", list,
+		"-map", "0",
+		"-c", "copy",
+		out.Name(),
+	)
+	if err != nil {
+		os.Remove(out.Name())
+		return "", fmt.Errorf("cannot join the audio files: %v", strings.TrimSpace(err.Error()))
+	}
+
+	return out.Name(), nil
+}
+
+// concatList writes the files to a temporary list for ffmpeg's concat
+// demuxer and returns its name, removed by the caller.
+func concatList(files []string) (string, error) {
+	list, err := os.CreateTemp("", "avsplit-*.txt")
+	if err != nil {
+		return "", err
+	}
+
+	for _, f := range files {
+		abs, err := filepath.Abs(f)
+		if err != nil {
+			list.Close()
+			os.Remove(list.Name())
+			return "", err
+		}
+		fmt.Fprintf(list, "file '%v'\n", strings.ReplaceAll(abs, "'", `'\''`))
+	}
+
+	if err := list.Close(); err != nil {
+		os.Remove(list.Name())
+		return "", err
+	}
+	return list.Name(), nil
+}
@@ -0,0 +1,48 @@
+package avsplit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// coverNames are the cover art files looked for next to the audio file when
+// the cover is "auto".
+var coverNames = []string{"cover.jpg", "cover.jpeg", "cover.png", "folder.jpg", "folder.jpeg", "folder.png"}
+
+func imageMIMEType(imageFile string) (string, error) {
+	switch strings.ToLower(filepath.Ext(imageFile)) {
+	case ".jpg", ".jpeg":
+		return "image/jpeg", nil
+	case ".png":
+		return "image/png", nil
+	}
+	return "", fmt.Errorf("cover art must be a jpeg or png image")
+}
+
+// findCover returns the cover art file for the run. A cover of "auto" looks
+// for one of coverNames next to the audio file and returns "" if there is
+// none.
+func findCover(audioFile, cover string) (string, error) {
+	if cover != "auto" {
+		if _, err := os.Stat(cover); err != nil {
+			return "", fmt.Errorf("cover art file not found")
+		}
+		_, err := imageMIMEType(cover)
+		return cover, err
+	}
+
+	if isURL(audioFile) {
+		return "", nil
+	}
+
+	for _, name := range coverNames {
+		p := filepath.Join(filepath.Dir(audioFile), name)
+		if _, err := os.Stat(p); err == nil {
+			return p, nil
+		}
+	}
+
+	return "", nil
+}
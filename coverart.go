@@ -0,0 +1,233 @@
+package avsplit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var (
+	coverArtArchiveURL = "https://coverartarchive.org"
+	itunesSearchURL    = "https://itunes.apple.com/search"
+)
+
+var coverArtClient = &http.Client{Timeout: 60 * time.Second, Transport: lookupTransport}
+
+// caaSizes are the sizes of the thumbnails the Cover Art Archive has of
+// each image, besides the original.
+var caaSizes = []int{250, 500, 1200}
+
+// coverArtGet fetches rawURL into v, returning false when there is nothing
+// there.
+func coverArtGet(ctx context.Context, service, rawURL string, v interface{}) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("User-Agent", musicBrainzUserAgent)
+
+	res, err := coverArtClient.Do(req)
+	if err != nil {
+		return false, fmt.Errorf("%v: %v", service, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return false, fmt.Errorf("%v: %v", service, res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return false, fmt.Errorf("%v: invalid response: %v", service, err)
+	}
+	return true, nil
+}
+
+// caaCoverURL returns the URL of the front cover of the MusicBrainz release
+// id, its smallest thumbnail of at least size pixels or the original, ""
+// when the release has none.
+func caaCoverURL(ctx context.Context, id string, size int) (string, error) {
+	var result struct {
+		Images []struct {
+			Front      bool              `json:"front"`
+			Image      string            `json:"image"`
+			Thumbnails map[string]string `json:"thumbnails"`
+		} `json:"images"`
+	}
+
+	ok, err := coverArtGet(ctx, "cover art archive", coverArtArchiveURL+"/release/"+url.PathEscape(id), &result)
+	if err != nil || !ok {
+		return "", err
+	}
+
+	for _, img := range result.Images {
+		if !img.Front {
+			continue
+		}
+
+		for _, s := range caaSizes {
+			if u := img.Thumbnails[strconv.Itoa(s)]; s >= size && u != "" {
+				return u, nil
+			}
+		}
+		return img.Image, nil
+	}
+	return "", nil
+}
+
+// itunesCoverURL returns the URL of the artwork of the first album an
+// iTunes search for the artist and album finds, at size pixels, "" when
+// there is none.
+func itunesCoverURL(ctx context.Context, artist, album string, size int) (string, error) {
+	query := url.Values{
+		"term":   {artist + " " + album},
+		"entity": {"album"},
+		"limit":  {"1"},
+	}
+
+	var result struct {
+		Results []struct {
+			ArtworkURL100 string `json:"artworkUrl100"`
+		} `json:"results"`
+	}
+
+	ok, err := coverArtGet(ctx, "itunes", itunesSearchURL+"?"+query.Encode(), &result)
+	if err != nil || !ok || len(result.Results) == 0 {
+		return "", err
+	}
+
+	// The URL names the size, any other is there too
+	u := result.Results[0].ArtworkURL100
+	return strings.Replace(u, "100x100bb", fmt.Sprintf("%dx%dbb", size, size), 1), nil
+}
+
+// fetchCover downloads the front cover of the release of the tracks, as
+// opts.FetchCover says, into a temporary file the caller removes. It
+// returns "" with a warning when no cover is found.
+func fetchCover(ctx context.Context, opts Options, tracks Tracklist) (string, error) {
+	size := opts.CoverSize
+	if size == 0 {
+		size = 1200
+	}
+
+	t := tracks[0]
+	var providers []string
+	switch opts.FetchCover {
+	case "caa":
+		providers = []string{"caa"}
+	case "itunes":
+		providers = []string{"itunes"}
+	case "auto":
+		providers = []string{"caa", "itunes"}
+	default:
+		return "", withKind(ErrInvalidInput, fmt.Errorf("unknown fetch-cover %v, must be caa, itunes or auto", opts.FetchCover))
+	}
+
+	for _, p := range providers {
+		var u string
+		var err error
+		switch {
+		case p == "caa" && t.MBAlbumID == "":
+			if opts.FetchCover == "caa" {
+				opts.logf("warning: the cover art archive needs a musicbrainz release, not fetching the cover\n")
+			}
+			continue
+		case p == "caa":
+			u, err = caaCoverURL(ctx, t.MBAlbumID, size)
+		default:
+			u, err = itunesCoverURL(ctx, t.AlbumArtist, t.Album, size)
+		}
+		if err != nil {
+			return "", err
+		}
+		if u == "" {
+			continue
+		}
+
+		opts.logf("fetching the cover art from %v\n", u)
+		return downloadCover(ctx, u)
+	}
+
+	opts.logf("warning: no cover art found for %v - %v\n", t.AlbumArtist, t.Album)
+	return "", nil
+}
+
+// downloadCover downloads the image at rawURL into a temporary file, named
+// for its type.
+func downloadCover(ctx context.Context, rawURL string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("User-Agent", musicBrainzUserAgent)
+
+	res, err := coverArtClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("cannot download the cover art: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("cannot download the cover art: %v", res.Status)
+	}
+
+	ext := ".jpg"
+	if strings.HasPrefix(res.Header.Get("Content-Type"), "image/png") {
+		ext = ".png"
+	}
+
+	f, err := os.CreateTemp("", "avsplit-cover-*"+ext)
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := io.Copy(f, res.Body); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", fmt.Errorf("cannot download the cover art: %v", err)
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+	return f.Name(), nil
+}
+
+// saveCover copies the cover art into each output directory of the tracks
+// as cover.jpg, or cover.png, leaving any there already.
+func saveCover(opts Options, tracks Tracklist, cover string) error {
+	seen := make(map[string]bool)
+	for _, t := range tracks {
+		dir := filepath.Dir(t.outputFilename(opts.Filename))
+		if seen[dir] {
+			continue
+		}
+		seen[dir] = true
+
+		dest := filepath.Join(dir, "cover"+strings.ToLower(filepath.Ext(cover)))
+		if _, err := os.Stat(dest); err == nil {
+			continue
+		}
+
+		data, err := os.ReadFile(cover)
+		if err != nil {
+			return err
+		}
+		if err := opts.writeFile(dest, data); err != nil {
+			return fmt.Errorf("cannot save the cover art: %v", err)
+		}
+		opts.logf("saved the cover art to %v\n", dest)
+	}
+	return nil
+}
@@ -0,0 +1,207 @@
+package avsplit
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cueFramesPerSecond is the number of CD frames in a second of a CUE
+// sheet's mm:ss:ff times.
+const cueFramesPerSecond = 75
+
+// parseCueTime parses a CUE sheet time, mm:ss:ff in minutes, seconds and CD
+// frames, exactly: minutes may run past 59 on a long disc, as in "74:59:74".
+func parseCueTime(s string) (time.Duration, error) {
+	parts := strings.Split(s, ":")
+	if len(parts) != 3 {
+		return 0, fmt.Errorf("invalid cue time %v", s)
+	}
+
+	var v [3]int
+	for i, p := range parts {
+		n, err := strconv.Atoi(p)
+		if err != nil || n < 0 {
+			return 0, fmt.Errorf("invalid cue time %v", s)
+		}
+		v[i] = n
+	}
+
+	if v[1] > 59 || v[2] >= cueFramesPerSecond {
+		return 0, fmt.Errorf("invalid cue time %v", s)
+	}
+
+	frames := (v[0]*60+v[1])*cueFramesPerSecond + v[2]
+	return time.Duration(frames) * time.Second / cueFramesPerSecond, nil
+}
+
+// cueValue returns the rest of a CUE command line, without the quotes
+// around it but with the spaces inside them as they are.
+func cueValue(line string) string {
+	v := strings.TrimSpace(line)
+	i := strings.IndexAny(v, " \t")
+	if i < 0 {
+		return ""
+	}
+	v = strings.TrimSpace(v[i:])
+	if strings.HasPrefix(v, "\"") {
+		v = strings.TrimSuffix(strings.TrimPrefix(v, "\""), "\"")
+	}
+	return v
+}
+
+// readCue reads the tracks from a CUE sheet. The -artist and -album flags
+// take precedence over the sheet's album PERFORMER and TITLE. A track's
+// pregap, from its INDEX 00 to its INDEX 01, is handled by opts.Pregap, and
+// with opts.HTOA the audio before the first track's INDEX 01 becomes track 0.
+func readCue(opts Options) ([]Track, error) {
+	data, err := readText(opts, opts.Cue)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read cue file")
+	}
+	return parseCue(bytes.NewReader(data), opts)
+}
+
+func parseCue(r io.Reader, opts Options) ([]Track, error) {
+	if opts.Pregap != "prepend" && opts.Pregap != "append" && opts.Pregap != "discard" {
+		return nil, fmt.Errorf("pregap must be prepend, append or discard")
+	}
+
+	var albumArtist, album string
+	var tracks []Track
+	var pregaps []string
+	files := 0
+	line := 0
+
+	s := bufio.NewScanner(r)
+	for s.Scan() {
+		line++
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		var cur *Track
+		if len(tracks) > 0 {
+			cur = &tracks[len(tracks)-1]
+		}
+
+		switch strings.ToUpper(fields[0]) {
+		case "FILE":
+			files++
+			if files > 1 {
+				return nil, fmt.Errorf("cue: sheets with more than one FILE are not supported")
+			}
+		case "PERFORMER":
+			if cur == nil {
+				albumArtist = cueValue(s.Text())
+			} else {
+				cur.Artist = cueValue(s.Text())
+			}
+		case "TITLE":
+			if cur == nil {
+				album = cueValue(s.Text())
+			} else {
+				cur.Title = cueValue(s.Text())
+			}
+		case "TRACK":
+			tracks = append(tracks, Track{Number: len(tracks) + 1, Line: line})
+			pregaps = append(pregaps, "")
+		case "INDEX":
+			if cur == nil || len(fields) != 3 {
+				return nil, lineErrorf(line, "cue: invalid INDEX on line %d", line)
+			}
+
+			if fields[1] != "00" && fields[1] != "01" {
+				continue
+			}
+
+			d, err := parseCueTime(fields[2])
+			if err != nil {
+				return nil, lineErrorf(line, "cue: %v on line %d", err, line)
+			}
+
+			if fields[1] == "00" {
+				pregaps[len(pregaps)-1] = formatTimecode(d)
+			} else {
+				cur.Start = formatTimecode(d)
+			}
+		}
+	}
+
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("cannot read cue file: %v", err)
+	}
+
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("no tracks found")
+	}
+
+	if opts.Artist != "" {
+		albumArtist = opts.Artist
+	}
+
+	if opts.Album != "" {
+		album = opts.Album
+	}
+
+	if opts.VA && albumArtist == "" {
+		albumArtist = variousArtists
+	}
+
+	for i := range tracks {
+		t := &tracks[i]
+		if t.Start == "" {
+			return nil, lineErrorf(t.Line, "cue: track %d has no INDEX 01", t.Number)
+		}
+
+		if t.Artist == "" {
+			t.Artist = albumArtist
+		}
+
+		t.AlbumArtist = albumArtist
+		t.Album = album
+		t.Total = len(tracks)
+	}
+	Tracklist(tracks).SetEnds()
+
+	// The hidden track is all of the first track's pregap, whatever the
+	// policy for the others
+	var hidden []Track
+	if opts.HTOA && tracks[0].Start != formatTimecode(0) {
+		hidden = []Track{{
+			Title:       "Hidden Track",
+			Start:       formatTimecode(0),
+			End:         tracks[0].Start,
+			Artist:      albumArtist,
+			AlbumArtist: albumArtist,
+			Album:       album,
+			Total:       len(tracks),
+		}}
+		pregaps[0] = ""
+	}
+
+	for i, pregap := range pregaps {
+		if pregap == "" {
+			continue
+		}
+
+		switch opts.Pregap {
+		case "prepend":
+			tracks[i].Start = pregap
+			if i > 0 {
+				tracks[i-1].End = pregap
+			}
+		case "discard":
+			if i > 0 {
+				tracks[i-1].End = pregap
+			}
+		}
+	}
+
+	return append(hidden, tracks...), nil
+}
@@ -0,0 +1,186 @@
+package avsplit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"time"
+)
+
+// splitRecordName is the file written into each output directory recording
+// the source and tracklist its tracks were split from.
+const splitRecordName = ".avsplit.json"
+
+// splitRecord is what a split wrote to an output directory. A run of the
+// same source and tracklist to the same directory is skipped.
+type splitRecord struct {
+	Source       string    `json:"source"`
+	SourceSHA256 string    `json:"source_sha256"`
+	TracksSHA256 string    `json:"tracks_sha256"`
+	Split        time.Time `json:"split"`
+	Tracks       []string  `json:"tracks"`
+}
+
+// splitSums returns the checksums of the source and of the prepared tracks,
+// whose boundaries, tags, outputs and encoder settings make the tracklist.
+func splitSums(opts Options, tracks Tracklist) (string, string, error) {
+	source, err := fileChecksum("sha256", opts.Filename)
+	if err != nil {
+		return "", "", fmt.Errorf("cannot checksum the audio file: %v", err)
+	}
+
+	var planned []planTrack
+	for _, t := range tracks {
+		planned = append(planned, planTrack{
+			Number:        t.Number,
+			Total:         t.Total,
+			Disc:          t.Disc,
+			DiscTotal:     t.DiscTotal,
+			Start:         t.Start,
+			End:           t.End,
+			Title:         t.Title,
+			Artist:        t.Artist,
+			AlbumArtist:   t.AlbumArtist,
+			Album:         t.Album,
+			Composer:      t.Composer,
+			Work:          t.Work,
+			Movement:      t.Movement,
+			Movements:     t.Movements,
+			Year:          t.Year,
+			Genre:         t.Genre,
+			Comment:       t.Comment,
+			Label:         t.Label,
+			CatalogNumber: t.CatalogNumber,
+			ISRC:          t.ISRC,
+			Output:        t.outputFilename(opts.Filename),
+			Reencode:      t.Reencode,
+			Video:         t.Video,
+			Codec:         t.Codec,
+			Bitrate:       t.Bitrate,
+			Quality:       t.Quality,
+			Filter:        t.Filter,
+			Speed:         t.Speed,
+			StartSample:   t.StartSample,
+			EndSample:     t.EndSample,
+			SampleRate:    t.SampleRate,
+			FadeIn:        t.FadeIn.String(),
+			FadeOut:       t.FadeOut.String(),
+			Cover:         t.Cover,
+			InputArgs:     t.InputArgs,
+			ExtraArgs:     t.ExtraArgs,
+			Stream:        t.Stream,
+			Bitexact:      t.Bitexact,
+		})
+	}
+
+	b, err := json.Marshal(planned)
+	if err != nil {
+		return "", "", err
+	}
+	return source, fmt.Sprintf("%x", sha256.Sum256(b)), nil
+}
+
+// tracksByDir returns the output files of the tracks by their directory.
+func tracksByDir(opts Options, tracks Tracklist) map[string][]string {
+	dirs := make(map[string][]string)
+	for _, t := range tracks {
+		out := t.outputFilename(opts.Filename)
+		dirs[filepath.Dir(out)] = append(dirs[filepath.Dir(out)], out)
+	}
+	return dirs
+}
+
+// alreadySplit reports whether every output directory records a split of
+// the same source and tracklist, with its tracks still there.
+func alreadySplit(opts Options, tracks Tracklist, source, sum string) bool {
+	for dir, files := range tracksByDir(opts, tracks) {
+		b, err := os.ReadFile(filepath.Join(dir, splitRecordName))
+		if err != nil {
+			return false
+		}
+
+		var r splitRecord
+		if err := json.Unmarshal(b, &r); err != nil || r.SourceSHA256 != source || r.TracksSHA256 != sum {
+			return false
+		}
+
+		for _, f := range files {
+			if _, err := os.Stat(f); err != nil {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+// recordSplit writes the split record into each output directory.
+func recordSplit(opts Options, tracks Tracklist, source, sum string) error {
+	byDir := tracksByDir(opts, tracks)
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	now := time.Now().UTC()
+	if opts.Reproducible {
+		// The record is the same on every run too
+		now = opts.Date.UTC()
+	}
+	for _, dir := range dirs {
+		r := splitRecord{
+			Source:       opts.Filename,
+			SourceSHA256: source,
+			TracksSHA256: sum,
+			Split:        now,
+		}
+		for _, f := range byDir[dir] {
+			r.Tracks = append(r.Tracks, filepath.Base(f))
+		}
+
+		b, err := json.MarshalIndent(r, "", "  ")
+		if err != nil {
+			return err
+		}
+
+		if err := writeSplitRecord(opts, filepath.Join(dir, splitRecordName), b); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeSplitRecord writes the record b to path, renamed into place so a run
+// checking it while another writes it never reads half of it.
+func writeSplitRecord(opts Options, path string, b []byte) error {
+	unlock, err := lockOutput(context.Background(), opts, path)
+	if err != nil {
+		return err
+	}
+	defer unlock()
+
+	f, err := os.CreateTemp(filepath.Dir(path), ".avsplit-*")
+	if err != nil {
+		return fmt.Errorf("cannot write split record: %v", err)
+	}
+	defer os.Remove(f.Name())
+
+	_, err = f.Write(append(b, '\n'))
+	if cerr := f.Close(); err == nil {
+		err = cerr
+	}
+	if err == nil {
+		err = os.Chmod(f.Name(), 0644)
+	}
+	if err == nil {
+		err = opts.setFilePerms(f.Name())
+	}
+	if err != nil {
+		return fmt.Errorf("cannot write split record: %v", err)
+	}
+	return os.Rename(f.Name(), path)
+}
@@ -0,0 +1,119 @@
+package avsplit
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// trackDirective matches an "@format=flac" or "@skip" directive in the title
+// of a timecodes line.
+var trackDirective = regexp.MustCompile(`(?:^|\s)@([a-z][a-z-]*)(=?)`)
+
+// trackDirectives are the directives a line can end with to set the track's
+// own tags and output, and whether they take a value. Other words starting
+// with @ are part of the title.
+var trackDirectives = map[string]bool{
+	"artist":       true,
+	"album-artist": true,
+	"album":        true,
+	"composer":     true,
+	"work":         true,
+	"movement":     true,
+	"year":         true,
+	"genre":        true,
+	"comment":      true,
+	"format":       true,
+	"codec":        true,
+	"bitrate":      true,
+	"quality":      true,
+	"encode":       false,
+	"video":        false,
+	"skip":         false,
+}
+
+// parseDirectives splits the directives off the end of a title, such as
+// "Encore @artist=Guest Star @format=flac". A value runs to the next
+// directive.
+func parseDirectives(title string) (string, map[string]string, error) {
+	var found [][]int
+	for _, m := range trackDirective.FindAllStringSubmatchIndex(title, -1) {
+		if _, ok := trackDirectives[title[m[2]:m[3]]]; ok {
+			found = append(found, m)
+		}
+	}
+
+	if len(found) == 0 {
+		return title, nil, nil
+	}
+
+	directives := make(map[string]string)
+	for i, m := range found {
+		key, hasValue := title[m[2]:m[3]], m[5] > m[4]
+		end := len(title)
+		if i < len(found)-1 {
+			end = found[i+1][0]
+		}
+		value := strings.TrimSpace(title[m[5]:end])
+
+		if !hasValue && value != "" {
+			return "", nil, fmt.Errorf("unexpected %v after @%v", strings.Fields(value)[0], key)
+		}
+
+		if trackDirectives[key] && value == "" {
+			return "", nil, fmt.Errorf("@%v needs a value, as in @%v=...", key, key)
+		}
+
+		if !trackDirectives[key] && hasValue {
+			return "", nil, fmt.Errorf("@%v takes no value", key)
+		}
+
+		if n, err := strconv.Atoi(value); key == "movement" && (err != nil || n < 1) {
+			return "", nil, fmt.Errorf("@movement needs a number from 1, not %v", value)
+		}
+		directives[key] = value
+	}
+
+	return strings.TrimSpace(title[:found[0][0]]), directives, nil
+}
+
+// applyDirectives sets the tags and output settings of a track from its
+// directives. The output settings are used over the run's when the track is
+// prepared.
+func applyDirectives(t *Track, directives map[string]string) {
+	for key, value := range directives {
+		switch key {
+		case "artist":
+			t.Artist = value
+		case "album-artist":
+			t.AlbumArtist = value
+		case "album":
+			t.Album = value
+		case "composer":
+			t.Composer = value
+		case "work":
+			t.Work = value
+		case "movement":
+			t.Movement, _ = strconv.Atoi(value)
+		case "year":
+			t.Year = value
+		case "genre":
+			t.Genre = value
+		case "comment":
+			t.Comment = value
+		case "format":
+			t.Format = value
+		case "codec":
+			t.Codec = value
+		case "bitrate":
+			t.Bitrate = value
+		case "quality":
+			t.Quality = value
+		case "encode":
+			t.Encode = true
+		case "video":
+			t.Video = true
+		}
+	}
+}
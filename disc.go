@@ -0,0 +1,91 @@
+package avsplit
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// discMarker matches a "# DISC 2" line of a timecodes file, which starts
+// the tracks of another disc.
+var discMarker = regexp.MustCompile(`^#\s*(?i:disc|cd)\s*(\d+)$`)
+
+// parseDiscMarker returns the disc a line starts, if it is a disc marker.
+func parseDiscMarker(line string) (int, bool) {
+	m := discMarker.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return 0, false
+	}
+
+	n, err := strconv.Atoi(m[1])
+	if err != nil || n < 1 {
+		return 0, false
+	}
+	return n, true
+}
+
+// multiDisc reports whether the tracks are on more than one disc.
+func multiDisc(tracks Tracklist) bool {
+	for _, t := range tracks {
+		if t.Disc != tracks[0].Disc {
+			return true
+		}
+	}
+	return false
+}
+
+// numberDiscs numbers the tracks from 1 on each disc and sets their track
+// and disc totals, when the tracks are on more than one disc.
+func numberDiscs(tracks Tracklist) {
+	if !multiDisc(tracks) {
+		return
+	}
+
+	counts := make(map[int]int)
+	discTotal := 0
+	for i := range tracks {
+		t := &tracks[i]
+		counts[t.Disc]++
+		t.Number = counts[t.Disc]
+		if t.Disc > discTotal {
+			discTotal = t.Disc
+		}
+	}
+
+	for i := range tracks {
+		tracks[i].Total = counts[tracks[i].Disc]
+		tracks[i].DiscTotal = discTotal
+		tracks[i].MultiDisc = true
+	}
+}
+
+// splitDiscs puts the tracks on discs by the number of tracks on each, such
+// as "12,10" for twelve tracks on disc 1 and ten on disc 2.
+func splitDiscs(tracks Tracklist, discs string) error {
+	var counts []int
+	sum := 0
+	for _, part := range strings.Split(discs, ",") {
+		n, err := strconv.Atoi(strings.TrimSpace(part))
+		if err != nil || n < 1 {
+			return fmt.Errorf("invalid discs %v", discs)
+		}
+		counts = append(counts, n)
+		sum += n
+	}
+
+	if sum != len(tracks) {
+		return fmt.Errorf("discs add up to %d tracks, there are %d tracks", sum, len(tracks))
+	}
+
+	i := 0
+	for disc, n := range counts {
+		for ; n > 0; n-- {
+			tracks[i].Disc = disc + 1
+			i++
+		}
+	}
+
+	numberDiscs(tracks)
+	return nil
+}
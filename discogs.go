@@ -0,0 +1,193 @@
+package avsplit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+var discogsURL = "https://api.discogs.com"
+
+var discogsClient = &http.Client{Timeout: 30 * time.Second, Transport: lookupTransport}
+
+var (
+	// "249504", "r249504", "[r249504]" or a release page URL
+	discogsReleaseID = regexp.MustCompile(`^(?:\[?r?(\d+)\]?|.*/release/(\d+)\S*)$`)
+
+	// The number Discogs adds to tell apart artists of the same name, as in
+	// "Nirvana (2)"
+	discogsNameNumber = regexp.MustCompile(`\s+\(\d+\)$`)
+)
+
+type discogsArtists []struct {
+	Name string `json:"name"`
+	// ANV is the name the artist is credited as on the release
+	ANV  string `json:"anv"`
+	Join string `json:"join"`
+}
+
+// names returns the names the artists are credited as.
+func (a discogsArtists) names() []string {
+	var names []string
+	for _, artist := range a {
+		name := artist.ANV
+		if name == "" {
+			name = discogsNameNumber.ReplaceAllString(artist.Name, "")
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+func (a discogsArtists) String() string {
+	var s string
+	for i, name := range a.names() {
+		artist := a[i]
+		s += name
+
+		if i < len(a)-1 {
+			switch join := strings.TrimSpace(artist.Join); join {
+			case "", ",":
+				s += join + " "
+			default:
+				s += " " + join + " "
+			}
+		}
+	}
+	return s
+}
+
+type discogsTrack struct {
+	Position string         `json:"position"`
+	Type     string         `json:"type_"`
+	Title    string         `json:"title"`
+	Duration string         `json:"duration"`
+	Artists  discogsArtists `json:"artists"`
+}
+
+type discogsRelease struct {
+	ID      int            `json:"id"`
+	Title   string         `json:"title"`
+	Year    int            `json:"year"`
+	Artists discogsArtists `json:"artists"`
+	Labels  []struct {
+		Name  string `json:"name"`
+		CatNo string `json:"catno"`
+	} `json:"labels"`
+	Tracklist []discogsTrack `json:"tracklist"`
+}
+
+// tracks returns the release's tracks, without the headings of its sides
+// or parts. A track of several sub-tracks counts as one.
+func (r *discogsRelease) tracks() []discogsTrack {
+	var tracks []discogsTrack
+	for _, t := range r.Tracklist {
+		if t.Type == "track" || t.Type == "index" {
+			tracks = append(tracks, t)
+		}
+	}
+	return tracks
+}
+
+func (r *discogsRelease) label() (string, string) {
+	if len(r.Labels) == 0 {
+		return "", ""
+	}
+
+	l := r.Labels[0]
+	catno := l.CatNo
+	if strings.EqualFold(catno, "none") {
+		catno = ""
+	}
+	return discogsNameNumber.ReplaceAllString(l.Name, ""), catno
+}
+
+type discogs struct{}
+
+// DiscogsProvider returns the provider of releases from Discogs, looked up
+// by their ID or the URL of their page. Discogs can't be searched without
+// a token, so it finds no release by the artist and album.
+func DiscogsProvider() MetadataProvider {
+	return discogs{}
+}
+
+func (discogs) Name() string {
+	return "discogs"
+}
+
+func (discogs) Lookup(ctx context.Context, artist, album string) (*Release, error) {
+	return nil, fmt.Errorf("discogs: releases can only be looked up by their ID or URL")
+}
+
+func (discogs) LookupByID(ctx context.Context, idOrURL string) (*Release, error) {
+	m := discogsReleaseID.FindStringSubmatch(strings.TrimSpace(idOrURL))
+	if m == nil {
+		return nil, fmt.Errorf("discogs: invalid release %v, must be its ID or URL", idOrURL)
+	}
+	id := m[1] + m[2]
+
+	req, err := http.NewRequestWithContext(ctx, "GET", discogsURL+"/releases/"+id, nil)
+	if err != nil {
+		return nil, err
+	}
+	// Discogs rejects clients without a user agent of their own
+	req.Header.Set("User-Agent", musicBrainzUserAgent)
+
+	res, err := discogsClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("discogs: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("discogs: %v", res.Status)
+	}
+
+	var release discogsRelease
+	if err := json.NewDecoder(res.Body).Decode(&release); err != nil {
+		return nil, fmt.Errorf("discogs: invalid response: %v", err)
+	}
+
+	r := &Release{
+		Provider: "discogs",
+		ID:       id,
+		Artist:   release.Artists.String(),
+		Title:    release.Title,
+	}
+	if release.Year > 0 {
+		r.Year = strconv.Itoa(release.Year)
+	}
+	r.Label, r.CatalogNumber = release.label()
+	for _, t := range release.tracks() {
+		d, _ := parseDiscogsDuration(t.Duration)
+		r.Tracks = append(r.Tracks, ReleaseTrack{
+			Title:   t.Title,
+			Artist:  t.Artists.String(),
+			Artists: t.Artists.names(),
+			Length:  d,
+		})
+	}
+	return r, nil
+}
+
+// parseDiscogsDuration parses a track duration such as "3:45" or "1:02:03".
+func parseDiscogsDuration(s string) (time.Duration, bool) {
+	if s == "" {
+		return 0, false
+	}
+
+	var d time.Duration
+	for _, part := range strings.Split(s, ":") {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return 0, false
+		}
+		d = d*60 + time.Duration(n)*time.Second
+	}
+	return d, true
+}
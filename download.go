@@ -0,0 +1,155 @@
+package avsplit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"path"
+	"path/filepath"
+	"time"
+)
+
+// downloadClient has no timeout, long recordings take a while to download.
+// A stalled download is ended through the context instead.
+var downloadClient = &http.Client{}
+
+// cacheDir returns the directory downloads are kept in, opts.CacheDir or
+// avsplit under the user's cache directory.
+func cacheDir(opts Options) (string, error) {
+	if opts.CacheDir != "" {
+		return opts.CacheDir, nil
+	}
+
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("no cache directory, see -cache-dir: %v", err)
+	}
+	return filepath.Join(dir, "avsplit"), nil
+}
+
+// cacheFilename returns the name a download of rawURL is cached under,
+// keyed by the whole URL but keeping the file's own name and extension.
+func cacheFilename(rawURL string) string {
+	sum := sha256.Sum256([]byte(rawURL))
+	key := hex.EncodeToString(sum[:8])
+
+	name := ""
+	if u, err := url.Parse(rawURL); err == nil {
+		name = path.Base(u.Path)
+	}
+	if name == "" || name == "." || name == "/" {
+		return key
+	}
+	return key + "-" + sanitizeName(name, false)
+}
+
+// cachedDownload returns the local copy of audioFile when it is a URL,
+// downloading it into the cache directory first unless an earlier run
+// already did. An interrupted download is resumed where it stopped.
+// Anything else is returned unchanged.
+func cachedDownload(ctx context.Context, opts Options, audioFile string) (string, error) {
+	if !isURL(audioFile) {
+		return audioFile, nil
+	}
+
+	dir, err := cacheDir(opts)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", err
+	}
+
+	out := filepath.Join(dir, cacheFilename(audioFile))
+	if _, err := os.Stat(out); err == nil {
+		opts.logf("using cached download %v\n", out)
+		return out, nil
+	}
+
+	if opts.Offline {
+		return "", fmt.Errorf("offline, and %v not downloaded yet", audioFile)
+	}
+
+	part := out + ".part"
+	if err := download(ctx, opts, audioFile, part); err != nil {
+		return "", err
+	}
+
+	if err := os.Rename(part, out); err != nil {
+		return "", err
+	}
+	return out, nil
+}
+
+// download fetches rawURL into filename, asking only for the rest of the
+// file when part of it is already there.
+func download(ctx context.Context, opts Options, rawURL, filename string) error {
+	var offset int64
+	if info, err := os.Stat(filename); err == nil {
+		offset = info.Size()
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", rawURL, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", musicBrainzUserAgent)
+	if offset > 0 {
+		req.Header.Set("Range", fmt.Sprintf("bytes=%d-", offset))
+	}
+
+	res, err := downloadClient.Do(req)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("cannot download %v: %v", rawURL, err)
+	}
+	defer res.Body.Close()
+
+	flags := os.O_CREATE | os.O_WRONLY
+	switch {
+	case offset > 0 && res.StatusCode == http.StatusPartialContent:
+		opts.logf("resuming download of %v at %d bytes\n", rawURL, offset)
+		flags |= os.O_APPEND
+	case offset > 0 && res.StatusCode == http.StatusRequestedRangeNotSatisfiable:
+		// Interrupted after the last byte but before it was renamed
+		return nil
+	case res.StatusCode == http.StatusOK:
+		// Servers that ignore the range send the whole file again
+		opts.logf("downloading %v\n", rawURL)
+		flags |= os.O_TRUNC
+	default:
+		return fmt.Errorf("cannot download %v: %v", rawURL, res.Status)
+	}
+
+	f, err := os.OpenFile(filename, flags, 0600)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(f, res.Body); err != nil {
+		f.Close()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("cannot download %v: %v", rawURL, err)
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	// Kept so -preserve-mtime has the recording's own date to go by
+	if modified, err := http.ParseTime(res.Header.Get("Last-Modified")); err == nil {
+		os.Chtimes(filename, time.Now(), modified)
+	}
+
+	return nil
+}
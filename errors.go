@@ -0,0 +1,96 @@
+package avsplit
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrorKind tells what sort of failure an error of a run is, so scripts
+// around avsplit can tell them apart by its exit code.
+type ErrorKind int
+
+const (
+	// ErrOther is any failure not listed below.
+	ErrOther ErrorKind = iota
+	// ErrInvalidInput is an invalid option, a missing audio file or tracks
+	// that don't fit it.
+	ErrInvalidInput
+	// ErrParse is a tracklist that cannot be read.
+	ErrParse
+	// ErrMissingTool is ffmpeg or another required tool not being found.
+	ErrMissingTool
+	// ErrPartial is some of the tracks failing, the rest were split.
+	ErrPartial
+	// ErrFailed is every track failing.
+	ErrFailed
+)
+
+// Error is an error of a run with its kind.
+type Error struct {
+	Kind ErrorKind
+	Err  error
+}
+
+func (e *Error) Error() string {
+	return e.Err.Error()
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// KindOf returns the kind of an error returned by avsplit, ErrOther when it
+// has none.
+func KindOf(err error) ErrorKind {
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Kind
+	}
+	return ErrOther
+}
+
+// withKind gives err a kind, unless it is nil or already has one.
+func withKind(kind ErrorKind, err error) error {
+	var e *Error
+	if err == nil || errors.As(err, &e) {
+		return err
+	}
+	return &Error{kind, err}
+}
+
+// failedKind returns the kind of failed out of total tracks or entries.
+func failedKind(failed, total int) ErrorKind {
+	if failed < total {
+		return ErrPartial
+	}
+	return ErrFailed
+}
+
+// lineError is an error about a line of the tracklist, which Check points
+// at. The message is its own, usually naming the line already.
+type lineError struct {
+	line int
+	err  error
+}
+
+func (e *lineError) Error() string {
+	return e.err.Error()
+}
+
+func (e *lineError) Unwrap() error {
+	return e.err
+}
+
+// lineErrorf formats an error about a line of the tracklist, 0 if unknown.
+func lineErrorf(line int, format string, a ...interface{}) error {
+	return &lineError{line, fmt.Errorf(format, a...)}
+}
+
+// errorLine returns the line of the tracklist err is about, 0 if unknown.
+func errorLine(err error) int {
+	var e *lineError
+	if errors.As(err, &e) {
+		return e.line
+	}
+	return 0
+}
@@ -0,0 +1,73 @@
+package avsplit
+
+import (
+	"sync"
+	"time"
+)
+
+// EventKind is what an Event reports.
+type EventKind int
+
+const (
+	// TrackStarted is sent as a track starts being extracted
+	TrackStarted EventKind = iota
+	// TrackProgress is sent as ffmpeg writes more of the track
+	TrackProgress
+	// TrackDone is sent once the track is tagged and in place, or was
+	// left by an earlier run
+	TrackDone
+	// TrackFailed is sent when a track failed, after any retries
+	TrackFailed
+	// RunCompleted is sent last, once Split returns
+	RunCompleted
+)
+
+func (k EventKind) String() string {
+	switch k {
+	case TrackStarted:
+		return "track started"
+	case TrackProgress:
+		return "track progress"
+	case TrackDone:
+		return "track done"
+	case TrackFailed:
+		return "track failed"
+	case RunCompleted:
+		return "run completed"
+	}
+	return "unknown event"
+}
+
+// Event reports on a split to Options.Events, for a program embedding
+// avsplit to show its own progress instead of reading the log.
+type Event struct {
+	Kind EventKind
+	// Track and File, its output file, are those of the track the event
+	// is about, unset for RunCompleted
+	Track Track
+	File  string
+	// Percent is how much of the track TrackProgress has been written, -1
+	// when its length is unknown, and Elapsed how much of its audio
+	Percent float64
+	Elapsed time.Duration
+	// Skipped is set on TrackDone for a track an earlier run wrote in full
+	Skipped bool
+	// Err is why the track of TrackFailed failed, and the error Split
+	// returns for RunCompleted, nil when it succeeded
+	Err error
+}
+
+// eventsMu calls Options.Events one event at a time from the tracks split
+// concurrently.
+var eventsMu sync.Mutex
+
+// event sends e to the Events callback, if there is one.
+func (o Options) event(e Event) {
+	if o.Events == nil {
+		return
+	}
+
+	eventsMu.Lock()
+	defer eventsMu.Unlock()
+	o.Events(e)
+}
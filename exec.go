@@ -0,0 +1,116 @@
+package avsplit
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// Executor runs the external tools, ffmpeg, ffprobe, eyed3 and the rest.
+// Options.Executor replaces the default, which runs them with os/exec, so
+// tests can check the commands of a split without the tools installed.
+type Executor interface {
+	// Run runs name with args until it exits, writing its output to
+	// stdout and stderr, either of which may be nil to discard it.
+	Run(ctx context.Context, name string, args []string, stdout, stderr io.Writer) error
+}
+
+type execExecutor struct{}
+
+func (execExecutor) Run(ctx context.Context, name string, args []string, stdout, stderr io.Writer) error {
+	cmd := exec.CommandContext(ctx, name, args...)
+	// eyed3 is Python, which without a UTF-8 locale decodes non-ASCII
+	// arguments as something else
+	cmd.Env = append(os.Environ(), "PYTHONUTF8=1")
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	return cmd.Run()
+}
+
+type optionsKey struct{}
+
+// withOptions returns a context carrying o, so commands run deep inside a
+// split find the tools' paths and are logged at debug level.
+func (o Options) withOptions(ctx context.Context) context.Context {
+	return context.WithValue(ctx, optionsKey{}, o)
+}
+
+// debugf logs at debug level through the options carried by ctx, if any.
+func debugf(ctx context.Context, format string, a ...interface{}) {
+	if o, ok := ctx.Value(optionsKey{}).(Options); ok {
+		o.debugf(format, a...)
+	}
+}
+
+// runCommand runs c through the executor of the options carried by ctx,
+// logging its full command line at debug level.
+func runCommand(ctx context.Context, c string, arg []string, stdout, stderr io.Writer) error {
+	var e Executor = execExecutor{}
+	o, ok := ctx.Value(optionsKey{}).(Options)
+	if ok && o.Executor != nil {
+		e = o.Executor
+	}
+
+	if ok && c == "ffmpeg" {
+		c, arg = o.throttle(toolPath(ctx, c), arg)
+	} else {
+		c = toolPath(ctx, c)
+	}
+	debugf(ctx, "running %v\n", shellCommand(c, arg...))
+	return e.Run(ctx, c, arg, stdout, stderr)
+}
+
+// argPath returns the file p as a command argument that can't be taken for
+// an option, as a name starting with "-" would be, say a track titled
+// "-intro-" written to "{title}.{ext}".
+func argPath(p string) string {
+	if strings.HasPrefix(p, "-") {
+		return "." + string(filepath.Separator) + p
+	}
+	return p
+}
+
+// debugStderr logs what a command wrote to stderr at debug level.
+func debugStderr(ctx context.Context, c string, stderr bytes.Buffer) {
+	if s := strings.TrimSpace(stderr.String()); s != "" {
+		debugf(ctx, "%v stderr:\n%v\n", c, s)
+	}
+}
+
+func commandOutput(ctx context.Context, c string, arg ...string) (string, error) {
+	var stdout, stderr bytes.Buffer
+	err := runCommand(ctx, c, arg, &stdout, &stderr)
+	debugStderr(ctx, c, stderr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return "", ctx.Err()
+		}
+		if stderr.Len() == 0 {
+			return "", err
+		}
+		return "", toolError(c, stderr.String())
+	}
+
+	return stdout.String(), nil
+}
+
+func execCommand(ctx context.Context, c string, arg ...string) error {
+	var stderr bytes.Buffer
+	err := runCommand(ctx, c, arg, nil, &stderr)
+	debugStderr(ctx, c, stderr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if stderr.Len() == 0 {
+			return err
+		}
+		return toolError(c, stderr.String())
+	}
+
+	return nil
+}
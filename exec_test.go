@@ -0,0 +1,573 @@
+package avsplit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// fakeExecutor records the commands it is asked to run and answers ffprobe
+// and -version as the real tools would for a 10 minute MP3. ffmpeg writes an
+// empty output file, even when it fails part way.
+type fakeExecutor struct {
+	mu    sync.Mutex
+	calls [][]string
+	fail  string
+}
+
+func (e *fakeExecutor) Run(ctx context.Context, name string, args []string, stdout, stderr io.Writer) error {
+	e.mu.Lock()
+	e.calls = append(e.calls, append([]string{name}, args...))
+	e.mu.Unlock()
+
+	if stdout == nil {
+		stdout = io.Discard
+	}
+
+	if filepath.Base(name) == "ffmpeg" && len(args) > 1 && args[len(args)-1] != "-" {
+		if err := os.WriteFile(args[len(args)-1], nil, 0600); err != nil {
+			return err
+		}
+	}
+
+	joined := strings.Join(args, " ")
+	if e.fail != "" && strings.Contains(joined, e.fail) {
+		fmt.Fprintf(stderr, "%v failed\n", name)
+		return fmt.Errorf("exit status 1")
+	}
+
+	switch {
+	case len(args) == 1 && args[0] == "-version":
+		fmt.Fprintf(stdout, "%v version 6.1.1\n", filepath.Base(name))
+	case strings.Contains(joined, "format=duration"):
+		fmt.Fprintln(stdout, "600.0")
+	case strings.Contains(joined, "stream=codec_name"):
+		fmt.Fprintln(stdout, "mp3")
+	case strings.Contains(joined, "stream=sample_rate"):
+		fmt.Fprintln(stdout, "44100")
+	case strings.Contains(joined, "stream=codec_type"):
+		fmt.Fprintln(stdout, "audio")
+	case strings.Contains(joined, "frame=pts_time"):
+		fmt.Fprintln(stdout, "0.000000")
+	}
+	return nil
+}
+
+// commands returns the recorded calls of the tool name.
+func (e *fakeExecutor) commands(name string) [][]string {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	var calls [][]string
+	for _, c := range e.calls {
+		if c[0] == name {
+			calls = append(calls, c[1:])
+		}
+	}
+	return calls
+}
+
+func writeTestFile(t *testing.T, name, content string) string {
+	t.Helper()
+	p := filepath.Join(t.TempDir(), name)
+	if err := os.WriteFile(p, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestSplitCommands(t *testing.T) {
+	e := &fakeExecutor{}
+	audio := writeTestFile(t, "live.mp3", "")
+	out := t.TempDir()
+
+	err := Split(context.Background(), Options{
+		Filename:  audio,
+		Timecodes: writeTestFile(t, "tracks.txt", "00:00 Intro\n03:10 Song Two\n"),
+		Artist:    "Artist",
+		Album:     "Album",
+		OutputDir: out,
+		Tagger:    "ffmpeg",
+		Executor:  e,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{
+		{
+			"-nostdin", "-y", "-loglevel", "error",
+			"-ss", "00:00:00", "-to", "00:03:10", "-i", audio, "-vn", "-c", "copy",
+			"-map_metadata", "-1",
+			"-metadata", "title=Intro",
+			"-metadata", "artist=Artist",
+			"-metadata", "album_artist=Artist",
+			"-metadata", "album=Album",
+			"-metadata", "track=1/2",
+			"-metadata", "TLEN=190000",
+			filepath.Join(out, "Artist", "Album", ".avsplit-01 - Intro.mp3"),
+		},
+		{
+			"-nostdin", "-y", "-loglevel", "error",
+			"-ss", "00:03:10", "-i", audio, "-vn", "-c", "copy",
+			"-map_metadata", "-1",
+			"-metadata", "title=Song Two",
+			"-metadata", "artist=Artist",
+			"-metadata", "album_artist=Artist",
+			"-metadata", "album=Album",
+			"-metadata", "track=2/2",
+			"-metadata", "TLEN=410000",
+			filepath.Join(out, "Artist", "Album", ".avsplit-02 - Song Two.mp3"),
+		},
+	}
+
+	var got [][]string
+	for _, c := range e.commands("ffmpeg") {
+		if len(c) > 1 {
+			got = append(got, c)
+		}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ffmpeg commands =\n%q\nwant\n%q", got, want)
+	}
+
+	// Renamed into place once tagged
+	for _, name := range []string{"01 - Intro.mp3", "02 - Song Two.mp3"} {
+		if _, err := os.Stat(filepath.Join(out, "Artist", "Album", name)); err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+// interruptingExecutor runs commands as fakeExecutor does, but is
+// interrupted, cancelling the split, when it runs the command name.
+type interruptingExecutor struct {
+	fakeExecutor
+	name   string
+	cancel context.CancelFunc
+}
+
+func (e *interruptingExecutor) Run(ctx context.Context, name string, args []string, stdout, stderr io.Writer) error {
+	if name == e.name {
+		e.cancel()
+		return ctx.Err()
+	}
+	return e.fakeExecutor.Run(ctx, name, args, stdout, stderr)
+}
+
+func TestSplitInterruptedKeepsFinishedTrack(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	out := t.TempDir()
+	err := Split(ctx, Options{
+		Filename:  writeTestFile(t, "live.mp3", ""),
+		Timecodes: writeTestFile(t, "tracks.txt", "00:00 Intro\n"),
+		Artist:    "Artist",
+		Album:     "Album",
+		OutputDir: out,
+		Tagger:    "ffmpeg",
+		PostCmd:   "true",
+		Executor:  &interruptingExecutor{name: "env", cancel: cancel},
+	})
+	if err == nil {
+		t.Fatal("Split() succeeded, want it interrupted")
+	}
+
+	// Interrupted in post-cmd, once the track was renamed into place
+	if _, err := os.Stat(filepath.Join(out, "Artist", "Album", "01 - Intro.mp3")); err != nil {
+		t.Errorf("finished track removed: %v", err)
+	}
+}
+
+func TestSplitOverwriteNever(t *testing.T) {
+	e := &fakeExecutor{}
+	out := t.TempDir()
+	opts := Options{
+		Filename:  writeTestFile(t, "live.mp3", ""),
+		Timecodes: writeTestFile(t, "tracks.txt", "00:00 Intro\n"),
+		Artist:    "Artist",
+		Album:     "Album",
+		OutputDir: out,
+		Tagger:    "ffmpeg",
+		Overwrite: "never",
+		Executor:  e,
+	}
+	if err := Split(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range e.commands("ffmpeg") {
+		if len(c) > 1 && c[1] != "-n" {
+			t.Errorf("ffmpeg %q, want -n with never", c)
+		}
+	}
+
+	// A track written since checkOverwrite is kept
+	track := filepath.Join(out, "Artist", "Album", "01 - Intro.mp3")
+	part := writeTestFile(t, "part.mp3", "new")
+	if err := os.WriteFile(track, []byte("old"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := opts.rename(part, track); err == nil {
+		t.Error("rename() over an existing track succeeded with never")
+	}
+	if b, _ := os.ReadFile(track); string(b) != "old" {
+		t.Errorf("track = %q, want it kept", b)
+	}
+}
+
+func TestSplitDryRunExports(t *testing.T) {
+	e := &fakeExecutor{}
+	dir := t.TempDir()
+	var log strings.Builder
+	err := Split(context.Background(), Options{
+		Filename:       writeTestFile(t, "live.mp3", ""),
+		Timecodes:      writeTestFile(t, "tracks.txt", "00:00 Intro\n03:10 Song Two\n"),
+		AudacityLabels: filepath.Join(dir, "labels.txt"),
+		VTTOut:         filepath.Join(dir, "chapters.vtt"),
+		AddChapters:    filepath.Join(dir, "live.m4a"),
+		DryRun:         true,
+		Log:            &log,
+		Executor:       e,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if files, _ := os.ReadDir(dir); len(files) > 0 {
+		t.Errorf("dry run wrote %v", files)
+	}
+	for _, c := range e.commands("ffmpeg") {
+		if len(c) > 1 {
+			t.Errorf("dry run ran ffmpeg %q", c)
+		}
+	}
+	for _, want := range []string{"190.000000\t190.000000\tSong Two", "WEBVTT", "-map_chapters 1"} {
+		if !strings.Contains(log.String(), want) {
+			t.Errorf("dry run printed\n%v\nwant %q in it", log.String(), want)
+		}
+	}
+}
+
+func TestSplitTagsCSVTrackStart(t *testing.T) {
+	e := &fakeExecutor{}
+	opts := Options{
+		Filename:   writeTestFile(t, "disc2.mp3", ""),
+		Timecodes:  writeTestFile(t, "tracks.txt", "00:00 Intro\n03:10 Song Two\n"),
+		TagsCSV:    writeTestFile(t, "tags.csv", "number,title\n12,Encore\n"),
+		TrackStart: 11,
+		Artist:     "Artist",
+		Album:      "Album",
+		OutputDir:  t.TempDir(),
+		Tagger:     "ffmpeg",
+		Executor:   e,
+	}
+	if err := Split(context.Background(), opts); err != nil {
+		t.Fatal(err)
+	}
+
+	var titles []string
+	for _, c := range e.commands("ffmpeg") {
+		for _, a := range c {
+			if strings.HasPrefix(a, "title=") {
+				titles = append(titles, a)
+			}
+		}
+	}
+	sort.Strings(titles)
+	if want := []string{"title=Encore", "title=Intro"}; !reflect.DeepEqual(titles, want) {
+		t.Errorf("titles = %q, want %q", titles, want)
+	}
+
+	// Track 1 is numbered 11
+	opts.TagsCSV = writeTestFile(t, "old.csv", "number,title\n1,Encore\n")
+	opts.OutputDir = t.TempDir()
+	if err := Split(context.Background(), opts); err == nil || !strings.Contains(err.Error(), "no track number 1") {
+		t.Errorf("Split() = %v, want no track number 1", err)
+	}
+}
+
+func TestSplitSingleTrack(t *testing.T) {
+	e := &fakeExecutor{}
+	audio := writeTestFile(t, "show.mp3", "")
+	out := t.TempDir()
+
+	// A bare timecode trims the pre-show off and names the rest after the
+	// album
+	err := Split(context.Background(), Options{
+		Filename:  audio,
+		Timecodes: writeTestFile(t, "tracks.txt", "00:00:30\n"),
+		Artist:    "Artist",
+		Album:     "Live",
+		OutputDir: out,
+		Tagger:    "ffmpeg",
+		Executor:  e,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{{
+		"-nostdin", "-y", "-loglevel", "error",
+		"-ss", "00:00:30", "-i", audio, "-vn", "-c", "copy",
+		"-map_metadata", "-1",
+		"-metadata", "title=Live",
+		"-metadata", "artist=Artist",
+		"-metadata", "album_artist=Artist",
+		"-metadata", "album=Live",
+		"-metadata", "track=1/1",
+		"-metadata", "TLEN=570000",
+		filepath.Join(out, "Artist", "Live", ".avsplit-01 - Live.mp3"),
+	}}
+
+	var got [][]string
+	for _, c := range e.commands("ffmpeg") {
+		if len(c) > 1 {
+			got = append(got, c)
+		}
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ffmpeg commands =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestSplitToolPaths(t *testing.T) {
+	e := &fakeExecutor{}
+	err := Split(context.Background(), Options{
+		Filename:    writeTestFile(t, "live.mp3", ""),
+		Timecodes:   writeTestFile(t, "tracks.txt", "00:00 Intro\n"),
+		Artist:      "Artist",
+		Album:       "Album",
+		OutputDir:   t.TempDir(),
+		Tagger:      "ffmpeg",
+		FFmpegPath:  "/opt/ffmpeg/bin/ffmpeg",
+		FFprobePath: "/opt/ffmpeg/bin/ffprobe",
+		Executor:    e,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, c := range e.calls {
+		if c[0] != "/opt/ffmpeg/bin/ffmpeg" && c[0] != "/opt/ffmpeg/bin/ffprobe" {
+			t.Errorf("ran %v, want the configured paths", c[0])
+		}
+	}
+}
+
+func TestSplitFailedTrack(t *testing.T) {
+	e := &fakeExecutor{fail: "title=Song Two"}
+	out := t.TempDir()
+	err := Split(context.Background(), Options{
+		Filename:  writeTestFile(t, "live.mp3", ""),
+		Timecodes: writeTestFile(t, "tracks.txt", "00:00 Intro\n03:10 Song Two\n05:00 Last\n"),
+		Artist:    "Artist",
+		Album:     "Album",
+		OutputDir: out,
+		Tagger:    "ffmpeg",
+		Executor:  e,
+	})
+	if err == nil || err.Error() != "1 of 3 tracks failed" {
+		t.Fatalf("Split() error = %v, want 1 of 3 tracks failed", err)
+	}
+
+	// The tracks after the failed one are still split
+	var extracted int
+	for _, c := range e.commands("ffmpeg") {
+		if len(c) > 1 {
+			extracted++
+		}
+	}
+	if extracted != 3 {
+		t.Errorf("extracted %d tracks, want 3", extracted)
+	}
+
+	// Nothing is left of the failed track, under its name or another
+	files, err := filepath.Glob(filepath.Join(out, "Artist", "Album", "*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	hidden, err := filepath.Glob(filepath.Join(out, "Artist", "Album", ".*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(out, "Artist", "Album", "01 - Intro.mp3"), filepath.Join(out, "Artist", "Album", "03 - Last.mp3")}
+	if got := append(files, hidden...); !reflect.DeepEqual(got, want) {
+		t.Errorf("files = %q, want %q", got, want)
+	}
+}
+
+func TestSplitEvents(t *testing.T) {
+	var events []Event
+	err := Split(context.Background(), Options{
+		Filename:  writeTestFile(t, "live.mp3", ""),
+		Timecodes: writeTestFile(t, "tracks.txt", "00:00 Intro\n03:10 Song Two\n05:00 Last\n"),
+		Artist:    "Artist",
+		Album:     "Album",
+		OutputDir: t.TempDir(),
+		Tagger:    "ffmpeg",
+		Executor:  &fakeExecutor{fail: "title=Song Two"},
+		Events:    func(e Event) { events = append(events, e) },
+	})
+	if err == nil {
+		t.Fatal("Split() succeeded, want track 2 to fail")
+	}
+
+	// The tracks are split concurrently, so only the order of each one's
+	// events is known
+	got := make(map[int][]EventKind)
+	for _, e := range events[:len(events)-1] {
+		got[e.Track.Number] = append(got[e.Track.Number], e.Kind)
+	}
+	want := map[int][]EventKind{
+		1: {TrackStarted, TrackProgress, TrackDone},
+		2: {TrackStarted, TrackProgress, TrackFailed},
+		3: {TrackStarted, TrackProgress, TrackDone},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %v, want %v", got, want)
+	}
+
+	last := events[len(events)-1]
+	if last.Kind != RunCompleted || last.Err != err {
+		t.Errorf("last event = %v, %v, want run completed, %v", last.Kind, last.Err, err)
+	}
+}
+
+func TestSplitStats(t *testing.T) {
+	var log strings.Builder
+	err := Split(context.Background(), Options{
+		Filename:  writeTestFile(t, "live.mp3", "source"),
+		Timecodes: writeTestFile(t, "tracks.txt", "00:00 Intro\n03:10 Song Two\n"),
+		Artist:    "Artist",
+		Album:     "Album",
+		OutputDir: t.TempDir(),
+		Tagger:    "ffmpeg",
+		Executor:  &fakeExecutor{},
+		JSON:      true,
+		Log:       &log,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var stats *jsonStats
+	for _, line := range strings.Split(strings.TrimSpace(log.String()), "\n") {
+		var e jsonEvent
+		if err := json.Unmarshal([]byte(line), &e); err != nil {
+			t.Fatalf("invalid event %q: %v", line, err)
+		}
+		if e.Event == "stats" {
+			stats = e.Stats
+		}
+	}
+	if stats == nil {
+		t.Fatalf("no stats event in:\n%v", log.String())
+	}
+
+	if stats.SourceBytes != int64(len("source")) || stats.Audio != 600 {
+		t.Errorf("source bytes, audio = %v, %v, want 6, 600", stats.SourceBytes, stats.Audio)
+	}
+	if len(stats.Tracks) != 2 || stats.Tracks[0].Number != 1 || stats.Tracks[1].Number != 2 {
+		t.Errorf("tracks = %+v, want tracks 1 and 2", stats.Tracks)
+	}
+}
+
+func TestTagTrackEyeD3(t *testing.T) {
+	e := &fakeExecutor{}
+	opts := Options{Tagger: "eyed3", Filename: "live.mp3", Executor: e}.withDefaults()
+	tr := Track{Number: 1, Total: 2, Title: "Intro", Artist: "A", AlbumArtist: "A", Album: "B", Year: "1999"}
+
+	if err := tagTrack(opts.withOptions(context.Background()), opts, tr); err != nil {
+		t.Fatal(err)
+	}
+
+	want := [][]string{{
+		"--artist=A",
+		"--album-artist=A",
+		"--album=B",
+		"--title=Intro",
+		"--track=1",
+		"--track-total=2",
+		"--encoding=utf8",
+		"--release-year=1999",
+		filepath.Join("A", "B", "01 - Intro.mp3"),
+	}}
+	if got := e.commands("eyed3"); !reflect.DeepEqual(got, want) {
+		t.Errorf("eyed3 commands =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestSplitDashedNames(t *testing.T) {
+	dir := t.TempDir()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	defer os.Chdir(wd)
+
+	if err := os.WriteFile("-live.mp3", nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	e := &fakeExecutor{}
+	err = Split(context.Background(), Options{
+		Filename:       "-live.mp3",
+		Timecodes:      writeTestFile(t, "tracks.txt", "00:00 -intro-\n03:10 --help\n"),
+		Artist:         "Artist",
+		Album:          "Album",
+		OutputTemplate: "{title}.{ext}",
+		Tagger:         "ffmpeg",
+		Executor:       e,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dot := "." + string(filepath.Separator)
+	var outputs []string
+	for _, c := range e.commands("ffmpeg") {
+		if len(c) > 1 {
+			outputs = append(outputs, c[len(c)-1])
+		}
+	}
+	if want := []string{".avsplit--intro-.mp3", ".avsplit---help.mp3"}; !reflect.DeepEqual(outputs, want) {
+		t.Errorf("ffmpeg outputs = %q, want %q", outputs, want)
+	}
+	for _, name := range []string{"-intro-.mp3", "--help.mp3"} {
+		if _, err := os.Stat(name); err != nil {
+			t.Error(err)
+		}
+	}
+
+	for _, c := range e.commands("ffprobe") {
+		if last := c[len(c)-1]; len(c) > 1 && last != dot+"-live.mp3" {
+			t.Errorf("ffprobe read %q, want %q", last, dot+"-live.mp3")
+		}
+	}
+}
+
+func TestReadTracklistNoSource(t *testing.T) {
+	_, err := ReadTracklist(context.Background(), Options{Filename: "live.mp3", Executor: &fakeExecutor{}})
+	if err == nil || KindOf(err) != ErrInvalidInput {
+		t.Errorf("ReadTracklist() without a tracklist = %v, want an invalid input error", err)
+	}
+
+	err = Split(context.Background(), Options{Filename: writeTestFile(t, "live.mp3", ""), Executor: &fakeExecutor{}})
+	if err == nil || KindOf(err) != ErrInvalidInput {
+		t.Errorf("Split() without a tracklist = %v, want an invalid input error", err)
+	}
+}
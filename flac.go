@@ -0,0 +1,237 @@
+package avsplit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+const (
+	flacBlockStreamInfo    = 0
+	flacBlockVorbisComment = 4
+	flacBlockCueSheet      = 5
+	flacBlockPicture       = 6
+	flacLastBlock          = 0x80
+	flacVendor             = "avsplit"
+)
+
+type flacBlock struct {
+	Type byte
+	Data []byte
+}
+
+// vorbisComments returns the track's tags as Vorbis comments, the tag format
+// of FLAC, Ogg and Opus files.
+func (t *Track) vorbisComments() []string {
+	c := []string{
+		"ARTIST=" + t.Artist,
+		"ALBUMARTIST=" + t.AlbumArtist,
+		"ALBUM=" + t.Album,
+		"TITLE=" + t.Title,
+		"TRACKNUMBER=" + strconv.Itoa(t.Number),
+		"TRACKTOTAL=" + strconv.Itoa(t.Total),
+	}
+
+	// A comment may be repeated, one for each of the artists
+	for _, a := range t.artists() {
+		c = append(c, "ARTISTS="+a)
+	}
+
+	if t.Composer != "" {
+		c = append(c, "COMPOSER="+t.Composer)
+	}
+
+	if t.Work != "" {
+		c = append(c,
+			"WORK="+t.Work,
+			"MOVEMENTNAME="+t.Title,
+			"MOVEMENT="+strconv.Itoa(t.Movement),
+			"MOVEMENTTOTAL="+strconv.Itoa(t.Movements),
+		)
+	}
+
+	if t.Year != "" {
+		c = append(c, "DATE="+t.Year)
+	}
+
+	if t.Genre != "" {
+		c = append(c, "GENRE="+t.Genre)
+	}
+
+	if t.Disc != 0 {
+		c = append(c, "DISCNUMBER="+strconv.Itoa(t.Disc))
+	}
+
+	if t.DiscTotal != 0 {
+		c = append(c, "DISCTOTAL="+strconv.Itoa(t.DiscTotal))
+	}
+
+	if t.Comment != "" {
+		c = append(c, "COMMENT="+t.Comment)
+	}
+
+	if t.Label != "" {
+		c = append(c, "LABEL="+t.Label)
+	}
+
+	if t.CatalogNumber != "" {
+		c = append(c, "CATALOGNUMBER="+t.CatalogNumber)
+	}
+
+	if t.ISRC != "" {
+		c = append(c, "ISRC="+t.ISRC)
+	}
+
+	if t.Lyrics != "" {
+		c = append(c, "LYRICS="+t.Lyrics)
+	}
+
+	return c
+}
+
+func vorbisCommentBlock(comments []string) flacBlock {
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, uint32(len(flacVendor)))
+	b.WriteString(flacVendor)
+	binary.Write(&b, binary.LittleEndian, uint32(len(comments)))
+	for _, c := range comments {
+		binary.Write(&b, binary.LittleEndian, uint32(len(c)))
+		b.WriteString(c)
+	}
+	return flacBlock{flacBlockVorbisComment, b.Bytes()}
+}
+
+// flacPictureBlock returns a PICTURE block holding the image file as the
+// front cover. The image dimensions are optional and left as 0.
+func flacPictureBlock(imageFile string) (flacBlock, error) {
+	mime, err := imageMIMEType(imageFile)
+	if err != nil {
+		return flacBlock{}, err
+	}
+
+	image, err := os.ReadFile(imageFile)
+	if err != nil {
+		return flacBlock{}, fmt.Errorf("cannot read cover art: %v", err)
+	}
+
+	var b bytes.Buffer
+	binary.Write(&b, binary.BigEndian, uint32(id3FrontCover))
+	binary.Write(&b, binary.BigEndian, uint32(len(mime)))
+	b.WriteString(mime)
+	// Empty description, then width, height, depth and colors
+	b.Write(make([]byte, 4+16))
+	binary.Write(&b, binary.BigEndian, uint32(len(image)))
+	b.Write(image)
+
+	return flacBlock{flacBlockPicture, b.Bytes()}, nil
+}
+
+// readFLACBlocks reads the metadata blocks at the start of a FLAC file,
+// leaving r at its audio.
+func readFLACBlocks(r io.Reader, filename string) ([]flacBlock, error) {
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(r, magic); err != nil || string(magic) != "fLaC" {
+		return nil, fmt.Errorf("%v: not a flac file", filename)
+	}
+
+	var blocks []flacBlock
+	for {
+		header := make([]byte, 4)
+		if _, err := io.ReadFull(r, header); err != nil {
+			return nil, fmt.Errorf("%v: invalid flac metadata", filename)
+		}
+
+		size := int(header[1])<<16 | int(header[2])<<8 | int(header[3])
+		data := make([]byte, size)
+		if _, err := io.ReadFull(r, data); err != nil {
+			return nil, fmt.Errorf("%v: invalid flac metadata", filename)
+		}
+		blocks = append(blocks, flacBlock{header[0] &^ flacLastBlock, data})
+
+		if header[0]&flacLastBlock != 0 {
+			return blocks, nil
+		}
+	}
+}
+
+// writeFLACTags replaces the Vorbis comments of filename, and its pictures
+// when cover is given, keeping the other metadata blocks. The file is
+// rewritten next to the original and renamed into place.
+func writeFLACTags(filename string, comments []string, cover string) error {
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	read, err := readFLACBlocks(src, filename)
+	if err != nil {
+		return err
+	}
+
+	var blocks []flacBlock
+	for _, b := range read {
+		if b.Type != flacBlockVorbisComment && (b.Type != flacBlockPicture || cover == "") {
+			blocks = append(blocks, b)
+		}
+	}
+
+	blocks = append(blocks, vorbisCommentBlock(comments))
+	if cover != "" {
+		p, err := flacPictureBlock(cover)
+		if err != nil {
+			return err
+		}
+		blocks = append(blocks, p)
+	}
+
+	var meta bytes.Buffer
+	meta.WriteString("fLaC")
+	for i, b := range blocks {
+		if len(b.Data) >= 1<<24 {
+			return fmt.Errorf("%v: flac metadata block too large", filename)
+		}
+
+		typ := b.Type
+		if i == len(blocks)-1 {
+			typ |= flacLastBlock
+		}
+		meta.Write([]byte{typ, byte(len(b.Data) >> 16), byte(len(b.Data) >> 8), byte(len(b.Data))})
+		meta.Write(b.Data)
+	}
+
+	dst, err := os.CreateTemp(filepath.Dir(filename), ".avsplit-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+
+	if _, err := dst.Write(meta.Bytes()); err != nil {
+		dst.Close()
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(dst.Name(), info.Mode()); err != nil {
+		return err
+	}
+
+	return os.Rename(dst.Name(), filename)
+}
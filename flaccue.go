@@ -0,0 +1,310 @@
+package avsplit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// flacCueTrack is an audio track of a FLAC CUESHEET block, its INDEX 01
+// and INDEX 00 in samples from the start of the stream. Pregap is -1 when
+// the track has no INDEX 00.
+type flacCueTrack struct {
+	number int
+	start  int64
+	pregap int64
+}
+
+// parseFLACCueSheet reads the audio tracks of a CUESHEET block. The last
+// one runs to its lead-out, the end of the stream.
+func parseFLACCueSheet(data []byte) ([]flacCueTrack, error) {
+	invalid := fmt.Errorf("invalid flac cuesheet")
+	r := bytes.NewReader(data)
+
+	var sheet struct {
+		Catalog  [128]byte
+		LeadIn   uint64
+		Reserved [259]byte
+		Tracks   uint8
+	}
+	if err := binary.Read(r, binary.BigEndian, &sheet); err != nil {
+		return nil, invalid
+	}
+
+	var tracks []flacCueTrack
+	for i := 0; i < int(sheet.Tracks); i++ {
+		var track struct {
+			Offset  uint64
+			Number  uint8
+			ISRC    [12]byte
+			Flags   [14]byte
+			Indexes uint8
+		}
+		if err := binary.Read(r, binary.BigEndian, &track); err != nil {
+			return nil, invalid
+		}
+
+		t := flacCueTrack{number: int(track.Number), start: -1, pregap: -1}
+		for k := 0; k < int(track.Indexes); k++ {
+			var index struct {
+				Offset   uint64
+				Number   uint8
+				Reserved [3]byte
+			}
+			if err := binary.Read(r, binary.BigEndian, &index); err != nil {
+				return nil, invalid
+			}
+
+			switch index.Number {
+			case 0:
+				t.pregap = int64(track.Offset + index.Offset)
+			case 1:
+				t.start = int64(track.Offset + index.Offset)
+			}
+		}
+
+		// The lead-out is numbered 170 on a CD, 255 otherwise
+		if i == int(sheet.Tracks)-1 && (track.Number == 170 || track.Number == 255) {
+			return tracks, nil
+		}
+
+		if track.Flags[0]&0x80 != 0 {
+			// A data track, nothing to split
+			continue
+		}
+
+		if t.start < 0 {
+			return nil, fmt.Errorf("flac cuesheet: track %d has no INDEX 01", t.number)
+		}
+		tracks = append(tracks, t)
+	}
+	return nil, fmt.Errorf("flac cuesheet has no lead-out track")
+}
+
+// flacSampleRate returns the sample rate of a STREAMINFO block.
+func flacSampleRate(data []byte) (int, error) {
+	if len(data) < 18 {
+		return 0, fmt.Errorf("invalid flac stream info")
+	}
+	rate := int(data[10])<<12 | int(data[11])<<4 | int(data[12])>>4
+	if rate == 0 {
+		return 0, fmt.Errorf("invalid flac sample rate")
+	}
+	return rate, nil
+}
+
+// vorbisComment returns the value of the Vorbis comment name in the data of
+// a VORBIS_COMMENT block, "" when there is none.
+func vorbisComment(data []byte, name string) string {
+	r := bytes.NewReader(data)
+	next := func() ([]byte, bool) {
+		var n uint32
+		if binary.Read(r, binary.LittleEndian, &n) != nil || int64(n) > int64(r.Len()) {
+			return nil, false
+		}
+		b := make([]byte, n)
+		r.Read(b)
+		return b, true
+	}
+
+	if _, ok := next(); !ok {
+		return ""
+	}
+
+	var count uint32
+	if binary.Read(r, binary.LittleEndian, &count) != nil {
+		return ""
+	}
+
+	for i := uint32(0); i < count; i++ {
+		c, ok := next()
+		if !ok {
+			return ""
+		}
+		if k := bytes.IndexByte(c, '='); k > 0 && strings.EqualFold(string(c[:k]), name) {
+			return string(c[k+1:])
+		}
+	}
+	return ""
+}
+
+// hasFLACCueSheet reports whether the FLAC file has a CUESHEET block.
+func hasFLACCueSheet(filename string) bool {
+	f, err := os.Open(filename)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+
+	blocks, err := readFLACBlocks(f, filename)
+	if err != nil {
+		return false
+	}
+	for _, b := range blocks {
+		if b.Type == flacBlockCueSheet {
+			return true
+		}
+	}
+	return false
+}
+
+// samplesDuration returns the time of the sample at offset.
+func samplesDuration(offset int64, rate int) time.Duration {
+	return time.Duration(float64(offset) / float64(rate) * float64(time.Second))
+}
+
+// flacCueTracks reads the tracks from the CUESHEET block embedded in a FLAC
+// file, cut on its exact samples. Their titles and performers come from the
+// CUESHEET Vorbis comment, the cue sheet some rippers embed as well. As
+// with a cue sheet file, opts.Pregap handles the pregaps and opts.HTOA
+// makes the audio before the first track track 0.
+func flacCueTracks(opts Options) (Tracklist, error) {
+	if isURL(opts.Filename) {
+		return nil, fmt.Errorf("flac-cue requires a local flac file")
+	}
+
+	f, err := os.Open(opts.Filename)
+	if err != nil {
+		return nil, fmt.Errorf("audio file not found")
+	}
+	defer f.Close()
+
+	blocks, err := readFLACBlocks(f, opts.Filename)
+	if err != nil {
+		return nil, err
+	}
+
+	rate := 0
+	var sheet []byte
+	text := ""
+	for _, b := range blocks {
+		switch b.Type {
+		case flacBlockStreamInfo:
+			if rate, err = flacSampleRate(b.Data); err != nil {
+				return nil, err
+			}
+		case flacBlockCueSheet:
+			sheet = b.Data
+		case flacBlockVorbisComment:
+			text = vorbisComment(b.Data, "CUESHEET")
+		}
+	}
+	if sheet == nil {
+		return nil, fmt.Errorf("%v has no embedded cuesheet", opts.Filename)
+	}
+
+	cues, err := parseFLACCueSheet(sheet)
+	if err != nil {
+		return nil, err
+	}
+	if len(cues) == 0 {
+		return nil, fmt.Errorf("no tracks found")
+	}
+
+	if opts.Pregap != "prepend" && opts.Pregap != "append" && opts.Pregap != "discard" {
+		return nil, fmt.Errorf("pregap must be prepend, append or discard")
+	}
+
+	// Only the titles and performers of the text are used, its times are
+	// whole CD frames
+	var named []Track
+	if text != "" {
+		o := opts
+		o.Pregap, o.HTOA = "append", false
+		named, err = parseCue(strings.NewReader(text), o)
+		if err != nil {
+			return nil, fmt.Errorf("invalid CUESHEET comment: %v", err)
+		}
+
+		if len(named) != len(cues) {
+			opts.logf("warning: the CUESHEET comment has %d tracks and the cuesheet %d, not using its titles\n", len(named), len(cues))
+			named = nil
+		}
+	}
+
+	albumArtist, album := opts.Artist, opts.Album
+	if len(named) > 0 {
+		albumArtist, album = named[0].AlbumArtist, named[0].Album
+	}
+	if opts.VA && albumArtist == "" {
+		albumArtist = variousArtists
+	}
+
+	// The samples each track starts and the one before ends on
+	starts := make([]int64, len(cues))
+	ends := make([]int64, len(cues))
+	for i, c := range cues {
+		starts[i], ends[i] = c.start, c.start
+		if c.pregap < 0 || (i == 0 && opts.HTOA) {
+			continue
+		}
+
+		switch opts.Pregap {
+		case "prepend":
+			starts[i], ends[i] = c.pregap, c.pregap
+		case "discard":
+			ends[i] = c.pregap
+		}
+	}
+
+	tracks := make(Tracklist, len(cues))
+	for i := range cues {
+		t := &tracks[i]
+		t.Number = i + 1
+		t.Total = len(cues)
+		t.AlbumArtist = albumArtist
+		t.Album = album
+		t.Artist = albumArtist
+		if len(named) > 0 {
+			t.Title, t.Artist = named[i].Title, named[i].Artist
+		}
+
+		t.SampleRate = rate
+		t.StartSample = starts[i]
+		if i < len(cues)-1 {
+			t.EndSample = ends[i+1]
+		}
+	}
+
+	if opts.HTOA && starts[0] > 0 {
+		tracks = append(Tracklist{{
+			Title:       "Hidden Track",
+			Artist:      albumArtist,
+			AlbumArtist: albumArtist,
+			Album:       album,
+			Total:       len(cues),
+			SampleRate:  rate,
+			EndSample:   starts[0],
+		}}, tracks...)
+	}
+
+	for i := range tracks {
+		tracks[i].setSampleTimes()
+	}
+	return tracks, nil
+}
+
+// setSampleTimes sets the track's Start and End to the times of its
+// samples.
+func (t *Track) setSampleTimes() {
+	t.Start = formatTimecode(samplesDuration(t.StartSample, t.SampleRate))
+	t.End = ""
+	if t.EndSample > 0 {
+		t.End = formatTimecode(samplesDuration(t.EndSample, t.SampleRate))
+	}
+}
+
+// cutsOnSamples reports whether the track is cut on its StartSample and
+// EndSample, as it is while its Start and End are still their times.
+func (t *Track) cutsOnSamples() bool {
+	if t.SampleRate <= 0 {
+		return false
+	}
+
+	c := *t
+	c.setSampleTimes()
+	return c.Start == t.Start && c.End == t.End
+}
@@ -0,0 +1,152 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"strings"
+)
+
+// codecExts maps ffprobe audio codec names to the extension of a container
+// that can hold the stream without re-encoding.
+var codecExts = map[string]string{
+	"aac":     ".m4a",
+	"ac3":     ".ac3",
+	"alac":    ".m4a",
+	"dts":     ".dts",
+	"eac3":    ".eac3",
+	"flac":    ".flac",
+	"mp2":     ".mp2",
+	"mp3":     ".mp3",
+	"opus":    ".opus",
+	"vorbis":  ".ogg",
+	"wavpack": ".wv",
+}
+
+// losslessExts lists the output extensions whose audio is lossless, so
+// re-encoding into them loses nothing.
+var losslessExts = map[string]bool{
+	".aif":  true,
+	".flac": true,
+	".wav":  true,
+	".wv":   true,
+	".aiff": true,
+}
+
+// pipeFormats maps output extensions to the ffmpeg muxer to write them to a
+// pipe with, which has no file name to guess the format from.
+var pipeFormats = map[string]string{
+	".aac":  "adts",
+	".ac3":  "ac3",
+	".aif":  "aiff",
+	".aiff": "aiff",
+	".dts":  "dts",
+	".eac3": "eac3",
+	".flac": "flac",
+	".m4a":  "ipod",
+	".mka":  "matroska",
+	".mkv":  "matroska",
+	".mp2":  "mp2",
+	".mp3":  "mp3",
+	".mp4":  "mp4",
+	".ogg":  "ogg",
+	".opus": "opus",
+	".wav":  "wav",
+	".webm": "webm",
+	".wv":   "wv",
+}
+
+// pipeArgs returns the ffmpeg output arguments to write ext to stdout.
+func pipeArgs(ext string) []string {
+	format, ok := pipeFormats[ext]
+	if !ok {
+		// Matroska can hold any codec
+		format = "matroska"
+	}
+
+	args := []string{"-f", format}
+	if format == "ipod" || format == "mp4" {
+		// MP4 normally seeks back to write its index at the start
+		args = append(args, "-movflags", "frag_keyframe+empty_moov")
+	}
+	return append(args, "pipe:1")
+}
+
+// encoderExts maps ffmpeg audio encoder names to the extension of a container
+// for their output.
+var encoderExts = map[string]string{
+	"libmp3lame": ".mp3",
+	"libshine":   ".mp3",
+	"libopus":    ".opus",
+	"libvorbis":  ".ogg",
+	"libfdk_aac": ".m4a",
+	"aac":        ".m4a",
+	"alac":       ".m4a",
+	"flac":       ".flac",
+	"wavpack":    ".wv",
+}
+
+// encoderExt returns the extension to encode with encoder into.
+func encoderExt(encoder string) string {
+	if ext, ok := encoderExts[encoder]; ok {
+		return ext
+	}
+	return codecExt(encoder)
+}
+
+func probeAudioCodec(ctx context.Context, audioFile string) (string, error) {
+	out, err := commandOutput(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-select_streams", probeStream(ctx),
+		"-show_entries", "stream=codec_name",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		argPath(audioFile),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	codec := firstLine(out)
+	if codec == "" {
+		return "", fmt.Errorf("no audio stream found in %v", audioFile)
+	}
+
+	return codec, nil
+}
+
+// codecExt returns the extension to stream copy codec into.
+func codecExt(codec string) string {
+	if strings.HasPrefix(codec, "pcm_") {
+		return ".wav"
+	}
+
+	if ext, ok := codecExts[codec]; ok {
+		return ext
+	}
+
+	// Matroska can hold any codec
+	return ".mka"
+}
+
+// outputFormat returns the extension of the output tracks and whether the
+// audio has to be re-encoded to fit it. By default the extension is chosen
+// to match the source's audio codec, otherwise format overrides it.
+func outputFormat(ctx context.Context, audioFile, format string) (string, bool, error) {
+	codec, err := probeAudioCodec(ctx, audioFile)
+	if err != nil && format == "" {
+		return "", false, fmt.Errorf("cannot determine the audio codec: %v", strings.TrimSpace(err.Error()))
+	}
+
+	if format == "" {
+		return codecExt(codec), false, nil
+	}
+
+	ext := "." + strings.ToLower(strings.TrimPrefix(format, "."))
+	if err != nil {
+		// Without knowing the source codec re-encoding is the safe choice
+		return ext, true, nil
+	}
+
+	return ext, ext != codecExt(codec), nil
+}
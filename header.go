@@ -0,0 +1,69 @@
+package avsplit
+
+import (
+	"regexp"
+	"strings"
+)
+
+// headerLine matches an "ARTIST: Miles Davis" line at the top of a timecodes
+// file, which sets that tag of the release for every track.
+var headerLine = regexp.MustCompile(`^(?i:(artist|album ?artist|album|date|year|genre|comment|composer|label))\s*:\s*(.*)$`)
+
+// parseHeaderLine returns the field and value a header line sets, the field
+// lowercased with "albumartist" spelled "album artist".
+func parseHeaderLine(line string) (string, string, bool) {
+	m := headerLine.FindStringSubmatch(strings.TrimSpace(line))
+	if m == nil {
+		return "", "", false
+	}
+
+	field := strings.ToLower(m[1])
+	if field == "albumartist" {
+		field = "album artist"
+	}
+	return field, strings.TrimSpace(m[2]), true
+}
+
+// applyHeader sets the tags the header of a timecodes file gives on the
+// tracks. The artist and album given in opts are kept over it, and a
+// composer or label the tracks have already over the header's.
+func applyHeader(tracks Tracklist, header map[string]string, opts Options) {
+	artist, album := header["artist"], header["album"]
+	if opts.Artist != "" {
+		artist = opts.Artist
+	}
+	if opts.Album != "" {
+		album = opts.Album
+	}
+	albumArtist := header["album artist"]
+	if albumArtist == "" || opts.Artist != "" {
+		albumArtist = artist
+	}
+
+	year := header["year"]
+	if date := header["date"]; year == "" && len(date) >= 4 {
+		// The year of a full date such as 2019-05-04
+		year = date[:4]
+	}
+
+	for i := range tracks {
+		t := &tracks[i]
+		t.Artist, t.AlbumArtist, t.Album = artist, albumArtist, album
+
+		if year != "" {
+			t.Year = year
+		}
+		if v := header["genre"]; v != "" {
+			t.Genre = v
+		}
+		if v := header["comment"]; v != "" {
+			t.Comment = v
+		}
+		if v := header["composer"]; v != "" && t.Composer == "" {
+			t.Composer = v
+		}
+		if v := header["label"]; v != "" && t.Label == "" {
+			t.Label = v
+		}
+	}
+}
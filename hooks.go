@@ -0,0 +1,96 @@
+package avsplit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// trackHookEnv returns the environment of the PostCmd of a track: its output
+// file and tags.
+func (t *Track) trackHookEnv(opts Options) []string {
+	out := t.outputFilename(opts.Filename)
+	return []string{
+		"AVSPLIT_FILE=" + out,
+		"AVSPLIT_DIR=" + filepath.Dir(out),
+		"AVSPLIT_SOURCE=" + opts.Filename,
+		"AVSPLIT_TITLE=" + t.Title,
+		"AVSPLIT_ARTIST=" + t.Artist,
+		"AVSPLIT_ALBUM_ARTIST=" + t.AlbumArtist,
+		"AVSPLIT_ALBUM=" + t.Album,
+		"AVSPLIT_TRACK=" + strconv.Itoa(t.Number),
+		"AVSPLIT_TOTAL=" + strconv.Itoa(t.Total),
+		"AVSPLIT_DISC=" + strconv.Itoa(t.Disc),
+		"AVSPLIT_YEAR=" + t.Year,
+		"AVSPLIT_GENRE=" + t.Genre,
+		"AVSPLIT_START=" + t.Start,
+		"AVSPLIT_END=" + t.End,
+	}
+}
+
+// albumHookEnv returns the environment of the PostAlbumCmd: the output files
+// of the tracks, one per line, their directories and the release's tags.
+func albumHookEnv(opts Options, tracks Tracklist) []string {
+	var files, dirs []string
+	seen := make(map[string]bool)
+	for _, t := range tracks {
+		out := t.outputFilename(opts.Filename)
+		files = append(files, out)
+		if dir := filepath.Dir(out); !seen[dir] {
+			seen[dir] = true
+			dirs = append(dirs, dir)
+		}
+	}
+
+	t := tracks[0]
+	return []string{
+		"AVSPLIT_FILES=" + strings.Join(files, "\n"),
+		"AVSPLIT_DIRS=" + strings.Join(dirs, "\n"),
+		"AVSPLIT_DIR=" + dirs[0],
+		"AVSPLIT_SOURCE=" + opts.Filename,
+		"AVSPLIT_ALBUM_ARTIST=" + t.AlbumArtist,
+		"AVSPLIT_ALBUM=" + t.Album,
+		"AVSPLIT_TOTAL=" + strconv.Itoa(len(tracks)),
+		"AVSPLIT_YEAR=" + t.Year,
+		"AVSPLIT_GENRE=" + t.Genre,
+	}
+}
+
+// hookArgs returns the env arguments that run the shell command cmd with the
+// variables of env added to the environment.
+func hookArgs(cmd string, env []string) []string {
+	return append(append([]string(nil), env...), "sh", "-c", cmd)
+}
+
+// runHook runs the shell command cmd of a post-cmd flag with env through
+// env(1), which the executor runs like any other tool. What it prints goes
+// to the log.
+func runHook(ctx context.Context, opts Options, flag, cmd string, env []string) error {
+	stdout := opts.logWriter()
+	if opts.JSON {
+		// Only JSON lines go to the log
+		stdout = nil
+	}
+
+	var stderr bytes.Buffer
+	err := runCommand(ctx, "env", hookArgs(cmd, env), stdout, &stderr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if s := strings.TrimSpace(stderr.String()); s != "" {
+			return fmt.Errorf("%v failed: %v: %v", flag, err, s)
+		}
+		return fmt.Errorf("%v failed: %v", flag, err)
+	}
+
+	if opts.JSON {
+		debugStderr(ctx, "env", stderr)
+	} else {
+		fmt.Fprint(opts.logWriter(), stderr.String())
+	}
+	return nil
+}
@@ -0,0 +1,266 @@
+package avsplit
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+const (
+	id3HeaderSize = 10
+	id3Version    = 4
+	id3UTF8       = 3
+	id3FlagFooter = 0x10
+	id3FrontCover = 3
+)
+
+type id3Frame struct {
+	ID   string
+	Data []byte
+}
+
+func id3Text(id, value string) id3Frame {
+	return id3Frame{id, append([]byte{id3UTF8}, value...)}
+}
+
+// id3Picture returns an APIC frame holding the image file as the front cover.
+func id3Picture(imageFile string) (id3Frame, error) {
+	mime, err := imageMIMEType(imageFile)
+	if err != nil {
+		return id3Frame{}, err
+	}
+
+	image, err := os.ReadFile(imageFile)
+	if err != nil {
+		return id3Frame{}, fmt.Errorf("cannot read cover art: %v", err)
+	}
+
+	var data bytes.Buffer
+	data.WriteByte(id3UTF8)
+	data.WriteString(mime)
+	data.WriteByte(0)
+	data.WriteByte(id3FrontCover)
+	// Empty description
+	data.WriteByte(0)
+	data.Write(image)
+
+	return id3Frame{"APIC", data.Bytes()}, nil
+}
+
+// id3Comment returns a COMM frame with an empty description.
+func id3Comment(value string) id3Frame {
+	data := append([]byte{id3UTF8}, "eng"...)
+	data = append(data, 0)
+	return id3Frame{"COMM", append(data, value...)}
+}
+
+// id3UserText returns a TXXX frame of a text tag of its own, named desc.
+func id3UserText(desc, value string) id3Frame {
+	data := append([]byte{id3UTF8}, desc...)
+	data = append(data, 0)
+	return id3Frame{"TXXX", append(data, value...)}
+}
+
+// id3Lyrics returns a USLT frame of unsynchronised lyrics in an unknown
+// language.
+func id3Lyrics(value string) id3Frame {
+	data := append([]byte{id3UTF8}, "XXX"...)
+	data = append(data, 0)
+	return id3Frame{"USLT", append(data, value...)}
+}
+
+func (t *Track) id3Frames() ([]id3Frame, error) {
+	frames := []id3Frame{
+		id3Text("TPE1", t.Artist),
+		id3Text("TPE2", t.AlbumArtist),
+		id3Text("TALB", t.Album),
+		id3Text("TIT2", t.Title),
+		id3Text("TRCK", fmt.Sprintf("%d/%d", t.Number, t.Total)),
+	}
+
+	if artists := t.artists(); artists != nil {
+		// ID3v2.4 parts the values of a text frame with NULs
+		frames = append(frames, id3UserText("ARTISTS", strings.Join(artists, "\x00")))
+	}
+
+	if t.Composer != "" {
+		frames = append(frames, id3Text("TCOM", t.Composer))
+	}
+
+	if t.Work != "" {
+		// The work and the iTunes movement frames, the movement named by
+		// the title
+		frames = append(frames,
+			id3Text("TIT1", t.Work),
+			id3Text("MVNM", t.Title),
+			id3Text("MVIN", fmt.Sprintf("%d/%d", t.Movement, t.Movements)),
+		)
+	}
+
+	if t.Year != "" {
+		frames = append(frames, id3Text("TDRC", t.Year))
+	}
+
+	if t.Genre != "" {
+		frames = append(frames, id3Text("TCON", t.Genre))
+	}
+
+	if t.Disc != 0 {
+		disc := strconv.Itoa(t.Disc)
+		if t.DiscTotal != 0 {
+			disc += "/" + strconv.Itoa(t.DiscTotal)
+		}
+		frames = append(frames, id3Text("TPOS", disc))
+	}
+
+	if t.Comment != "" {
+		frames = append(frames, id3Comment(t.Comment))
+	}
+
+	if t.Label != "" {
+		frames = append(frames, id3Text("TPUB", t.Label))
+	}
+
+	if t.CatalogNumber != "" {
+		frames = append(frames, id3UserText("CATALOGNUMBER", t.CatalogNumber))
+	}
+
+	if t.ISRC != "" {
+		frames = append(frames, id3Text("TSRC", t.ISRC))
+	}
+
+	if t.Length > 0 {
+		// In milliseconds
+		frames = append(frames, id3Text("TLEN", strconv.FormatInt(t.Length.Milliseconds(), 10)))
+	}
+
+	if t.Lyrics != "" {
+		frames = append(frames, id3Lyrics(t.Lyrics))
+	}
+
+	if t.Cover != "" {
+		f, err := id3Picture(t.Cover)
+		if err != nil {
+			return nil, err
+		}
+		frames = append(frames, f)
+	}
+
+	return frames, nil
+}
+
+func synchsafe(n int) []byte {
+	return []byte{
+		byte(n >> 21 & 0x7f),
+		byte(n >> 14 & 0x7f),
+		byte(n >> 7 & 0x7f),
+		byte(n & 0x7f),
+	}
+}
+
+func unsynchsafe(b []byte) int {
+	return int(b[0])<<21 | int(b[1])<<14 | int(b[2])<<7 | int(b[3])
+}
+
+// id3Tag encodes the frames as an ID3v2.4 tag.
+func id3Tag(frames []id3Frame) []byte {
+	var body bytes.Buffer
+	for _, f := range frames {
+		body.WriteString(f.ID)
+		body.Write(synchsafe(len(f.Data)))
+		body.Write([]byte{0, 0})
+		body.Write(f.Data)
+	}
+
+	var tag bytes.Buffer
+	tag.WriteString("ID3")
+	tag.Write([]byte{id3Version, 0, 0})
+	tag.Write(synchsafe(body.Len()))
+	tag.Write(body.Bytes())
+	return tag.Bytes()
+}
+
+// id3TagSize returns the size of the ID3v2 tag at the start of header, or 0
+// if there is none.
+func id3TagSize(header []byte) (int, error) {
+	if len(header) < id3HeaderSize || string(header[:3]) != "ID3" {
+		return 0, nil
+	}
+
+	for _, b := range header[6:id3HeaderSize] {
+		if b&0x80 != 0 {
+			return 0, fmt.Errorf("invalid id3 tag size")
+		}
+	}
+
+	size := id3HeaderSize + unsynchsafe(header[6:id3HeaderSize])
+	if header[5]&id3FlagFooter != 0 {
+		size += id3HeaderSize
+	}
+
+	return size, nil
+}
+
+// writeID3 replaces any ID3v2 tag at the start of filename with the frames.
+// The file is rewritten next to the original and renamed into place.
+func writeID3(filename string, frames []id3Frame) error {
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	header := make([]byte, id3HeaderSize)
+	n, err := io.ReadFull(src, header)
+	if err != nil && err != io.ErrUnexpectedEOF && err != io.EOF {
+		return err
+	}
+
+	size, err := id3TagSize(header[:n])
+	if err != nil {
+		return fmt.Errorf("%v: %v", filename, err)
+	}
+
+	if _, err := src.Seek(int64(size), io.SeekStart); err != nil {
+		return err
+	}
+
+	dst, err := os.CreateTemp(filepath.Dir(filename), ".avsplit-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+
+	if _, err := dst.Write(id3Tag(frames)); err != nil {
+		dst.Close()
+		return err
+	}
+
+	if _, err := io.Copy(dst, src); err != nil {
+		dst.Close()
+		return err
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(dst.Name(), info.Mode()); err != nil {
+		return err
+	}
+
+	// Windows can't rename over a file that is still open
+	if err := src.Close(); err != nil {
+		return err
+	}
+	return os.Rename(dst.Name(), filename)
+}
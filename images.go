@@ -0,0 +1,47 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"path/filepath"
+	"strings"
+)
+
+// imageFilters are the ffmpeg filters that render each kind of track image.
+var imageFilters = map[string]string{
+	"waveform":    "showwavespic=s=800x160:split_channels=1",
+	"spectrogram": "showspectrumpic=s=800x320:legend=0",
+}
+
+// trackImage returns the path of the image of a track's output file.
+func trackImage(out, kind string) string {
+	return strings.TrimSuffix(out, filepath.Ext(out)) + "." + kind + ".png"
+}
+
+// renderTrackImages renders a waveform or spectrogram PNG next to each
+// extracted track, to check at a glance that it starts and ends in the
+// gaps between songs.
+func renderTrackImages(ctx context.Context, opts Options, tracks []Track) error {
+	filter := imageFilters[opts.Images]
+
+	for _, t := range tracks {
+		out := t.outputFilename(opts.Filename)
+		image := trackImage(out, opts.Images)
+		opts.logf("rendering %v \"%v\"\n", opts.Images, image)
+
+		err := execCommand(
+			ctx,
+			"ffmpeg",
+			"-nostdin", "-y", "-loglevel", "error",
+			"-i", argPath(out),
+			"-filter_complex", filter,
+			"-frames:v", "1",
+			argPath(image),
+		)
+		if err != nil {
+			return fmt.Errorf("cannot render %v of track %d: %v", opts.Images, t.Number, strings.TrimSpace(err.Error()))
+		}
+	}
+
+	return nil
+}
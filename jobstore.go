@@ -0,0 +1,237 @@
+package avsplit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"text/tabwriter"
+	"time"
+)
+
+// jobFileName is the file in a job's directory holding its state, so jobs
+// outlive the server or batch that ran them.
+const jobFileName = "job.json"
+
+// The marker files "avsplit jobs" leaves in a job's directory for whatever
+// runs it to cancel or retry it.
+const (
+	cancelMarker = "cancel"
+	retryMarker  = "retry"
+)
+
+// save writes the job's state into its directory.
+func (j *job) save() error {
+	b, err := json.MarshalIndent(j, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Renamed into place so a crash never leaves half a job file
+	path := filepath.Join(j.dir, jobFileName)
+	if err := os.WriteFile(path+".tmp", append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("cannot write job %v: %v", j.ID, err)
+	}
+	return os.Rename(path+".tmp", path)
+}
+
+// readJob reads the state of the job in dir.
+func readJob(dir string) (*job, error) {
+	b, err := os.ReadFile(filepath.Join(dir, jobFileName))
+	if err != nil {
+		return nil, err
+	}
+
+	var j job
+	if err := json.Unmarshal(b, &j); err != nil {
+		return nil, fmt.Errorf("invalid job %v: %v", dir, err)
+	}
+	j.dir = dir
+	return &j, nil
+}
+
+// readJobs reads every job under jobsDir, oldest first.
+func readJobs(jobsDir string) ([]*job, error) {
+	entries, err := os.ReadDir(jobsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("cannot read jobs: %v", err)
+	}
+
+	var jobs []*job
+	for _, e := range entries {
+		if !e.IsDir() {
+			continue
+		}
+
+		j, err := readJob(filepath.Join(jobsDir, e.Name()))
+		if os.IsNotExist(err) {
+			// Not a job, or one still being created
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		jobs = append(jobs, j)
+	}
+
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Created.Before(jobs[k].Created) })
+	return jobs, nil
+}
+
+// finished reports whether the job has stopped, one way or another.
+func (j *job) finished() bool {
+	return j.State == "done" || j.State == "failed" || j.State == "canceled"
+}
+
+// marked reports whether the marker is in the job's directory.
+func (j *job) marked(marker string) bool {
+	_, err := os.Stat(filepath.Join(j.dir, marker))
+	return err == nil
+}
+
+// takeMarker reports whether the marker is in the job's directory, and
+// removes it.
+func (j *job) takeMarker(marker string) bool {
+	return os.Remove(filepath.Join(j.dir, marker)) == nil
+}
+
+// requeue resets a job to run again. The tracks it split already are
+// skipped then, and counted again.
+func (j *job) requeue() {
+	j.State, j.Error, j.Finished = "queued", "", nil
+	j.Done, j.Files, j.Warnings = 0, nil, nil
+	j.Track, j.Percent, j.ETA = 0, nil, nil
+}
+
+// batchJob returns the job of entry n of manifest under jobsDir, a new
+// queued one the first time the entry is split. Its id stays the same for
+// every run of the manifest.
+func batchJob(jobsDir, manifest string, n int, source string) (*job, error) {
+	abs, err := filepath.Abs(manifest)
+	if err != nil {
+		return nil, err
+	}
+	sum := sha256.Sum256([]byte(abs))
+	id := fmt.Sprintf("%x-%d", sum[:4], n)
+	dir := filepath.Join(jobsDir, id)
+
+	j, err := readJob(dir)
+	if !os.IsNotExist(err) {
+		return j, err
+	}
+
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create job %v: %v", id, err)
+	}
+	j = &job{
+		ID:       id,
+		State:    "queued",
+		Source:   source,
+		Created:  time.Now().UTC(),
+		Manifest: abs,
+		Entry:    n,
+		dir:      dir,
+	}
+	return j, j.save()
+}
+
+// watchCancel returns a context of ctx that is canceled once "avsplit jobs
+// cancel" asks for the job to be.
+func (j *job) watchCancel(ctx context.Context) (context.Context, context.CancelFunc) {
+	ctx, cancel := context.WithCancel(ctx)
+	go func() {
+		t := time.NewTicker(time.Second)
+		defer t.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-t.C:
+			}
+
+			if j.takeMarker(cancelMarker) {
+				cancel()
+				return
+			}
+		}
+	}()
+	return ctx, cancel
+}
+
+// WriteJobs writes a table of the jobs under jobsDir, of serve and of
+// batches run with a jobs directory.
+func WriteJobs(w io.Writer, jobsDir string) error {
+	jobs, err := readJobs(jobsDir)
+	if err != nil {
+		return err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(tw, "ID\tSTATE\tCREATED\tTRACKS\tSOURCE")
+	for _, j := range jobs {
+		state := j.State
+		switch {
+		case !j.finished() && j.marked(cancelMarker):
+			state = "canceling"
+		case j.finished() && j.marked(retryMarker):
+			state = "retrying"
+		}
+
+		tracks := "-"
+		if j.Tracks > 0 {
+			tracks = fmt.Sprintf("%d/%d", j.Done, j.Tracks)
+		}
+		fmt.Fprintf(tw, "%v\t%v\t%v\t%v\t%v\n", j.ID, state, j.Created.Local().Format(time.RFC3339), tracks, j.Source)
+	}
+	return tw.Flush()
+}
+
+// CancelJob asks for the job id under jobsDir to be canceled, stopping it if
+// it is running. A queued job is canceled when the server or batch it
+// belongs to gets to it.
+func CancelJob(jobsDir, id string) error {
+	j, err := findJob(jobsDir, id)
+	if err != nil {
+		return err
+	}
+
+	if j.finished() {
+		return &Error{ErrInvalidInput, fmt.Errorf("job %v is already %v", id, j.State)}
+	}
+	return os.WriteFile(filepath.Join(j.dir, cancelMarker), nil, 0644)
+}
+
+// RetryJob asks for the failed or canceled job id under jobsDir to be run
+// again. The tracks it split already are kept.
+func RetryJob(jobsDir, id string) error {
+	j, err := findJob(jobsDir, id)
+	if err != nil {
+		return err
+	}
+
+	if j.State != "failed" && j.State != "canceled" {
+		return &Error{ErrInvalidInput, fmt.Errorf("job %v is %v, only failed and canceled jobs can be retried", id, j.State)}
+	}
+	j.takeMarker(cancelMarker)
+	return os.WriteFile(filepath.Join(j.dir, retryMarker), nil, 0644)
+}
+
+func findJob(jobsDir, id string) (*job, error) {
+	if id == "" || filepath.Base(id) != id {
+		return nil, &Error{ErrInvalidInput, fmt.Errorf("invalid job id %v", id)}
+	}
+
+	j, err := readJob(filepath.Join(jobsDir, id))
+	if os.IsNotExist(err) {
+		return nil, &Error{ErrInvalidInput, fmt.Errorf("no job %v in %v", id, jobsDir)}
+	}
+	return j, err
+}
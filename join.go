@@ -0,0 +1,160 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Join is the inverse of a split: it joins the tracks of an earlier split,
+// those of the plan opts.Plan or the audio files in opts.TagDir as their
+// tags number them, back into the one file opts.Output with a chapter for
+// each track, tagged as the release. The audio is stream copied, never
+// re-encoded, so the tracks must share a codec that fits the container of
+// the output. With opts.DryRun the chapters are only printed, as a
+// timecodes file of the joined file.
+func Join(ctx context.Context, opts Options) error {
+	opts = opts.withDefaults()
+	// Each track has the one audio stream
+	opts.AudioStream = ""
+	ctx = opts.withOptions(ctx)
+
+	var tracks Tracklist
+	switch {
+	case opts.Plan != "":
+		p, err := readPlan(opts.Plan)
+		if err != nil {
+			return withKind(ErrInvalidInput, err)
+		}
+		if tracks, err = p.tracklist(); err != nil {
+			return withKind(ErrInvalidInput, err)
+		}
+	case opts.TagDir != "":
+		var err error
+		if tracks, err = taggedTracks(ctx, opts, opts.TagDir); err != nil {
+			return withKind(ErrInvalidInput, err)
+		}
+	default:
+		return withKind(ErrInvalidInput, fmt.Errorf("join needs a plan or a directory of tracks"))
+	}
+
+	if opts.Output == "" || opts.Output == "-" {
+		return withKind(ErrInvalidInput, fmt.Errorf("join needs an output file"))
+	}
+	ext := strings.ToLower(filepath.Ext(opts.Output))
+	if !chapterFormats[ext] {
+		return withKind(ErrInvalidInput, fmt.Errorf("output format %v does not support chapters", ext))
+	}
+
+	sort.SliceStable(tracks, func(i, k int) bool {
+		if tracks[i].Disc != tracks[k].Disc {
+			return tracks[i].Disc < tracks[k].Disc
+		}
+		return tracks[i].Number < tracks[k].Number
+	})
+
+	// The tracks become chapters of the joined file, one after another
+	files := make([]string, len(tracks))
+	codec := ""
+	var offset time.Duration
+	for i := range tracks {
+		t := &tracks[i]
+		files[i] = t.Output
+		if _, err := os.Stat(t.Output); err != nil {
+			return withKind(ErrInvalidInput, fmt.Errorf("track %d: %v not found", t.Number, t.Output))
+		}
+
+		c, err := probeAudioCodec(ctx, t.Output)
+		if err != nil {
+			return fmt.Errorf("track %d: %v", t.Number, strings.TrimSpace(err.Error()))
+		}
+		if codec == "" {
+			codec = c
+		} else if c != codec {
+			return withKind(ErrInvalidInput, fmt.Errorf("track %d is %v and track %d %v, tracks of different codecs can't be joined without re-encoding", tracks[0].Number, codec, t.Number, c))
+		}
+
+		d, err := probeDuration(ctx, t.Output)
+		if err != nil {
+			return fmt.Errorf("track %d: %v", t.Number, err)
+		}
+		t.Start = formatTimecode(offset)
+		offset += d
+		t.End = formatTimecode(offset)
+	}
+	// The last chapter runs to the end
+	tracks[len(tracks)-1].End = ""
+
+	if codecs, ok := chapterCodecs[ext]; ok {
+		fits := false
+		for _, c := range codecs {
+			fits = fits || c == codec
+		}
+		if !fits {
+			return withKind(ErrInvalidInput, fmt.Errorf("%v audio cannot be copied into %v, join into .mka instead", codec, ext))
+		}
+	}
+
+	if opts.DryRun {
+		return WriteTimecodes(opts.logWriter(), tracks)
+	}
+
+	kept, err := checkOverwrite(opts, []string{opts.Output})
+	if err != nil || kept[opts.Output] {
+		return err
+	}
+
+	meta, err := ffmetadata(tracks, offset)
+	if err != nil {
+		return err
+	}
+	metaFile, err := writeTempFile(meta)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(metaFile)
+
+	list, err := concatList(files)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(list)
+
+	if err := opts.mkdirAll(filepath.Dir(opts.Output)); err != nil {
+		return err
+	}
+
+	args := []string{
+		"-nostdin", opts.overwriteFlag(), "-loglevel", "error",
+		"-f", "concat", "-safe", "0", "-i", list,
+		"-i", metaFile,
+		"-map", "0:a",
+		"-map_metadata", "-1",
+		"-map_chapters", "1",
+		"-c", "copy",
+	}
+	first := tracks[0]
+	for _, tag := range [][2]string{
+		{"title", first.Album},
+		{"artist", first.AlbumArtist},
+		{"album_artist", first.AlbumArtist},
+		{"album", first.Album},
+		{"date", first.Year},
+		{"genre", first.Genre},
+	} {
+		if tag[1] != "" {
+			args = append(args, "-metadata", tag[0]+"="+tag[1])
+		}
+	}
+	args = append(args, argPath(opts.Output))
+
+	opts.logf("joining %d tracks into %v\n", len(tracks), opts.Output)
+	if err := execCommand(ctx, "ffmpeg", args...); err != nil {
+		return fmt.Errorf("cannot join the tracks: %v", strings.TrimSpace(err.Error()))
+	}
+	return opts.setFilePerms(opts.Output)
+}
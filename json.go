@@ -0,0 +1,81 @@
+package avsplit
+
+import (
+	"encoding/json"
+	"strings"
+	"sync"
+)
+
+// jsonEvent is a line of --json output. Event is one of log, warning, debug,
+// plan, progress, track, stats or error.
+type jsonEvent struct {
+	Event   string      `json:"event"`
+	Message string      `json:"message,omitempty"`
+	Track   int         `json:"track,omitempty"`
+	File    string      `json:"file,omitempty"`
+	Skipped bool        `json:"skipped,omitempty"`
+	Percent *float64    `json:"percent,omitempty"`
+	Elapsed *float64    `json:"elapsed,omitempty"`
+	ETA     *float64    `json:"eta,omitempty"`
+	Speed   *float64    `json:"speed,omitempty"`
+	Tracks  []jsonTrack `json:"tracks,omitempty"`
+	Stats   *jsonStats  `json:"stats,omitempty"`
+}
+
+type jsonTrack struct {
+	Number int     `json:"number"`
+	Start  string  `json:"start"`
+	End    string  `json:"end,omitempty"`
+	Length float64 `json:"length,omitempty"`
+	Title  string  `json:"title"`
+	Artist string  `json:"artist,omitempty"`
+	Album  string  `json:"album,omitempty"`
+	Output string  `json:"output"`
+}
+
+// jsonMu keeps events written from concurrent tracks on their own lines.
+var jsonMu sync.Mutex
+
+func (o Options) emit(e jsonEvent) {
+	if o.Log == nil {
+		return
+	}
+
+	// Command errors carry ffmpeg's trailing newline
+	e.Message = strings.TrimSpace(e.Message)
+
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+
+	jsonMu.Lock()
+	defer jsonMu.Unlock()
+	o.Log.Write(append(b, '\n'))
+}
+
+// emitLog writes a log message as a log or warning event.
+func (o Options) emitLog(msg string) {
+	if strings.HasPrefix(msg, "warning: ") {
+		o.emit(jsonEvent{Event: "warning", Message: strings.TrimPrefix(msg, "warning: ")})
+		return
+	}
+	o.emit(jsonEvent{Event: "log", Message: msg})
+}
+
+func (o Options) emitPlan(tracks []Track) {
+	e := jsonEvent{Event: "plan"}
+	for _, t := range tracks {
+		e.Tracks = append(e.Tracks, jsonTrack{
+			Number: t.Number,
+			Start:  t.Start,
+			End:    t.End,
+			Length: t.Length.Seconds(),
+			Title:  t.Title,
+			Artist: t.Artist,
+			Album:  t.Album,
+			Output: t.outputFilename(o.Filename),
+		})
+	}
+	o.emit(e)
+}
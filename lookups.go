@@ -0,0 +1,250 @@
+package avsplit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httputil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// lookupTransport is the transport of the clients of every lookup avsplit
+// makes, of MusicBrainz, Discogs, cover art, lyrics and AcoustID. It answers
+// from responses cached under the cache directory while they are fresh,
+// spaces the requests to each host out and retries those that fail for a
+// while, all as set by the options the request's context carries.
+var lookupTransport http.RoundTripper = lookupCache{http.DefaultTransport}
+
+// lookupIntervals are the least times between two requests to the hosts
+// whose services limit how often they may be asked.
+var lookupIntervals = map[string]time.Duration{
+	"musicbrainz.org":     time.Second,
+	"coverartarchive.org": time.Second,
+	// 25 a minute without a token
+	"api.discogs.com": 2500 * time.Millisecond,
+	// 20 a minute
+	"itunes.apple.com": 3 * time.Second,
+	// Three a second
+	"api.acoustid.org": 350 * time.Millisecond,
+}
+
+// Defaults of the lookup options left 0
+const (
+	defaultLookupRetries  = 3
+	defaultLookupCacheTTL = 7 * 24 * time.Hour
+)
+
+// lookupSlots are when each host may next be asked, shared by every run of
+// the process.
+var lookupSlots = struct {
+	mu   sync.Mutex
+	next map[string]time.Time
+}{next: make(map[string]time.Time)}
+
+// waitLookupSlot waits until the host may be asked again, at least interval
+// after the request before.
+func waitLookupSlot(ctx context.Context, host string, interval time.Duration) error {
+	if interval <= 0 {
+		return nil
+	}
+
+	lookupSlots.mu.Lock()
+	now := time.Now()
+	slot := lookupSlots.next[host]
+	if slot.Before(now) {
+		slot = now
+	}
+	lookupSlots.next[host] = slot.Add(interval)
+	lookupSlots.mu.Unlock()
+
+	wait := time.Until(slot)
+	if wait <= 0 {
+		return nil
+	}
+
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+type lookupCache struct {
+	next http.RoundTripper
+}
+
+func (c lookupCache) RoundTrip(req *http.Request) (*http.Response, error) {
+	ctx := req.Context()
+	opts, _ := ctx.Value(optionsKey{}).(Options)
+
+	ttl := opts.LookupCacheTTL
+	if ttl == 0 {
+		ttl = defaultLookupCacheTTL
+	}
+
+	var body []byte
+	if req.GetBody != nil {
+		r, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		body, err = io.ReadAll(r)
+		r.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	file := ""
+	if ttl > 0 || opts.Offline {
+		if dir, err := cacheDir(opts); err == nil {
+			sum := sha256.Sum256([]byte(req.Method + " " + req.URL.String() + "\n" + string(body)))
+			file = filepath.Join(dir, "lookups", hex.EncodeToString(sum[:]))
+		}
+	}
+
+	if file != "" {
+		// Offline, a stale response is still better than none
+		if info, err := os.Stat(file); err == nil && (opts.Offline || time.Since(info.ModTime()) < ttl) {
+			res, err := readCachedResponse(file, req)
+			if err == nil {
+				debugf(ctx, "using the cached response to %v\n", req.URL)
+				return res, nil
+			}
+			debugf(ctx, "ignoring the cached response to %v: %v\n", req.URL, err)
+		}
+	}
+
+	if opts.Offline {
+		return nil, fmt.Errorf("offline, and no response cached")
+	}
+
+	res, err := c.retry(ctx, opts, req, body)
+	if err != nil {
+		return nil, err
+	}
+
+	// A release or lyrics that aren't there are worth remembering too
+	if file != "" && ttl > 0 && (res.StatusCode == http.StatusOK || res.StatusCode == http.StatusNotFound) {
+		b, err := httputil.DumpResponse(res, true)
+		if err != nil {
+			res.Body.Close()
+			return nil, err
+		}
+		if err := writeCachedResponse(file, b); err != nil {
+			debugf(ctx, "cannot cache the response to %v: %v\n", req.URL, err)
+		}
+	}
+	return res, nil
+}
+
+// retry sends req, spaced out from the requests before it to the same host,
+// sending it again a few times while it fails, or the service asks for
+// time, before giving up with the last response.
+func (c lookupCache) retry(ctx context.Context, opts Options, req *http.Request, body []byte) (*http.Response, error) {
+	retries := opts.LookupRetries
+	if retries == 0 {
+		retries = defaultLookupRetries
+	}
+
+	interval := opts.LookupInterval
+	if d := lookupIntervals[req.URL.Hostname()]; d > interval {
+		interval = d
+	}
+
+	for attempt := 0; ; attempt++ {
+		if err := waitLookupSlot(ctx, req.URL.Hostname(), interval); err != nil {
+			return nil, err
+		}
+
+		r := req
+		if attempt > 0 && body != nil {
+			r = req.Clone(ctx)
+			r.Body = io.NopCloser(bytes.NewReader(body))
+		}
+
+		debugf(ctx, "requesting %v\n", req.URL.Redacted())
+		res, err := c.next.RoundTrip(r)
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+
+		failed := err != nil
+		if err == nil {
+			switch res.StatusCode {
+			case http.StatusTooManyRequests, http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+				failed = true
+			}
+		}
+		if !failed || attempt >= retries {
+			return res, err
+		}
+
+		wait := time.Second << attempt
+		if err == nil {
+			if s, err := strconv.Atoi(res.Header.Get("Retry-After")); err == nil && s >= 0 {
+				wait = time.Duration(s) * time.Second
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			debugf(ctx, "%v answered %v, asking again in %v\n", req.URL.Hostname(), res.Status, wait)
+		} else {
+			debugf(ctx, "%v, asking again in %v\n", err, wait)
+		}
+
+		timer := time.NewTimer(wait)
+		select {
+		case <-timer.C:
+		case <-ctx.Done():
+			timer.Stop()
+			return nil, ctx.Err()
+		}
+	}
+}
+
+func readCachedResponse(file string, req *http.Request) (*http.Response, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return http.ReadResponse(bufio.NewReader(bytes.NewReader(b)), req)
+}
+
+// writeCachedResponse writes the dumped response b to file, through a
+// temporary file so a run reading it at the same time never sees half.
+func writeCachedResponse(file string, b []byte) error {
+	if err := os.MkdirAll(filepath.Dir(file), 0700); err != nil {
+		return err
+	}
+
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".response-*")
+	if err != nil {
+		return err
+	}
+	if _, err := tmp.Write(b); err != nil {
+		tmp.Close()
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	if err := os.Rename(tmp.Name(), file); err != nil {
+		os.Remove(tmp.Name())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,337 @@
+package avsplit
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"fmt"
+	"hash"
+	"io"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// losslessSearch is how far from its timecode verifyLossless looks for where
+// the audio of a track starts in the source, past the keyframe or frame its
+// stream copy was snapped to.
+const losslessSearch = 5 * time.Second
+
+// losslessProbe is how many frames, a sample of each channel, of a track are
+// matched against the source to find where it starts there.
+const losslessProbe = 4096
+
+// lossyEdge is how much of each end of a track of a lossy codec is left out
+// of the comparison, which its decoder gets wrong without the frames before
+// it, as with the bit reservoir of MP3.
+const lossyEdge = 100 * time.Millisecond
+
+// pcmWindow is how much audio each hash verifyLossless compares covers, so a
+// difference is reported to the second.
+const pcmWindow = time.Second
+
+func probeChannels(ctx context.Context, audioFile string) (int, error) {
+	out, err := commandOutput(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-select_streams", probeStream(ctx),
+		"-show_entries", "stream=channels",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		argPath(audioFile),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	n, err := strconv.Atoi(firstLine(out))
+	if err != nil || n < 1 {
+		return 0, fmt.Errorf("cannot tell the channels of %v", audioFile)
+	}
+	return n, nil
+}
+
+// decodePCM decodes the audio of file to w as 32-bit samples, with inArgs
+// before the input and the audio filter, if any, after it.
+func decodePCM(ctx context.Context, file string, source bool, inArgs []string, filter string, w io.Writer) error {
+	args := append([]string{"-nostdin", "-v", "error"}, inArgs...)
+	args = append(args, "-i", argPath(file))
+	if source {
+		args = append(args, audioMapArgs(ctx)...)
+	} else {
+		args = append(args, "-map", "0:a:0")
+	}
+	if filter != "" {
+		args = append(args, "-af", filter)
+	}
+	args = append(args, "-f", "s32le", "-c:a", "pcm_s32le", "-")
+
+	var stderr bytes.Buffer
+	err := runCommand(ctx, "ffmpeg", args, w, &stderr)
+	debugStderr(ctx, "ffmpeg", stderr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return toolError("ffmpeg", stderr.String())
+	}
+	return nil
+}
+
+// pcmSamples returns the 32-bit samples of the decoded audio b.
+func pcmSamples(b []byte) []int32 {
+	samples := make([]int32, len(b)/4)
+	for i := range samples {
+		samples[i] = int32(binary.LittleEndian.Uint32(b[i*4:]))
+	}
+	return samples
+}
+
+// alignTrack returns the frame k of the head of a track, from skip on, and
+// the frame o of the source region at which losslessProbe frames of them
+// match exactly, or false if they don't anywhere. Silence matches
+// everywhere, so k is the first frame of a stretch that isn't all one
+// level.
+func alignTrack(head, region []int32, channels, skip int) (int, int, bool) {
+	n := losslessProbe * channels
+	k := -1
+	for at := skip * channels; at+n <= len(head); at += n {
+		for _, s := range head[at : at+n] {
+			if s != head[at] {
+				k = at
+				break
+			}
+		}
+		if k >= 0 {
+			break
+		}
+	}
+	if k < 0 {
+		return 0, 0, false
+	}
+
+	probe := head[k : k+n]
+	for o := 0; o+n <= len(region); o += channels {
+		i := 0
+		for i < n && region[o+i] == probe[i] {
+			i++
+		}
+		if i == n {
+			return k / channels, o / channels, true
+		}
+	}
+	return 0, 0, false
+}
+
+// windowHasher hashes what is written to it in windows of size bytes, after
+// skipping the first skip bytes.
+type windowHasher struct {
+	size, skip int
+	n          int64
+	h          hash.Hash
+	filled     int
+	sums       []string
+}
+
+func newWindowHasher(size, skip int) *windowHasher {
+	return &windowHasher{size: size, skip: skip, h: sha256.New()}
+}
+
+func (w *windowHasher) Write(p []byte) (int, error) {
+	written := len(p)
+	w.n += int64(len(p))
+
+	if w.skip > 0 {
+		if len(p) <= w.skip {
+			w.skip -= len(p)
+			return written, nil
+		}
+		p, w.skip = p[w.skip:], 0
+	}
+
+	for len(p) > 0 {
+		chunk := w.size - w.filled
+		if chunk > len(p) {
+			chunk = len(p)
+		}
+		w.h.Write(p[:chunk])
+		w.filled += chunk
+		p = p[chunk:]
+
+		if w.filled == w.size {
+			w.sums = append(w.sums, fmt.Sprintf("%x", w.h.Sum(nil)))
+			w.h.Reset()
+			w.filled = 0
+		}
+	}
+	return written, nil
+}
+
+// finish hashes what is left of the last window, if anything.
+func (w *windowHasher) finish() {
+	if w.filled > 0 {
+		w.sums = append(w.sums, fmt.Sprintf("%x", w.h.Sum(nil)))
+		w.h.Reset()
+		w.filled = 0
+	}
+}
+
+// losslessTrack is where verifyLossless found the audio of a track in the
+// source, as frames of it.
+type losslessTrack struct {
+	track  Track
+	start  int64
+	frames int64
+}
+
+// verifyLossless decodes each stream-copied track and compares its audio
+// with the region of the source it was cut from, a hash of each pcmWindow
+// of them. It reports every track that differs from the source and every
+// gap or overlap between tracks that should follow on from each other,
+// such as those cut on another keyframe than the timecode.
+func verifyLossless(ctx context.Context, opts Options, tracks Tracklist) error {
+	var copied Tracklist
+	for _, t := range tracks {
+		if !t.Reencode {
+			copied = append(copied, t)
+		}
+	}
+	if len(copied) < len(tracks) {
+		opts.logf("warning: verify-lossless only checks stream-copied tracks, %d of %d are re-encoded\n", len(tracks)-len(copied), len(tracks))
+	}
+	if len(copied) == 0 {
+		return nil
+	}
+
+	v, err := probeSampleRate(ctx, opts.Filename)
+	if err != nil {
+		return err
+	}
+	rate, err := strconv.Atoi(v)
+	if err != nil || rate < 1 {
+		return fmt.Errorf("cannot tell the sample rate of %v", opts.Filename)
+	}
+	channels, err := probeChannels(ctx, opts.Filename)
+	if err != nil {
+		return err
+	}
+	frameSize := 4 * channels
+	frames := func(d time.Duration) int64 {
+		return int64(d.Seconds() * float64(rate))
+	}
+	timeOf := func(n int64) time.Duration {
+		return time.Duration(n) * time.Second / time.Duration(rate)
+	}
+
+	var problems []string
+	var found []losslessTrack
+	for _, t := range copied {
+		out := t.outputFilename(opts.Filename)
+		opts.logf("verifying track \"%v\" against the source\n", out)
+
+		edge := int64(0)
+		if !losslessExts[strings.ToLower(filepath.Ext(out))] {
+			edge = frames(lossyEdge)
+		}
+
+		start, err := parseDuration(t.Start)
+		if err != nil {
+			return err
+		}
+
+		// The head of the track, and the source around where it should
+		// start from a whole second, which every sample rate has an exact
+		// frame for. A region from a second earlier still decodes right
+		// where the track does.
+		var head bytes.Buffer
+		if err := decodePCM(ctx, out, false, []string{"-t", formatTimecode(2 * losslessSearch)}, "", &head); err != nil {
+			return err
+		}
+
+		from := (start - losslessSearch - time.Second).Truncate(time.Second)
+		if from < 0 {
+			from = 0
+		}
+		var region bytes.Buffer
+		length := start + losslessSearch*3 - from
+		if err := decodePCM(ctx, opts.Filename, true, []string{"-ss", strconv.Itoa(int(from / time.Second)), "-t", formatTimecode(length)}, "", &region); err != nil {
+			return err
+		}
+
+		k, o, ok := alignTrack(pcmSamples(head.Bytes()), pcmSamples(region.Bytes()), channels, int(edge))
+		if !ok {
+			problems = append(problems, fmt.Sprintf("track %d: its audio is not in the source within %v of %v", t.Number, losslessSearch, t.Start))
+			continue
+		}
+		srcStart := frames(from) + int64(o) - int64(k)
+
+		// The whole track against the same stretch of the source
+		track := newWindowHasher(rate*frameSize*int(pcmWindow/time.Second), int(edge)*frameSize)
+		if err := decodePCM(ctx, out, false, nil, "", track); err != nil {
+			return err
+		}
+		total := track.n / int64(frameSize)
+		found = append(found, losslessTrack{t, srcStart, total})
+
+		compared := total - 2*edge
+		if compared <= 0 {
+			continue
+		}
+		if edge == 0 {
+			track.finish()
+		}
+
+		first := srcStart + edge
+		seek := (timeOf(first) - time.Second).Truncate(time.Second)
+		if seek < 0 {
+			seek = 0
+		}
+		skip := first - frames(seek)
+		filter := fmt.Sprintf("atrim=start_sample=%d:end_sample=%d", skip, skip+compared)
+		source := newWindowHasher(track.size, 0)
+		if err := decodePCM(ctx, opts.Filename, true, []string{"-ss", strconv.Itoa(int(seek / time.Second))}, filter, source); err != nil {
+			return err
+		}
+		if edge == 0 {
+			source.finish()
+		}
+
+		windows := len(source.sums)
+		if len(track.sums) < windows {
+			windows = len(track.sums)
+		}
+		for i := 0; i < windows; i++ {
+			if track.sums[i] != source.sums[i] {
+				at := timeOf(edge + int64(i)*int64(rate)*int64(pcmWindow/time.Second))
+				problems = append(problems, fmt.Sprintf("track %d: differs from the source between %v and %v of it", t.Number, formatTimecode(at), formatTimecode(at+pcmWindow)))
+				break
+			}
+		}
+		if edge == 0 && source.n != track.n {
+			problems = append(problems, fmt.Sprintf("track %d: is %v long, its stretch of the source %v", t.Number, timeOf(total), timeOf(source.n/int64(frameSize))))
+		}
+	}
+
+	for i := 1; i < len(found); i++ {
+		a, b := found[i-1], found[i]
+		if a.track.End == "" || a.track.End != b.track.Start {
+			// Meant to have a gap between them
+			continue
+		}
+
+		switch d := b.start - (a.start + a.frames); {
+		case d > 0:
+			problems = append(problems, fmt.Sprintf("tracks %d and %d: %v (%d samples) of the source is in neither", a.track.Number, b.track.Number, timeOf(d), d))
+		case d < 0:
+			problems = append(problems, fmt.Sprintf("tracks %d and %d: overlap by %v (%d samples)", a.track.Number, b.track.Number, timeOf(-d), -d))
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%d problems found verifying the tracks against the source:\n%v", len(problems), strings.Join(problems, "\n"))
+	}
+	opts.logf("the tracks match the source sample for sample\n")
+	return nil
+}
@@ -0,0 +1,140 @@
+package avsplit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// lyricsProvider looks up the plain and time synced lyrics of a track of
+// the given length, returning empty lyrics when it has none.
+type lyricsProvider func(ctx context.Context, t Track, length time.Duration) (plain, synced string, err error)
+
+// lyricsProviders are the providers selectable in Options.LyricsProvider.
+var lyricsProviders = map[string]lyricsProvider{
+	"lrclib": lookupLRCLIB,
+}
+
+var lrclibURL = "https://lrclib.net/api/get"
+
+var lrclibClient = &http.Client{Timeout: 30 * time.Second, Transport: lookupTransport}
+
+func lookupLRCLIB(ctx context.Context, t Track, length time.Duration) (string, string, error) {
+	query := url.Values{
+		"artist_name": {t.Artist},
+		"track_name":  {t.Title},
+		"album_name":  {t.Album},
+	}
+	if length > 0 {
+		// Matched to within a couple of seconds
+		query.Set("duration", strconv.Itoa(int(length.Round(time.Second).Seconds())))
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", lrclibURL+"?"+query.Encode(), nil)
+	if err != nil {
+		return "", "", err
+	}
+	req.Header.Set("User-Agent", musicBrainzUserAgent)
+
+	res, err := lrclibClient.Do(req)
+	if err != nil {
+		return "", "", fmt.Errorf("lrclib: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		return "", "", nil
+	}
+
+	if res.StatusCode != http.StatusOK {
+		return "", "", fmt.Errorf("lrclib: %v", res.Status)
+	}
+
+	var result struct {
+		PlainLyrics  string `json:"plainLyrics"`
+		SyncedLyrics string `json:"syncedLyrics"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return "", "", fmt.Errorf("lrclib: invalid response: %v", err)
+	}
+
+	return strings.TrimSpace(result.PlainLyrics), strings.TrimSpace(result.SyncedLyrics), nil
+}
+
+// fetchLyrics looks up the lyrics of each track. Tracks without any are
+// left as they are with a warning, so one missing song doesn't stop a
+// concert from being split.
+func fetchLyrics(ctx context.Context, opts Options, tracks Tracklist) error {
+	if opts.Lyrics != "tag" && opts.Lyrics != "lrc" && opts.Lyrics != "both" {
+		return fmt.Errorf("unknown lyrics %v, must be tag, lrc or both", opts.Lyrics)
+	}
+
+	provider, ok := lyricsProviders[opts.LyricsProvider]
+	if !ok {
+		return fmt.Errorf("unknown lyrics provider %v", opts.LyricsProvider)
+	}
+
+	// Without the source length the last track is looked up by name only
+	total, _ := probeDuration(ctx, opts.Filename)
+
+	for i := range tracks {
+		t := &tracks[i]
+		length, err := t.duration(total)
+		if err != nil || length < 0 {
+			length = 0
+		}
+
+		opts.logf("looking up lyrics of track %d\n", t.Number)
+		plain, synced, err := provider(ctx, *t, length)
+		if err != nil {
+			if ctx.Err() != nil {
+				return ctx.Err()
+			}
+			opts.logf("warning: cannot look up lyrics of track %d: %v\n", t.Number, err)
+			continue
+		}
+
+		if plain == "" && synced == "" {
+			opts.logf("warning: no lyrics found for track %d\n", t.Number)
+			continue
+		}
+
+		if synced == "" && opts.Lyrics == "lrc" {
+			opts.logf("warning: no synced lyrics found for track %d\n", t.Number)
+			continue
+		}
+
+		if opts.Lyrics != "lrc" {
+			t.Lyrics = plain
+		}
+		t.SyncedLyrics = synced
+	}
+	return nil
+}
+
+// lyricsFile returns the .lrc file written next to a track's output file.
+func lyricsFile(out string) string {
+	return strings.TrimSuffix(out, filepath.Ext(out)) + ".lrc"
+}
+
+// writeLyricsFiles writes the synced lyrics of each track to an .lrc file
+// next to it, which players show in time with the song.
+func writeLyricsFiles(opts Options, tracks Tracklist) error {
+	for _, t := range tracks {
+		if t.SyncedLyrics == "" {
+			continue
+		}
+
+		out := lyricsFile(t.outputFilename(opts.Filename))
+		if err := opts.writeFile(out, []byte(t.SyncedLyrics+"\n")); err != nil {
+			return fmt.Errorf("cannot write lyrics: %v", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,365 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Release is an album as a MetadataProvider found it, with the tags of its
+// tracks and, where known, their lengths. It is shared once cached, so it
+// is only read.
+type Release struct {
+	// Provider names where the release was found, as in "musicbrainz"
+	Provider string
+	ID       string
+
+	Artist        string
+	Title         string
+	Year          string
+	Label         string
+	CatalogNumber string
+
+	// MBAlbumID is the MusicBrainz ID of the release, when it has one
+	MBAlbumID string
+
+	Tracks []ReleaseTrack
+}
+
+// ReleaseTrack is a track of a Release. Length is 0 when the provider
+// doesn't know it.
+type ReleaseTrack struct {
+	Title  string
+	Artist string
+	Length time.Duration
+
+	// Artists are those Artist credits, when there are several
+	Artists []string
+
+	// MusicBrainz IDs of the recording and release track
+	MBTrackID        string
+	MBReleaseTrackID string
+}
+
+// MetadataProvider looks up the release a tracklist is of, to fill its
+// titles and tags in from. Options.Metadata takes one of these or a
+// provider of the caller's own.
+type MetadataProvider interface {
+	// Name names the provider in messages, as in "musicbrainz".
+	Name() string
+	// Lookup finds the release of the artist and album.
+	Lookup(ctx context.Context, artist, album string) (*Release, error)
+	// LookupByID fetches the release by its ID at the provider.
+	LookupByID(ctx context.Context, id string) (*Release, error)
+}
+
+// Shared by every run of the process, so the runs of a batch, watch or
+// serve look each release up once
+var (
+	musicBrainzProvider = CacheProvider(MusicBrainzProvider())
+	discogsProvider     = CacheProvider(DiscogsProvider())
+)
+
+// lookupRelease looks up the release of the tracklist with p, by id or
+// without one by the artist and album of opts.
+func lookupRelease(ctx context.Context, opts Options, p MetadataProvider, id string) (*Release, error) {
+	var r *Release
+	var err error
+	if id != "" {
+		r, err = p.LookupByID(ctx, id)
+	} else {
+		if opts.Artist == "" || opts.Album == "" {
+			return nil, fmt.Errorf("%v: searching requires an artist and album", p.Name())
+		}
+		r, err = p.Lookup(ctx, opts.Artist, opts.Album)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if len(r.Tracks) == 0 {
+		return nil, fmt.Errorf("%v: release %v has no tracks", r.Provider, r.ID)
+	}
+
+	opts.logf("using %v release \"%v - %v\"\n", r.Provider, r.Artist, r.Title)
+	return r, nil
+}
+
+// releaseTracks builds the tracks from the release's track lengths for when
+// no timecodes are given.
+func releaseTracks(release *Release) ([]Track, error) {
+	tracks := make([]Track, len(release.Tracks))
+
+	var start time.Duration
+	for i, t := range release.Tracks {
+		if t.Length <= 0 && i < len(release.Tracks)-1 {
+			return nil, fmt.Errorf("%v: track %d has no length, timecodes are required", release.Provider, i+1)
+		}
+
+		tracks[i] = Track{
+			Number: i + 1,
+			Total:  len(release.Tracks),
+			Start:  formatTimecode(start),
+		}
+
+		start += t.Length
+		if i < len(release.Tracks)-1 {
+			tracks[i].End = formatTimecode(start)
+		}
+	}
+
+	return tracks, nil
+}
+
+// applyRelease fills untitled tracks and tags from the release. Artist and
+// album flags take precedence over the release's.
+func applyRelease(release *Release, tracks []Track, opts Options) {
+	rTracks := release.Tracks
+	if len(rTracks) != len(tracks) {
+		opts.logf("warning: %v release has %d tracks, found %d\n", release.Provider, len(rTracks), len(tracks))
+	}
+
+	albumArtist := opts.Artist
+	if albumArtist == "" {
+		albumArtist = release.Artist
+	}
+
+	album := opts.Album
+	if album == "" {
+		album = release.Title
+	}
+
+	for i := range tracks {
+		t := &tracks[i]
+		t.AlbumArtist = albumArtist
+		t.Album = album
+		t.Artist = albumArtist
+		if release.MBAlbumID != "" {
+			t.MBAlbumID = release.MBAlbumID
+		}
+		if release.Label != "" || release.CatalogNumber != "" {
+			t.Label, t.CatalogNumber = release.Label, release.CatalogNumber
+		}
+
+		if t.Year == "" {
+			t.Year = release.Year
+		}
+
+		if i >= len(rTracks) {
+			continue
+		}
+
+		if t.Title == "" {
+			t.Title = rTracks[i].Title
+		}
+		if rTracks[i].MBTrackID != "" {
+			t.MBTrackID = rTracks[i].MBTrackID
+			t.MBReleaseTrackID = rTracks[i].MBReleaseTrackID
+		}
+
+		if a := rTracks[i].Artist; a != "" && opts.Artist == "" {
+			t.Artist = a
+			if len(rTracks[i].Artists) > 1 {
+				t.Artists = rTracks[i].Artists
+			}
+		}
+	}
+}
+
+type providerChain []MetadataProvider
+
+// ChainProviders returns a provider that asks each of providers in turn,
+// returning the first release found. It fails with what each of them
+// said when none has it.
+func ChainProviders(providers ...MetadataProvider) MetadataProvider {
+	return providerChain(providers)
+}
+
+func (c providerChain) Name() string {
+	names := make([]string, len(c))
+	for i, p := range c {
+		names[i] = p.Name()
+	}
+	return strings.Join(names, ", ")
+}
+
+func (c providerChain) Lookup(ctx context.Context, artist, album string) (*Release, error) {
+	return c.first(ctx, func(p MetadataProvider) (*Release, error) {
+		return p.Lookup(ctx, artist, album)
+	})
+}
+
+func (c providerChain) LookupByID(ctx context.Context, id string) (*Release, error) {
+	return c.first(ctx, func(p MetadataProvider) (*Release, error) {
+		return p.LookupByID(ctx, id)
+	})
+}
+
+func (c providerChain) first(ctx context.Context, lookup func(MetadataProvider) (*Release, error)) (*Release, error) {
+	var errs []string
+	for _, p := range c {
+		r, err := lookup(p)
+		if err == nil {
+			return r, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		errs = append(errs, err.Error())
+	}
+
+	if len(errs) == 0 {
+		return nil, fmt.Errorf("no metadata providers to look the release up with")
+	}
+	return nil, fmt.Errorf("no release found: %v", strings.Join(errs, "; "))
+}
+
+type cachedProvider struct {
+	p        MetadataProvider
+	mu       sync.Mutex
+	releases map[string]*Release
+}
+
+// CacheProvider returns a provider that asks p for each release once,
+// keeping what it found for the next lookup of the same artist and album
+// or ID. Failed lookups are asked again.
+func CacheProvider(p MetadataProvider) MetadataProvider {
+	return &cachedProvider{p: p, releases: make(map[string]*Release)}
+}
+
+func (c *cachedProvider) Name() string {
+	return c.p.Name()
+}
+
+func (c *cachedProvider) Lookup(ctx context.Context, artist, album string) (*Release, error) {
+	return c.cached("search\x00"+strings.ToLower(artist)+"\x00"+strings.ToLower(album), func() (*Release, error) {
+		return c.p.Lookup(ctx, artist, album)
+	})
+}
+
+func (c *cachedProvider) LookupByID(ctx context.Context, id string) (*Release, error) {
+	return c.cached("id\x00"+id, func() (*Release, error) {
+		return c.p.LookupByID(ctx, id)
+	})
+}
+
+func (c *cachedProvider) cached(key string, lookup func() (*Release, error)) (*Release, error) {
+	c.mu.Lock()
+	r, ok := c.releases[key]
+	c.mu.Unlock()
+	if ok {
+		return r, nil
+	}
+
+	r, err := lookup()
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	c.releases[key] = r
+	c.mu.Unlock()
+	return r, nil
+}
+
+type localProvider struct {
+	dir string
+}
+
+// LocalProvider returns a provider of the releases kept as YAML or TOML
+// tracklists in dir, one file each, which it looks up by the artist and
+// album of the file or by its name as the ID. Only the tracks with an end,
+// or another track after them, have a length.
+func LocalProvider(dir string) MetadataProvider {
+	return localProvider{dir}
+}
+
+func (l localProvider) Name() string {
+	return "local"
+}
+
+func (l localProvider) Lookup(ctx context.Context, artist, album string) (*Release, error) {
+	entries, err := os.ReadDir(l.dir)
+	if err != nil {
+		return nil, fmt.Errorf("local: %v", err)
+	}
+
+	for _, e := range entries {
+		r, err := l.read(e.Name())
+		if err != nil {
+			// Not a tracklist, or not one that can be read
+			continue
+		}
+
+		if strings.EqualFold(r.Artist, artist) && strings.EqualFold(r.Title, album) {
+			return r, nil
+		}
+	}
+	return nil, fmt.Errorf("local: no release found for %v - %v in %v", artist, album, l.dir)
+}
+
+func (l localProvider) LookupByID(ctx context.Context, id string) (*Release, error) {
+	if filepath.Base(id) != id {
+		return nil, fmt.Errorf("local: invalid release %v, must be the name of a file in %v", id, l.dir)
+	}
+
+	r, err := l.read(id)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("local: no release %v in %v", id, l.dir)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("local: %v: %v", id, err)
+	}
+	return r, nil
+}
+
+// read reads the release of the tracklist name in the directory.
+func (l localProvider) read(name string) (*Release, error) {
+	parse := parseYAMLTracklist
+	switch strings.ToLower(filepath.Ext(name)) {
+	case ".yaml", ".yml":
+	case ".toml":
+		parse = parseTOMLTracklist
+	default:
+		return nil, fmt.Errorf("not a YAML or TOML tracklist")
+	}
+
+	f, err := os.Open(filepath.Join(l.dir, name))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	s, err := parse(f)
+	if err != nil {
+		return nil, err
+	}
+
+	// Titles may be left to the release lookups of another provider
+	tracks, err := structuredTracks(s, Options{AutoTitle: "-"}, l.dir)
+	if err != nil {
+		return nil, err
+	}
+
+	r := &Release{Provider: "local", ID: name}
+	if len(tracks) > 0 {
+		t := tracks[0]
+		r.Artist, r.Title, r.Year = t.AlbumArtist, t.Album, t.Year
+		r.Label, r.CatalogNumber = t.Label, t.CatalogNumber
+	}
+
+	for _, t := range tracks {
+		rt := ReleaseTrack{Title: t.Title, Artist: t.Artist}
+		if t.End != "" {
+			if d, err := t.duration(0); err == nil {
+				rt.Length = d
+			}
+		}
+		r.Tracks = append(r.Tracks, rt)
+	}
+	return r, nil
+}
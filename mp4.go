@@ -0,0 +1,358 @@
+package avsplit
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// mp4Atom is an atom read from an MP4 file. Offset and Size locate it in the
+// file, header included.
+type mp4Atom struct {
+	Type   string
+	Offset int64
+	Size   int64
+	Header int64
+}
+
+// readMP4Atoms lists the atoms of r between offset and end.
+func readMP4Atoms(r io.ReaderAt, offset, end int64) ([]mp4Atom, error) {
+	var atoms []mp4Atom
+	for offset+8 <= end {
+		h := make([]byte, 16)
+		if _, err := r.ReadAt(h[:8], offset); err != nil {
+			return nil, err
+		}
+
+		a := mp4Atom{
+			Type:   string(h[4:8]),
+			Offset: offset,
+			Size:   int64(binary.BigEndian.Uint32(h)),
+			Header: 8,
+		}
+
+		switch a.Size {
+		case 0:
+			a.Size = end - offset
+		case 1:
+			if _, err := r.ReadAt(h[8:], offset+8); err != nil {
+				return nil, err
+			}
+			a.Size = int64(binary.BigEndian.Uint64(h[8:]))
+			a.Header = 16
+		}
+
+		if a.Size < a.Header || offset+a.Size > end {
+			return nil, fmt.Errorf("invalid mp4 atom %q", a.Type)
+		}
+
+		atoms = append(atoms, a)
+		offset += a.Size
+	}
+	return atoms, nil
+}
+
+func mp4Box(typ string, payload ...[]byte) []byte {
+	size := 8
+	for _, p := range payload {
+		size += len(p)
+	}
+
+	b := make([]byte, 8, size)
+	binary.BigEndian.PutUint32(b, uint32(size))
+	copy(b[4:], typ)
+	for _, p := range payload {
+		b = append(b, p...)
+	}
+	return b
+}
+
+// mp4Item returns an ilst item holding one data atom of the given type, 1
+// for UTF-8 text and 0 for binary.
+func mp4Item(name string, typ uint32, value []byte) []byte {
+	head := make([]byte, 8)
+	binary.BigEndian.PutUint32(head, typ)
+	return mp4Box(name, mp4Box("data", head, value))
+}
+
+// mp4Freeform returns a freeform iTunes item, a text tag of its own named
+// name, with a data atom for each of its values.
+func mp4Freeform(name string, values ...string) []byte {
+	head := make([]byte, 8)
+	binary.BigEndian.PutUint32(head, 1)
+	boxes := [][]byte{
+		mp4Box("mean", make([]byte, 4), []byte("com.apple.iTunes")),
+		mp4Box("name", make([]byte, 4), []byte(name)),
+	}
+	for _, v := range values {
+		boxes = append(boxes, mp4Box("data", head, []byte(v)))
+	}
+	return mp4Box("----", boxes...)
+}
+
+func mp4Pair(n, total int, size int) []byte {
+	b := make([]byte, size)
+	binary.BigEndian.PutUint16(b[2:], uint16(n))
+	binary.BigEndian.PutUint16(b[4:], uint16(total))
+	return b
+}
+
+// mp4Meta returns the udta meta atom holding the track's tags in an iTunes
+// style ilst.
+func (t *Track) mp4Meta() ([]byte, error) {
+	text := [][2]string{
+		{"\xa9nam", t.Title},
+		{"\xa9ART", t.Artist},
+		{"aART", t.AlbumArtist},
+		{"\xa9alb", t.Album},
+		{"\xa9wrt", t.Composer},
+		{"\xa9day", t.Year},
+		{"\xa9gen", t.Genre},
+		{"\xa9cmt", t.Comment},
+		{"\xa9lyr", t.Lyrics},
+	}
+
+	var items [][]byte
+	for _, kv := range text {
+		if kv[1] != "" {
+			items = append(items, mp4Item(kv[0], 1, []byte(kv[1])))
+		}
+	}
+
+	if t.Work != "" {
+		// Movement numbers are 16 bit integers, shwm has players show the
+		// work and movement instead of the title
+		items = append(items,
+			mp4Item("\xa9wrk", 1, []byte(t.Work)),
+			mp4Item("\xa9mvn", 1, []byte(t.Title)),
+			mp4Item("\xa9mvi", 21, []byte{byte(t.Movement >> 8), byte(t.Movement)}),
+			mp4Item("\xa9mvc", 21, []byte{byte(t.Movements >> 8), byte(t.Movements)}),
+			mp4Item("shwm", 21, []byte{1}),
+		)
+	}
+
+	if artists := t.artists(); artists != nil {
+		items = append(items, mp4Freeform("ARTISTS", artists...))
+	}
+
+	if t.Label != "" {
+		items = append(items, mp4Freeform("LABEL", t.Label))
+	}
+
+	if t.CatalogNumber != "" {
+		items = append(items, mp4Freeform("CATALOGNUMBER", t.CatalogNumber))
+	}
+
+	if t.ISRC != "" {
+		items = append(items, mp4Freeform("ISRC", t.ISRC))
+	}
+
+	items = append(items, mp4Item("trkn", 0, mp4Pair(t.Number, t.Total, 8)))
+	if t.Disc != 0 {
+		items = append(items, mp4Item("disk", 0, mp4Pair(t.Disc, t.DiscTotal, 6)))
+	}
+
+	if t.Cover != "" {
+		mime, err := imageMIMEType(t.Cover)
+		if err != nil {
+			return nil, err
+		}
+
+		image, err := os.ReadFile(t.Cover)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read cover art: %v", err)
+		}
+
+		// 13 is JPEG and 14 PNG
+		typ := uint32(13)
+		if mime == "image/png" {
+			typ = 14
+		}
+		items = append(items, mp4Item("covr", typ, image))
+	}
+
+	// The handler marks the metadata as iTunes style
+	hdlr := mp4Box("hdlr", make([]byte, 8), []byte("mdirappl"), make([]byte, 9))
+	return mp4Box("meta", make([]byte, 4), hdlr, mp4Box("ilst", items...)), nil
+}
+
+// mp4ContainerPath is the atoms down to the chunk offset tables, which point
+// into mdat and move when moov before it changes size.
+var mp4ContainerPath = map[string]bool{
+	"moov": true, "trak": true, "mdia": true, "minf": true, "stbl": true,
+}
+
+// shiftMP4Offsets adds delta to the chunk offsets in the stco and co64
+// atoms among atoms, which are laid out in buf.
+func shiftMP4Offsets(buf []byte, atoms []mp4Atom, delta int64) error {
+	for _, a := range atoms {
+		if mp4ContainerPath[a.Type] {
+			children, err := readMP4Atoms(bytes.NewReader(buf), a.Offset+a.Header, a.Offset+a.Size)
+			if err != nil {
+				return err
+			}
+			if err := shiftMP4Offsets(buf, children, delta); err != nil {
+				return err
+			}
+			continue
+		}
+
+		if a.Type != "stco" && a.Type != "co64" {
+			continue
+		}
+
+		width := 4
+		if a.Type == "co64" {
+			width = 8
+		}
+
+		// Version and flags, then the entry count
+		data := buf[a.Offset+a.Header : a.Offset+a.Size]
+		if len(data) < 8 || len(data) < 8+int(binary.BigEndian.Uint32(data[4:]))*width {
+			return fmt.Errorf("invalid mp4 atom %q", a.Type)
+		}
+
+		n := int(binary.BigEndian.Uint32(data[4:]))
+		for i := 0; i < n; i++ {
+			p := data[8+i*width:]
+			if width == 8 {
+				binary.BigEndian.PutUint64(p, uint64(int64(binary.BigEndian.Uint64(p))+delta))
+				continue
+			}
+
+			v := int64(binary.BigEndian.Uint32(p)) + delta
+			if v >= 1<<32 {
+				return fmt.Errorf("mp4 chunk offset too large after tagging")
+			}
+			binary.BigEndian.PutUint32(p, uint32(v))
+		}
+	}
+	return nil
+}
+
+// rebuildMP4Moov returns moov with its udta meta atom replaced by meta,
+// keeping everything else in it.
+func rebuildMP4Moov(moov []byte, meta []byte) ([]byte, error) {
+	r := bytes.NewReader(moov)
+	atoms, err := readMP4Atoms(r, 8, int64(len(moov)))
+	if err != nil {
+		return nil, err
+	}
+
+	var children [][]byte
+	var udta [][]byte
+	for _, a := range atoms {
+		body := moov[a.Offset : a.Offset+a.Size]
+		if a.Type != "udta" {
+			children = append(children, body)
+			continue
+		}
+
+		inner, err := readMP4Atoms(r, a.Offset+a.Header, a.Offset+a.Size)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range inner {
+			if c.Type != "meta" {
+				udta = append(udta, moov[c.Offset:c.Offset+c.Size])
+			}
+		}
+	}
+
+	udta = append(udta, meta)
+	children = append(children, mp4Box("udta", udta...))
+	return mp4Box("moov", children...), nil
+}
+
+// writeMP4Tags replaces the iTunes style tags of an MP4 file, shifting the
+// chunk offsets when moov comes before the audio. The file is rewritten next
+// to the original and renamed into place.
+func writeMP4Tags(filename string, t Track) error {
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	atoms, err := readMP4Atoms(src, 0, info.Size())
+	if err != nil || len(atoms) == 0 || atoms[0].Type != "ftyp" {
+		return fmt.Errorf("%v: not an mp4 file", filename)
+	}
+
+	moovIndex, mdatOffset := -1, int64(-1)
+	for i, a := range atoms {
+		switch a.Type {
+		case "moov":
+			moovIndex = i
+		case "mdat":
+			if mdatOffset < 0 {
+				mdatOffset = a.Offset
+			}
+		}
+	}
+	if moovIndex < 0 || atoms[moovIndex].Header != 8 {
+		return fmt.Errorf("%v: no moov atom", filename)
+	}
+
+	moovAtom := atoms[moovIndex]
+	moov := make([]byte, moovAtom.Size)
+	if _, err := src.ReadAt(moov, moovAtom.Offset); err != nil {
+		return err
+	}
+
+	meta, err := t.mp4Meta()
+	if err != nil {
+		return err
+	}
+
+	newMoov, err := rebuildMP4Moov(moov, meta)
+	if err != nil {
+		return fmt.Errorf("%v: %v", filename, err)
+	}
+
+	if delta := int64(len(newMoov)) - moovAtom.Size; delta != 0 && mdatOffset > moovAtom.Offset {
+		inner, err := readMP4Atoms(bytes.NewReader(newMoov), 0, int64(len(newMoov)))
+		if err != nil {
+			return err
+		}
+		if err := shiftMP4Offsets(newMoov, inner, delta); err != nil {
+			return fmt.Errorf("%v: %v", filename, err)
+		}
+	}
+
+	dst, err := os.CreateTemp(filepath.Dir(filename), ".avsplit-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+
+	for i, a := range atoms {
+		if i == moovIndex {
+			_, err = dst.Write(newMoov)
+		} else {
+			_, err = io.Copy(dst, io.NewSectionReader(src, a.Offset, a.Size))
+		}
+		if err != nil {
+			dst.Close()
+			return err
+		}
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	if err := os.Chmod(dst.Name(), info.Mode()); err != nil {
+		return err
+	}
+
+	return os.Rename(dst.Name(), filename)
+}
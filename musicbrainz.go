@@ -0,0 +1,163 @@
+package avsplit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"time"
+)
+
+var musicBrainzURL = "https://musicbrainz.org/ws/2"
+
+// musicBrainzUserAgent identifies avsplit to MusicBrainz, which rejects
+// anonymous clients.
+const musicBrainzUserAgent = "avsplit/0.1 ( https://github.com/berryp/avsplit )"
+
+type mbArtistCredit []struct {
+	Name       string `json:"name"`
+	JoinPhrase string `json:"joinphrase"`
+}
+
+// names returns the names of the artists credited.
+func (c mbArtistCredit) names() []string {
+	var names []string
+	for _, a := range c {
+		names = append(names, a.Name)
+	}
+	return names
+}
+
+func (c mbArtistCredit) String() string {
+	var s string
+	for _, a := range c {
+		s += a.Name + a.JoinPhrase
+	}
+	return s
+}
+
+type mbTrack struct {
+	ID           string         `json:"id"`
+	Title        string         `json:"title"`
+	Length       int64          `json:"length"`
+	ArtistCredit mbArtistCredit `json:"artist-credit"`
+	Recording    struct {
+		ID string `json:"id"`
+	} `json:"recording"`
+}
+
+type mbRelease struct {
+	ID           string         `json:"id"`
+	Title        string         `json:"title"`
+	Date         string         `json:"date"`
+	ArtistCredit mbArtistCredit `json:"artist-credit"`
+	Media        []struct {
+		Tracks []mbTrack `json:"tracks"`
+	} `json:"media"`
+}
+
+func (r *mbRelease) tracks() []mbTrack {
+	var tracks []mbTrack
+	for _, m := range r.Media {
+		tracks = append(tracks, m.Tracks...)
+	}
+	return tracks
+}
+
+func (r *mbRelease) year() string {
+	if len(r.Date) < 4 {
+		return ""
+	}
+	return r.Date[:4]
+}
+
+var musicBrainzClient = &http.Client{Timeout: 30 * time.Second, Transport: lookupTransport}
+
+func musicBrainzGet(ctx context.Context, path string, query url.Values, v interface{}) error {
+	query.Set("fmt", "json")
+
+	req, err := http.NewRequestWithContext(ctx, "GET", musicBrainzURL+path+"?"+query.Encode(), nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", musicBrainzUserAgent)
+
+	res, err := musicBrainzClient.Do(req)
+	if err != nil {
+		return fmt.Errorf("musicbrainz: %v", err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("musicbrainz: %v", res.Status)
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(v); err != nil {
+		return fmt.Errorf("musicbrainz: invalid response: %v", err)
+	}
+
+	return nil
+}
+
+type musicBrainz struct{}
+
+// MusicBrainzProvider returns the provider of releases from MusicBrainz,
+// looked up by their MBID or the best match for the artist and album.
+func MusicBrainzProvider() MetadataProvider {
+	return musicBrainz{}
+}
+
+func (musicBrainz) Name() string {
+	return "musicbrainz"
+}
+
+func (m musicBrainz) Lookup(ctx context.Context, artist, album string) (*Release, error) {
+	var result struct {
+		Releases []struct {
+			ID string `json:"id"`
+		} `json:"releases"`
+	}
+
+	query := url.Values{}
+	query.Set("query", fmt.Sprintf("release:%q AND artist:%q", album, artist))
+	query.Set("limit", "1")
+	if err := musicBrainzGet(ctx, "/release/", query, &result); err != nil {
+		return nil, err
+	}
+
+	if len(result.Releases) == 0 {
+		return nil, fmt.Errorf("musicbrainz: no release found for %v - %v", artist, album)
+	}
+	return m.LookupByID(ctx, result.Releases[0].ID)
+}
+
+func (musicBrainz) LookupByID(ctx context.Context, id string) (*Release, error) {
+	query := url.Values{}
+	query.Set("inc", "recordings artist-credits")
+
+	var release mbRelease
+	if err := musicBrainzGet(ctx, "/release/"+url.PathEscape(id), query, &release); err != nil {
+		return nil, err
+	}
+
+	r := &Release{
+		Provider:  "musicbrainz",
+		ID:        id,
+		MBAlbumID: release.ID,
+		Artist:    release.ArtistCredit.String(),
+		Title:     release.Title,
+		Year:      release.year(),
+	}
+	for _, t := range release.tracks() {
+		r.Tracks = append(r.Tracks, ReleaseTrack{
+			Title:            t.Title,
+			Artist:           t.ArtistCredit.String(),
+			Artists:          t.ArtistCredit.names(),
+			Length:           time.Duration(t.Length) * time.Millisecond,
+			MBTrackID:        t.Recording.ID,
+			MBReleaseTrackID: t.ID,
+		})
+	}
+	return r, nil
+}
@@ -0,0 +1,100 @@
+package avsplit
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// Loudness targets of the loudnorm filter, EBU R128 as used by most streaming
+// services.
+const (
+	loudnormI   = "-16"
+	loudnormTP  = "-1.5"
+	loudnormLRA = "11"
+)
+
+// loudness is what the first loudnorm pass measures.
+type loudness struct {
+	InputI       string `json:"input_i"`
+	InputTP      string `json:"input_tp"`
+	InputLRA     string `json:"input_lra"`
+	InputThresh  string `json:"input_thresh"`
+	TargetOffset string `json:"target_offset"`
+}
+
+// loudnormFilter returns the loudnorm filter for the second pass. Without a
+// measurement it is a single dynamic pass, which is what the dry run and
+// scripts show. loudnorm works at 192kHz, so sampleRate puts the output back
+// at the source's rate when known.
+func loudnormFilter(m *loudness, sampleRate string) string {
+	f := fmt.Sprintf("loudnorm=I=%v:TP=%v:LRA=%v", loudnormI, loudnormTP, loudnormLRA)
+	if m != nil {
+		f += fmt.Sprintf(
+			":measured_I=%v:measured_TP=%v:measured_LRA=%v:measured_thresh=%v:offset=%v:linear=true",
+			m.InputI, m.InputTP, m.InputLRA, m.InputThresh, m.TargetOffset,
+		)
+	}
+
+	if sampleRate != "" {
+		f += ",aresample=" + sampleRate
+	}
+	return f
+}
+
+// measureLoudness runs the first loudnorm pass over the audio between start
+// and end, an empty end reading to the end of the file.
+func measureLoudness(ctx context.Context, audioFile, start, end string) (*loudness, error) {
+	args := []string{"-nostdin", "-hide_banner", "-ss", start}
+	if end != "" {
+		args = append(args, "-to", end)
+	}
+
+	filter := fmt.Sprintf("loudnorm=I=%v:TP=%v:LRA=%v:print_format=json", loudnormI, loudnormTP, loudnormLRA)
+	args = append(args, "-i", argPath(audioFile))
+	args = append(args, audioMapArgs(ctx)...)
+	args = append(args, "-af", filter, "-f", "null", "-")
+
+	var stderr bytes.Buffer
+	err := runCommand(ctx, "ffmpeg", args, nil, &stderr)
+	debugStderr(ctx, "ffmpeg", stderr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, toolError("ffmpeg", stderr.String())
+	}
+
+	// The measurement is the JSON object at the end of the log
+	out := stderr.String()
+	i := strings.LastIndex(out, "{")
+	j := strings.LastIndex(out, "}")
+	if i < 0 || j < i {
+		return nil, fmt.Errorf("cannot measure loudness of %v", audioFile)
+	}
+
+	var m loudness
+	if err := json.Unmarshal([]byte(out[i:j+1]), &m); err != nil {
+		return nil, fmt.Errorf("cannot measure loudness of %v: %v", audioFile, err)
+	}
+
+	return &m, nil
+}
+
+func probeSampleRate(ctx context.Context, audioFile string) (string, error) {
+	out, err := commandOutput(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-select_streams", probeStream(ctx),
+		"-show_entries", "stream=sample_rate",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		argPath(audioFile),
+	)
+	if err != nil {
+		return "", err
+	}
+	return firstLine(out), nil
+}
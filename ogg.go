@@ -0,0 +1,287 @@
+package avsplit
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+const (
+	oggHeaderSize  = 27
+	oggContinued   = 0x01
+	oggFirstPage   = 0x02
+	oggMaxSegments = 255
+)
+
+var oggCRCTable = func() [256]uint32 {
+	var t [256]uint32
+	for i := range t {
+		r := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if r&0x80000000 != 0 {
+				r = r<<1 ^ 0x04c11db7
+			} else {
+				r <<= 1
+			}
+		}
+		t[i] = r
+	}
+	return t
+}()
+
+// oggCRC is the checksum of an Ogg page, computed with its own checksum
+// field zeroed.
+func oggCRC(page []byte) uint32 {
+	var crc uint32
+	for _, b := range page {
+		crc = crc<<8 ^ oggCRCTable[byte(crc>>24)^b]
+	}
+	return crc
+}
+
+type oggPage struct {
+	Type    byte
+	Granule uint64
+	Serial  uint32
+	Seq     uint32
+	Lacing  []byte
+	Data    []byte
+}
+
+func readOggPage(r io.Reader) (*oggPage, error) {
+	header := make([]byte, oggHeaderSize)
+	if _, err := io.ReadFull(r, header); err != nil {
+		return nil, err
+	}
+	if string(header[:4]) != "OggS" {
+		return nil, fmt.Errorf("invalid ogg page")
+	}
+
+	p := &oggPage{
+		Type:    header[5],
+		Granule: binary.LittleEndian.Uint64(header[6:]),
+		Serial:  binary.LittleEndian.Uint32(header[14:]),
+		Seq:     binary.LittleEndian.Uint32(header[18:]),
+		Lacing:  make([]byte, header[26]),
+	}
+	if _, err := io.ReadFull(r, p.Lacing); err != nil {
+		return nil, err
+	}
+
+	size := 0
+	for _, l := range p.Lacing {
+		size += int(l)
+	}
+	p.Data = make([]byte, size)
+	if _, err := io.ReadFull(r, p.Data); err != nil {
+		return nil, err
+	}
+	return p, nil
+}
+
+func (p *oggPage) bytes() []byte {
+	var b bytes.Buffer
+	b.WriteString("OggS")
+	b.WriteByte(0)
+	b.WriteByte(p.Type)
+	binary.Write(&b, binary.LittleEndian, p.Granule)
+	binary.Write(&b, binary.LittleEndian, p.Serial)
+	binary.Write(&b, binary.LittleEndian, p.Seq)
+	b.Write(make([]byte, 4))
+	b.WriteByte(byte(len(p.Lacing)))
+	b.Write(p.Lacing)
+	b.Write(p.Data)
+
+	page := b.Bytes()
+	binary.LittleEndian.PutUint32(page[22:], oggCRC(page))
+	return page
+}
+
+// oggHeaderPages lays out header packets on pages of their own, the first
+// packet alone on the first page as the Vorbis and Opus specs require.
+func oggHeaderPages(serial uint32, packets [][]byte) []*oggPage {
+	var pages []*oggPage
+	for i, packet := range packets {
+		if i <= 1 {
+			p := &oggPage{Serial: serial}
+			if i == 0 {
+				p.Type = oggFirstPage
+			}
+			pages = append(pages, p)
+		}
+
+		// Every packet ends with a lacing value under 255, a zero if need be
+		continued := false
+		for {
+			p := pages[len(pages)-1]
+			if len(p.Lacing) == oggMaxSegments {
+				p = &oggPage{Serial: serial}
+				if continued {
+					p.Type = oggContinued
+				}
+				pages = append(pages, p)
+			}
+
+			n := len(packet)
+			if n > 255 {
+				n = 255
+			}
+			p.Lacing = append(p.Lacing, byte(n))
+			p.Data = append(p.Data, packet[:n]...)
+			packet = packet[n:]
+			continued = true
+
+			if n < 255 {
+				break
+			}
+		}
+	}
+
+	for i, p := range pages {
+		p.Seq = uint32(i)
+		if p.Lacing[len(p.Lacing)-1] == 255 {
+			// No packet ends on the page
+			p.Granule = ^uint64(0)
+		}
+	}
+	return pages
+}
+
+// oggCommentPacket returns the packet of Vorbis comments for the codec of
+// the stream, with the cover as a METADATA_BLOCK_PICTURE comment.
+func oggCommentPacket(codec string, comments []string, cover string) ([]byte, error) {
+	if cover != "" {
+		p, err := flacPictureBlock(cover)
+		if err != nil {
+			return nil, err
+		}
+		comments = append(comments, "METADATA_BLOCK_PICTURE="+base64.StdEncoding.EncodeToString(p.Data))
+	}
+
+	var b bytes.Buffer
+	if codec == "opus" {
+		b.WriteString("OpusTags")
+	} else {
+		b.WriteString("\x03vorbis")
+	}
+	b.Write(vorbisCommentBlock(comments).Data)
+	if codec == "vorbis" {
+		// Framing bit
+		b.WriteByte(1)
+	}
+	return b.Bytes(), nil
+}
+
+// writeOggTags replaces the Vorbis comments of an Ogg Vorbis or Opus file.
+// The header pages are laid out again and the audio pages renumbered after
+// them, so the file is rewritten next to the original and renamed into
+// place.
+func writeOggTags(filename string, comments []string, cover string) error {
+	src, err := os.Open(filename)
+	if err != nil {
+		return err
+	}
+	defer src.Close()
+
+	// The identification and comment headers, and Vorbis's setup header,
+	// end on the last header page
+	var packets [][]byte
+	var packet []byte
+	var codec string
+	var serial uint32
+	headers := 0
+	for pages := 0; codec == "" || len(packets) < headers; pages++ {
+		p, err := readOggPage(src)
+		if err != nil {
+			return fmt.Errorf("%v: not an ogg vorbis or opus file", filename)
+		}
+
+		if pages == 0 {
+			serial = p.Serial
+		} else if p.Serial != serial {
+			return fmt.Errorf("%v: ogg files with more than one stream are not supported", filename)
+		}
+
+		data := p.Data
+		for _, l := range p.Lacing {
+			packet = append(packet, data[:l]...)
+			data = data[l:]
+			if l == 255 {
+				continue
+			}
+
+			packets = append(packets, packet)
+			packet = nil
+		}
+
+		if codec == "" && len(packets) > 0 {
+			switch {
+			case bytes.HasPrefix(packets[0], []byte("OpusHead")):
+				codec, headers = "opus", 2
+			case bytes.HasPrefix(packets[0], []byte("\x01vorbis")):
+				codec, headers = "vorbis", 3
+			default:
+				return fmt.Errorf("%v: not an ogg vorbis or opus file", filename)
+			}
+		}
+	}
+
+	if len(packets) != headers || packet != nil {
+		return fmt.Errorf("%v: audio shares a page with the ogg headers", filename)
+	}
+
+	packets[1], err = oggCommentPacket(codec, comments, cover)
+	if err != nil {
+		return err
+	}
+
+	dst, err := os.CreateTemp(filepath.Dir(filename), ".avsplit-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(dst.Name())
+
+	header := oggHeaderPages(serial, packets)
+	for _, p := range header {
+		if _, err := dst.Write(p.bytes()); err != nil {
+			dst.Close()
+			return err
+		}
+	}
+
+	for seq := uint32(len(header)); ; seq++ {
+		p, err := readOggPage(src)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			dst.Close()
+			return fmt.Errorf("%v: invalid ogg page", filename)
+		}
+
+		p.Seq = seq
+		if _, err := dst.Write(p.bytes()); err != nil {
+			dst.Close()
+			return err
+		}
+	}
+
+	if err := dst.Close(); err != nil {
+		return err
+	}
+
+	info, err := src.Stat()
+	if err != nil {
+		return err
+	}
+
+	if err := os.Chmod(dst.Name(), info.Mode()); err != nil {
+		return err
+	}
+
+	return os.Rename(dst.Name(), filename)
+}
@@ -0,0 +1,150 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+// outputLockStale is how long since its holder last touched it that a lock
+// file is taken to be left by a run that was killed, and outputLockPoll how
+// often a run waiting for one looks again.
+const (
+	outputLockStale = 30 * time.Second
+	outputLockPoll  = 250 * time.Millisecond
+)
+
+// outputLocks are the output files the splits of this process are writing,
+// so that two of them running at once, as library callers or the runs of a
+// batch, serve or watch into the same album can, write a file one after the
+// other rather than over each other.
+var outputLocks = struct {
+	mu    sync.Mutex
+	files map[string]*outputLock
+}{files: make(map[string]*outputLock)}
+
+// outputLock is held by whichever split is writing a file, waited for by
+// the others. refs counts both, for the lock to be dropped once none are
+// left.
+type outputLock struct {
+	held chan struct{}
+	refs int
+}
+
+// lockOutput waits until no other split of this process or another avsplit
+// is writing the file p, logging that it does, and returns the func that
+// lets the next one have it. It returns ctx's error if ctx is done first.
+func lockOutput(ctx context.Context, opts Options, p string) (func(), error) {
+	key := p
+	if abs, err := filepath.Abs(p); err == nil {
+		key = abs
+	}
+	key = collisionKey(key)
+
+	outputLocks.mu.Lock()
+	l := outputLocks.files[key]
+	if l == nil {
+		l = &outputLock{held: make(chan struct{}, 1)}
+		outputLocks.files[key] = l
+	}
+	l.refs++
+	outputLocks.mu.Unlock()
+
+	unref := func() {
+		outputLocks.mu.Lock()
+		defer outputLocks.mu.Unlock()
+		if l.refs--; l.refs == 0 {
+			delete(outputLocks.files, key)
+		}
+	}
+
+	select {
+	case l.held <- struct{}{}:
+	default:
+		opts.logf("waiting for another split writing \"%v\"\n", p)
+		select {
+		case l.held <- struct{}{}:
+		case <-ctx.Done():
+			unref()
+			return nil, ctx.Err()
+		}
+	}
+
+	release, err := claimOutput(ctx, opts, p)
+	if err != nil {
+		<-l.held
+		unref()
+		return nil, err
+	}
+
+	return func() {
+		release()
+		<-l.held
+		unref()
+	}, nil
+}
+
+// claimOutput keeps the runs of other processes off the file p with a
+// lock file next to it, created only if it isn't there, waiting while
+// another run holds it. The holder touches it while it writes, so one left
+// by a killed run is taken over once outputLockStale has passed. Where no
+// lock file can be made, as in a read-only directory, only the splits of
+// this process are kept apart.
+func claimOutput(ctx context.Context, opts Options, p string) (func(), error) {
+	lock := filepath.Join(filepath.Dir(p), ".avsplit-"+filepath.Base(p)+".lock")
+	waiting := false
+	for {
+		f, err := os.OpenFile(lock, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0644)
+		if err == nil {
+			fmt.Fprintf(f, "%d\n", os.Getpid())
+			f.Close()
+			break
+		}
+		if !os.IsExist(err) {
+			return func() {}, nil
+		}
+
+		info, err := os.Stat(lock)
+		if os.IsNotExist(err) {
+			// Released since
+			continue
+		}
+		if err == nil && time.Since(info.ModTime()) > outputLockStale {
+			os.Remove(lock)
+			continue
+		}
+
+		if !waiting {
+			opts.logf("waiting for another avsplit writing \"%v\"\n", p)
+			waiting = true
+		}
+		select {
+		case <-time.After(outputLockPoll):
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+
+	done := make(chan struct{})
+	go func() {
+		t := time.NewTicker(outputLockStale / 3)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				now := time.Now()
+				os.Chtimes(lock, now, now)
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		close(done)
+		os.Remove(lock)
+	}, nil
+}
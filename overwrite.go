@@ -0,0 +1,132 @@
+package avsplit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// checkOverwrite applies the Overwrite policy of opts to the output files
+// about to be written, returning those to leave as they are. With "never"
+// any that exists already is an error naming them all, and with "ask"
+// AskOverwrite is asked about each of them. With "always", or without a
+// policy, none are kept here.
+func checkOverwrite(opts Options, files []string) (map[string]bool, error) {
+	switch opts.Overwrite {
+	case "", "always":
+		return nil, nil
+	case "never", "ask":
+	default:
+		return nil, withKind(ErrInvalidInput, fmt.Errorf("unknown overwrite %v, must be never, always or ask", opts.Overwrite))
+	}
+
+	var existing []string
+	for _, f := range files {
+		if f == "" || f == "-" {
+			continue
+		}
+		if _, err := os.Stat(f); err == nil {
+			existing = append(existing, f)
+		}
+	}
+	if len(existing) == 0 {
+		return nil, nil
+	}
+
+	if opts.Overwrite == "never" || (opts.AskOverwrite == nil && !opts.DryRun) {
+		how := "with overwrite never"
+		if opts.Overwrite == "ask" {
+			how = "and there is no one to ask about overwriting them"
+		}
+		return nil, withKind(ErrInvalidInput, fmt.Errorf("%d output files already exist %v:\n%v", len(existing), how, strings.Join(existing, "\n")))
+	}
+
+	kept := make(map[string]bool)
+	for _, f := range existing {
+		if opts.DryRun {
+			opts.logf("would ask to overwrite %v\n", f)
+			continue
+		}
+
+		ok, err := opts.AskOverwrite(f)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			opts.logf("keeping %v\n", f)
+			kept[f] = true
+		}
+	}
+	return kept, nil
+}
+
+// overwriteFlag returns the ffmpeg flag for an output file checkOverwrite
+// has let through, "-n" with "never" so that ffmpeg refuses one that
+// appeared since as well.
+func (o Options) overwriteFlag() string {
+	if o.Overwrite == "never" {
+		return "-n"
+	}
+	return "-y"
+}
+
+// rename moves the tagged track from part to its output file out. With
+// "never" it refuses to replace an out that appeared since checkOverwrite,
+// by hard linking, which fails rather than replacing it, and only checking
+// just before the rename on a file system without hard links.
+func (o Options) rename(part, out string) error {
+	if o.Overwrite != "never" {
+		return os.Rename(part, out)
+	}
+
+	err := os.Link(part, out)
+	if err == nil {
+		return os.Remove(part)
+	}
+	if _, serr := os.Lstat(out); serr == nil || os.IsExist(err) {
+		return fmt.Errorf("%v already exists, written since it was checked (see -overwrite)", out)
+	}
+	return os.Rename(part, out)
+}
+
+// overwrites reports whether existing tracks are written over rather than
+// kept when they check out, as by an earlier run that stopped part way.
+func (o Options) overwrites() bool {
+	return o.Force || o.Overwrite == "always" || o.Overwrite == "ask"
+}
+
+// PromptOverwrite returns an Options.AskOverwrite that asks on w whether to
+// overwrite each file, reading the answers from r: y to overwrite it, n or
+// nothing to keep it, a to overwrite it and every file after it, and q to
+// stop.
+func PromptOverwrite(r io.Reader, w io.Writer) func(file string) (bool, error) {
+	s := bufio.NewScanner(r)
+	all := false
+	return func(file string) (bool, error) {
+		for !all {
+			fmt.Fprintf(w, "%v exists, overwrite it? [y/N/a/q] ", file)
+			if !s.Scan() {
+				if err := s.Err(); err != nil {
+					return false, err
+				}
+				return false, fmt.Errorf("overwrite aborted")
+			}
+
+			switch strings.ToLower(strings.TrimSpace(s.Text())) {
+			case "y", "yes":
+				return true, nil
+			case "", "n", "no":
+				return false, nil
+			case "a", "all":
+				all = true
+			case "q", "quit":
+				return false, fmt.Errorf("overwrite aborted")
+			default:
+				fmt.Fprintln(w, "answer y, n, a or q")
+			}
+		}
+		return true, nil
+	}
+}
@@ -0,0 +1,163 @@
+package avsplit
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+)
+
+var update = flag.Bool("update", false, "rewrite the golden files of TestParsers with what the parsers return")
+
+// parsers are the tracklist formats TestParsers reads, by the directory of
+// testdata/parsers their fixtures are in. A new format gets an entry here
+// and a directory of fixtures.
+var parsers = map[string]func(r io.Reader) ([]Track, error){
+	"native": func(r io.Reader) ([]Track, error) {
+		return ParseTimecodes(r, Options{})
+	},
+	"youtube": func(r io.Reader) ([]Track, error) {
+		return ParseTimecodes(r, Options{YouTube: true})
+	},
+	"cue": func(r io.Reader) ([]Track, error) {
+		return parseCue(r, Options{}.withDefaults())
+	},
+	"chapters": func(r io.Reader) ([]Track, error) {
+		// As ffprobe -show_chapters prints them
+		var result struct {
+			Chapters []probedChapter `json:"chapters"`
+		}
+		if err := json.NewDecoder(r).Decode(&result); err != nil {
+			return nil, err
+		}
+		return chaptersToTracks(result.Chapters, Options{})
+	},
+	"audacity": func(r io.Reader) ([]Track, error) {
+		return parseAudacityLabels(r, Options{})
+	},
+	"timeline": func(r io.Reader) ([]Track, error) {
+		data, err := io.ReadAll(r)
+		if err != nil {
+			return nil, err
+		}
+		return parseTimeline(data, Options{Album: "Radio Show"})
+	},
+}
+
+// TestParsers parses each fixture of testdata/parsers/FORMAT and compares
+// the tracks, and what validating them reports, with the .golden file of
+// the same name. go test -run TestParsers -update writes the golden files
+// of new fixtures, and rewrites the others, to be checked in the diff.
+func TestParsers(t *testing.T) {
+	formats := make([]string, 0, len(parsers))
+	for f := range parsers {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+
+	for _, format := range formats {
+		parse := parsers[format]
+		fixtures, err := filepath.Glob(filepath.Join("testdata", "parsers", format, "*"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		found := false
+		for _, fixture := range fixtures {
+			if filepath.Ext(fixture) == ".golden" {
+				continue
+			}
+			found = true
+
+			name := strings.TrimSuffix(filepath.Base(fixture), filepath.Ext(fixture))
+			golden := strings.TrimSuffix(fixture, filepath.Ext(fixture)) + ".golden"
+			t.Run(format+"/"+name, func(t *testing.T) {
+				f, err := os.Open(fixture)
+				if err != nil {
+					t.Fatal(err)
+				}
+				defer f.Close()
+
+				tracks, err := parse(f)
+				got := describeParsed(tracks, err)
+
+				if *update {
+					if err := os.WriteFile(golden, []byte(got), 0644); err != nil {
+						t.Fatal(err)
+					}
+					return
+				}
+
+				want, err := os.ReadFile(golden)
+				if err != nil {
+					t.Fatalf("%v, run go test -run TestParsers -update to write it", err)
+				}
+				if got != string(want) {
+					t.Errorf("%v parsed as\n%v\nwant\n%v", fixture, got, string(want))
+				}
+			})
+		}
+
+		if !found {
+			t.Errorf("no fixtures for the %v parser", format)
+		}
+	}
+}
+
+// describeParsed writes the tracks a parser returned one per line, with the
+// fields it set, followed by what validateTracks makes of them, or the
+// error the parser returned.
+func describeParsed(tracks []Track, err error) string {
+	if err != nil {
+		return fmt.Sprintf("error: %v\n", err)
+	}
+
+	var b strings.Builder
+	for _, t := range tracks {
+		end := t.End
+		if end == "" {
+			end = "EOF"
+		}
+		fmt.Fprintf(&b, "%d/%d %v-%v %q", t.Number, t.Total, t.Start, end, t.Title)
+
+		for _, f := range []struct {
+			name, value string
+		}{
+			{"artist", t.Artist},
+			{"album_artist", t.AlbumArtist},
+			{"album", t.Album},
+			{"year", t.Year},
+			{"genre", t.Genre},
+			{"composer", t.Composer},
+			{"work", t.Work},
+		} {
+			if f.value != "" {
+				fmt.Fprintf(&b, " %v=%q", f.name, f.value)
+			}
+		}
+		if t.Disc != 0 {
+			fmt.Fprintf(&b, " disc=%d/%d", t.Disc, t.DiscTotal)
+		}
+		if t.Movement != 0 {
+			fmt.Fprintf(&b, " movement=%d/%d", t.Movement, t.Movements)
+		}
+		if t.Line != 0 {
+			fmt.Fprintf(&b, " line=%d", t.Line)
+		}
+		b.WriteString("\n")
+	}
+
+	warnings, err := validateTracks(tracks, 0)
+	for _, w := range warnings {
+		fmt.Fprintf(&b, "warning: %v\n", w)
+	}
+	if err != nil {
+		fmt.Fprintf(&b, "invalid: %v\n", err)
+	}
+	return b.String()
+}
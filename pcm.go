@@ -0,0 +1,92 @@
+package avsplit
+
+import (
+	"context"
+	"strings"
+)
+
+// pcmExts lists the uncompressed output formats, which ffmpeg would write
+// as 16 bit whatever the source's bit depth is.
+var pcmExts = map[string]string{
+	".wav":  "le",
+	".aif":  "be",
+	".aiff": "be",
+}
+
+// pcmCodec returns the PCM encoder for ext that keeps a source's sample
+// format, as ffprobe reports it with the bits per sample it really holds.
+// Float samples, decoded from a lossy source, are written as 24 bit.
+func pcmCodec(ext, sampleFmt, bits string) string {
+	depth := "s24"
+	switch strings.TrimSuffix(sampleFmt, "p") {
+	case "u8", "s16":
+		depth = "s16"
+	case "s32", "s64":
+		if bits != "24" {
+			depth = "s32"
+		}
+	}
+	return "pcm_" + depth + pcmExts[ext]
+}
+
+// probeSampleFormat returns the sample format and bits per raw sample of
+// the source's audio, the latter "N/A" or "0" when the format tells.
+func probeSampleFormat(ctx context.Context, audioFile string) (string, string, error) {
+	out, err := commandOutput(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-select_streams", probeStream(ctx),
+		"-show_entries", "stream=sample_fmt,bits_per_raw_sample",
+		"-of", "default=noprint_wrappers=1",
+		argPath(audioFile),
+	)
+	if err != nil {
+		return "", "", err
+	}
+
+	var sampleFmt, bits string
+	for _, line := range strings.Split(out, "\n") {
+		k, v := line, ""
+		if i := strings.Index(line, "="); i >= 0 {
+			k, v = line[:i], strings.TrimSpace(line[i+1:])
+		}
+
+		switch k {
+		case "sample_fmt":
+			sampleFmt = v
+		case "bits_per_raw_sample":
+			bits = v
+		}
+	}
+	return sampleFmt, bits, nil
+}
+
+// preparePCM gives the tracks re-encoded to WAV or AIFF without a codec of
+// their own the PCM encoder keeping the source's bit depth. ffmpeg keeps its
+// sample rate already.
+func (s *Splitter) preparePCM(ctx context.Context, tracks Tracklist) error {
+	probed := false
+	var sampleFmt, bits string
+	for i := range tracks {
+		t := &tracks[i]
+		if _, ok := pcmExts[t.Ext]; !ok || !t.Reencode || t.Codec != "" {
+			continue
+		}
+
+		if !probed {
+			var err error
+			sampleFmt, bits, err = probeSampleFormat(ctx, s.opts.Filename)
+			if err != nil {
+				return err
+			}
+			probed = true
+		}
+		t.Codec = pcmCodec(t.Ext, sampleFmt, bits)
+	}
+
+	if _, ok := pcmExts[tracks[0].Ext]; ok && s.opts.Output == "-" {
+		s.opts.logf("warning: %v written to a pipe has no length in its header, some programs will not read it\n", tracks[0].Ext)
+	}
+	return nil
+}
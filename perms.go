@@ -0,0 +1,121 @@
+package avsplit
+
+import (
+	"fmt"
+	"os"
+	"os/user"
+	"path/filepath"
+	"strconv"
+)
+
+// defaultDirMode is the mode of the directories a run creates without a
+// DirMode, less the umask.
+const defaultDirMode = 0755
+
+// dirMode returns the mode to create output directories with.
+func (o Options) dirMode() os.FileMode {
+	if o.DirMode != 0 {
+		return o.DirMode
+	}
+	return defaultDirMode
+}
+
+// ownerIDs returns the user and group IDs Owner and Group name, by name or
+// number, -1 for either that isn't set.
+func (o Options) ownerIDs() (int, int, error) {
+	uid, gid := -1, -1
+	if o.Owner != "" {
+		id := o.Owner
+		if _, err := strconv.Atoi(id); err != nil {
+			u, err := user.Lookup(o.Owner)
+			if err != nil {
+				return 0, 0, withKind(ErrInvalidInput, fmt.Errorf("unknown owner %v", o.Owner))
+			}
+			id = u.Uid
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return 0, 0, withKind(ErrInvalidInput, fmt.Errorf("owner %v has no numeric ID, owners aren't supported here", o.Owner))
+		}
+		uid = n
+	}
+
+	if o.Group != "" {
+		id := o.Group
+		if _, err := strconv.Atoi(id); err != nil {
+			g, err := user.LookupGroup(o.Group)
+			if err != nil {
+				return 0, 0, withKind(ErrInvalidInput, fmt.Errorf("unknown group %v", o.Group))
+			}
+			id = g.Gid
+		}
+		n, err := strconv.Atoi(id)
+		if err != nil {
+			return 0, 0, withKind(ErrInvalidInput, fmt.Errorf("group %v has no numeric ID, groups aren't supported here", o.Group))
+		}
+		gid = n
+	}
+	return uid, gid, nil
+}
+
+// setPerms gives a file or directory the run created mode, unless it is 0,
+// and the Owner and Group. Set explicitly, so the umask doesn't take any
+// permissions away from those asked for.
+func (o Options) setPerms(path string, mode os.FileMode) error {
+	if mode != 0 {
+		if err := os.Chmod(path, mode); err != nil {
+			return err
+		}
+	}
+
+	if o.Owner == "" && o.Group == "" {
+		return nil
+	}
+	uid, gid, err := o.ownerIDs()
+	if err != nil {
+		return err
+	}
+	return os.Chown(path, uid, gid)
+}
+
+// setFilePerms gives an output file the FileMode and owner of the run.
+func (o Options) setFilePerms(file string) error {
+	return o.setPerms(file, o.FileMode)
+}
+
+// mkdirAll creates dir and those of its parents that don't exist yet, each
+// with the DirMode and owner of the run. Those that exist are left as they
+// are.
+func (o Options) mkdirAll(dir string) error {
+	var missing []string
+	for d := dir; ; d = filepath.Dir(d) {
+		if _, err := os.Stat(d); err == nil {
+			break
+		}
+		missing = append(missing, d)
+		if filepath.Dir(d) == d {
+			break
+		}
+	}
+
+	if err := os.MkdirAll(dir, o.dirMode()); err != nil {
+		return err
+	}
+
+	// Parents first, so a group can reach into them as they are made
+	for i := len(missing) - 1; i >= 0; i-- {
+		if err := o.setPerms(missing[i], o.DirMode); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// writeFile writes an output file, such as a sidecar or a receipt, with
+// the FileMode and owner of the run.
+func (o Options) writeFile(file string, data []byte) error {
+	if err := os.WriteFile(file, data, 0644); err != nil {
+		return err
+	}
+	return o.setFilePerms(file)
+}
@@ -0,0 +1,307 @@
+package avsplit
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"text/tabwriter"
+	"time"
+)
+
+// printPlan writes the track table and the commands a run would execute.
+func printPlan(w io.Writer, opts Options, tracks []Track) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tSTART\tEND\tLENGTH\tTITLE\tOUTPUT")
+	for _, t := range tracks {
+		end := t.End
+		if end == "" {
+			end = "EOF"
+		}
+		length := "?"
+		if t.Length > 0 {
+			length = formatTimecode(t.Length)
+		}
+		fmt.Fprintf(tw, "%d\t%v\t%v\t%v\t%v\t%v\n", t.Number, t.Start, end, length, t.Title, t.outputFilename(opts.Filename))
+	}
+
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	fmt.Fprintln(w)
+	for _, t := range tracks {
+		for _, c := range trackCommands(opts, t) {
+			fmt.Fprintln(w, c)
+		}
+	}
+
+	if opts.PostAlbumCmd != "" && len(tracks) > 0 {
+		fmt.Fprintln(w, shellCommand("env", hookArgs(opts.PostAlbumCmd, albumHookEnv(opts, tracks))...))
+	}
+
+	return nil
+}
+
+// trackCommands returns the command lines a run executes for the track, a
+// comment standing in for the native tagger.
+func trackCommands(opts Options, t Track) []string {
+	c, args := opts.throttle(opts.FFmpegPath, t.ffmpegArgs(opts.Filename, opts.overwriteFlag()))
+	lines := []string{shellCommand(c, args...)}
+
+	if tg, ok := taggers[opts.Tagger]; ok && tg.Supports(t.ext(opts.Filename)) {
+		// The ffmpeg tagger is part of the ffmpeg command
+		switch opts.Tagger {
+		case "eyed3":
+			lines = append(lines, shellCommand("eyed3", t.eyeD3Args(t.outputFilename(opts.Filename))...))
+		case "native":
+			lines = append(lines, fmt.Sprintf("# write tags to %v", shellQuote(t.outputFilename(opts.Filename))))
+		}
+	}
+
+	if opts.PostCmd != "" {
+		lines = append(lines, shellCommand("env", hookArgs(opts.PostCmd, t.trackHookEnv(opts))...))
+	}
+	return lines
+}
+
+// planVersion is the version of the plan files written by writePlan.
+const planVersion = 1
+
+// planFile is a split plan saved as JSON, to be reviewed or edited and split
+// later or elsewhere with Options.Plan.
+type planFile struct {
+	Version   int         `json:"version"`
+	Sources   []string    `json:"sources"`
+	Tagger    string      `json:"tagger"`
+	Normalize string      `json:"normalize,omitempty"`
+	Tracks    []planTrack `json:"tracks"`
+}
+
+// planTrack is a track of a plan file, with its output file and encoder
+// settings settled.
+type planTrack struct {
+	Number        int    `json:"number"`
+	Total         int    `json:"total"`
+	Disc          int    `json:"disc,omitempty"`
+	DiscTotal     int    `json:"disc_total,omitempty"`
+	Start         string `json:"start"`
+	End           string `json:"end,omitempty"`
+	Length        string `json:"length,omitempty"`
+	Title         string `json:"title"`
+	Artist        string `json:"artist,omitempty"`
+	AlbumArtist   string `json:"album_artist,omitempty"`
+	Album         string `json:"album,omitempty"`
+	Composer      string `json:"composer,omitempty"`
+	Work          string `json:"work,omitempty"`
+	Movement      int    `json:"movement,omitempty"`
+	Movements     int    `json:"movements,omitempty"`
+	Year          string `json:"year,omitempty"`
+	Genre         string `json:"genre,omitempty"`
+	Comment       string `json:"comment,omitempty"`
+	Label         string `json:"label,omitempty"`
+	CatalogNumber string `json:"catalog_number,omitempty"`
+	ISRC          string `json:"isrc,omitempty"`
+	Output        string `json:"output"`
+	Reencode      bool   `json:"reencode,omitempty"`
+	Video         bool   `json:"video,omitempty"`
+	Codec         string `json:"codec,omitempty"`
+	Bitrate       string `json:"bitrate,omitempty"`
+	Quality       string `json:"quality,omitempty"`
+	Filter        string `json:"filter,omitempty"`
+	Speed         string `json:"speed,omitempty"`
+	StartSample   int64  `json:"start_sample,omitempty"`
+	EndSample     int64  `json:"end_sample,omitempty"`
+	SampleRate    int    `json:"sample_rate,omitempty"`
+	FadeIn        string `json:"fade_in,omitempty"`
+	FadeOut       string `json:"fade_out,omitempty"`
+	Cover         string `json:"cover,omitempty"`
+	Lyrics        string `json:"lyrics,omitempty"`
+	SyncedLyrics  string `json:"synced_lyrics,omitempty"`
+
+	InputArgs []string `json:"input_args,omitempty"`
+	ExtraArgs []string `json:"extra_args,omitempty"`
+	Stream    string   `json:"stream,omitempty"`
+	Bitexact  bool     `json:"bitexact,omitempty"`
+}
+
+// writePlan saves the prepared tracks of the sources as a plan file.
+func writePlan(path string, opts Options, sources []string, tracks Tracklist) error {
+	return newPlan(opts, sources, tracks).write(path)
+}
+
+// newPlan returns the plan of the prepared tracks of the sources.
+func newPlan(opts Options, sources []string, tracks Tracklist) *planFile {
+	p := &planFile{
+		Version:   planVersion,
+		Sources:   sources,
+		Tagger:    opts.Tagger,
+		Normalize: opts.Normalize,
+	}
+
+	durationString := func(d time.Duration) string {
+		if d == 0 {
+			return ""
+		}
+		return d.String()
+	}
+
+	for _, t := range tracks {
+		p.Tracks = append(p.Tracks, planTrack{
+			Number:        t.Number,
+			Total:         t.Total,
+			Disc:          t.Disc,
+			DiscTotal:     t.DiscTotal,
+			Start:         t.Start,
+			End:           t.End,
+			Length:        durationString(t.Length),
+			Title:         t.Title,
+			Artist:        t.Artist,
+			AlbumArtist:   t.AlbumArtist,
+			Album:         t.Album,
+			Composer:      t.Composer,
+			Work:          t.Work,
+			Movement:      t.Movement,
+			Movements:     t.Movements,
+			Year:          t.Year,
+			Genre:         t.Genre,
+			Comment:       t.Comment,
+			Label:         t.Label,
+			CatalogNumber: t.CatalogNumber,
+			ISRC:          t.ISRC,
+			Output:        t.outputFilename(opts.Filename),
+			Reencode:      t.Reencode,
+			Video:         t.Video,
+			Codec:         t.Codec,
+			Bitrate:       t.Bitrate,
+			Quality:       t.Quality,
+			Filter:        t.Filter,
+			Speed:         t.Speed,
+			StartSample:   t.StartSample,
+			EndSample:     t.EndSample,
+			SampleRate:    t.SampleRate,
+			FadeIn:        durationString(t.FadeIn),
+			FadeOut:       durationString(t.FadeOut),
+			Cover:         t.Cover,
+			Lyrics:        t.Lyrics,
+			SyncedLyrics:  t.SyncedLyrics,
+			InputArgs:     t.InputArgs,
+			ExtraArgs:     t.ExtraArgs,
+			Stream:        t.Stream,
+			Bitexact:      t.Bitexact,
+		})
+	}
+	return p
+}
+
+// write saves the plan as path.
+func (p *planFile) write(path string) error {
+	b, err := json.MarshalIndent(p, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.WriteFile(path, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("cannot write plan: %v", err)
+	}
+	return nil
+}
+
+// readPlan reads a plan file written by writePlan, possibly edited since.
+func readPlan(path string) (*planFile, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read plan: %v", err)
+	}
+
+	var p planFile
+	if err := json.Unmarshal(b, &p); err != nil {
+		return nil, fmt.Errorf("invalid plan %v: %v", path, err)
+	}
+
+	if p.Version != planVersion {
+		return nil, fmt.Errorf("plan %v is version %d, this avsplit reads version %d", path, p.Version, planVersion)
+	}
+
+	if len(p.Tracks) == 0 {
+		return nil, fmt.Errorf("plan %v has no tracks", path)
+	}
+	return &p, nil
+}
+
+// tracklist returns the tracks of the plan, ready to split without
+// preparing them again.
+func (p *planFile) tracklist() (Tracklist, error) {
+	var tracks Tracklist
+	for _, pt := range p.Tracks {
+		if pt.Output == "" {
+			return nil, fmt.Errorf("track %d of the plan has no output", pt.Number)
+		}
+
+		t := Track{
+			Number:        pt.Number,
+			Total:         pt.Total,
+			Disc:          pt.Disc,
+			DiscTotal:     pt.DiscTotal,
+			Start:         pt.Start,
+			End:           pt.End,
+			Title:         pt.Title,
+			Artist:        pt.Artist,
+			AlbumArtist:   pt.AlbumArtist,
+			Album:         pt.Album,
+			Composer:      pt.Composer,
+			Work:          pt.Work,
+			Movement:      pt.Movement,
+			Movements:     pt.Movements,
+			Year:          pt.Year,
+			Genre:         pt.Genre,
+			Comment:       pt.Comment,
+			Label:         pt.Label,
+			CatalogNumber: pt.CatalogNumber,
+			ISRC:          pt.ISRC,
+			Ext:           filepath.Ext(pt.Output),
+			Output:        pt.Output,
+			Reencode:      pt.Reencode,
+			Video:         pt.Video,
+			Codec:         pt.Codec,
+			Bitrate:       pt.Bitrate,
+			Quality:       pt.Quality,
+			Filter:        pt.Filter,
+			Speed:         pt.Speed,
+			StartSample:   pt.StartSample,
+			EndSample:     pt.EndSample,
+			SampleRate:    pt.SampleRate,
+			Cover:         pt.Cover,
+			Metadata:      true,
+			Lyrics:        pt.Lyrics,
+			SyncedLyrics:  pt.SyncedLyrics,
+			InputArgs:     pt.InputArgs,
+			ExtraArgs:     pt.ExtraArgs,
+			Stream:        pt.Stream,
+			Bitexact:      pt.Bitexact,
+		}
+
+		var err error
+		for _, f := range []struct {
+			s string
+			d *time.Duration
+		}{{pt.FadeIn, &t.FadeIn}, {pt.FadeOut, &t.FadeOut}} {
+			if f.s == "" {
+				continue
+			}
+			if *f.d, err = time.ParseDuration(f.s); err != nil || *f.d < 0 {
+				return nil, fmt.Errorf("invalid fade %v of track %d of the plan", f.s, pt.Number)
+			}
+		}
+
+		if pt.Length != "" {
+			if t.Length, err = time.ParseDuration(pt.Length); err != nil || t.Length < 0 {
+				return nil, fmt.Errorf("invalid length %v of track %d of the plan", pt.Length, pt.Number)
+			}
+		}
+
+		tracks = append(tracks, t)
+	}
+	return tracks, nil
+}
@@ -0,0 +1,109 @@
+package avsplit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// preflightFrames is how many frames of audio preflightSource decodes at
+// each place it looks, enough to get past a stray bad frame.
+const preflightFrames = 8
+
+// preflightTail is how far before the end the source is expected to still
+// decode, short of the last frames a clean encode may leave off.
+const preflightTail = 5 * time.Second
+
+// preflightSource checks the source can be split before a track is read
+// from it: that ffprobe reads it, that it has an audio stream and that the
+// audio decodes both at the start and near the end the header gives it.
+// Without it a video-only file, a DRM protected purchase or a download
+// that stopped part way fails once for every track, with whatever ffmpeg
+// makes of it.
+func preflightSource(ctx context.Context, opts Options) error {
+	file := opts.Filename
+	out, err := commandOutput(ctx, "ffprobe", "-v", "error", "-show_entries", "stream=codec_type", "-of", "default=noprint_wrappers=1:nokey=1", argPath(file))
+	if err != nil {
+		return withKind(ErrInvalidInput, fmt.Errorf("cannot read %v: %v", file, err))
+	}
+
+	var kinds []string
+	audio := false
+	for _, line := range strings.Split(out, "\n") {
+		if kind := strings.TrimSpace(line); kind != "" {
+			kinds = append(kinds, kind)
+			audio = audio || kind == "audio"
+		}
+	}
+	if !audio {
+		if len(kinds) == 0 {
+			return withKind(ErrInvalidInput, fmt.Errorf("%v has no streams, it may be damaged or not a media file", file))
+		}
+		return withKind(ErrInvalidInput, fmt.Errorf("%v has no audio stream, only %v", file, strings.Join(kinds, " and ")))
+	}
+
+	frames, stderr, err := probeFrames(ctx, file, "")
+	if err != nil {
+		return withKind(ErrInvalidInput, fmt.Errorf("cannot decode the audio of %v: %v", file, err))
+	}
+	if frames == 0 {
+		reason := "no audio frames decode"
+		if stderr != "" {
+			reason = toolError("ffprobe", stderr).Error()
+		}
+		return withKind(ErrInvalidInput, fmt.Errorf("cannot decode the audio of %v, it may be DRM protected or damaged: %v", file, reason))
+	}
+
+	total, err := probeDuration(ctx, file)
+	if err != nil || total <= 2*preflightTail {
+		// Nothing to compare the end with
+		return nil
+	}
+	at := total - preflightTail
+	frames, _, err = probeFrames(ctx, file, fmt.Sprintf("%.3f", at.Seconds()))
+	if err != nil {
+		return withKind(ErrInvalidInput, fmt.Errorf("cannot decode the end of %v: %v", file, err))
+	}
+	if frames == 0 {
+		return withKind(ErrInvalidInput, fmt.Errorf("%v is truncated, its header gives it %v but no audio decodes past %v, download or copy it again", file, formatTimecode(total), formatTimecode(at)))
+	}
+	return nil
+}
+
+// probeFrames has ffprobe decode preflightFrames frames of the selected
+// audio stream from seconds into file, or from the start when it is "",
+// and returns how many it got and what ffprobe wrote to stderr, which
+// lists the frames that failed to decode.
+func probeFrames(ctx context.Context, file, seconds string) (int, string, error) {
+	args := []string{
+		"-v", "error",
+		"-select_streams", probeStream(ctx),
+		"-show_entries", "frame=pts_time",
+		"-of", "csv=p=0",
+		"-read_intervals", fmt.Sprintf("%v%%+#%d", seconds, preflightFrames),
+		argPath(file),
+	}
+
+	var stdout, stderr bytes.Buffer
+	err := runCommand(ctx, "ffprobe", args, &stdout, &stderr)
+	debugStderr(ctx, "ffprobe", stderr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return 0, "", ctx.Err()
+		}
+		if stderr.Len() == 0 {
+			return 0, "", err
+		}
+		return 0, "", toolError("ffprobe", stderr.String())
+	}
+
+	frames := 0
+	for _, line := range strings.Split(stdout.String(), "\n") {
+		if strings.TrimSpace(line) != "" {
+			frames++
+		}
+	}
+	return frames, strings.TrimSpace(stderr.String()), nil
+}
@@ -0,0 +1,143 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// splitPoint is a boundary of the tracks to preview, with what it is.
+type splitPoint struct {
+	at    time.Duration
+	tc    string
+	about string
+}
+
+// splitPoints returns the boundaries of the tracks numbered in selected, or
+// of all the tracks when it is nil. The end of a track and the start of
+// the next one are the same point.
+func splitPoints(tracks Tracklist, selected trackSelection) ([]splitPoint, error) {
+	var points []splitPoint
+	seen := make(map[string]bool)
+	add := func(tc, about string) error {
+		if seen[tc] {
+			return nil
+		}
+		seen[tc] = true
+
+		at, err := parseDuration(tc)
+		if err != nil {
+			return err
+		}
+		points = append(points, splitPoint{at, tc, about})
+		return nil
+	}
+
+	for i, t := range tracks {
+		if selected != nil && !selected.has(t.Number) {
+			continue
+		}
+
+		if i > 0 && tracks[i-1].End == t.Start {
+			prev := tracks[i-1]
+			if err := add(t.Start, fmt.Sprintf("end of track %d \"%v\", start of track %d \"%v\"", prev.Number, prev.Title, t.Number, t.Title)); err != nil {
+				return nil, err
+			}
+		} else if i > 0 || t.Start != formatTimecode(0) {
+			if err := add(t.Start, fmt.Sprintf("start of track %d \"%v\"", t.Number, t.Title)); err != nil {
+				return nil, err
+			}
+		}
+
+		if t.End == "" {
+			continue
+		}
+
+		if i < len(tracks)-1 && tracks[i+1].Start == t.End {
+			next := tracks[i+1]
+			if err := add(t.End, fmt.Sprintf("end of track %d \"%v\", start of track %d \"%v\"", t.Number, t.Title, next.Number, next.Title)); err != nil {
+				return nil, err
+			}
+		} else if err := add(t.End, fmt.Sprintf("end of track %d \"%v\"", t.Number, t.Title)); err != nil {
+			return nil, err
+		}
+	}
+	return points, nil
+}
+
+// Preview plays the audio around each split point with ffplay, the
+// opts.PreviewLength before and after it, to check the tracklist by ear
+// before splitting. opts.Tracks chooses the tracks whose start and end are
+// played, all of them by default. With opts.DryRun the ffplay commands are
+// printed instead.
+func Preview(ctx context.Context, opts Options) error {
+	opts = opts.withDefaults()
+	ctx = opts.withOptions(ctx)
+
+	if _, err := exec.LookPath("ffplay"); err != nil && !opts.DryRun {
+		return withKind(ErrMissingTool, fmt.Errorf("ffplay not found, install it with ffmpeg to preview"))
+	}
+
+	tracks, err := ReadTracklist(ctx, opts)
+	if err != nil {
+		return withKind(ErrParse, err)
+	}
+
+	if opts.Offset != 0 {
+		if err := shiftTracks(tracks, opts.Offset); err != nil {
+			return err
+		}
+	}
+
+	if opts.FixOverlaps {
+		if tracks, _, err = fixOverlaps(tracks, opts.MergeWithin); err != nil {
+			return withKind(ErrInvalidInput, err)
+		}
+	}
+
+	var selected trackSelection
+	if opts.Tracks != "" {
+		if selected, err = parseTrackSelection(opts.Tracks); err != nil {
+			return withKind(ErrInvalidInput, err)
+		}
+	}
+
+	points, err := splitPoints(tracks, selected)
+	if err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+	if len(points) == 0 {
+		return withKind(ErrInvalidInput, fmt.Errorf("no split points to preview"))
+	}
+
+	length := opts.PreviewLength
+	if length <= 0 {
+		length = 5 * time.Second
+	}
+
+	for i, p := range points {
+		from := p.at - length
+		if from < 0 {
+			from = 0
+		}
+
+		args := []string{
+			"-nodisp", "-autoexit", "-loglevel", "error",
+			"-ss", formatTimecode(from),
+			"-t", fmt.Sprintf("%.3f", (p.at + length - from).Seconds()),
+			argPath(opts.Filename),
+		}
+
+		opts.logf("%v (%d of %d): %v\n", p.tc, i+1, len(points), p.about)
+		if opts.DryRun {
+			opts.logf("%v\n", shellCommand("ffplay", args...))
+			continue
+		}
+
+		if err := execCommand(ctx, "ffplay", args...); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,173 @@
+package avsplit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+func probeDuration(ctx context.Context, audioFile string) (time.Duration, error) {
+	out, err := commandOutput(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-show_entries", "format=duration",
+		"-of", "default=noprint_wrappers=1:nokey=1",
+		argPath(audioFile),
+	)
+	if err != nil {
+		return 0, err
+	}
+
+	d, err := parseSeconds(out)
+	if err != nil {
+		return 0, fmt.Errorf("cannot determine duration of %v", audioFile)
+	}
+
+	return d, nil
+}
+
+type probedChapter struct {
+	StartTime string `json:"start_time"`
+	EndTime   string `json:"end_time"`
+	Tags      struct {
+		Title string `json:"title"`
+	} `json:"tags"`
+}
+
+func probeChapters(ctx context.Context, audioFile string) ([]probedChapter, error) {
+	out, err := commandOutput(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-print_format", "json",
+		"-show_chapters",
+		argPath(audioFile),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Chapters []probedChapter `json:"chapters"`
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		return nil, fmt.Errorf("cannot read chapters of %v", audioFile)
+	}
+
+	return result.Chapters, nil
+}
+
+// probeSeconds is a time in seconds as ffprobe prints it, which may be
+// negative for a stream starting before 0.
+var probeSeconds = regexp.MustCompile(`^-?\d+(\.\d+)?$`)
+
+// parseSeconds parses a time in seconds printed by ffprobe or exported by
+// Audacity.
+func parseSeconds(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if !probeSeconds.MatchString(s) {
+		return 0, fmt.Errorf("invalid seconds %q", s)
+	}
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || math.Abs(secs) >= math.MaxInt64/float64(time.Second) {
+		return 0, fmt.Errorf("invalid seconds %q", s)
+	}
+	return time.Duration(secs * float64(time.Second)), nil
+}
+
+// chapterTracks builds the tracks from the chapters embedded in the audio
+// file.
+func chapterTracks(ctx context.Context, opts Options) ([]Track, error) {
+	chapters, err := probeChapters(ctx, opts.Filename)
+	if err != nil {
+		return nil, err
+	}
+	return chaptersToTracks(chapters, opts)
+}
+
+func chaptersToTracks(chapters []probedChapter, opts Options) ([]Track, error) {
+	if len(chapters) == 0 {
+		return nil, fmt.Errorf("no chapters found")
+	}
+
+	tracks := make([]Track, len(chapters))
+	for i, c := range chapters {
+		start, err := parseSeconds(c.StartTime)
+		if err != nil {
+			return nil, fmt.Errorf("invalid start of chapter %d", i+1)
+		}
+
+		tracks[i] = Track{
+			Number:      i + 1,
+			Total:       len(chapters),
+			Title:       strings.TrimSpace(c.Tags.Title),
+			Start:       formatTimecode(start),
+			Artist:      opts.Artist,
+			AlbumArtist: opts.Artist,
+			Album:       opts.Album,
+		}
+
+		// The last chapter is read to the end of the file
+		if i < len(chapters)-1 {
+			end, err := parseSeconds(c.EndTime)
+			if err != nil {
+				return nil, fmt.Errorf("invalid end of chapter %d", i+1)
+			}
+			tracks[i].End = formatTimecode(end)
+		}
+	}
+
+	return tracks, nil
+}
+
+// SourceInfo describes an audio file as ffprobe sees it.
+type SourceInfo struct {
+	Duration   time.Duration
+	Codec      string
+	SampleRate string
+	Chapters   Tracklist
+}
+
+// Probe returns what ffprobe finds out about opts.Filename.
+func Probe(ctx context.Context, opts Options) (SourceInfo, error) {
+	opts = opts.withDefaults()
+	ctx = opts.withOptions(ctx)
+
+	if err := checkTools(ctx, opts); err != nil {
+		return SourceInfo{}, err
+	}
+
+	var info SourceInfo
+	var err error
+	info.Duration, err = probeDuration(ctx, opts.Filename)
+	if err != nil {
+		return SourceInfo{}, err
+	}
+
+	info.Codec, err = probeAudioCodec(ctx, opts.Filename)
+	if err != nil {
+		return SourceInfo{}, err
+	}
+
+	// Not every codec reports a sample rate
+	info.SampleRate, _ = probeSampleRate(ctx, opts.Filename)
+
+	chapters, err := probeChapters(ctx, opts.Filename)
+	if err != nil {
+		return SourceInfo{}, err
+	}
+	if len(chapters) > 0 {
+		info.Chapters, err = chapterTracks(ctx, opts)
+		if err != nil {
+			return SourceInfo{}, err
+		}
+	}
+
+	return info, nil
+}
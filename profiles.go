@@ -0,0 +1,141 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+// profileBitrate matches the bitrate of a profile, as in 128k or 1M.
+var profileBitrate = regexp.MustCompile(`^\d+[kKmM]?$`)
+
+// Profile is one of the variants of the tracks a split writes, such as a
+// FLAC archive and Opus copies for a phone, into a directory of its own.
+type Profile struct {
+	// Name names the directory under the output directory the profile's
+	// tracks go in
+	Name string
+	// Format is the output format, as Options.Format, or "copy" to stream
+	// copy the tracks
+	Format  string
+	Bitrate string
+	Quality string
+}
+
+// ParseProfile parses a profile written as its format, optionally its
+// bitrate or "q" and its quality, and its name, such as "flac archive",
+// "opus 128k phone" or "mp3 q2 car".
+func ParseProfile(s string) (Profile, error) {
+	fields := strings.Fields(s)
+	if len(fields) < 2 || len(fields) > 3 {
+		return Profile{}, fmt.Errorf("invalid profile %q, must be a format, an optional bitrate or quality and a name, as in \"opus 128k phone\"", s)
+	}
+
+	p := Profile{Format: strings.ToLower(fields[0]), Name: fields[len(fields)-1]}
+	if len(fields) == 3 {
+		setting := fields[1]
+		switch {
+		case len(setting) > 1 && (setting[0] == 'q' || setting[0] == 'Q'):
+			p.Quality = setting[1:]
+		case profileBitrate.MatchString(setting):
+			p.Bitrate = setting
+		default:
+			return Profile{}, fmt.Errorf("invalid profile %q, %v is neither a bitrate such as 128k nor a quality such as q2", s, setting)
+		}
+	}
+
+	if p.Format == "copy" && (p.Bitrate != "" || p.Quality != "") {
+		return Profile{}, fmt.Errorf("invalid profile %q, stream copies have no bitrate or quality", s)
+	}
+	if sanitizeName(p.Name, false) != p.Name || p.Name == "." || p.Name == ".." {
+		return Profile{}, fmt.Errorf("invalid profile %q, %v cannot name a directory", s, p.Name)
+	}
+	return p, nil
+}
+
+// splitProfiles splits the tracks once for each of the output profiles,
+// each into a directory named after it under the output directory. When
+// several profiles re-encode, the audio of the source is decoded once into
+// a temporary FLAC file that they are all encoded from, sample for sample
+// the same, instead of each reading and decoding the source again.
+func (s *Splitter) splitProfiles(ctx context.Context, tracks Tracklist) error {
+	opts := s.opts
+	if opts.Output != "" {
+		return withKind(ErrInvalidInput, fmt.Errorf("output writes a single file, it can't be used with profiles"))
+	}
+
+	names := make(map[string]bool)
+	encoded := 0
+	for _, p := range opts.Profiles {
+		if names[strings.ToLower(p.Name)] {
+			return withKind(ErrInvalidInput, fmt.Errorf("two profiles are named %v", p.Name))
+		}
+		names[strings.ToLower(p.Name)] = true
+		if p.Format != "copy" {
+			encoded++
+		}
+	}
+
+	ctx = opts.withOptions(ctx)
+	decoded := ""
+	if encoded > 1 && !opts.Video && !opts.DryRun && opts.Script == "" {
+		var err error
+		if decoded, err = decodeSource(ctx, opts); err != nil {
+			return err
+		}
+		defer os.Remove(decoded)
+	}
+
+	for _, p := range opts.Profiles {
+		o := opts
+		o.Profiles = nil
+		o.OutputDir = filepath.Join(opts.OutputDir, p.Name)
+		o.Codec, o.Bitrate, o.Quality = "", p.Bitrate, p.Quality
+		if p.Format == "copy" {
+			o.Format, o.Encode = "", false
+		} else {
+			o.Format, o.Encode = p.Format, true
+			o.decoded = decoded
+		}
+
+		opts.logf("splitting the %v profile\n", p.Name)
+		if err := NewSplitter(o).Split(ctx, append(Tracklist(nil), tracks...)); err != nil {
+			return withKind(KindOf(err), fmt.Errorf("%v profile: %v", p.Name, err))
+		}
+	}
+	return nil
+}
+
+// decodeSource decodes the audio of the source to a temporary FLAC file,
+// removed by the caller.
+func decodeSource(ctx context.Context, opts Options) (string, error) {
+	f, err := os.CreateTemp("", "avsplit-decoded-*.flac")
+	if err != nil {
+		return "", err
+	}
+	f.Close()
+
+	opts.logf("decoding %v once for the profiles\n", opts.Filename)
+	args := []string{"-nostdin", "-y", "-loglevel", "error", "-i", argPath(opts.Filename)}
+	args = append(args, audioMapArgs(ctx)...)
+	args = append(args, "-map_metadata", "-1", "-c:a", "flac", "-compression_level", "0", f.Name())
+	if err := execCommand(ctx, "ffmpeg", args...); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("cannot decode %v: %v", opts.Filename, err)
+	}
+	return f.Name(), nil
+}
+
+// trackSource returns the file the track is extracted from, the source or,
+// for a track re-encoded for one of several profiles, the source decoded
+// once for them, which has only the one audio stream.
+func (o Options) trackSource(t *Track) string {
+	if o.decoded == "" || !t.Reencode || t.Video {
+		return o.Filename
+	}
+	t.Stream = ""
+	return o.decoded
+}
@@ -0,0 +1,291 @@
+package avsplit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const progressBarWidth = 20
+
+type trackProgress struct {
+	name    string
+	percent float64
+	elapsed time.Duration
+}
+
+// progressBar draws a single status line with the overall track count, the
+// time left and speed of the whole job, and a bar for every track being
+// extracted.
+type progressBar struct {
+	mu        sync.Mutex
+	w         io.Writer
+	opts      Options
+	total     int
+	done      int
+	active    map[int]*trackProgress
+	lineWidth int
+
+	// The audio of the tracks to extract and of those finished, which with
+	// the time since start give the speed and time left
+	lengths   map[int]time.Duration
+	audio     time.Duration
+	doneAudio time.Duration
+	start     time.Time
+}
+
+// newProgressBar returns the bar of a split of tracks from a source of
+// length total, or 0 if unknown.
+func newProgressBar(opts Options, tracks Tracklist, total time.Duration) *progressBar {
+	p := &progressBar{
+		w:       opts.logWriter(),
+		opts:    opts,
+		total:   len(tracks),
+		active:  make(map[int]*trackProgress),
+		lengths: make(map[int]time.Duration),
+		start:   time.Now(),
+	}
+
+	for _, t := range tracks {
+		if t.End == "" && total == 0 {
+			// The speed is still shown, the time left isn't
+			p.audio = -1
+			continue
+		}
+
+		if d, err := t.duration(total); err == nil && d > 0 {
+			p.lengths[t.Number] = d
+			if p.audio >= 0 {
+				p.audio += d
+			}
+		}
+	}
+	return p
+}
+
+// estimate returns the time left and how many times realtime the audio is
+// extracted at, once a second of output is in. The time left is negative
+// when the length of the job is unknown.
+func (p *progressBar) estimate() (time.Duration, float64, bool) {
+	processed := p.doneAudio
+	for _, t := range p.active {
+		processed += t.elapsed
+	}
+
+	wall := time.Since(p.start)
+	if processed < time.Second || wall < time.Second {
+		return 0, 0, false
+	}
+
+	speed := float64(processed) / float64(wall)
+	if p.audio < 0 {
+		return -1, speed, true
+	}
+
+	left := p.audio - processed
+	if left < 0 {
+		left = 0
+	}
+	return time.Duration(float64(left) / speed), speed, true
+}
+
+// update sets how far along track number is. A negative percent means the
+// track length is unknown and only the elapsed output time is shown.
+func (p *progressBar) update(number int, name string, percent float64, elapsed time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.opts.JSON {
+		// Emitted as events instead of drawn, finished tracks report
+		// themselves with a track event
+		e := jsonEvent{Event: "progress", Track: number, File: name}
+		secs := elapsed.Seconds()
+		e.Elapsed = &secs
+		if percent >= 0 {
+			e.Percent = &percent
+		}
+
+		p.active[number] = &trackProgress{name, percent, elapsed}
+		if left, speed, ok := p.estimate(); ok {
+			e.Speed = &speed
+			if left >= 0 {
+				secs := left.Seconds()
+				e.ETA = &secs
+			}
+		}
+		p.opts.emit(e)
+		return
+	}
+
+	p.active[number] = &trackProgress{name, percent, elapsed}
+	p.render()
+}
+
+func (p *progressBar) finish(number int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	delete(p.active, number)
+	p.done++
+	p.doneAudio += p.lengths[number]
+	if !p.opts.JSON {
+		p.render()
+	}
+}
+
+// skip counts a track left by an earlier run as done, without it taking
+// part in the speed.
+func (p *progressBar) skip(number int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.done++
+	if p.audio > 0 {
+		p.audio -= p.lengths[number]
+	}
+	if !p.opts.JSON {
+		p.render()
+	}
+}
+
+func (p *progressBar) close() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if !p.opts.JSON {
+		fmt.Fprintln(p.w)
+	}
+}
+
+func (p *progressBar) render() {
+	var numbers []int
+	for n := range p.active {
+		numbers = append(numbers, n)
+	}
+	sort.Ints(numbers)
+
+	line := fmt.Sprintf("[%d/%d]", p.done, p.total)
+	if left, speed, ok := p.estimate(); ok {
+		if left >= 0 {
+			line += fmt.Sprintf(" ETA %v", left.Round(time.Second))
+		}
+		line += fmt.Sprintf(" %.1fx", speed)
+	}
+	for _, n := range numbers {
+		t := p.active[n]
+		if t.percent < 0 {
+			line += fmt.Sprintf("  %v %v", t.name, t.elapsed.Truncate(time.Second))
+			continue
+		}
+
+		filled := int(t.percent / 100 * progressBarWidth)
+		line += fmt.Sprintf(
+			"  %v [%v%v] %3.0f%%",
+			t.name,
+			strings.Repeat("=", filled),
+			strings.Repeat(" ", progressBarWidth-filled),
+			t.percent,
+		)
+	}
+
+	// Pad with spaces to clear what is left of a longer previous line
+	pad := ""
+	if len(line) < p.lineWidth {
+		pad = strings.Repeat(" ", p.lineWidth-len(line))
+	}
+	p.lineWidth = len(line)
+
+	fmt.Fprintf(p.w, "\r%v%v", line, pad)
+}
+
+// execFFmpegProgress runs ffmpeg reporting through -progress, calling
+// report with the amount of output written so far.
+func execFFmpegProgress(ctx context.Context, args []string, report func(time.Duration)) error {
+	args = append([]string{"-progress", "pipe:1", "-nostats"}, args...)
+	var stderr bytes.Buffer
+	stdout, w := io.Pipe()
+	done := make(chan error, 1)
+	go func() {
+		err := runCommand(ctx, "ffmpeg", args, w, &stderr)
+		w.Close()
+		done <- err
+	}()
+
+	s := bufio.NewScanner(stdout)
+	for s.Scan() {
+		kv := strings.SplitN(s.Text(), "=", 2)
+		if len(kv) != 2 || kv[0] != "out_time_us" {
+			continue
+		}
+
+		us, err := strconv.ParseInt(kv[1], 10, 64)
+		if err == nil && us >= 0 {
+			report(time.Duration(us) * time.Microsecond)
+		}
+	}
+
+	// Drained so ffmpeg never blocks writing progress nobody reads
+	io.Copy(io.Discard, stdout)
+
+	err := <-done
+	debugStderr(ctx, "ffmpeg", stderr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return toolError("ffmpeg", stderr.String())
+	}
+
+	return nil
+}
+
+// extractTrackProgress extracts the track into the file part while updating
+// its bar, if there is one, and sending TrackProgress events. total is the
+// length of the source, or 0 if unknown.
+func extractTrackProgress(ctx context.Context, opts Options, t Track, part string, bar *progressBar, total time.Duration) error {
+	out := t.outputFilename(opts.Filename)
+	name := filepath.Base(out)
+	if bar == nil {
+		opts.logf("processing track \"%v\"\n", out)
+	}
+
+	length := time.Duration(-1)
+	if t.End != "" || total > 0 {
+		d, err := t.duration(total)
+		if err != nil {
+			return err
+		}
+		length = d
+	}
+
+	track := t
+	report := func(elapsed time.Duration) {
+		percent := float64(-1)
+		if length > 0 {
+			percent = float64(elapsed) / float64(length) * 100
+			if percent > 100 {
+				percent = 100
+			}
+		}
+		if bar != nil {
+			bar.update(t.Number, name, percent, elapsed)
+		}
+		opts.event(Event{Kind: TrackProgress, Track: track, File: out, Percent: percent, Elapsed: elapsed})
+	}
+
+	report(0)
+	if bar != nil {
+		defer bar.finish(t.Number)
+	}
+
+	t.Output = part
+	return execFFmpegProgress(ctx, t.ffmpegArgs(opts.trackSource(&t), opts.overwriteFlag()), report)
+}
@@ -0,0 +1,152 @@
+package avsplit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"runtime/debug"
+	"sort"
+	"strings"
+	"time"
+)
+
+// receiptName is the receipt written into each output directory.
+const receiptName = "avsplit-receipt.json"
+
+// receipt records how the tracks of an output directory were split: from
+// which source and tracklist, with which tools and commands, and the
+// checksum and length of each track. It is a plan file too, so the tracks
+// can be split or tagged again from it with Options.Plan.
+type receipt struct {
+	Version   int            `json:"version"`
+	Sources   []string       `json:"sources"`
+	Tagger    string         `json:"tagger"`
+	Normalize string         `json:"normalize,omitempty"`
+	Tracks    []receiptTrack `json:"tracks"`
+
+	Created         time.Time         `json:"created"`
+	SourceSHA256    string            `json:"source_sha256,omitempty"`
+	Tracklist       string            `json:"tracklist,omitempty"`
+	TracklistSHA256 string            `json:"tracklist_sha256,omitempty"`
+	Tools           map[string]string `json:"tools"`
+}
+
+// receiptTrack is a track of a receipt, its plan and what was written.
+type receiptTrack struct {
+	planTrack
+	SHA256   string   `json:"sha256"`
+	Duration string   `json:"duration"`
+	Commands []string `json:"commands"`
+}
+
+// toolVersions returns the version of avsplit and the first line of what
+// ffmpeg, ffprobe and the tagger print as theirs.
+func toolVersions(ctx context.Context, opts Options) map[string]string {
+	tools := map[string]string{"avsplit": "unknown"}
+	if info, ok := debug.ReadBuildInfo(); ok {
+		tools["avsplit"] = info.Main.Version
+	}
+
+	commands := [][]string{{"ffmpeg", "-version"}, {"ffprobe", "-version"}}
+	if opts.Tagger == "eyed3" {
+		commands = append(commands, []string{"eyed3", "--version"})
+	}
+	for _, c := range commands {
+		out, err := commandOutput(ctx, c[0], c[1:]...)
+		if err != nil {
+			opts.logf("warning: cannot read the version of %v: %v\n", c[0], strings.TrimSpace(err.Error()))
+			continue
+		}
+		if v := strings.TrimSpace(strings.SplitN(strings.TrimSpace(out), "\n", 2)[0]); v != "" {
+			tools[c[0]] = v
+		}
+	}
+	return tools
+}
+
+// writeReceipts writes a receipt into each output directory of the split
+// tracks, source being the checksum of the source if it is known already.
+func writeReceipts(ctx context.Context, opts Options, tracks Tracklist, source string) error {
+	sources := opts.Filenames
+	if len(sources) == 0 {
+		sources = []string{opts.Filename}
+	}
+
+	if source == "" && !isURL(opts.Filename) {
+		var err error
+		if source, err = fileChecksum("sha256", opts.Filename); err != nil {
+			return fmt.Errorf("cannot checksum the audio file: %v", err)
+		}
+	}
+
+	tracklist, tracklistSum := opts.tracklistFile(), ""
+	if tracklist != "" && tracklist != "-" {
+		var err error
+		if tracklistSum, err = fileChecksum("sha256", tracklist); err != nil {
+			return fmt.Errorf("cannot checksum the tracklist: %v", err)
+		}
+	}
+
+	created := time.Now().UTC()
+	if opts.Reproducible {
+		created = opts.Date.UTC()
+	}
+	tools := toolVersions(ctx, opts)
+
+	plan := newPlan(opts, sources, tracks)
+	byDir := make(map[string]*receipt)
+	var dirs []string
+	for i, t := range tracks {
+		out := t.outputFilename(opts.Filename)
+		sum, err := fileChecksum("sha256", out)
+		if err != nil {
+			return fmt.Errorf("cannot checksum %v: %v", out, err)
+		}
+
+		length, err := probeDuration(ctx, out)
+		if err != nil {
+			return err
+		}
+
+		dir := filepath.Dir(out)
+		r, ok := byDir[dir]
+		if !ok {
+			r = &receipt{
+				Version:         plan.Version,
+				Sources:         plan.Sources,
+				Tagger:          plan.Tagger,
+				Normalize:       plan.Normalize,
+				Created:         created,
+				SourceSHA256:    source,
+				Tracklist:       tracklist,
+				TracklistSHA256: tracklistSum,
+				Tools:           tools,
+			}
+			byDir[dir] = r
+			dirs = append(dirs, dir)
+		}
+
+		r.Tracks = append(r.Tracks, receiptTrack{
+			planTrack: plan.Tracks[i],
+			SHA256:    sum,
+			Duration:  length.String(),
+			Commands:  trackCommands(opts, t),
+		})
+	}
+
+	sort.Strings(dirs)
+	for _, dir := range dirs {
+		b, err := json.MarshalIndent(byDir[dir], "", "  ")
+		if err != nil {
+			return err
+		}
+
+		path := filepath.Join(dir, receiptName)
+		if err := opts.writeFile(path, append(b, '\n')); err != nil {
+			return fmt.Errorf("cannot write receipt: %v", err)
+		}
+		opts.logf("wrote the receipt %v\n", path)
+	}
+	return nil
+}
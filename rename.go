@@ -0,0 +1,277 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// renameMove is a file Rename moves, a track or its .lrc file.
+type renameMove struct {
+	from, to string
+	track    int
+}
+
+// tagNumber parses a number tag such as "3" or "3/12" into the number and
+// total, 0 for either when it is missing.
+func tagNumber(s string) (int, int) {
+	parts := strings.SplitN(s, "/", 2)
+	n, _ := strconv.Atoi(strings.TrimSpace(parts[0]))
+	total := 0
+	if len(parts) == 2 {
+		total, _ = strconv.Atoi(strings.TrimSpace(parts[1]))
+	}
+	return n, total
+}
+
+// taggedTracks returns the audio files under dir as tracks from their tags,
+// each with the file as its output. Files without a title or track number
+// are left out with a warning.
+func taggedTracks(ctx context.Context, opts Options, dir string) (Tracklist, error) {
+	files, err := tagDirFiles(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks Tracklist
+	for _, f := range files {
+		tags, err := probeTags(ctx, f)
+		if err != nil {
+			return nil, err
+		}
+
+		t := Track{
+			Title:       tags["title"],
+			Artist:      tags["artist"],
+			AlbumArtist: tags["album_artist"],
+			Album:       tags["album"],
+			Composer:    tags["composer"],
+			Work:        tags["work"],
+			Genre:       tags["genre"],
+			Ext:         strings.ToLower(filepath.Ext(f)),
+			Output:      f,
+		}
+		t.Number, t.Total = tagNumber(tags["track"])
+		t.Disc, t.DiscTotal = tagNumber(tags["disc"])
+		t.Movement, t.Movements = tagNumber(tags["movement"])
+		if t.AlbumArtist == "" {
+			t.AlbumArtist = t.Artist
+		}
+		if date := tags["date"]; len(date) >= 4 {
+			t.Year = date[:4]
+		}
+
+		if t.Title == "" || t.Number == 0 {
+			opts.logf("warning: %v has no title or track number tag, leaving it out\n", f)
+			continue
+		}
+		tracks = append(tracks, t)
+	}
+
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("no tagged audio files found in %v", dir)
+	}
+
+	for i := range tracks {
+		if tracks[i].Total == 0 {
+			tracks[i].Total = len(tracks)
+		}
+	}
+	return tracks, nil
+}
+
+// Rename moves the tracks of an earlier split to the output files opts names
+// now, say after changing its OutputTemplate: the tracks of the plan
+// opts.Plan, which is updated, or otherwise the audio files in opts.TagDir
+// as their tags describe them. Each track's .lrc file moves with it. Nothing
+// is moved if two files would be moved to the same one or over a file that
+// stays, and with opts.DryRun the moves are only printed.
+func Rename(ctx context.Context, opts Options) error {
+	opts = opts.withDefaults()
+	ctx = opts.withOptions(ctx)
+
+	var plan *planFile
+	var tracks Tracklist
+	root := "."
+	switch {
+	case opts.Plan != "":
+		p, err := readPlan(opts.Plan)
+		if err != nil {
+			return withKind(ErrInvalidInput, err)
+		}
+		if tracks, err = p.tracklist(); err != nil {
+			return withKind(ErrInvalidInput, err)
+		}
+		plan = p
+	case opts.TagDir != "":
+		var err error
+		if tracks, err = taggedTracks(ctx, opts, opts.TagDir); err != nil {
+			return withKind(ErrInvalidInput, err)
+		}
+		root = opts.TagDir
+	default:
+		return withKind(ErrInvalidInput, fmt.Errorf("rename needs a plan or a directory of tracks"))
+	}
+
+	outputs, err := renameOutputs(opts, tracks, root)
+	if err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	var moves []renameMove
+	for i, t := range tracks {
+		if outputs[i] == filepath.Clean(t.Output) {
+			continue
+		}
+		moves = append(moves, renameMove{t.Output, outputs[i], t.Number})
+
+		lrc := strings.TrimSuffix(t.Output, filepath.Ext(t.Output)) + ".lrc"
+		if _, err := os.Stat(lrc); err == nil {
+			moves = append(moves, renameMove{lrc, strings.TrimSuffix(outputs[i], filepath.Ext(outputs[i])) + ".lrc", t.Number})
+		}
+	}
+
+	if err := checkMoves(moves); err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	if len(moves) == 0 {
+		opts.logf("the files are named as they should be already\n")
+		return nil
+	}
+
+	if opts.DryRun {
+		for _, m := range moves {
+			fmt.Fprintf(opts.logWriter(), "%v -> %v\n", m.from, m.to)
+		}
+		return nil
+	}
+
+	if err := moveFiles(opts, moves, root); err != nil {
+		return err
+	}
+
+	if plan != nil {
+		for i := range plan.Tracks {
+			plan.Tracks[i].Output = outputs[i]
+		}
+		return plan.write(opts.Plan)
+	}
+	return nil
+}
+
+// renameOutputs returns the output file of each track as a split with opts
+// would name it, under root unless opts.OutputDir is given.
+func renameOutputs(opts Options, tracks Tracklist, root string) ([]string, error) {
+	tracks = append(Tracklist(nil), tracks...)
+	multi := multiDisc(tracks)
+	for i := range tracks {
+		t := &tracks[i]
+		t.Output, t.Dir = "", ""
+		t.ASCII, t.PadWidth, t.MultiDisc = opts.ASCII, opts.PadWidth, multi
+	}
+
+	if opts.DirTemplate != "" {
+		if err := applyDirTemplate(tracks, opts.DirTemplate, opts.ASCII); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.OutputDir != "" {
+		root = opts.OutputDir
+	}
+
+	outputs := make([]string, len(tracks))
+	for i := range tracks {
+		t := &tracks[i]
+		if opts.DiscDirs && t.MultiDisc {
+			t.Dir = filepath.Join(t.dir(), fmt.Sprintf("Disc %d", t.Disc))
+			t.MultiDisc = false
+		}
+
+		if opts.OutputTemplate != "" {
+			out, err := renderOutputTemplate(opts.OutputTemplate, t.outputFields("", opts.Vars), opts.ASCII)
+			if err != nil {
+				return nil, err
+			}
+			t.Output = out
+		}
+
+		out := t.outputFilename("")
+		if !filepath.IsAbs(out) {
+			out = filepath.Join(root, out)
+		}
+		outputs[i] = out
+	}
+	return outputs, nil
+}
+
+// checkMoves returns an error if a file to move is missing, two would be
+// moved to the same one, or one over a file that isn't moved away.
+func checkMoves(moves []renameMove) error {
+	moved := make(map[string]bool)
+	for _, m := range moves {
+		if _, err := os.Stat(m.from); err != nil {
+			return fmt.Errorf("file %v of track %d not found", m.from, m.track)
+		}
+		moved[collisionKey(m.from)] = true
+	}
+
+	seen := make(map[string]renameMove)
+	for _, m := range moves {
+		if first, ok := seen[collisionKey(m.to)]; ok {
+			return fmt.Errorf("tracks %d and %d would both be moved to %v", first.track, m.track, m.to)
+		}
+		seen[collisionKey(m.to)] = m
+
+		if _, err := os.Stat(m.to); err == nil && !moved[collisionKey(m.to)] {
+			return fmt.Errorf("cannot move %v to %v, it exists already", m.from, m.to)
+		}
+	}
+	return nil
+}
+
+// moveFiles makes the moves, through a temporary name next to each file so
+// that files swapping names, or only changing case, don't get in each
+// other's way. The directories left empty under root are removed.
+func moveFiles(opts Options, moves []renameMove, root string) error {
+	temps := make([]string, len(moves))
+	for i, m := range moves {
+		temps[i] = filepath.Join(filepath.Dir(m.from), fmt.Sprintf(".avsplit-rename-%d%v", i, filepath.Ext(m.from)))
+		if err := os.Rename(m.from, temps[i]); err != nil {
+			// Nothing is moved yet, the files moved aside go back
+			for k := 0; k < i; k++ {
+				os.Rename(temps[k], moves[k].from)
+			}
+			return fmt.Errorf("cannot move %v: %v", m.from, err)
+		}
+	}
+
+	for i, m := range moves {
+		if err := opts.mkdirAll(filepath.Dir(m.to)); err != nil {
+			return fmt.Errorf("cannot move %v, it is left as %v: %v", m.from, temps[i], err)
+		}
+		if err := os.Rename(temps[i], m.to); err != nil {
+			return fmt.Errorf("cannot move %v to %v, it is left as %v: %v", m.from, m.to, temps[i], err)
+		}
+		opts.logf("moved %v to %v\n", m.from, m.to)
+	}
+
+	under := func(dir string) bool {
+		rel, err := filepath.Rel(root, dir)
+		return err == nil && rel != "." && !strings.HasPrefix(rel, "..")
+	}
+
+	// Removing a directory fails while anything is left in it
+	for _, m := range moves {
+		for dir := filepath.Dir(m.from); under(dir); dir = filepath.Dir(dir) {
+			if os.Remove(dir) != nil {
+				break
+			}
+		}
+	}
+	return nil
+}
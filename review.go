@@ -0,0 +1,189 @@
+package avsplit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+const reviewHelp = `commands:
+  list                 show the tracks
+  title N TEXT         rename track N
+  start N TIME         move the start of track N, TIME is a timecode or +/-seconds
+  end N TIME           move the end of track N
+  merge N              merge track N with the one after it
+  delete N             drop track N
+  go                   split the tracks
+  quit                 stop without splitting
+`
+
+// ReviewTracks lets the user edit the tracks through commands read from r
+// before they are split, writing the tracklist and prompts to w. It returns
+// the edited tracks once the user confirms, or an error if they quit.
+func ReviewTracks(r io.Reader, w io.Writer, tracks Tracklist) (Tracklist, error) {
+	tracks = append(Tracklist(nil), tracks...)
+
+	printTracks(w, tracks)
+	fmt.Fprint(w, "\nenter a command, help for the list\n")
+
+	s := bufio.NewScanner(r)
+	for {
+		fmt.Fprint(w, "> ")
+		if !s.Scan() {
+			if err := s.Err(); err != nil {
+				return nil, err
+			}
+			return nil, fmt.Errorf("review aborted")
+		}
+
+		fields := strings.Fields(s.Text())
+		if len(fields) == 0 {
+			continue
+		}
+
+		cmd, args := fields[0], fields[1:]
+		switch cmd {
+		case "go", "y", "yes":
+			if len(tracks) == 0 {
+				fmt.Fprintln(w, "no tracks left to split")
+				continue
+			}
+			return tracks, nil
+		case "quit", "q":
+			return nil, fmt.Errorf("review aborted")
+		case "help", "?":
+			fmt.Fprint(w, reviewHelp)
+			continue
+		case "list", "l", "ls":
+			printTracks(w, tracks)
+			continue
+		}
+
+		var err error
+		tracks, err = reviewCommand(tracks, cmd, args, strings.TrimSpace(s.Text()))
+		if err != nil {
+			fmt.Fprintf(w, "error: %v\n", err)
+			continue
+		}
+		printTracks(w, tracks)
+	}
+}
+
+// reviewCommand applies an editing command to the tracks. line is the whole
+// command, so titles keep their spacing.
+func reviewCommand(tracks Tracklist, cmd string, args []string, line string) (Tracklist, error) {
+	if len(args) == 0 {
+		return tracks, fmt.Errorf("%v needs a track number", cmd)
+	}
+
+	n, err := strconv.Atoi(args[0])
+	if err != nil || n < 1 || n > len(tracks) {
+		return tracks, fmt.Errorf("no track %v", args[0])
+	}
+	i := n - 1
+
+	switch cmd {
+	case "title", "t":
+		fields := strings.SplitN(line, " ", 3)
+		if len(fields) < 3 || strings.TrimSpace(fields[2]) == "" {
+			return tracks, fmt.Errorf("title needs the new title")
+		}
+		tracks[i].Title = strings.TrimSpace(fields[2])
+	case "start", "s":
+		if len(args) < 2 {
+			return tracks, fmt.Errorf("start needs a time")
+		}
+
+		start, err := adjustTimecode(tracks[i].Start, args[1])
+		if err != nil {
+			return tracks, err
+		}
+
+		// Keep the previous track running up to it
+		if i > 0 && tracks[i-1].End == tracks[i].Start {
+			tracks[i-1].End = start
+		}
+		tracks[i].Start = start
+	case "end", "e":
+		if len(args) < 2 {
+			return tracks, fmt.Errorf("end needs a time")
+		}
+
+		if tracks[i].End == "" && strings.ContainsAny(args[1][:1], "+-") {
+			return tracks, fmt.Errorf("track %d runs to the end of the file, give the end as a timecode", n)
+		}
+
+		end, err := adjustTimecode(tracks[i].End, args[1])
+		if err != nil {
+			return tracks, err
+		}
+
+		// Keep the next track starting where it ends
+		if i < len(tracks)-1 && tracks[i+1].Start == tracks[i].End {
+			tracks[i+1].Start = end
+		}
+		tracks[i].End = end
+	case "merge", "m":
+		if i == len(tracks)-1 {
+			return tracks, fmt.Errorf("track %d is the last track", n)
+		}
+		tracks[i].End = tracks[i+1].End
+		tracks = append(tracks[:i+1], tracks[i+2:]...)
+	case "delete", "d", "rm":
+		tracks = append(tracks[:i], tracks[i+1:]...)
+	default:
+		return tracks, fmt.Errorf("unknown command %v, see help", cmd)
+	}
+
+	for i := range tracks {
+		tracks[i].Number = i + 1
+		tracks[i].Total = len(tracks)
+	}
+
+	return tracks, nil
+}
+
+// adjustTimecode returns the timecode v, or tc moved by v seconds when v
+// starts with + or -.
+func adjustTimecode(tc, v string) (string, error) {
+	if !strings.HasPrefix(v, "+") && !strings.HasPrefix(v, "-") {
+		d, err := parseDuration(v)
+		if err != nil {
+			return "", err
+		}
+		return formatTimecode(d), nil
+	}
+
+	secs, err := strconv.ParseFloat(v, 64)
+	if err != nil {
+		return "", fmt.Errorf("invalid adjustment %v", v)
+	}
+
+	d, err := parseDuration(tc)
+	if err != nil {
+		return "", err
+	}
+
+	d += time.Duration(secs * float64(time.Second)).Round(time.Millisecond)
+	if d < 0 {
+		return "", fmt.Errorf("%v moves %v before the start of the file", v, tc)
+	}
+	return formatTimecode(d), nil
+}
+
+func printTracks(w io.Writer, tracks Tracklist) {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tSTART\tEND\tTITLE")
+	for _, t := range tracks {
+		end := t.End
+		if end == "" {
+			end = "EOF"
+		}
+		fmt.Fprintf(tw, "%d\t%v\t%v\t%v\n", t.Number, t.Start, end, t.Title)
+	}
+	tw.Flush()
+}
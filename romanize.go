@@ -0,0 +1,277 @@
+package avsplit
+
+import (
+	"strings"
+	"unicode"
+)
+
+// altTitleSeparator parts the two titles of a dual-column tracklist line,
+// as in "夜に駆ける | Yoru ni Kakeru".
+const altTitleSeparator = " | "
+
+// altTitles returns where the second titles of the tracks go, "filenames",
+// "tags" or "" for nowhere. Romanize alone makes one for the file names.
+func (o Options) altTitles() string {
+	if o.AltTitles == "" && o.Romanize {
+		return "filenames"
+	}
+	return o.AltTitles
+}
+
+// splitAltTitle parts a dual-column title into the title and the second
+// one, "" when the tracklist is read without AltTitles or the title has
+// none.
+func splitAltTitle(title string, opts Options) (string, string) {
+	if opts.altTitles() == "" {
+		return title, ""
+	}
+
+	i := strings.Index(title, altTitleSeparator)
+	if i < 0 {
+		return title, ""
+	}
+	return strings.TrimSpace(title[:i]), strings.TrimSpace(title[i+len(altTitleSeparator):])
+}
+
+// setAltTitle gives the track its second title, in the file name with
+// AltTitles "filenames" and in the tags with "tags", where the first title
+// names the file instead.
+func setAltTitle(t *Track, alt string, opts Options) {
+	if alt == "" {
+		return
+	}
+
+	switch opts.altTitles() {
+	case "filenames":
+		t.FileTitle = alt
+	case "tags":
+		t.Title, t.FileTitle = alt, t.Title
+	}
+}
+
+// romanizeTitles gives each track without a second title its romanized
+// title, warning about those with characters romanize can't spell, kanji
+// above all.
+func romanizeTitles(tracks Tracklist, opts Options) {
+	for i := range tracks {
+		t := &tracks[i]
+		if t.FileTitle != "" || t.Title == "" {
+			continue
+		}
+
+		r, ok := romanize(t.Title)
+		if !ok {
+			opts.logf("warning: track %d: cannot romanize all of \"%v\", give its romanized title after \"%v\"\n", t.Number, t.Title, strings.TrimSpace(altTitleSeparator))
+		}
+		if r != t.Title {
+			setAltTitle(t, r, opts)
+		}
+	}
+}
+
+// cyrillicLatin spells the Russian, Ukrainian and Belarusian letters in
+// Latin, close to BGN/PCGN without its diacritics.
+var cyrillicLatin = map[rune]string{
+	'а': "a", 'б': "b", 'в': "v", 'г': "g", 'д': "d", 'е': "e", 'ё': "yo",
+	'ж': "zh", 'з': "z", 'и': "i", 'й': "y", 'к': "k", 'л': "l", 'м': "m",
+	'н': "n", 'о': "o", 'п': "p", 'р': "r", 'с': "s", 'т': "t", 'у': "u",
+	'ф': "f", 'х': "kh", 'ц': "ts", 'ч': "ch", 'ш': "sh", 'щ': "shch",
+	'ъ': "", 'ы': "y", 'ь': "", 'э': "e", 'ю': "yu", 'я': "ya",
+	'і': "i", 'ї': "yi", 'є': "ye", 'ґ': "g", 'ў': "w",
+}
+
+// greekLatin spells the Greek letters in Latin, as ELOT 743 does for the
+// unaccented ones.
+var greekLatin = map[rune]string{
+	'α': "a", 'β': "v", 'γ': "g", 'δ': "d", 'ε': "e", 'ζ': "z", 'η': "i",
+	'θ': "th", 'ι': "i", 'κ': "k", 'λ': "l", 'μ': "m", 'ν': "n", 'ξ': "x",
+	'ο': "o", 'π': "p", 'ρ': "r", 'σ': "s", 'ς': "s", 'τ': "t", 'υ': "y",
+	'φ': "f", 'χ': "ch", 'ψ': "ps", 'ω': "o",
+	'ά': "a", 'έ': "e", 'ή': "i", 'ί': "i", 'ό': "o", 'ύ': "y", 'ώ': "o",
+	'ϊ': "i", 'ϋ': "y", 'ΐ': "i", 'ΰ': "y",
+}
+
+// kanaLatin spells the hiragana in Hepburn, katakana being spelled as the
+// hiragana they match. The small kana are those that combine with the kana
+// before them.
+var kanaLatin = map[rune]string{
+	'あ': "a", 'い': "i", 'う': "u", 'え': "e", 'お': "o",
+	'か': "ka", 'き': "ki", 'く': "ku", 'け': "ke", 'こ': "ko",
+	'が': "ga", 'ぎ': "gi", 'ぐ': "gu", 'げ': "ge", 'ご': "go",
+	'さ': "sa", 'し': "shi", 'す': "su", 'せ': "se", 'そ': "so",
+	'ざ': "za", 'じ': "ji", 'ず': "zu", 'ぜ': "ze", 'ぞ': "zo",
+	'た': "ta", 'ち': "chi", 'つ': "tsu", 'て': "te", 'と': "to",
+	'だ': "da", 'ぢ': "ji", 'づ': "zu", 'で': "de", 'ど': "do",
+	'な': "na", 'に': "ni", 'ぬ': "nu", 'ね': "ne", 'の': "no",
+	'は': "ha", 'ひ': "hi", 'ふ': "fu", 'へ': "he", 'ほ': "ho",
+	'ば': "ba", 'び': "bi", 'ぶ': "bu", 'べ': "be", 'ぼ': "bo",
+	'ぱ': "pa", 'ぴ': "pi", 'ぷ': "pu", 'ぺ': "pe", 'ぽ': "po",
+	'ま': "ma", 'み': "mi", 'む': "mu", 'め': "me", 'も': "mo",
+	'や': "ya", 'ゆ': "yu", 'よ': "yo",
+	'ら': "ra", 'り': "ri", 'る': "ru", 'れ': "re", 'ろ': "ro",
+	'わ': "wa", 'ゐ': "i", 'ゑ': "e", 'を': "o", 'ん': "n", 'ゔ': "vu",
+}
+
+// smallKana are the small kana, the vowel or glide they add to the kana
+// before them.
+var smallKana = map[rune]string{
+	'ぁ': "a", 'ぃ': "i", 'ぅ': "u", 'ぇ': "e", 'ぉ': "o",
+	'ゃ': "ya", 'ゅ': "yu", 'ょ': "yo", 'ゎ': "wa",
+}
+
+// cjkPunctuation spells the Japanese and Korean punctuation in titles.
+var cjkPunctuation = map[rune]string{
+	'　': " ", '、': ", ", '。': ". ", '・': " ", '「': "'", '」': "'",
+	'『': "'", '』': "'", '（': "(", '）': ")", '！': "!", '？': "?", '〜': "~",
+	'～': "~", '：': ": ",
+}
+
+// Revised Romanization of the Hangul jamo, initial, medial and final. The
+// initial of a final is how it reads when the next syllable starts with a
+// vowel.
+var (
+	hangulInitials = []string{"g", "kk", "n", "d", "tt", "r", "m", "b", "pp", "s", "ss", "", "j", "jj", "ch", "k", "t", "p", "h"}
+	hangulMedials  = []string{"a", "ae", "ya", "yae", "eo", "e", "yeo", "ye", "o", "wa", "wae", "oe", "yo", "u", "wo", "we", "wi", "yu", "eu", "ui", "i"}
+	hangulFinals   = []string{"", "k", "k", "k", "n", "n", "n", "t", "l", "k", "m", "l", "l", "l", "p", "l", "m", "p", "p", "t", "t", "ng", "t", "t", "k", "t", "p", "t"}
+	hangulLinked   = []string{"", "g", "kk", "ks", "n", "nj", "nh", "d", "r", "lg", "lm", "lb", "ls", "lt", "lp", "lh", "m", "b", "bs", "s", "ss", "ng", "j", "ch", "k", "t", "p", ""}
+)
+
+// romanize spells s in Latin letters, Cyrillic and Greek letter by letter,
+// kana in Hepburn and Hangul in a simplified Revised Romanization, which
+// leaves out the sound changes between syllables other than a final
+// consonant carried over to a vowel. The romanized Japanese and Korean
+// words start with a capital. Anything else is kept, reporting false when
+// that includes letters of another script, such as kanji, which can't be
+// romanized without knowing how they are read.
+func romanize(s string) (string, bool) {
+	ok := true
+	rs := []rune(s)
+	var b strings.Builder
+	wordStart := func() bool {
+		out := b.String()
+		return out == "" || strings.HasSuffix(out, " ") || strings.HasSuffix(out, "'") || strings.HasSuffix(out, "(")
+	}
+	capitalized := func(v string, upper bool) string {
+		if !upper || v == "" {
+			return v
+		}
+		return strings.ToUpper(v[:1]) + v[1:]
+	}
+
+	for i := 0; i < len(rs); i++ {
+		r := rs[i]
+		lower := unicode.ToLower(r)
+
+		if v, found := cyrillicLatin[lower]; found {
+			b.WriteString(capitalized(v, lower != r))
+			continue
+		}
+		if v, found := greekLatin[lower]; found {
+			b.WriteString(capitalized(v, lower != r))
+			continue
+		}
+		if v, found := cjkPunctuation[r]; found {
+			b.WriteString(v)
+			continue
+		}
+
+		if hangulSyllable(r) {
+			upper := wordStart()
+			var word strings.Builder
+			for ; i < len(rs) && hangulSyllable(rs[i]); i++ {
+				n := int(rs[i] - hangulBase)
+				l, v, t := n/(hangulVCount*hangulTCount), n/hangulTCount%hangulVCount, n%hangulTCount
+				word.WriteString(hangulInitials[l] + hangulMedials[v])
+
+				// A final before a silent initial starts the next syllable
+				if next := i + 1; next < len(rs) && hangulSyllable(rs[next]) && int(rs[next]-hangulBase)/(hangulVCount*hangulTCount) == 11 && t != 21 {
+					word.WriteString(hangulLinked[t])
+				} else {
+					word.WriteString(hangulFinals[t])
+				}
+			}
+			i--
+			b.WriteString(capitalized(word.String(), upper))
+			continue
+		}
+
+		if kana(r) != 0 || r == 'ー' {
+			upper := wordStart()
+			var word strings.Builder
+			double := false
+			for ; i < len(rs) && (kana(rs[i]) != 0 || rs[i] == 'ー'); i++ {
+				k := kana(rs[i])
+				switch {
+				case rs[i] == 'ー':
+					// Long vowels are written as the short ones
+					continue
+				case k == 'っ':
+					double = true
+					continue
+				}
+
+				v, small := kanaLatin[k], false
+				if v == "" {
+					v, small = smallKana[k], true
+				}
+
+				out := word.String()
+				if small && out != "" {
+					// Combines with the kana before, as in "kya", "sha" or "fa"
+					out = strings.TrimSuffix(out, out[len(out)-1:])
+					switch {
+					case strings.HasSuffix(out, "sh") || strings.HasSuffix(out, "ch") || strings.HasSuffix(out, "j"):
+						v = strings.TrimPrefix(v, "y")
+					case strings.HasSuffix(out, "f") || strings.HasSuffix(out, "ts"):
+					case out == "" || strings.HasSuffix(out, " "):
+						out += "w"
+						v = strings.TrimPrefix(v, "w")
+					}
+					word.Reset()
+					word.WriteString(out)
+				}
+
+				if double && v != "" {
+					if strings.HasPrefix(v, "ch") {
+						word.WriteString("t")
+					} else if c := v[0]; !strings.ContainsRune("aeiouny", rune(c)) {
+						word.WriteByte(c)
+					}
+					double = false
+				}
+
+				// "n'" keeps "kan'i" apart from "kani"
+				if i > 0 && kana(rs[i-1]) == 'ん' && strings.ContainsRune("aeiouy", rune(v[0])) {
+					word.WriteByte('\'')
+				}
+				word.WriteString(v)
+			}
+			i--
+			b.WriteString(capitalized(word.String(), upper))
+			continue
+		}
+
+		if r > unicode.MaxASCII && unicode.IsLetter(r) && !unicode.Is(unicode.Latin, r) {
+			ok = false
+		}
+		b.WriteRune(r)
+	}
+
+	return strings.TrimSpace(strings.Join(strings.Fields(b.String()), " ")), ok
+}
+
+func hangulSyllable(r rune) bool {
+	return r >= hangulBase && r < hangulBase+hangulLCount*hangulVCount*hangulTCount
+}
+
+// kana returns the hiragana of the kana r, the katakana matching one, or 0
+// for anything else.
+func kana(r rune) rune {
+	switch {
+	case r >= 'ぁ' && r <= 'ゔ':
+		return r
+	case r >= 'ァ' && r <= 'ヴ':
+		return r - 0x60
+	}
+	return 0
+}
@@ -0,0 +1,313 @@
+package avsplit
+
+import (
+	"path/filepath"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxNameBytes is the longest file or directory name most filesystems allow.
+const maxNameBytes = 255
+
+// invalidNameChars can't appear in names on at least one common filesystem.
+// A slash would also add a directory level.
+var invalidNameChars = strings.NewReplacer(
+	"/", "-",
+	"\\", "-",
+	":", "-",
+	"|", "-",
+	"*", "_",
+	"?", "_",
+	"\"", "'",
+	"<", "_",
+	">", "_",
+)
+
+// reservedNames can't be used as file names on Windows, with or without an
+// extension.
+var reservedNames = map[string]bool{
+	"CON": true, "PRN": true, "AUX": true, "NUL": true,
+	"COM1": true, "COM2": true, "COM3": true, "COM4": true, "COM5": true,
+	"COM6": true, "COM7": true, "COM8": true, "COM9": true,
+	"LPT1": true, "LPT2": true, "LPT3": true, "LPT4": true, "LPT5": true,
+	"LPT6": true, "LPT7": true, "LPT8": true, "LPT9": true,
+}
+
+// asciiFolds spells out the letters without a plain ASCII base letter and
+// the punctuation commonly found in titles. Other accented letters lose their
+// accents by decomposition.
+var asciiFolds = map[rune]string{
+	'ß': "ss", 'Æ': "AE", 'æ': "ae", 'Œ': "OE", 'œ': "oe", 'Ø': "O", 'ø': "o",
+	'Ł': "L", 'ł': "l", 'Đ': "D", 'đ': "d", 'Ð': "D", 'ð': "d", 'Þ': "Th",
+	'þ': "th", 'ı': "i", 'Ħ': "H", 'ħ': "h",
+	'‘': "'", '’': "'", '‚': "'", '“': "'", '”': "'", '„': "'", '«': "'", '»': "'",
+	'–': "-", '—': "-", '‐': "-", '…': "...", '×': "x", ' ': " ",
+}
+
+// latinBases maps the precomposed Latin letters with diacritics to their
+// base letter, covering Latin-1 and Latin Extended-A.
+var latinBases = map[rune]rune{}
+
+func init() {
+	for base, letters := range map[rune]string{
+		'A': "ÀÁÂÃÄÅĀĂĄ", 'a': "àáâãäåāăą",
+		'C': "ÇĆĈĊČ", 'c': "çćĉċč",
+		'D': "Ď", 'd': "ď",
+		'E': "ÈÉÊËĒĔĖĘĚ", 'e': "èéêëēĕėęě",
+		'G': "ĜĞĠĢ", 'g': "ĝğġģ",
+		'H': "Ĥ", 'h': "ĥ",
+		'I': "ÌÍÎÏĨĪĬĮİ", 'i': "ìíîïĩīĭį",
+		'J': "Ĵ", 'j': "ĵ",
+		'K': "Ķ", 'k': "ķ",
+		'L': "ĹĻĽĿ", 'l': "ĺļľŀ",
+		'N': "ÑŃŅŇ", 'n': "ñńņň",
+		'O': "ÒÓÔÕÖŌŎŐ", 'o': "òóôõöōŏő",
+		'R': "ŔŖŘ", 'r': "ŕŗř",
+		'S': "ŚŜŞŠ", 's': "śŝşš",
+		'T': "ŢŤŦ", 't': "ţťŧ",
+		'U': "ÙÚÛÜŨŪŬŮŰŲ", 'u': "ùúûüũūŭůűų",
+		'W': "Ŵ", 'w': "ŵ",
+		'Y': "ÝŶŸ", 'y': "ýÿŷ",
+		'Z': "ŹŻŽ", 'z': "źżž",
+	} {
+		for _, r := range letters {
+			latinBases[r] = base
+		}
+	}
+}
+
+// latinMarks lists, for each combining mark, the base letters it composes
+// with and the precomposed letters they make, in the same order.
+var latinMarks = map[rune][2]string{
+	'\u0300': {"AEIOUaeiou", "ÀÈÌÒÙàèìòù"},
+	'\u0301': {"ACEILNORSUYZacegilnorsuyz", "ÁĆÉÍĹŃÓŔŚÚÝŹáćéǵíĺńóŕśúýź"},
+	'\u0302': {"ACEGHIJOSUWYaceghijosuwy", "ÂĈÊĜĤÎĴÔŜÛŴŶâĉêĝĥîĵôŝûŵŷ"},
+	'\u0303': {"AINOUainou", "ÃĨÑÕŨãĩñõũ"},
+	'\u0304': {"AEIOUaeiou", "ĀĒĪŌŪāēīōū"},
+	'\u0306': {"AEGIOUaegiou", "ĂĔĞĬŎŬăĕğĭŏŭ"},
+	'\u0307': {"CEGIZcegz", "ĊĖĠİŻċėġż"},
+	'\u0308': {"AEIOUYaeiouy", "ÄËÏÖÜŸäëïöüÿ"},
+	'\u030a': {"AUau", "ÅŮåů"},
+	'\u030b': {"OUou", "ŐŰőű"},
+	'\u030c': {"CDENRSTZcdenrstz", "ČĎĚŇŘŠŤŽčďěňřšťž"},
+	'\u0327': {"CGKLNRSTcgklnrst", "ÇĢĶĻŅŖŞŢçģķļņŗşţ"},
+	'\u0328': {"AEIUaeiu", "ĄĘĮŲąęįų"},
+}
+
+// compositions maps a base letter and the combining mark after it to the
+// precomposed letter.
+var compositions = map[[2]rune]rune{}
+
+func init() {
+	for mark, letters := range latinMarks {
+		bases, composed := []rune(letters[0]), []rune(letters[1])
+		for i, base := range bases {
+			compositions[[2]rune{base, mark}] = composed[i]
+		}
+	}
+
+	// Kana and their voiced forms are next to each other
+	for _, base := range "かきくけこさしすせそたちつてとはひふへほカキクケコサシスセソタチツテトハヒフヘホ" {
+		compositions[[2]rune{base, '\u3099'}] = base + 1
+	}
+	for _, base := range "はひふへほハヒフヘホ" {
+		compositions[[2]rune{base, '\u309a'}] = base + 2
+	}
+	compositions[[2]rune{'う', '\u3099'}] = 'ゔ'
+	compositions[[2]rune{'ウ', '\u3099'}] = 'ヴ'
+}
+
+// Hangul syllables are composed from their jamo arithmetically.
+const (
+	hangulBase   = 0xac00
+	hangulLBase  = 0x1100
+	hangulVBase  = 0x1161
+	hangulTBase  = 0x11a7
+	hangulLCount = 19
+	hangulVCount = 21
+	hangulTCount = 28
+)
+
+// composeNFC composes the decomposed letters macOS file names and some
+// tracklists are written with, accented Latin letters, voiced kana and
+// Hangul syllables, so a name looks the same whichever form its title came
+// in. It covers those cases of Unicode NFC rather than all of it.
+func composeNFC(s string) string {
+	if isASCII(s) {
+		return s
+	}
+
+	rs := []rune(s)
+	out := make([]rune, 0, len(rs))
+	for _, r := range rs {
+		if n := len(out); n > 0 {
+			last := out[n-1]
+			if c, ok := compositions[[2]rune{last, r}]; ok {
+				out[n-1] = c
+				continue
+			}
+
+			l, v := last-hangulLBase, r-hangulVBase
+			if l >= 0 && l < hangulLCount && v >= 0 && v < hangulVCount {
+				out[n-1] = hangulBase + (l*hangulVCount+v)*hangulTCount
+				continue
+			}
+
+			lv, t := last-hangulBase, r-hangulTBase
+			if lv >= 0 && lv < hangulLCount*hangulVCount*hangulTCount && lv%hangulTCount == 0 && t > 0 && t < hangulTCount {
+				out[n-1] = last + t
+				continue
+			}
+		}
+		out = append(out, r)
+	}
+	return string(out)
+}
+
+func isASCII(s string) bool {
+	for i := 0; i < len(s); i++ {
+		if s[i] >= utf8.RuneSelf {
+			return false
+		}
+	}
+	return true
+}
+
+// transliterate returns s in plain ASCII. Characters without a spelling in
+// ASCII are dropped.
+func transliterate(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		switch {
+		case r < utf8.RuneSelf:
+			b.WriteRune(r)
+		case asciiFolds[r] != "":
+			b.WriteString(asciiFolds[r])
+		case latinBases[r] != 0:
+			b.WriteRune(latinBases[r])
+		case unicode.IsSpace(r):
+			b.WriteByte(' ')
+		}
+	}
+	return b.String()
+}
+
+// sanitizeChars composes s, replaces the characters that can't appear in a
+// name, and with ascii transliterates the rest.
+func sanitizeChars(s string, ascii bool) string {
+	s = composeNFC(s)
+	if ascii {
+		s = transliterate(s)
+	}
+
+	s = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, s)
+	return invalidNameChars.Replace(s)
+}
+
+// sanitizeName returns s as a safe file or directory name: without invalid
+// characters, leading dots that would hide it, trailing dots and spaces that
+// Windows drops, or reserved device names, and no longer than maxNameBytes
+// with its extension kept. An empty name stays empty so it drops out of a
+// joined path.
+func sanitizeName(s string, ascii bool) string {
+	if s == "" {
+		return ""
+	}
+
+	s = sanitizeChars(s, ascii)
+	s = strings.TrimLeft(strings.TrimSpace(s), ".")
+	s = strings.TrimRight(s, ". ")
+	if s == "" {
+		return "_"
+	}
+
+	stem := s
+	if i := strings.IndexByte(s, '.'); i > 0 {
+		stem = s[:i]
+	}
+	if reservedNames[strings.ToUpper(stem)] {
+		s = "_" + s
+	}
+
+	if len(s) <= maxNameBytes {
+		return s
+	}
+
+	ext := filepath.Ext(s)
+	if len(ext) > 16 {
+		ext = ""
+	}
+
+	return strings.TrimRight(truncateName(s, maxNameBytes-len(ext)), ". ") + ext
+}
+
+// zeroWidthJoiner joins emoji into one, such as a family from its people.
+const zeroWidthJoiner = '\u200d'
+
+// truncateName cuts s to at most n bytes without splitting a character, or
+// what shows as one: a letter from its combining accents, or an emoji from
+// its modifiers, variation selector or the emoji it is joined to.
+func truncateName(s string, n int) string {
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+
+	for n > 0 {
+		next, _ := utf8.DecodeRuneInString(s[n:])
+		prev, size := utf8.DecodeLastRuneInString(s[:n])
+		if !unicode.Is(unicode.Mn, next) && !unicode.Is(unicode.Me, next) &&
+			!(next >= 0x1f3fb && next <= 0x1f3ff) && next != zeroWidthJoiner && prev != zeroWidthJoiner &&
+			!(isRegionalIndicator(next) && regionalIndicatorsBefore(s[:n])%2 == 1) {
+			break
+		}
+		n -= size
+	}
+	return s[:n]
+}
+
+// isRegionalIndicator reports whether r is half of a flag emoji.
+func isRegionalIndicator(r rune) bool {
+	return r >= 0x1f1e6 && r <= 0x1f1ff
+}
+
+func regionalIndicatorsBefore(s string) int {
+	count := 0
+	for len(s) > 0 {
+		r, size := utf8.DecodeLastRuneInString(s)
+		if !isRegionalIndicator(r) {
+			break
+		}
+		count++
+		s = s[:len(s)-size]
+	}
+	return count
+}
+
+// sanitizePath sanitizes each name in a path, which may use slashes as the
+// separator on any system. The volume and root of an absolute path are kept
+// as the user chose them.
+func sanitizePath(p string, ascii bool) string {
+	p = filepath.Clean(filepath.FromSlash(p))
+	sep := string(filepath.Separator)
+
+	root := filepath.VolumeName(p)
+	p = p[len(root):]
+	if strings.HasPrefix(p, sep) {
+		root, p = root+sep, strings.TrimLeft(p, sep)
+	}
+
+	parts := strings.Split(p, sep)
+	for i, part := range parts {
+		if part == "." || part == ".." {
+			continue
+		}
+		parts[i] = sanitizeName(part, ascii)
+	}
+	return root + filepath.Join(parts...)
+}
@@ -0,0 +1,55 @@
+package avsplit
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestSanitizeName(t *testing.T) {
+	tests := []struct {
+		in, want string
+	}{
+		{"AC/DC", "AC-DC"},
+		{`AC\DC`, "AC-DC"},
+		{`What? <Live> "x"`, "What_ _Live_ 'x'"},
+		{".hidden", "hidden"},
+		{"trailing. ", "trailing"},
+		{"CON", "_CON"},
+		{"nul.mp3", "_nul.mp3"},
+		{"...", "_"},
+		{"Cafe\u0301", "Caf\u00e9"},
+		{"\u1112\u1161\u11ab", "\ud55c"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := sanitizeName(tt.in, false); got != tt.want {
+			t.Errorf("sanitizeName(%q) = %q, want %q", tt.in, got, tt.want)
+		}
+	}
+}
+
+func TestSanitizePath(t *testing.T) {
+	tests := []struct {
+		name, in, want string
+	}{
+		{"slashes", "A/.B/01 - C.mp3", filepath.Join("A", "B", "01 - C.mp3")},
+		{"native separators", filepath.Join("A", "CON", "01.mp3"), filepath.Join("A", "_CON", "01.mp3")},
+		{"absolute", "/music/A/01.mp3", filepath.FromSlash("/music/A/01.mp3")},
+	}
+
+	if filepath.Separator == '\\' {
+		tests = append(tests, struct{ name, in, want string }{"drive", `C:\Music\A:B\01.mp3`, `C:\Music\A-B\01.mp3`})
+	} else {
+		// Only a separator on Windows, elsewhere part of the name
+		tests = append(tests, struct{ name, in, want string }{"backslash", `A\B/01.mp3`, "A-B/01.mp3"})
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := sanitizePath(tt.in, false); got != tt.want {
+				t.Errorf("sanitizePath(%q) = %q, want %q", tt.in, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,64 @@
+package avsplit
+
+import (
+	"fmt"
+	"sort"
+	"time"
+)
+
+// implausibleFactor is how many times longer than the median a track has to
+// be before it is reported as a likely typo.
+const implausibleFactor = 10
+
+// sanityCheck returns a warning for each track whose duration is implausibly
+// long compared to the rest of the tracklist, the median of the other
+// tracks. Left out of it, a typo in one of two tracks still stands out
+// against the other, where the median of both would be half its length.
+func sanityCheck(tracks []Track, total time.Duration) ([]string, error) {
+	if len(tracks) < 2 {
+		// Nothing to compare it with
+		return nil, nil
+	}
+
+	durations := make([]time.Duration, len(tracks))
+	for i := range tracks {
+		d, err := tracks[i].duration(total)
+		if err != nil {
+			return nil, err
+		}
+		durations[i] = d
+	}
+
+	sorted := append([]time.Duration(nil), durations...)
+	sort.Slice(sorted, func(i, j int) bool { return sorted[i] < sorted[j] })
+
+	var warnings []string
+	for i, d := range durations {
+		median := medianWithout(sorted, sort.Search(len(sorted), func(k int) bool { return sorted[k] >= d }))
+		if median > 0 && d > median*implausibleFactor {
+			warnings = append(warnings, fmt.Sprintf(
+				"track %d \"%v\" is %v long, the median of the other tracks is %v",
+				tracks[i].Number, tracks[i].Title, d, median,
+			))
+		}
+	}
+
+	return warnings, nil
+}
+
+// medianWithout returns the median of the sorted durations without the
+// one at skip.
+func medianWithout(sorted []time.Duration, skip int) time.Duration {
+	at := func(k int) time.Duration {
+		if k >= skip {
+			k++
+		}
+		return sorted[k]
+	}
+
+	n := len(sorted) - 1
+	if n%2 == 1 {
+		return at(n / 2)
+	}
+	return (at(n/2-1) + at(n/2)) / 2
+}
@@ -0,0 +1,127 @@
+package avsplit
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shellQuote quotes s for a POSIX shell.
+func shellQuote(s string) string {
+	if s != "" && strings.Trim(s, "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789-_=./:,+") == "" {
+		return s
+	}
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+func shellCommand(c string, arg ...string) string {
+	quoted := []string{shellQuote(c)}
+	for _, a := range arg {
+		quoted = append(quoted, shellQuote(a))
+	}
+	return strings.Join(quoted, " ")
+}
+
+// splitArgs splits s into arguments the way a POSIX shell would, honouring
+// single and double quotes and backslashes, so "-af 'volume=2, bass=g=3'" is
+// two arguments.
+func splitArgs(s string) ([]string, error) {
+	var args []string
+	var arg strings.Builder
+	inArg := false
+	var quote rune
+	escaped := false
+
+	for _, r := range s {
+		switch {
+		case escaped:
+			arg.WriteRune(r)
+			escaped = false
+		case quote == '\'':
+			if r == '\'' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\\':
+			escaped, inArg = true, true
+		case quote == '"':
+			if r == '"' {
+				quote = 0
+			} else {
+				arg.WriteRune(r)
+			}
+		case r == '\'' || r == '"':
+			quote, inArg = r, true
+		case r == ' ' || r == '\t' || r == '\n':
+			if inArg {
+				args = append(args, arg.String())
+				arg.Reset()
+				inArg = false
+			}
+		default:
+			arg.WriteRune(r)
+			inArg = true
+		}
+	}
+
+	if quote != 0 || escaped {
+		return nil, fmt.Errorf("unterminated quote or escape in %v", s)
+	}
+	if inArg {
+		args = append(args, arg.String())
+	}
+	return args, nil
+}
+
+// writeScript writes the commands a run would execute as a standalone shell
+// script.
+func writeScript(filename string, opts Options, tracks []Track) error {
+	if opts.Tagger != "eyed3" && opts.Tagger != "ffmpeg" {
+		// Picked for the output format when eyed3 can't tag it
+		opts.logf("warning: the %v tagger can't run from a script, only the tags ffmpeg writes are kept\n", opts.Tagger)
+	}
+
+	var b strings.Builder
+	b.WriteString("#!/bin/sh\nset -e\n")
+	// Read by eyed3, see execExecutor
+	b.WriteString("export PYTHONUTF8=1\n")
+
+	dirs := make(map[string]bool)
+	for _, t := range tracks {
+		out := t.outputFilename(opts.Filename)
+		b.WriteString("\n")
+
+		if dir := filepath.Dir(out); !dirs[dir] {
+			dirs[dir] = true
+			fmt.Fprintln(&b, shellCommand("mkdir", "-p", argPath(dir)))
+		}
+
+		c, args := opts.throttle(opts.FFmpegPath, t.ffmpegArgs(opts.Filename, opts.overwriteFlag()))
+		fmt.Fprintln(&b, shellCommand(c, args...))
+		if opts.Tagger == "eyed3" && t.ext(opts.Filename) == ".mp3" {
+			fmt.Fprintln(&b, shellCommand("eyed3", t.eyeD3Args(t.outputFilename(opts.Filename))...))
+		}
+
+		if opts.PreserveMtime {
+			fmt.Fprintln(&b, shellCommand("touch", "-r", argPath(opts.Filename), argPath(out)))
+		} else if !opts.Date.IsZero() {
+			fmt.Fprintln(&b, shellCommand("touch", "-t", opts.Date.Local().Format("200601021504.05"), argPath(out)))
+		}
+
+		if opts.PostCmd != "" {
+			fmt.Fprintln(&b, shellCommand("env", hookArgs(opts.PostCmd, t.trackHookEnv(opts))...))
+		}
+	}
+
+	if opts.PostAlbumCmd != "" && len(tracks) > 0 {
+		fmt.Fprintf(&b, "\n%v\n", shellCommand("env", hookArgs(opts.PostAlbumCmd, albumHookEnv(opts, tracks))...))
+	}
+
+	if err := os.WriteFile(filename, []byte(b.String()), 0700); err != nil {
+		return fmt.Errorf("cannot write script file")
+	}
+
+	return nil
+}
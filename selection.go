@@ -0,0 +1,108 @@
+package avsplit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// trackSelection is the ranges of track numbers of a selection, each from
+// its first to its last number, a single track being a range of one. They
+// are kept as ranges so "1-999999999" costs no more than "1-9".
+type trackSelection [][2]int
+
+// has reports whether the track number n is selected.
+func (s trackSelection) has(n int) bool {
+	for _, r := range s {
+		if n >= r[0] && n <= r[1] {
+			return true
+		}
+	}
+	return false
+}
+
+// parseTrackSelection parses a list of track numbers and ranges such as
+// "3,5-9".
+func parseTrackSelection(s string) (trackSelection, error) {
+	var selected trackSelection
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		from, to := part, part
+		if i := strings.IndexByte(part, '-'); i >= 0 {
+			from, to = part[:i], part[i+1:]
+		}
+
+		first, err := strconv.Atoi(strings.TrimSpace(from))
+		if err != nil || first < 0 {
+			return nil, fmt.Errorf("invalid tracks %v", s)
+		}
+
+		last, err := strconv.Atoi(strings.TrimSpace(to))
+		if err != nil || last < first {
+			return nil, fmt.Errorf("invalid tracks %v", s)
+		}
+
+		selected = append(selected, [2]int{first, last})
+	}
+	return selected, nil
+}
+
+// selectTracks returns the tracks whose numbers are in the selection. The
+// tracks keep their numbers, total and boundaries from the full list.
+func selectTracks(tracks Tracklist, selection string) (Tracklist, error) {
+	selected, err := parseTrackSelection(selection)
+	if err != nil {
+		return nil, err
+	}
+
+	var out Tracklist
+	found := make(map[int]bool)
+	for _, t := range tracks {
+		if selected.has(t.Number) {
+			out = append(out, t)
+			found[t.Number] = true
+		}
+	}
+
+	// A range with a track missing has no more numbers before the gap than
+	// there are tracks, so this stops well short of its end
+	for _, r := range selected {
+		for n := r[0]; n <= r[1]; n++ {
+			if !found[n] {
+				return nil, fmt.Errorf("no track %d, there are %d tracks", n, len(tracks))
+			}
+		}
+	}
+
+	return out, nil
+}
+
+// renumberTracks numbers the tracks left after a selection by policy:
+// "original" keeps the numbers and total of the full list, "renumber" counts
+// them again from 1, or from trackStart, on each disc.
+func renumberTracks(tracks Tracklist, policy string, trackStart int) error {
+	switch policy {
+	case "original":
+		return nil
+	case "renumber":
+	default:
+		return fmt.Errorf("unknown numbering %v, must be original or renumber", policy)
+	}
+
+	offset := 0
+	if trackStart > 1 {
+		offset = trackStart - 1
+	}
+
+	counts := make(map[int]int)
+	for i := range tracks {
+		t := &tracks[i]
+		counts[t.Disc]++
+		t.Number = counts[t.Disc] + offset
+	}
+
+	for i := range tracks {
+		tracks[i].Total = counts[tracks[i].Disc] + offset
+	}
+	return nil
+}
@@ -0,0 +1,705 @@
+package avsplit
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxUploadBytes is the largest request, with its audio file, Serve takes.
+const maxUploadBytes = 8 << 30
+
+// jobRequest is the body of a POST to /jobs, or its "request" field when
+// the audio file is uploaded with it. The tags and settings override the
+// server's own.
+type jobRequest struct {
+	Source          string `json:"source,omitempty"`
+	Tracklist       string `json:"tracklist,omitempty"`
+	TracklistFormat string `json:"tracklist_format,omitempty"`
+	Chapters        bool   `json:"chapters,omitempty"`
+	FLACCue         bool   `json:"flac_cue,omitempty"`
+	Artist          string `json:"artist,omitempty"`
+	Album           string `json:"album,omitempty"`
+	Year            string `json:"year,omitempty"`
+	Genre           string `json:"genre,omitempty"`
+	Format          string `json:"format,omitempty"`
+	Codec           string `json:"codec,omitempty"`
+	Bitrate         string `json:"bitrate,omitempty"`
+	Quality         string `json:"quality,omitempty"`
+	Normalize       string `json:"normalize,omitempty"`
+	Tracks          string `json:"tracks,omitempty"`
+
+	// Titles are the titles of the tracklist's tracks as edited in the page
+	// of Web, over those of the tracklist. An empty one keeps the title.
+	Titles []string `json:"titles,omitempty"`
+}
+
+// job is a split submitted to Serve or an entry of a batch run with a jobs
+// directory, its state one of queued, running, done, failed or canceled.
+type job struct {
+	ID       string     `json:"id"`
+	State    string     `json:"state"`
+	Source   string     `json:"source"`
+	Created  time.Time  `json:"created"`
+	Finished *time.Time `json:"finished,omitempty"`
+	Tracks   int        `json:"tracks"`
+	Done     int        `json:"done"`
+	Track    int        `json:"track,omitempty"`
+	Percent  *float64   `json:"percent,omitempty"`
+	ETA      *float64   `json:"eta,omitempty"`
+	Files    []string   `json:"files,omitempty"`
+	Warnings []string   `json:"warnings,omitempty"`
+	Error    string     `json:"error,omitempty"`
+
+	// What to run again after a restart, the request of a Serve job or the
+	// entry of a batch's manifest
+	Request  *jobRequest `json:"request,omitempty"`
+	Manifest string      `json:"manifest,omitempty"`
+	Entry    int         `json:"entry,omitempty"`
+
+	dir    string
+	opts   Options
+	cancel context.CancelFunc
+}
+
+// jobServer is the HTTP API of Serve. Its jobs run one at a time, each with
+// the server's options and the request's over them.
+type jobServer struct {
+	mu   sync.Mutex
+	opts Options
+	dir  string
+	jobs map[string]*job
+	run  chan struct{}
+	ctx  context.Context
+}
+
+// Serve runs an HTTP API on addr to submit splits, follow their progress
+// and fetch their tracks, until ctx is done:
+//
+//	POST   /jobs                  submit a split, as JSON or a multipart upload
+//	GET    /jobs                  list the jobs
+//	GET    /jobs/{id}             get a job's state, progress and files
+//	GET    /jobs/{id}/files/{f}   download a track
+//	DELETE /jobs/{id}             cancel a job
+//	POST   /jobs/{id}/retry       run a failed or canceled job again
+//
+// Each job's state, upload, tracklist and tracks are kept in a directory of
+// its own under opts.JobsDir. The jobs left queued or running by an earlier
+// Serve are run again, skipping the tracks already split, and the cancel
+// and retry requests of "avsplit jobs" are picked up as they come.
+func Serve(ctx context.Context, addr string, opts Options) error {
+	opts = opts.withDefaults()
+	s, err := newJobServer(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return listenAndServe(ctx, opts, addr, s)
+}
+
+// newJobServer returns the job server of Serve and Web, with the jobs of an
+// earlier one restored.
+func newJobServer(ctx context.Context, opts Options) (*jobServer, error) {
+	if err := checkTools(opts.withOptions(ctx), opts); err != nil {
+		return nil, withKind(ErrMissingTool, err)
+	}
+
+	dir := opts.JobsDir
+	if dir == "" {
+		dir = "avsplit-jobs"
+	}
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return nil, fmt.Errorf("cannot create jobs directory: %v", err)
+	}
+
+	s := &jobServer{
+		opts: opts,
+		dir:  dir,
+		jobs: make(map[string]*job),
+		run:  make(chan struct{}, 1),
+		ctx:  ctx,
+	}
+	if err := s.restore(); err != nil {
+		return nil, err
+	}
+	go s.sync()
+	return s, nil
+}
+
+// listenAndServe serves h on addr until ctx is done, to the requests that
+// checkOrigin lets through.
+func listenAndServe(ctx context.Context, opts Options, addr string, h http.Handler) error {
+	l, err := net.Listen("tcp", addr)
+	if err != nil {
+		return err
+	}
+
+	host, _, _ := net.SplitHostPort(addr)
+	srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if err := checkOrigin(r, host, l.Addr()); err != nil {
+			httpError(w, http.StatusForbidden, err.Error())
+			return
+		}
+		h.ServeHTTP(w, r)
+	})}
+	go func() {
+		<-ctx.Done()
+		srv.Shutdown(context.Background())
+	}()
+
+	opts.logf("serving on http://%v\n", l.Addr())
+	if err := srv.Serve(l); err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+func (s *jobServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	if parts[0] != "jobs" {
+		httpError(w, http.StatusNotFound, "not found")
+		return
+	}
+
+	switch {
+	case len(parts) == 1 && r.Method == http.MethodPost:
+		s.submit(w, r)
+	case len(parts) == 1 && r.Method == http.MethodGet:
+		s.list(w)
+	case len(parts) == 2 && r.Method == http.MethodGet:
+		s.withJob(w, parts[1], func(j *job) { writeJSON(w, http.StatusOK, j) })
+	case len(parts) == 2 && r.Method == http.MethodDelete:
+		s.withJob(w, parts[1], func(j *job) {
+			if j.State == "queued" || j.State == "running" {
+				j.cancel()
+			}
+			writeJSON(w, http.StatusOK, j)
+		})
+	case len(parts) == 3 && parts[2] == "retry" && r.Method == http.MethodPost:
+		s.withJob(w, parts[1], func(j *job) {
+			if j.State != "failed" && j.State != "canceled" {
+				httpError(w, http.StatusConflict, fmt.Sprintf("job %v is %v, only failed and canceled jobs can be retried", j.ID, j.State))
+				return
+			}
+			s.retry(j)
+			writeJSON(w, http.StatusAccepted, j)
+		})
+	case len(parts) > 3 && parts[2] == "files" && r.Method == http.MethodGet:
+		s.file(w, r, parts[1], filepath.Join(parts[3:]...))
+	case len(parts) <= 2 || (len(parts) == 3 && parts[2] == "retry") || (len(parts) > 3 && parts[2] == "files"):
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		httpError(w, http.StatusNotFound, "not found")
+	}
+}
+
+// checkOrigin refuses the requests another web site has a browser send.
+// The Host must be the one the server listens on, host as -listen names it
+// and addr where it listens, so a site whose name is pointed at the server
+// can't reach it, and the Origin, which browsers send with every cross-site
+// POST and WebSocket, must be the Host. Clients that aren't browsers send
+// no Origin. Listening on all interfaces, as on a NAS, any Host is taken.
+func checkOrigin(r *http.Request, host string, addr net.Addr) error {
+	h, port, err := net.SplitHostPort(r.Host)
+	if err != nil {
+		h, port = r.Host, "80"
+	}
+	h = strings.ToLower(strings.Trim(h, "[]"))
+
+	tcp, _ := addr.(*net.TCPAddr)
+	switch {
+	case tcp != nil && tcp.IP.IsUnspecified():
+	case tcp == nil || port != fmt.Sprint(tcp.Port):
+		return fmt.Errorf("host %v is not served here", r.Host)
+	case h == strings.ToLower(host), net.ParseIP(h).Equal(tcp.IP):
+	case tcp.IP.IsLoopback() && (h == "localhost" || net.ParseIP(h).IsLoopback()):
+	default:
+		return fmt.Errorf("host %v is not served here", r.Host)
+	}
+	return sameOrigin(r)
+}
+
+// sameOrigin checks the Origin of r, if a browser sent one, is its Host.
+func sameOrigin(r *http.Request) error {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		return nil
+	}
+	u, err := url.Parse(origin)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") || !strings.EqualFold(u.Host, r.Host) {
+		return fmt.Errorf("cross-origin requests from %v are not allowed", origin)
+	}
+	return nil
+}
+
+// hasContentType reports whether r has one of the content types, a form a
+// web site can't post across sites unless it is multipart/form-data.
+func hasContentType(r *http.Request, types ...string) bool {
+	t, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil {
+		return false
+	}
+	for _, v := range types {
+		if t == v {
+			return true
+		}
+	}
+	return false
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func httpError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, map[string]string{"error": msg})
+}
+
+// withJob calls f with the job id holding the server's lock, so f sees and
+// writes a consistent job.
+func (s *jobServer) withJob(w http.ResponseWriter, id string, f func(*job)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		httpError(w, http.StatusNotFound, "no job "+id)
+		return
+	}
+	f(j)
+}
+
+func (s *jobServer) list(w http.ResponseWriter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	jobs := make([]*job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		jobs = append(jobs, j)
+	}
+	sort.Slice(jobs, func(i, k int) bool { return jobs[i].Created.Before(jobs[k].Created) })
+	writeJSON(w, http.StatusOK, jobs)
+}
+
+func (s *jobServer) file(w http.ResponseWriter, r *http.Request, id, name string) {
+	s.mu.Lock()
+	j, ok := s.jobs[id]
+	found := false
+	if ok {
+		for _, f := range j.Files {
+			found = found || filepath.FromSlash(f) == filepath.Clean(name)
+		}
+	}
+	s.mu.Unlock()
+
+	// Only the tracks a job wrote are served, nothing else of the disk
+	if !found {
+		httpError(w, http.StatusNotFound, "no file "+name)
+		return
+	}
+	http.ServeFile(w, r, filepath.Join(j.opts.OutputDir, name))
+}
+
+// submit creates a job from the request and queues it.
+func (s *jobServer) submit(w http.ResponseWriter, r *http.Request) {
+	if !hasContentType(r, "application/json", "multipart/form-data") {
+		httpError(w, http.StatusUnsupportedMediaType, "a job is submitted as application/json or multipart/form-data")
+		return
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxUploadBytes)
+
+	id, err := newJobID()
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	dir := filepath.Join(s.dir, id)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	req, source, err := readJobRequest(r, dir)
+	if err == nil && req.Source == source {
+		// Not uploaded, so a file on the server's disk
+		req.Source, err = s.sourcePath(req.Source)
+	}
+	var opts Options
+	if err == nil {
+		opts, err = s.jobOptions(req, dir)
+	}
+	if err != nil {
+		os.RemoveAll(dir)
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	j := &job{
+		ID:      id,
+		State:   "queued",
+		Source:  source,
+		Created: time.Now().UTC(),
+		Request: &req,
+		dir:     dir,
+		opts:    opts,
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.jobs[id] = j
+
+	s.save(j)
+	s.start(j)
+	writeJSON(w, http.StatusAccepted, j)
+}
+
+// readJobRequest reads the job requested by r, saving an uploaded audio file
+// into dir as the request's source. It returns the request and the source
+// to show, the name of the upload rather than where it was saved.
+func readJobRequest(r *http.Request, dir string) (jobRequest, string, error) {
+	var req jobRequest
+	if !hasContentType(r, "multipart/form-data") {
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			return req, "", fmt.Errorf("invalid request: %v", err)
+		}
+		return req, req.Source, nil
+	}
+
+	if err := r.ParseMultipartForm(32 << 20); err != nil {
+		return req, "", fmt.Errorf("invalid upload: %v", err)
+	}
+
+	if v := r.FormValue("request"); v != "" {
+		if err := json.Unmarshal([]byte(v), &req); err != nil {
+			return req, "", fmt.Errorf("invalid request: %v", err)
+		}
+	}
+	if v := r.FormValue("tracklist"); v != "" {
+		req.Tracklist = v
+	}
+	if files := r.MultipartForm.File["tracklist"]; len(files) > 0 {
+		// Uploaded as a file rather than a field
+		text, err := readUpload(files[0])
+		if err != nil {
+			return req, "", err
+		}
+		req.Tracklist = text
+	}
+
+	source := req.Source
+	if files := r.MultipartForm.File["audio"]; len(files) > 0 {
+		source = filepath.Base(files[0].Filename)
+		req.Source = filepath.Join(dir, sanitizeName(source, false))
+		if err := saveUpload(files[0], req.Source); err != nil {
+			return req, "", err
+		}
+	}
+	return req, source, nil
+}
+
+// sourcePath returns the file source of a job names, relative to the
+// SourceRoot it must be in. Without a SourceRoot, or for a URL, the request
+// would have the server read whatever the client asks for.
+func (s *jobServer) sourcePath(source string) (string, error) {
+	if source == "" {
+		return "", nil
+	}
+	if s.opts.SourceRoot == "" {
+		return "", fmt.Errorf("this server only splits uploaded audio files, see -source-root")
+	}
+	if isURL(source) {
+		return "", fmt.Errorf("this server only splits uploaded audio files and those under its source root")
+	}
+
+	root, err := filepath.EvalSymlinks(s.opts.SourceRoot)
+	if err != nil {
+		return "", fmt.Errorf("cannot read the source root: %v", err)
+	}
+	path := source
+	if !filepath.IsAbs(path) {
+		path = filepath.Join(root, path)
+	}
+	// Symlinks could lead out of it
+	if path, err = filepath.EvalSymlinks(path); err != nil {
+		return "", fmt.Errorf("source %v not found", source)
+	}
+	if rel, err := filepath.Rel(root, path); err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("source %v is not under the source root", source)
+	}
+	return path, nil
+}
+
+// jobOptions returns the options of the job req, writing its tracklist into
+// dir.
+func (s *jobServer) jobOptions(req jobRequest, dir string) (Options, error) {
+	opts := s.opts
+	if req.Source == "" {
+		return opts, fmt.Errorf("source or an uploaded audio file is required")
+	}
+	opts.Filename = req.Source
+	opts.Filenames = nil
+
+	switch {
+	case req.Tracklist != "":
+		opts.Timecodes = filepath.Join(dir, "tracklist.txt")
+		if err := os.WriteFile(opts.Timecodes, []byte(req.Tracklist), 0644); err != nil {
+			return opts, err
+		}
+		opts.TimecodesFormat = req.TracklistFormat
+	case req.Chapters:
+		opts.FromChapters = true
+	case req.FLACCue:
+		opts.FLACCue = true
+	default:
+		return opts, fmt.Errorf("tracklist, chapters or flac_cue is required")
+	}
+
+	for _, f := range []struct {
+		v string
+		p *string
+	}{
+		{req.Artist, &opts.Artist},
+		{req.Album, &opts.Album},
+		{req.Year, &opts.Year},
+		{req.Genre, &opts.Genre},
+		{req.Format, &opts.Format},
+		{req.Codec, &opts.Codec},
+		{req.Bitrate, &opts.Bitrate},
+		{req.Quality, &opts.Quality},
+		{req.Normalize, &opts.Normalize},
+		{req.Tracks, &opts.Tracks},
+	} {
+		if f.v != "" {
+			*f.p = f.v
+		}
+	}
+
+	opts.OutputDir = filepath.Join(dir, "tracks")
+	opts.JSON, opts.Progress = true, true
+	opts.Review, opts.DryRun, opts.Script, opts.Output = nil, false, "", ""
+	if len(req.Titles) > 0 {
+		opts.Review = retitle(req.Titles)
+	}
+	return opts, nil
+}
+
+// restore loads the jobs of an earlier Serve, queueing again those it left
+// queued or running.
+func (s *jobServer) restore() error {
+	jobs, err := readJobs(s.dir)
+	if err != nil {
+		return err
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, j := range jobs {
+		if j.Request == nil {
+			// A batch's, run again by the batch
+			continue
+		}
+
+		opts, err := s.jobOptions(*j.Request, j.dir)
+		if err != nil {
+			return fmt.Errorf("cannot restore job %v: %v", j.ID, err)
+		}
+		j.opts = opts
+		s.jobs[j.ID] = j
+
+		if !j.finished() {
+			s.opts.logf("resuming job %v\n", j.ID)
+			j.requeue()
+			s.start(j)
+		}
+	}
+	return nil
+}
+
+// sync picks up the cancel and retry requests "avsplit jobs" leaves in the
+// jobs' directories, until the server stops.
+func (s *jobServer) sync() {
+	t := time.NewTicker(time.Second)
+	defer t.Stop()
+
+	for {
+		select {
+		case <-s.ctx.Done():
+			return
+		case <-t.C:
+		}
+
+		s.mu.Lock()
+		for _, j := range s.jobs {
+			switch {
+			case !j.finished() && j.takeMarker(cancelMarker):
+				j.cancel()
+			case j.finished() && j.takeMarker(retryMarker):
+				s.retry(j)
+			}
+		}
+		s.mu.Unlock()
+	}
+}
+
+// retry queues the failed or canceled job j again. The lock must be held.
+func (s *jobServer) retry(j *job) {
+	j.requeue()
+	s.save(j)
+	s.start(j)
+}
+
+// start runs j once the jobs before it are done. The lock must be held.
+func (s *jobServer) start(j *job) {
+	ctx, cancel := context.WithCancel(s.ctx)
+	j.cancel = cancel
+	j.opts.Log = &jobLog{s: s, j: j}
+	go s.runJob(ctx, j)
+}
+
+// save saves the state of j, which is only logged when it fails as the job
+// goes on regardless. The lock must be held.
+func (s *jobServer) save(j *job) {
+	if err := j.save(); err != nil {
+		s.opts.logf("error: %v\n", err)
+	}
+}
+
+func saveUpload(fh *multipart.FileHeader, path string) error {
+	in, err := fh.Open()
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return fmt.Errorf("cannot save upload: %v", err)
+	}
+	return out.Close()
+}
+
+func readUpload(fh *multipart.FileHeader) (string, error) {
+	f, err := fh.Open()
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	b, err := io.ReadAll(f)
+	if err != nil {
+		return "", fmt.Errorf("cannot read upload: %v", err)
+	}
+	return string(b), nil
+}
+
+func newJobID() (string, error) {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// runJob waits for the jobs before it and splits, recording how it ended.
+func (s *jobServer) runJob(ctx context.Context, j *job) {
+	defer j.cancel()
+
+	select {
+	case s.run <- struct{}{}:
+		defer func() { <-s.run }()
+	case <-ctx.Done():
+		s.finish(ctx, j, ctx.Err())
+		return
+	}
+
+	s.mu.Lock()
+	if ctx.Err() == nil {
+		j.State = "running"
+		s.save(j)
+	}
+	s.mu.Unlock()
+
+	s.finish(ctx, j, Split(ctx, j.opts))
+}
+
+func (s *jobServer) finish(ctx context.Context, j *job, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j.Track, j.Percent, j.ETA = 0, nil, nil
+	if s.ctx.Err() != nil {
+		// The server is stopping, the job is left to the next one
+		j.State = "queued"
+		s.save(j)
+		return
+	}
+
+	now := time.Now().UTC()
+	j.Finished = &now
+	switch {
+	case ctx.Err() != nil:
+		j.State = "canceled"
+	case err != nil:
+		j.State, j.Error = "failed", err.Error()
+	default:
+		j.State = "done"
+	}
+	s.save(j)
+}
+
+// jobLog follows the JSON events of a job's split, one per Write, into its
+// progress and files.
+type jobLog struct {
+	s *jobServer
+	j *job
+}
+
+func (l *jobLog) Write(b []byte) (int, error) {
+	var e jsonEvent
+	if err := json.Unmarshal(b, &e); err != nil {
+		return len(b), nil
+	}
+
+	l.s.mu.Lock()
+	defer l.s.mu.Unlock()
+
+	j := l.j
+	switch e.Event {
+	case "plan":
+		j.Tracks = len(e.Tracks)
+		l.s.save(j)
+	case "progress":
+		j.Track, j.Percent, j.ETA = e.Track, e.Percent, e.ETA
+	case "track":
+		j.Done++
+		if rel, err := filepath.Rel(j.opts.OutputDir, e.File); err == nil {
+			j.Files = append(j.Files, filepath.ToSlash(rel))
+		}
+		l.s.save(j)
+	case "warning":
+		j.Warnings = append(j.Warnings, e.Message)
+	}
+	return len(b), nil
+}
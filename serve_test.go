@@ -0,0 +1,100 @@
+package avsplit
+
+import (
+	"net"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckOrigin(t *testing.T) {
+	loopback := &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 8090}
+	all := &net.TCPAddr{IP: net.IPv4zero, Port: 8090}
+
+	tests := []struct {
+		host, origin string
+		addr         *net.TCPAddr
+		ok           bool
+	}{
+		{"localhost:8090", "", loopback, true},
+		{"127.0.0.1:8090", "http://127.0.0.1:8090", loopback, true},
+		{"localhost:8090", "http://localhost:8090", loopback, true},
+		{"localhost:8090", "https://evil.example", loopback, false},
+		{"localhost:8090", "null", loopback, false},
+		{"localhost:8091", "", loopback, false},
+		{"evil.example:8090", "http://evil.example:8090", loopback, false},
+		{"nas.lan:8090", "http://nas.lan:8090", all, true},
+		{"nas.lan:8090", "http://evil.example", all, false},
+	}
+
+	for _, tt := range tests {
+		r := httptest.NewRequest("POST", "/jobs", nil)
+		r.Host = tt.host
+		if tt.origin != "" {
+			r.Header.Set("Origin", tt.origin)
+		}
+		if err := checkOrigin(r, "localhost", tt.addr); (err == nil) != tt.ok {
+			t.Errorf("checkOrigin(%v, %q, %v) = %v, want ok %v", tt.host, tt.origin, tt.addr, err, tt.ok)
+		}
+	}
+}
+
+func TestJobSourcePath(t *testing.T) {
+	root := t.TempDir()
+	if err := os.WriteFile(filepath.Join(root, "live.mp3"), nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+	outside := writeTestFile(t, "secret.mp3", "")
+
+	s := &jobServer{}
+	if _, err := s.sourcePath(outside); err == nil {
+		t.Errorf("sourcePath() without a source root succeeded")
+	}
+
+	s.opts.SourceRoot = root
+	for _, source := range []string{"live.mp3", filepath.Join(root, "live.mp3")} {
+		got, err := s.sourcePath(source)
+		if err != nil || filepath.Base(got) != "live.mp3" {
+			t.Errorf("sourcePath(%q) = %q, %v, want live.mp3 in the root", source, got, err)
+		}
+	}
+	for _, source := range []string{outside, filepath.Join("..", filepath.Base(outside)), "https://example.com/live.mp3"} {
+		if got, err := s.sourcePath(source); err == nil {
+			t.Errorf("sourcePath(%q) = %q, want an error", source, got)
+		}
+	}
+
+	// A plain POST, as any web site can send, is refused
+	r := httptest.NewRequest("POST", "/jobs", strings.NewReader(`{"source": "live.mp3"}`))
+	r.Header.Set("Content-Type", "text/plain")
+	w := httptest.NewRecorder()
+	s.submit(w, r)
+	if w.Code != 415 {
+		t.Errorf("submit() of text/plain = %v, want 415", w.Code)
+	}
+}
+
+func TestWebCrossOrigin(t *testing.T) {
+	s := &webServer{jobs: &jobServer{jobs: map[string]*job{"1": {ID: "1"}}}}
+
+	r := httptest.NewRequest("GET", "/jobs/1/events", nil)
+	r.Header.Set("Upgrade", "websocket")
+	r.Header.Set("Connection", "Upgrade")
+	r.Header.Set("Sec-WebSocket-Key", "dGhlIHNhbXBsZSBub25jZQ==")
+	r.Header.Set("Origin", "https://evil.example")
+	w := httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != 400 || !strings.Contains(w.Body.String(), "cross-origin") {
+		t.Errorf("websocket from another origin = %v %v, want refused", w.Code, w.Body)
+	}
+
+	r = httptest.NewRequest("POST", "/preview", strings.NewReader(`{"tracklist": "00:00 A\n"}`))
+	r.Header.Set("Content-Type", "text/plain")
+	w = httptest.NewRecorder()
+	s.ServeHTTP(w, r)
+	if w.Code != 415 {
+		t.Errorf("preview of text/plain = %v, want 415", w.Code)
+	}
+}
@@ -0,0 +1,179 @@
+package avsplit
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cueFileType returns the CUE sheet FILE type for a track's extension.
+func cueFileType(ext string) string {
+	switch ext {
+	case ".mp3":
+		return "MP3"
+	case ".aif", ".aiff":
+		return "AIFF"
+	default:
+		return "WAVE"
+	}
+}
+
+// cueQuote quotes a CUE sheet value, which can't hold double quotes.
+func cueQuote(s string) string {
+	return "\"" + strings.ReplaceAll(s, "\"", "'") + "\""
+}
+
+// writeSidecarCue writes a CUE sheet with each track as its own FILE, as
+// players expect for an album that is already split.
+func writeSidecarCue(filename, source string, tracks []Track) error {
+	t := tracks[0]
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "REM COMMENT %v\n", cueQuote("split from "+filepath.Base(source)+" by avsplit"))
+	if t.Genre != "" {
+		fmt.Fprintf(&b, "REM GENRE %v\n", cueQuote(t.Genre))
+	}
+	if t.Year != "" {
+		fmt.Fprintf(&b, "REM DATE %v\n", t.Year)
+	}
+	fmt.Fprintf(&b, "PERFORMER %v\n", cueQuote(t.AlbumArtist))
+	fmt.Fprintf(&b, "TITLE %v\n", cueQuote(t.Album))
+
+	for _, t := range tracks {
+		out := t.outputFilename(source)
+		fmt.Fprintf(&b, "FILE %v %v\n", cueQuote(filepath.Base(out)), cueFileType(filepath.Ext(out)))
+		fmt.Fprintf(&b, "  TRACK %02d AUDIO\n", t.Number)
+		fmt.Fprintf(&b, "    TITLE %v\n", cueQuote(t.Title))
+		fmt.Fprintf(&b, "    PERFORMER %v\n", cueQuote(t.Artist))
+		fmt.Fprintf(&b, "    INDEX 01 00:00:00\n")
+	}
+
+	return os.WriteFile(filename, []byte(b.String()), 0644)
+}
+
+// writeSidecarPlaylist writes an extended m3u8 playlist of the tracks. A
+// length of -1 means unknown.
+func writeSidecarPlaylist(filename, source string, tracks []Track, lengths []time.Duration) error {
+	var b strings.Builder
+	b.WriteString("#EXTM3U\n")
+	for i, t := range tracks {
+		secs := -1
+		if lengths[i] > 0 {
+			secs = int(lengths[i].Round(time.Second) / time.Second)
+		}
+		fmt.Fprintf(&b, "#EXTINF:%d,%v - %v\n", secs, t.Artist, t.Title)
+		fmt.Fprintf(&b, "%v\n", filepath.Base(t.outputFilename(source)))
+	}
+
+	return os.WriteFile(filename, []byte(b.String()), 0644)
+}
+
+type nfoAlbum struct {
+	XMLName xml.Name   `xml:"album"`
+	Title   string     `xml:"title"`
+	Artist  string     `xml:"artist"`
+	Genre   string     `xml:"genre,omitempty"`
+	Year    string     `xml:"year,omitempty"`
+	Review  string     `xml:"review"`
+	Tracks  []nfoTrack `xml:"track"`
+}
+
+type nfoTrack struct {
+	Position int    `xml:"position"`
+	Title    string `xml:"title"`
+	Duration string `xml:"duration,omitempty"`
+}
+
+// writeSidecarNFO writes a Kodi album NFO of the tracks.
+func writeSidecarNFO(filename, source string, tracks []Track, lengths []time.Duration) error {
+	t := tracks[0]
+	album := nfoAlbum{
+		Title:  t.Album,
+		Artist: t.AlbumArtist,
+		Genre:  t.Genre,
+		Year:   t.Year,
+		Review: "Split from " + filepath.Base(source) + " by avsplit",
+	}
+
+	for i, t := range tracks {
+		nt := nfoTrack{Position: t.Number, Title: t.Title}
+		if lengths[i] > 0 {
+			s := int(lengths[i].Round(time.Second) / time.Second)
+			nt.Duration = fmt.Sprintf("%d:%02d", s/60, s%60)
+		}
+		album.Tracks = append(album.Tracks, nt)
+	}
+
+	b, err := xml.MarshalIndent(album, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(filename, append([]byte(xml.Header), append(b, '\n')...), 0644)
+}
+
+// writeSidecars writes a CUE sheet, m3u8 playlist and album NFO into each
+// output directory, reflecting the tracks as they were split.
+func writeSidecars(ctx context.Context, opts Options, tracks []Track) error {
+	// Without the source length the last track's length is unknown
+	total, _ := probeDuration(ctx, opts.Filename)
+
+	byDir := make(map[string][]Track)
+	lengths := make(map[string][]time.Duration)
+	for _, t := range tracks {
+		dir := filepath.Dir(t.outputFilename(opts.Filename))
+
+		d, err := t.duration(total)
+		if err != nil || d < 0 {
+			d = 0
+		}
+
+		byDir[dir] = append(byDir[dir], t)
+		lengths[dir] = append(lengths[dir], d)
+	}
+
+	dirs := make([]string, 0, len(byDir))
+	for dir := range byDir {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		tracks := byDir[dir]
+		name := sanitizeName(tracks[0].Album, tracks[0].ASCII)
+		if name == "" {
+			name = "album"
+		}
+
+		opts.logf("writing cue sheet, playlist and nfo to %v\n", dir)
+
+		cue := filepath.Join(dir, name+".cue")
+		if err := writeSidecarCue(cue, opts.Filename, tracks); err != nil {
+			return fmt.Errorf("cannot write cue sheet: %v", err)
+		}
+
+		playlist := filepath.Join(dir, name+".m3u8")
+		if err := writeSidecarPlaylist(playlist, opts.Filename, tracks, lengths[dir]); err != nil {
+			return fmt.Errorf("cannot write playlist: %v", err)
+		}
+
+		// Kodi looks for album.nfo by name
+		nfo := filepath.Join(dir, "album.nfo")
+		if err := writeSidecarNFO(nfo, opts.Filename, tracks, lengths[dir]); err != nil {
+			return fmt.Errorf("cannot write nfo: %v", err)
+		}
+
+		for _, f := range []string{cue, playlist, nfo} {
+			if err := opts.setFilePerms(f); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package avsplit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// minTrackGap is the smallest distance between split points found by
+// silence detection, so silence at the very start of the file or two close
+// silences don't produce empty tracks.
+const minTrackGap = time.Second
+
+// parseSilence returns the split points in ffmpeg silencedetect output, each
+// in the middle of a detected silence.
+func parseSilence(output string) []time.Duration {
+	var points []time.Duration
+	var start float64
+	inSilence := false
+
+	s := bufio.NewScanner(strings.NewReader(output))
+	for s.Scan() {
+		line := s.Text()
+
+		if i := strings.Index(line, "silence_start: "); i >= 0 {
+			v, err := strconv.ParseFloat(strings.Fields(line[i+len("silence_start: "):])[0], 64)
+			if err == nil {
+				start = v
+				inSilence = true
+			}
+			continue
+		}
+
+		if i := strings.Index(line, "silence_end: "); i >= 0 && inSilence {
+			end, err := strconv.ParseFloat(strings.Fields(line[i+len("silence_end: "):])[0], 64)
+			if err != nil {
+				continue
+			}
+			inSilence = false
+
+			p := time.Duration((start + end) / 2 * float64(time.Second))
+			if p < minTrackGap || (len(points) > 0 && p-points[len(points)-1] < minTrackGap) {
+				continue
+			}
+			points = append(points, p)
+		}
+	}
+
+	return points
+}
+
+// detectSilence builds the tracks from the silences ffmpeg finds in the audio
+// file. The tracks are left untitled.
+func detectSilence(ctx context.Context, opts Options) ([]Track, error) {
+	filter := fmt.Sprintf("silencedetect=noise=%v:d=%v", opts.SilenceNoise, opts.SilenceDuration.Seconds())
+	args := append([]string{"-nostdin", "-hide_banner", "-i", argPath(opts.Filename)}, audioMapArgs(ctx)...)
+	args = append(args, "-af", filter, "-f", "null", "-")
+
+	var stderr bytes.Buffer
+	opts.logf("detecting silence\n")
+	err := runCommand(ctx, "ffmpeg", args, nil, &stderr)
+	debugStderr(ctx, "ffmpeg", stderr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, toolError("ffmpeg", stderr.String())
+	}
+
+	starts := []string{formatTimecode(0)}
+	for _, p := range parseSilence(stderr.String()) {
+		starts = append(starts, formatTimecode(p))
+	}
+
+	tracks := make([]Track, len(starts))
+	for i := range starts {
+		tracks[i] = Track{
+			Number:      i + 1,
+			Total:       len(starts),
+			Start:       starts[i],
+			Artist:      opts.Artist,
+			AlbumArtist: opts.Artist,
+			Album:       opts.Album,
+		}
+	}
+	Tracklist(tracks).SetEnds()
+
+	return tracks, nil
+}
@@ -0,0 +1,194 @@
+package avsplit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// snapStep is how finely snapTracks measures the loudness around a split
+// point.
+const snapStep = 100 * time.Millisecond
+
+// snapLevel is the loudness of the snapStep of audio at a time.
+type snapLevel struct {
+	at time.Duration
+	db float64
+}
+
+// noiseLevel parses a silencedetect noise level, such as "-30dB" or the
+// amplitude "0.001", into dB.
+func noiseLevel(s string) (float64, error) {
+	if strings.HasSuffix(strings.ToLower(s), "db") {
+		v, err := strconv.ParseFloat(strings.TrimSpace(s[:len(s)-2]), 64)
+		if err != nil {
+			return 0, fmt.Errorf("invalid silence noise %v", s)
+		}
+		return v, nil
+	}
+
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil || v <= 0 {
+		return 0, fmt.Errorf("invalid silence noise %v", s)
+	}
+	return 20 * math.Log10(v), nil
+}
+
+// parseLevels returns the RMS levels ffmpeg's astats printed through
+// ametadata, from start.
+func parseLevels(output string, start time.Duration) []snapLevel {
+	var levels []snapLevel
+	var at time.Duration
+
+	s := bufio.NewScanner(strings.NewReader(output))
+	for s.Scan() {
+		line := s.Text()
+
+		if i := strings.Index(line, "pts_time:"); i >= 0 {
+			v, err := strconv.ParseFloat(strings.Fields(line[i+len("pts_time:"):])[0], 64)
+			if err == nil {
+				at = start + time.Duration(v*float64(time.Second))
+			}
+			continue
+		}
+
+		if i := strings.Index(line, "lavfi.astats.Overall.RMS_level="); i >= 0 {
+			v, err := strconv.ParseFloat(strings.TrimSpace(line[i+len("lavfi.astats.Overall.RMS_level="):]), 64)
+			if err != nil {
+				// "-inf", digital silence
+				v = math.Inf(-1)
+			}
+			levels = append(levels, snapLevel{at, v})
+		}
+	}
+	return levels
+}
+
+// measureLevels returns the loudness of each snapStep of the audio file
+// from start for length.
+func measureLevels(ctx context.Context, file string, start, length time.Duration) ([]snapLevel, error) {
+	// Resampled so every step is as many samples
+	rate := 8000
+	filter := fmt.Sprintf("aresample=%d,asetnsamples=n=%d:p=0,astats=metadata=1:reset=1,ametadata=mode=print:key=lavfi.astats.Overall.RMS_level",
+		rate, int(snapStep.Seconds()*float64(rate)))
+	args := []string{"-nostdin", "-hide_banner", "-ss", formatTimecode(start), "-t", formatTimecode(length), "-i", argPath(file)}
+	args = append(args, audioMapArgs(ctx)...)
+	args = append(args, "-af", filter, "-f", "null", "-")
+
+	var stderr bytes.Buffer
+	err := runCommand(ctx, "ffmpeg", args, nil, &stderr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return nil, ctx.Err()
+		}
+		return nil, toolError("ffmpeg", stderr.String())
+	}
+	return parseLevels(stderr.String(), start), nil
+}
+
+// snapPoint returns where the split point p goes among the levels around
+// it: the middle of the nearest run of steps quieter than threshold, or
+// the quietest step when none is, the nearest of those as loud. The reason
+// is for the log.
+func snapPoint(p time.Duration, levels []snapLevel, threshold float64) (time.Duration, string) {
+	distance := func(d time.Duration) time.Duration {
+		if d < p {
+			return p - d
+		}
+		return d - p
+	}
+
+	best, found := p, false
+	for i := 0; i < len(levels); i++ {
+		if levels[i].db >= threshold {
+			continue
+		}
+
+		k := i
+		for k+1 < len(levels) && levels[k+1].db < threshold {
+			k++
+		}
+		mid := (levels[i].at + levels[k].at + snapStep) / 2
+		if !found || distance(mid) < distance(best) {
+			best, found = mid, true
+		}
+		i = k
+	}
+	if found {
+		return best, "silence"
+	}
+
+	quietest := -1
+	for i, l := range levels {
+		mid := l.at + snapStep/2
+		if quietest < 0 || l.db < levels[quietest].db || (l.db == levels[quietest].db && distance(mid) < distance(best)) {
+			quietest, best = i, mid
+		}
+	}
+	if quietest < 0 {
+		return p, ""
+	}
+	return best, "quietest moment"
+}
+
+// snapTracks moves the start and end of each track to the nearest silence
+// of the audio file within window of it, or otherwise its quietest moment
+// there, so cuts made by ear land between songs. A boundary two tracks
+// share moves once, for both. Tracks cut on samples are left alone.
+func snapTracks(ctx context.Context, opts Options, tracks Tracklist, window time.Duration) error {
+	threshold, err := noiseLevel(opts.SilenceNoise)
+	if err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	snapped := make(map[string]string)
+	snap := func(tc string) (string, error) {
+		if v, ok := snapped[tc]; ok {
+			return v, nil
+		}
+
+		p, err := parseDuration(tc)
+		if err != nil || p == 0 {
+			return tc, err
+		}
+
+		start := p - window
+		if start < 0 {
+			start = 0
+		}
+		levels, err := measureLevels(ctx, opts.Filename, start, p+window-start)
+		if err != nil {
+			return "", err
+		}
+
+		to, reason := snapPoint(p, levels, threshold)
+		v := formatTimecode(to.Round(time.Millisecond))
+		if reason != "" && v != tc {
+			opts.logf("moved the split point at %v to %v, the %v within %v\n", tc, v, reason, window)
+		}
+		snapped[tc] = v
+		return v, nil
+	}
+
+	for i := range tracks {
+		t := &tracks[i]
+		if t.cutsOnSamples() {
+			continue
+		}
+
+		if t.Start, err = snap(t.Start); err != nil {
+			return err
+		}
+		if t.End != "" {
+			if t.End, err = snap(t.End); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,231 @@
+package avsplit
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sourceLyricsExts are the sidecars of a whole recording SourceLyrics
+// "auto" looks for next to the audio file, in this order.
+var sourceLyricsExts = []string{".lrc", ".srt", ".vtt"}
+
+// lyricLine is a line of synced lyrics or a subtitle cue, shown from At
+// into the recording.
+type lyricLine struct {
+	At   time.Duration
+	Text string
+}
+
+var (
+	// lrcTime matches a [mm:ss.xx] tag of an LRC line, several of which
+	// may start a line repeated in the song
+	lrcTime = regexp.MustCompile(`^\[(\d+):(\d{1,2}(?:[.:]\d{1,3})?)\]`)
+	// lrcOffset matches the [offset:+/-ms] tag that moves every line
+	lrcOffset = regexp.MustCompile(`^\[offset:\s*([+-]?\d+)\s*\]`)
+	// cueTiming matches the timing line of an SRT or WebVTT cue
+	cueTiming = regexp.MustCompile(`^((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})\s+-->\s+((?:\d+:)?\d{1,2}:\d{2}[.,]\d{1,3})`)
+	// cueMarkup matches the tags of styled subtitle text, as in <i>
+	cueMarkup = regexp.MustCompile(`</?[^>]*>|\{\\[^}]*\}`)
+)
+
+// sourceLyricsFile returns the lyrics or subtitle file of the whole
+// recording opts.SourceLyrics names, or with "auto" the one next to the
+// audio file with its name, "" when there is none.
+func sourceLyricsFile(opts Options) string {
+	if opts.SourceLyrics != "auto" {
+		return opts.SourceLyrics
+	}
+	if len(opts.Filenames) > 1 || isURL(opts.Filename) {
+		return ""
+	}
+
+	base := strings.TrimSuffix(opts.Filename, filepath.Ext(opts.Filename))
+	for _, ext := range sourceLyricsExts {
+		f := base + ext
+		if f == opts.VTTOut {
+			// The chapters written by an earlier run, not subtitles
+			continue
+		}
+		if info, err := os.Stat(f); err == nil && !info.IsDir() {
+			return f
+		}
+	}
+	return ""
+}
+
+// readLyricLines reads the lines of an LRC file or the cues of an SRT or
+// WebVTT one, by its extension, in the order they are shown.
+func readLyricLines(file string) ([]lyricLine, error) {
+	b, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	// Saved by the same editors as tracklists are
+	text, _ := cleanText(b)
+
+	var lines []lyricLine
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".lrc":
+		lines, err = parseLRC(text)
+	case ".srt", ".vtt":
+		lines, err = parseSubtitles(text)
+	default:
+		return nil, fmt.Errorf("%v is not an .lrc, .srt or .vtt file", file)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%v: %v", file, err)
+	}
+
+	sort.SliceStable(lines, func(i, j int) bool { return lines[i].At < lines[j].At })
+	return lines, nil
+}
+
+// parseLRC returns the timed lines of LRC lyrics, leaving out the tags of
+// the song such as [ar:] and [ti:] but applying [offset:], which is in
+// milliseconds and shows the lines that much earlier.
+func parseLRC(text string) ([]lyricLine, error) {
+	var offset time.Duration
+	var lines []lyricLine
+
+	s := bufio.NewScanner(strings.NewReader(text))
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if m := lrcOffset.FindStringSubmatch(line); m != nil {
+			ms, _ := strconv.Atoi(m[1])
+			offset = time.Duration(ms) * time.Millisecond
+			continue
+		}
+
+		var times []time.Duration
+		for {
+			m := lrcTime.FindStringSubmatch(line)
+			if m == nil {
+				break
+			}
+			d, err := parseDuration(m[1] + ":" + strings.Replace(m[2], ":", ".", 1))
+			if err != nil {
+				return nil, fmt.Errorf("line %d: %v", n, err)
+			}
+			times = append(times, d)
+			line = line[len(m[0]):]
+		}
+
+		for _, d := range times {
+			lines = append(lines, lyricLine{At: d, Text: strings.TrimSpace(line)})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+
+	for i := range lines {
+		if lines[i].At -= offset; lines[i].At < 0 {
+			lines[i].At = 0
+		}
+	}
+	return lines, nil
+}
+
+// parseSubtitles returns the cues of SRT or WebVTT subtitles as lines
+// shown from their start, the text of a cue on several lines joined into
+// one and stripped of its styling.
+func parseSubtitles(text string) ([]lyricLine, error) {
+	var lines []lyricLine
+	for _, block := range strings.Split(text, "\n\n") {
+		rows := strings.Split(strings.TrimSpace(block), "\n")
+		for i, row := range rows {
+			m := cueTiming.FindStringSubmatch(strings.TrimSpace(row))
+			if m == nil {
+				continue
+			}
+
+			at, err := parseDuration(strings.Replace(m[1], ",", ".", 1))
+			if err != nil {
+				return nil, err
+			}
+
+			var words []string
+			for _, r := range rows[i+1:] {
+				if r = strings.TrimSpace(cueMarkup.ReplaceAllString(r, "")); r != "" {
+					words = append(words, r)
+				}
+			}
+			lines = append(lines, lyricLine{At: at, Text: strings.Join(words, " ")})
+			break
+		}
+	}
+	return lines, nil
+}
+
+// lrcTimestamp formats d as the [mm:ss.xx] tag of an LRC line.
+func lrcTimestamp(d time.Duration) string {
+	cs := d.Round(10*time.Millisecond).Milliseconds() / 10
+	return fmt.Sprintf("[%02d:%02d.%02d]", cs/6000, cs/100%60, cs%100)
+}
+
+// applySourceLyrics gives each track the lines of the whole recording's
+// lyrics or subtitle file that fall within it, timed from the start of the
+// track, as synced lyrics for its .lrc file. Those of the recording are a
+// better match than looked up ones, so they take their place. Tracks
+// without any lines are left as they are.
+func applySourceLyrics(opts Options, tracks Tracklist) error {
+	file := sourceLyricsFile(opts)
+	if file == "" {
+		return nil
+	}
+
+	lines, err := readLyricLines(file)
+	if err != nil {
+		return fmt.Errorf("cannot read the lyrics of the recording: %v", err)
+	}
+	opts.logf("splitting the lyrics of %v between the tracks\n", file)
+
+	tempo := opts.Tempo
+	if tempo == 0 {
+		tempo = 1
+	}
+
+	for i := range tracks {
+		t := &tracks[i]
+		start, err := parseDuration(t.Start)
+		if err != nil {
+			return err
+		}
+		// The last track runs to the end of the recording
+		end := time.Duration(1<<63 - 1)
+		if t.End != "" {
+			if end, err = parseDuration(t.End); err != nil {
+				return err
+			}
+		}
+
+		var b strings.Builder
+		for _, k := range [][2]string{{"ar", t.Artist}, {"al", t.Album}, {"ti", t.Title}} {
+			if k[1] != "" {
+				fmt.Fprintf(&b, "[%v:%v]\n", k[0], k[1])
+			}
+		}
+
+		found := false
+		for _, l := range lines {
+			if l.At < start || l.At >= end {
+				continue
+			}
+			// The tracks play tempo times as fast as the recording
+			at := time.Duration(float64(l.At-start) / tempo)
+			fmt.Fprintf(&b, "%v%v\n", lrcTimestamp(at), l.Text)
+			found = true
+		}
+		if found {
+			t.SyncedLyrics = strings.TrimSuffix(b.String(), "\n")
+		}
+	}
+	return nil
+}
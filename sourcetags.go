@@ -0,0 +1,193 @@
+package avsplit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// sourceTagFields are the tags of the audio file Options.SourceTags can
+// carry over into the tracks, "art" being its embedded cover.
+var sourceTagFields = []string{"artist", "album", "date", "genre", "composer", "comment", "art"}
+
+// parseSourceTags returns the fields selected by a comma separated list of
+// sourceTagFields, or all of them.
+func parseSourceTags(list string) (map[string]bool, error) {
+	fields := make(map[string]bool)
+	for _, f := range strings.Split(list, ",") {
+		f = strings.ToLower(strings.TrimSpace(f))
+		if f == "all" {
+			for _, f := range sourceTagFields {
+				fields[f] = true
+			}
+			continue
+		}
+
+		known := false
+		for _, k := range sourceTagFields {
+			known = known || k == f
+		}
+		if !known {
+			return nil, fmt.Errorf("unknown source tag %v, must be all or some of %v", f, strings.Join(sourceTagFields, ", "))
+		}
+		fields[f] = true
+	}
+	return fields, nil
+}
+
+// sourceTagAliases are the names other tag families give the tags probeTags
+// returns under ffmpeg's generic names, such as the Vorbis comments of a
+// FLAC file and the iTunes names of an MP4 one.
+var sourceTagAliases = [][2]string{
+	{"albumartist", "album_artist"},
+	{"album artist", "album_artist"},
+	{"year", "date"},
+	{"tyer", "date"},
+	{"description", "comment"},
+	{"organization", "publisher"},
+	{"label", "publisher"},
+	{"tracknumber", "track"},
+	{"discnumber", "disc"},
+}
+
+// probeTags returns the tags of the audio file, with lower case names and
+// those that only a tag family of the file calls by another name, as
+// "ALBUMARTIST" in a Vorbis comment, under ffmpeg's names as well. Ogg and
+// Opus files keep theirs on the audio stream rather than the container.
+func probeTags(ctx context.Context, audioFile string) (map[string]string, error) {
+	out, err := commandOutput(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-select_streams", probeStream(ctx),
+		"-show_entries", "format_tags:stream_tags",
+		"-of", "json",
+		argPath(audioFile),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	var result struct {
+		Format struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"format"`
+		Streams []struct {
+			Tags map[string]string `json:"tags"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		return nil, fmt.Errorf("cannot read tags of %v", audioFile)
+	}
+
+	tags := make(map[string]string)
+	for _, s := range result.Streams {
+		for k, v := range s.Tags {
+			tags[strings.ToLower(k)] = strings.TrimSpace(v)
+		}
+	}
+	for k, v := range result.Format.Tags {
+		tags[strings.ToLower(k)] = strings.TrimSpace(v)
+	}
+
+	for _, a := range sourceTagAliases {
+		if v := tags[a[0]]; v != "" && tags[a[1]] == "" {
+			tags[a[1]] = v
+		}
+	}
+	return tags, nil
+}
+
+// applySourceTags fills in the selected fields the tracks don't have from
+// the tags of the audio file, so the flags and tracklist still win.
+func applySourceTags(ctx context.Context, opts Options, tracks Tracklist) error {
+	fields, err := parseSourceTags(opts.SourceTags)
+	if err != nil {
+		return err
+	}
+
+	tags, err := probeTags(ctx, opts.Filename)
+	if err != nil {
+		return fmt.Errorf("cannot read the tags of the audio file: %v", err)
+	}
+
+	albumArtist := tags["album_artist"]
+	if albumArtist == "" {
+		albumArtist = tags["artist"]
+	}
+
+	year := tags["date"]
+	if len(year) > 4 {
+		// Only the year of a full date is tagged
+		year = year[:4]
+	}
+
+	fill := func(field string, p *string, v string) {
+		if fields[field] && *p == "" {
+			*p = v
+		}
+	}
+
+	for i := range tracks {
+		t := &tracks[i]
+		fill("artist", &t.Artist, tags["artist"])
+		fill("artist", &t.AlbumArtist, albumArtist)
+		fill("album", &t.Album, tags["album"])
+		fill("date", &t.Year, year)
+		fill("genre", &t.Genre, tags["genre"])
+		fill("composer", &t.Composer, tags["composer"])
+		fill("comment", &t.Comment, tags["comment"])
+	}
+	return nil
+}
+
+// extractSourceArt writes the cover embedded in the audio file to a
+// temporary file, returning "" when it has none. The caller removes it.
+func extractSourceArt(ctx context.Context, audioFile string) (string, error) {
+	out, err := commandOutput(
+		ctx,
+		"ffprobe",
+		"-v", "error",
+		"-select_streams", "v",
+		"-show_entries", "stream=codec_name:stream_disposition=attached_pic",
+		"-of", "json",
+		argPath(audioFile),
+	)
+	if err != nil {
+		return "", err
+	}
+
+	var result struct {
+		Streams []struct {
+			CodecName   string `json:"codec_name"`
+			Disposition struct {
+				AttachedPic int `json:"attached_pic"`
+			} `json:"disposition"`
+		} `json:"streams"`
+	}
+	if err := json.Unmarshal([]byte(out), &result); err != nil {
+		return "", fmt.Errorf("cannot read the streams of %v", audioFile)
+	}
+
+	for i, s := range result.Streams {
+		ext := map[string]string{"mjpeg": ".jpg", "png": ".png"}[s.CodecName]
+		if s.Disposition.AttachedPic != 1 || ext == "" {
+			continue
+		}
+
+		f, err := os.CreateTemp("", "avsplit-cover-*"+ext)
+		if err != nil {
+			return "", err
+		}
+		f.Close()
+
+		if err := execCommand(ctx, "ffmpeg", "-nostdin", "-y", "-loglevel", "error", "-i", argPath(audioFile), "-map", fmt.Sprintf("0:v:%d", i), "-c", "copy", "-frames:v", "1", f.Name()); err != nil {
+			os.Remove(f.Name())
+			return "", fmt.Errorf("cannot extract the cover art of %v: %v", audioFile, err)
+		}
+		return f.Name(), nil
+	}
+	return "", nil
+}
@@ -0,0 +1,360 @@
+package avsplit
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+type trackError struct {
+	Track Track
+	Err   error
+}
+
+func (e trackError) Error() string {
+	return fmt.Sprintf("track %d: %v", e.Track.Number, strings.TrimSpace(e.Err.Error()))
+}
+
+// splitTracks extracts the tracks through a pool of Jobs workers and tags
+// them through a separate pool of TagJobs workers, so tagging one track
+// overlaps with extracting the next. A failed track is retried up to Retries
+// times, and the rest are still split unless FailFast is set, when no new
+// tracks are started but those already in flight are finished. Each track is
+// written to its partFilename and only renamed into place once it is tagged.
+func splitTracks(ctx context.Context, opts Options, tracks []Track) error {
+	var mtime time.Time
+	if opts.PreserveMtime {
+		info, err := os.Stat(opts.Filename)
+		if err != nil {
+			return err
+		}
+		mtime = info.ModTime()
+	} else if !opts.Date.IsZero() {
+		mtime = opts.Date
+	}
+
+	var bar *progressBar
+	var total time.Duration
+	if opts.Progress || opts.Events != nil {
+		// Without the source length the last track only shows elapsed time
+		total, _ = probeDuration(ctx, opts.Filename)
+	}
+	if opts.Progress {
+		bar = newProgressBar(opts, tracks, total)
+		defer bar.close()
+	}
+
+	jobSem := make(chan struct{}, opts.Jobs)
+	tagSem := make(chan struct{}, opts.TagJobs)
+
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	var failures []trackError
+	var quarantineErr error
+	var completed []int
+	var stats []trackStat
+	start := time.Now()
+
+	fail := func(t Track, part string, err error) {
+		if ctx.Err() != nil {
+			// Killed part way through, so whatever was staged is incomplete,
+			// but a track left in place or already renamed into it is whole
+			if part != t.outputFilename(opts.Filename) {
+				os.Remove(part)
+			}
+			return
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+
+		failures = append(failures, trackError{t, err})
+		opts.event(Event{Kind: TrackFailed, Track: t, File: t.outputFilename(opts.Filename), Err: err})
+		if opts.JSON {
+			opts.emit(jsonEvent{Event: "error", Track: t.Number, File: t.outputFilename(opts.Filename), Message: err.Error()})
+		}
+
+		if !opts.Quarantine {
+			if part != t.outputFilename(opts.Filename) {
+				os.Remove(part)
+			}
+			return
+		}
+
+		opts.logf("quarantining track \"%v\": %v\n", t.outputFilename(opts.Filename), err)
+		if qerr := quarantine(opts, part, t.outputFilename(opts.Filename), err); qerr != nil && quarantineErr == nil {
+			quarantineErr = qerr
+		}
+	}
+
+	stopped := func() bool {
+		mu.Lock()
+		defer mu.Unlock()
+		return quarantineErr != nil || (opts.FailFast && len(failures) > 0)
+	}
+
+	for _, t := range tracks {
+		jobSem <- struct{}{}
+		if stopped() || ctx.Err() != nil {
+			<-jobSem
+			break
+		}
+
+		wg.Add(1)
+		go func(t Track) {
+			defer wg.Done()
+
+			// Tags may move a track into its own album directory
+			out := t.outputFilename(opts.Filename)
+			err := opts.mkdirAll(filepath.Dir(out))
+			if err != nil {
+				<-jobSem
+				fail(t, out, err)
+				return
+			}
+
+			// Another split into the same album may be writing it, and
+			// once it is done this one finds it left as by an earlier run
+			unlock, err := lockOutput(ctx, opts, out)
+			if err != nil {
+				<-jobSem
+				return
+			}
+			defer unlock()
+
+			// What is tagged, the track itself when it is left in place
+			staged := t
+			var extract time.Duration
+			skipped := !opts.overwrites() && trackExists(ctx, opts, t)
+			if skipped {
+				// Left by an earlier run, still tagged below in case that
+				// run stopped before tagging it
+				if bar != nil {
+					bar.skip(t.Number)
+				} else {
+					opts.logf("skipping existing track \"%v\"\n", out)
+				}
+			} else {
+				staged.Output = partFilename(out)
+				opts.event(Event{Kind: TrackStarted, Track: t, File: out})
+				extractStart := time.Now()
+				err = retry(ctx, opts, t, "extracting", func() error {
+					// Left by an interrupted run or a failed attempt, and
+					// refused by ffmpeg's -n otherwise
+					os.Remove(staged.Output)
+					if bar != nil || opts.Events != nil {
+						return extractTrackProgress(ctx, opts, t, staged.Output, bar, total)
+					}
+					return extractTrack(ctx, opts, t, staged.Output)
+				})
+				extract = time.Since(extractStart)
+			}
+			part := staged.outputFilename(opts.Filename)
+			<-jobSem
+			if err != nil {
+				fail(t, part, err)
+				return
+			}
+
+			tagSem <- struct{}{}
+			defer func() { <-tagSem }()
+
+			err = retry(ctx, opts, t, "tagging", func() error {
+				return tagTrack(ctx, opts, staged)
+			})
+			if err == nil && !mtime.IsZero() {
+				// Applied after tagging, which rewrites the file
+				err = os.Chtimes(part, mtime, mtime)
+			}
+			if err == nil {
+				err = opts.setFilePerms(part)
+			}
+
+			if err == nil && part != out {
+				if err = opts.rename(part, out); err == nil {
+					part = out
+				}
+			}
+
+			if err == nil && opts.PostCmd != "" {
+				err = runHook(ctx, opts, "post-cmd", opts.PostCmd, t.trackHookEnv(opts))
+			}
+
+			if err != nil {
+				fail(t, part, err)
+				return
+			}
+
+			var size int64
+			if info, err := os.Stat(out); err == nil {
+				size = info.Size()
+			}
+
+			mu.Lock()
+			completed = append(completed, t.Number)
+			stats = append(stats, trackStat{t.Number, out, extract, size, t.Length, skipped})
+			mu.Unlock()
+
+			opts.event(Event{Kind: TrackDone, Track: t, File: out, Skipped: skipped})
+			if opts.JSON {
+				opts.emit(jsonEvent{Event: "track", Track: t.Number, File: out, Skipped: skipped})
+			}
+		}(t)
+	}
+
+	wg.Wait()
+
+	// Callers can tell from their own context, the error is for the user
+	if ctx.Err() != nil {
+		if len(completed) == 0 {
+			return fmt.Errorf("interrupted before any track was completed")
+		}
+
+		sort.Ints(completed)
+		done := make([]string, len(completed))
+		for i, n := range completed {
+			done[i] = strconv.Itoa(n)
+		}
+		return fmt.Errorf(
+			"interrupted with %d of %d tracks completed (%v)",
+			len(completed), len(tracks), strings.Join(done, ", "),
+		)
+	}
+
+	reportStats(opts, stats, time.Since(start))
+
+	if quarantineErr != nil {
+		return quarantineErr
+	}
+
+	if len(failures) == 0 {
+		return nil
+	}
+
+	if !opts.FailFast {
+		if !opts.JSON {
+			printSummary(opts.logWriter(), opts, tracks, failures)
+		}
+		return withKind(failedKind(len(failures), len(tracks)), fmt.Errorf("%d of %d tracks failed", len(failures), len(tracks)))
+	}
+
+	kind := failedKind(len(failures), len(tracks))
+	if len(failures) == 1 {
+		return withKind(kind, failures[0].Err)
+	}
+
+	msgs := make([]string, len(failures))
+	for i, f := range failures {
+		msgs[i] = f.Error()
+	}
+	return withKind(kind, fmt.Errorf("%d tracks failed:\n%v", len(failures), strings.Join(msgs, "\n")))
+}
+
+// retry runs f, running it again up to opts.Retries times after a failure
+// with a doubling delay in between.
+func retry(ctx context.Context, opts Options, t Track, what string, f func() error) error {
+	delay := time.Second
+	for attempt := 0; ; attempt++ {
+		err := f()
+		if err == nil || ctx.Err() != nil || attempt >= opts.Retries {
+			return err
+		}
+
+		opts.logf("warning: %v track %d failed, retrying in %v: %v\n", what, t.Number, delay, strings.TrimSpace(err.Error()))
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		delay *= 2
+	}
+}
+
+// printSummary writes a table of which tracks were split and which failed.
+func printSummary(w io.Writer, opts Options, tracks []Track, failures []trackError) {
+	failed := make(map[int]error)
+	for _, f := range failures {
+		failed[f.Track.Number] = f.Err
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "\n#\tTRACK\tRESULT")
+	for _, t := range tracks {
+		result := "ok"
+		if err, ok := failed[t.Number]; ok {
+			result = "failed: " + strings.Join(strings.Fields(err.Error()), " ")
+		}
+		fmt.Fprintf(tw, "%d\t%v\t%v\n", t.Number, t.outputFilename(opts.Filename), result)
+	}
+	tw.Flush()
+}
+
+// extractTrack extracts the track into the file part, renamed to its
+// output file once it is tagged.
+func extractTrack(ctx context.Context, opts Options, t Track, part string) error {
+	opts.logf("processing track \"%v\"\n", t.outputFilename(opts.Filename))
+	t.Output = part
+	return execCommand(ctx, "ffmpeg", t.ffmpegArgs(opts.trackSource(&t), opts.overwriteFlag())...)
+}
+
+// partFilename returns the file a track is written and tagged in before it
+// is renamed to its output file out, hidden next to it with the same
+// extension, so a failed or interrupted split never leaves a half written
+// track under the real name.
+func partFilename(out string) string {
+	return filepath.Join(filepath.Dir(out), ".avsplit-"+filepath.Base(out))
+}
+
+// streamTrack extracts a single track to w instead of a file, tagged only
+// with what ffmpeg writes as it extracts.
+func streamTrack(ctx context.Context, opts Options, t Track, w io.Writer) error {
+	opts.logf("streaming track %d\n", t.Number)
+
+	var stderr bytes.Buffer
+	err := runCommand(ctx, "ffmpeg", t.ffmpegArgs(opts.Filename, opts.overwriteFlag()), w, &stderr)
+	debugStderr(ctx, "ffmpeg", stderr)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return toolError("ffmpeg", stderr.String())
+	}
+	return nil
+}
+
+// trackExists reports whether the output file of the track is already there
+// with the expected length, from an earlier run that was interrupted.
+func trackExists(ctx context.Context, opts Options, t Track) bool {
+	out := t.outputFilename(opts.Filename)
+	if _, err := os.Stat(out); err != nil {
+		return false
+	}
+	return checkLength(ctx, opts, t, out) == nil
+}
+
+// quarantine moves a failed track, as written to part, into a .failed
+// directory next to its outputFile and writes the error alongside so the
+// good output stays separate.
+func quarantine(opts Options, part, outputFile string, cause error) error {
+	dir := filepath.Join(filepath.Dir(outputFile), ".failed")
+	if err := opts.mkdirAll(dir); err != nil {
+		return err
+	}
+
+	dest := filepath.Join(dir, filepath.Base(outputFile))
+	err := os.Rename(part, dest)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	return opts.writeFile(dest+".error", []byte(cause.Error()))
+}
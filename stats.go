@@ -0,0 +1,117 @@
+package avsplit
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// trackStat is how long a track of a split took to extract and how big it
+// came out.
+type trackStat struct {
+	number  int
+	file    string
+	extract time.Duration
+	size    int64
+	// length is how much audio the track holds, 0 when unknown
+	length  time.Duration
+	skipped bool
+}
+
+// jsonStats is the stats event of --json output, the times in seconds and
+// the sizes in bytes.
+type jsonStats struct {
+	Wall        float64          `json:"wall"`
+	Audio       float64          `json:"audio,omitempty"`
+	Speed       float64          `json:"speed,omitempty"`
+	Bytes       int64            `json:"bytes"`
+	SourceBytes int64            `json:"source_bytes,omitempty"`
+	Tracks      []jsonTrackStats `json:"tracks"`
+}
+
+type jsonTrackStats struct {
+	Number  int     `json:"number"`
+	File    string  `json:"file"`
+	Extract float64 `json:"extract"`
+	Bytes   int64   `json:"bytes"`
+	Skipped bool    `json:"skipped,omitempty"`
+}
+
+// formatSize formats a number of bytes in kB, MB or GB, as du -h --si does.
+func formatSize(n int64) string {
+	switch {
+	case n >= 1e9:
+		return fmt.Sprintf("%.1f GB", float64(n)/1e9)
+	case n >= 1e6:
+		return fmt.Sprintf("%.1f MB", float64(n)/1e6)
+	case n >= 1e3:
+		return fmt.Sprintf("%.1f kB", float64(n)/1e3)
+	}
+	return fmt.Sprintf("%d B", n)
+}
+
+// reportStats logs what the split of the tracks completed took, wall being
+// the time since it started: how long each track took to extract and how
+// big it is, and for the run the speed the audio was extracted at and the
+// size of the tracks against that of the source, to compare stream copies
+// with re-encodes. Tracks an earlier run wrote take no part in the speed.
+func reportStats(opts Options, stats []trackStat, wall time.Duration) {
+	if len(stats) == 0 {
+		return
+	}
+	sort.Slice(stats, func(i, k int) bool { return stats[i].number < stats[k].number })
+
+	var size, source int64
+	var audio time.Duration
+	extracted := 0
+	for _, s := range stats {
+		size += s.size
+		if !s.skipped {
+			audio += s.length
+			extracted++
+		}
+	}
+	if info, err := os.Stat(opts.Filename); err == nil && !isURL(opts.Filename) {
+		source = info.Size()
+	}
+
+	speed := 0.0
+	if audio > 0 && wall > 0 && extracted > 0 {
+		speed = audio.Seconds() / wall.Seconds()
+	}
+
+	if opts.JSON {
+		e := jsonStats{Wall: wall.Seconds(), Audio: audio.Seconds(), Speed: speed, Bytes: size, SourceBytes: source}
+		for _, s := range stats {
+			e.Tracks = append(e.Tracks, jsonTrackStats{s.number, s.file, s.extract.Seconds(), s.size, s.skipped})
+		}
+		opts.emit(jsonEvent{Event: "stats", Stats: &e})
+		return
+	}
+
+	line := fmt.Sprintf("split %d tracks in %v", len(stats), wall.Round(10*time.Millisecond))
+	if speed > 0 {
+		line += fmt.Sprintf(", %.1fx realtime", speed)
+	}
+	line += fmt.Sprintf(", %v written", formatSize(size))
+	if source > 0 {
+		line += fmt.Sprintf(" from a %v source (%.0f%%)", formatSize(source), float64(size)/float64(source)*100)
+	}
+
+	var b strings.Builder
+	tw := tabwriter.NewWriter(&b, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "#\tTRACK\tEXTRACT\tSIZE")
+	for _, s := range stats {
+		extract := s.extract.Round(10 * time.Millisecond).String()
+		if s.skipped {
+			extract = "skipped"
+		}
+		fmt.Fprintf(tw, "%d\t%v\t%v\t%v\n", s.number, s.file, extract, formatSize(s.size))
+	}
+	tw.Flush()
+
+	opts.logf("\n%v\n\n%v", line, b.String())
+}
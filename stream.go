@@ -0,0 +1,69 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parseAudioStream returns the ffmpeg stream specifier, without the input
+// index, of the audio stream s selects: a number counts from 0 among the
+// audio streams and a language code, such as "eng", picks by language. It
+// returns "" when s is empty, for the default stream.
+func parseAudioStream(s string) (string, error) {
+	if s == "" {
+		return "", nil
+	}
+
+	if n, err := strconv.Atoi(s); err == nil {
+		if n < 0 {
+			return "", fmt.Errorf("audio-stream must not be negative")
+		}
+		return fmt.Sprintf("a:%d", n), nil
+	}
+
+	if len(s) < 2 || len(s) > 3 || strings.Trim(strings.ToLower(s), "abcdefghijklmnopqrstuvwxyz") != "" {
+		return "", fmt.Errorf("invalid audio-stream %v, must be a number or a language code such as eng", s)
+	}
+	return "a:m:language:" + strings.ToLower(s), nil
+}
+
+// audioStream returns the stream specifier of the audio stream selected by
+// the options carried by ctx, "" when none is.
+func audioStream(ctx context.Context) string {
+	if o, ok := ctx.Value(optionsKey{}).(Options); ok {
+		if spec, err := parseAudioStream(o.AudioStream); err == nil {
+			return spec
+		}
+	}
+	return ""
+}
+
+// probeStream returns the audio stream for ffprobe to look at, the first
+// one unless another is selected.
+func probeStream(ctx context.Context) string {
+	if spec := audioStream(ctx); spec != "" {
+		return spec
+	}
+	return "a:0"
+}
+
+// audioMapArgs returns the ffmpeg arguments that read only the selected
+// audio stream, or leave ffmpeg to pick one and drop the video.
+func audioMapArgs(ctx context.Context) []string {
+	if spec := audioStream(ctx); spec != "" {
+		return []string{"-map", "0:" + spec}
+	}
+	return []string{"-vn"}
+}
+
+// firstLine returns the first line of the output of ffprobe, which prints
+// one per stream when a language matches several.
+func firstLine(out string) string {
+	out = strings.TrimSpace(out)
+	if i := strings.IndexByte(out, '\n'); i >= 0 {
+		out = out[:i]
+	}
+	return strings.TrimSpace(out)
+}
@@ -0,0 +1,438 @@
+package avsplit
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// structuredReleaseKeys are the fields of the release in a YAML or TOML
+// tracklist, its defaults for every track.
+var structuredReleaseKeys = map[string]bool{
+	"artist":       true,
+	"album-artist": true,
+	"album":        true,
+	"composer":     true,
+	"year":         true,
+	"genre":        true,
+	"comment":      true,
+	"label":        true,
+	"catno":        true,
+	"artwork":      true,
+}
+
+// structuredTrackKeys are the fields of a track in a YAML or TOML
+// tracklist. Those of its directives set the same as them.
+var structuredTrackKeys = map[string]bool{
+	"title":     true,
+	"alt-title": true,
+	"start":     true,
+	"end":       true,
+	"artist":    true,
+	"featuring": true,
+	"composer":  true,
+	"work":      true,
+	"movement":  true,
+	"year":      true,
+	"genre":     true,
+	"comment":   true,
+	"isrc":      true,
+	"artwork":   true,
+	"disc":      true,
+	"format":    true,
+	"codec":     true,
+	"bitrate":   true,
+	"quality":   true,
+	"encode":    true,
+	"video":     true,
+	"skip":      true,
+}
+
+// structuredTracklist is a YAML or TOML tracklist as read: the fields of the
+// release and of each track, a list of values or one, and the line each
+// track starts on.
+type structuredTracklist struct {
+	release map[string][]string
+	tracks  []map[string][]string
+	lines   []int
+}
+
+// set sets the field key of the release, or of the last track when there is
+// one, checking it is a field there.
+func (s *structuredTracklist) set(key string, values []string, line int) error {
+	fields, known := s.release, structuredReleaseKeys
+	if s.tracks != nil {
+		fields, known = s.tracks[len(s.tracks)-1], structuredTrackKeys
+	}
+
+	if !known[key] {
+		return lineErrorf(line, "line %d: unknown field %v", line, key)
+	}
+	fields[key] = values
+	return nil
+}
+
+func (s *structuredTracklist) addTrack(line int) {
+	if s.tracks == nil {
+		s.tracks = []map[string][]string{}
+	}
+	s.tracks = append(s.tracks, map[string][]string{})
+	s.lines = append(s.lines, line)
+}
+
+// splitList splits the items of a one-line list value such as
+// `["A", 'B', C]`, each parsed by scalar.
+func splitList(v string, scalar func(string) (string, error)) ([]string, error) {
+	inner := strings.TrimSpace(v[1:])
+	if !strings.HasSuffix(inner, "]") {
+		return nil, fmt.Errorf("unclosed list %v", v)
+	}
+	inner = strings.TrimSpace(strings.TrimSuffix(inner, "]"))
+
+	var items []string
+	for inner != "" {
+		// An item runs to the next comma outside quotes
+		end, quote := len(inner), byte(0)
+		for i := 0; i < len(inner); i++ {
+			switch c := inner[i]; {
+			case quote != 0 && c == '\\' && quote == '"':
+				i++
+			case quote != 0 && c == quote:
+				quote = 0
+			case quote == 0 && (c == '"' || c == '\''):
+				quote = c
+			case quote == 0 && c == ',':
+				end = i
+			}
+			if end < len(inner) {
+				break
+			}
+		}
+
+		item, err := scalar(strings.TrimSpace(inner[:end]))
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+
+		if end == len(inner) {
+			break
+		}
+		inner = strings.TrimSpace(inner[end+1:])
+	}
+	return items, nil
+}
+
+// parseStructuredValue parses a value, a list or a scalar.
+func parseStructuredValue(v string, scalar func(string) (string, error)) ([]string, error) {
+	if strings.HasPrefix(v, "[") {
+		return splitList(v, scalar)
+	}
+	s, err := scalar(v)
+	if err != nil {
+		return nil, err
+	}
+	return []string{s}, nil
+}
+
+// parseYAMLTracklist reads the subset of YAML a tracklist needs, the fields
+// of the release and a list of tracks, each a mapping of fields to scalars
+// or one-line lists:
+//
+//	artist: The Band
+//	album: Live at the Roxy
+//	tracks:
+//	  - title: Intro
+//	    start: "00:00"
+//	  - title: Song Two
+//	    start: "03:10"
+//	    featuring: [Guest Star]
+//	    isrc: USRC17607839
+func parseYAMLTracklist(r io.Reader) (*structuredTracklist, error) {
+	s := &structuredTracklist{release: make(map[string][]string)}
+
+	sc := bufio.NewScanner(r)
+	line, inTracks := 0, false
+	for sc.Scan() {
+		line++
+		text := strings.TrimRight(sc.Text(), " \t\r")
+		trimmed := strings.TrimSpace(text)
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") || trimmed == "---" {
+			continue
+		}
+
+		indented := text[0] == ' ' || text[0] == '\t'
+		switch {
+		case !indented && strings.TrimSpace(strings.Split(trimmed, "#")[0]) == "tracks:":
+			inTracks = true
+			continue
+		case inTracks && strings.HasPrefix(trimmed, "-"):
+			s.addTrack(line)
+			trimmed = strings.TrimSpace(strings.TrimPrefix(trimmed, "-"))
+			if trimmed == "" {
+				continue
+			}
+		case inTracks && indented:
+			if s.tracks == nil {
+				return nil, lineErrorf(line, "line %d: expected a \"- \" track", line)
+			}
+		case indented:
+			return nil, lineErrorf(line, "line %d: unexpected indent", line)
+		case s.tracks != nil:
+			return nil, lineErrorf(line, "line %d: release fields go before the tracks", line)
+		}
+
+		kv := strings.SplitN(trimmed, ":", 2)
+		if len(kv) != 2 {
+			return nil, lineErrorf(line, "line %d: expected \"field: value\"", line)
+		}
+
+		values, err := parseStructuredValue(strings.TrimSpace(kv[1]), manifestScalar)
+		if err != nil {
+			return nil, lineErrorf(line, "line %d: %v", line, err)
+		}
+		if err := s.set(strings.TrimSpace(kv[0]), values, line); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// tomlScalar parses a TOML string, basic or literal, or a bare number or
+// boolean.
+func tomlScalar(v string) (string, error) {
+	if strings.HasPrefix(v, "'") {
+		end := strings.Index(v[1:], "'")
+		if end < 0 {
+			return "", fmt.Errorf("invalid string %v", v)
+		}
+		if rest := strings.TrimSpace(v[end+2:]); rest != "" && !strings.HasPrefix(rest, "#") {
+			return "", fmt.Errorf("unexpected %v after string", rest)
+		}
+		return v[1 : end+1], nil
+	}
+
+	if strings.HasPrefix(v, `"`) {
+		return manifestScalar(v)
+	}
+
+	if i := strings.Index(v, "#"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	if _, err := strconv.ParseFloat(v, 64); err != nil && v != "true" && v != "false" {
+		return "", fmt.Errorf("invalid value %v, strings are quoted", v)
+	}
+	return v, nil
+}
+
+// parseTOMLTracklist reads the subset of TOML a tracklist needs, the fields
+// of the release and a [[tracks]] table for each track:
+//
+//	artist = "The Band"
+//	album = "Live at the Roxy"
+//
+//	[[tracks]]
+//	title = "Intro"
+//	start = "00:00"
+//
+//	[[tracks]]
+//	title = "Song Two"
+//	start = "03:10"
+//	featuring = ["Guest Star"]
+func parseTOMLTracklist(r io.Reader) (*structuredTracklist, error) {
+	s := &structuredTracklist{release: make(map[string][]string)}
+
+	sc := bufio.NewScanner(r)
+	line := 0
+	for sc.Scan() {
+		line++
+		trimmed := strings.TrimSpace(sc.Text())
+		if trimmed == "" || strings.HasPrefix(trimmed, "#") {
+			continue
+		}
+
+		if strings.HasPrefix(trimmed, "[") {
+			if strings.TrimSpace(strings.Split(trimmed, "#")[0]) != "[[tracks]]" {
+				return nil, lineErrorf(line, "line %d: unknown table %v, tracks are [[tracks]]", line, trimmed)
+			}
+			s.addTrack(line)
+			continue
+		}
+
+		kv := strings.SplitN(trimmed, "=", 2)
+		if len(kv) != 2 {
+			return nil, lineErrorf(line, "line %d: expected \"field = value\"", line)
+		}
+
+		key := strings.Trim(strings.TrimSpace(kv[0]), `"`)
+		values, err := parseStructuredValue(strings.TrimSpace(kv[1]), tomlScalar)
+		if err != nil {
+			return nil, lineErrorf(line, "line %d: %v", line, err)
+		}
+		if err := s.set(key, values, line); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// structuredTracks builds the tracks of a YAML or TOML tracklist. The
+// release's fields are the defaults of its tracks, and opts' artist and
+// album are over them. Artwork is relative to dir, the tracklist's
+// directory. A track without an end runs to the next one.
+func structuredTracks(s *structuredTracklist, opts Options, dir string) (Tracklist, error) {
+	allowUntitled := opts.AutoTitle != "" || opts.looksUpRelease()
+
+	release := func(key string) string {
+		if v := s.release[key]; len(v) > 0 {
+			return v[0]
+		}
+		return ""
+	}
+	artist, album := release("artist"), release("album")
+	if opts.Artist != "" {
+		artist = opts.Artist
+	}
+	if opts.Album != "" {
+		album = opts.Album
+	}
+	albumArtist := release("album-artist")
+	if albumArtist == "" || opts.Artist != "" {
+		albumArtist = artist
+	}
+
+	artwork := func(line int, v string) (string, error) {
+		if v != "" && !filepath.IsAbs(v) {
+			v = filepath.Join(dir, v)
+		}
+		if _, err := os.Stat(v); v != "" && err != nil {
+			return "", lineErrorf(line, "line %d: artwork %v not found", line, v)
+		}
+		return v, nil
+	}
+
+	cover, err := artwork(0, release("artwork"))
+	if err != nil {
+		return nil, err
+	}
+
+	var tracks Tracklist
+	var ends []bool
+	for i, fields := range s.tracks {
+		line := s.lines[i]
+		field := func(key string) string {
+			if v := fields[key]; len(v) > 0 {
+				return v[0]
+			}
+			return ""
+		}
+
+		if field("skip") == "true" {
+			continue
+		}
+
+		start, err := normalizeTimecode(field("start"))
+		if err != nil {
+			return nil, lineErrorf(line, "line %d: invalid or missing start", line)
+		}
+
+		t := Track{
+			Number:        len(tracks) + 1,
+			Start:         start,
+			Title:         field("title"),
+			Artist:        artist,
+			AlbumArtist:   albumArtist,
+			Album:         album,
+			Composer:      release("composer"),
+			Year:          release("year"),
+			Genre:         release("genre"),
+			Comment:       release("comment"),
+			Label:         release("label"),
+			CatalogNumber: release("catno"),
+			ISRC:          field("isrc"),
+			Cover:         cover,
+			Line:          line,
+		}
+
+		if t.Title == "" && !allowUntitled {
+			return nil, lineErrorf(line, "line %d: track has no title", line)
+		}
+		setAltTitle(&t, field("alt-title"), opts)
+
+		if end := field("end"); end != "" {
+			if t.End, err = normalizeTimecode(end); err != nil {
+				return nil, lineErrorf(line, "line %d: invalid end %v", line, end)
+			}
+		}
+
+		if v := field("disc"); v != "" {
+			if t.Disc, err = strconv.Atoi(v); err != nil || t.Disc < 1 {
+				return nil, lineErrorf(line, "line %d: invalid disc %v", line, v)
+			}
+		}
+
+		if v := field("artwork"); v != "" {
+			if t.Cover, err = artwork(line, v); err != nil {
+				return nil, err
+			}
+		}
+
+		directives := make(map[string]string)
+		for key := range trackDirectives {
+			v, ok := fields[key]
+			if !ok || key == "skip" {
+				continue
+			}
+
+			if !trackDirectives[key] {
+				if len(v) > 0 && v[0] == "true" {
+					directives[key] = ""
+				}
+				continue
+			}
+
+			if n, err := strconv.Atoi(strings.Join(v, "")); key == "movement" && (err != nil || n < 1) {
+				return nil, lineErrorf(line, "line %d: movement needs a number from 1", line)
+			}
+			directives[key] = strings.Join(v, ", ")
+		}
+		applyDirectives(&t, directives)
+
+		if featuring := fields["featuring"]; len(featuring) > 0 {
+			t.Artists = append([]string{t.Artist}, featuring...)
+			t.Artist += " feat. " + strings.Join(featuring, ", ")
+		}
+
+		tracks = append(tracks, t)
+		ends = append(ends, t.End != "")
+	}
+
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("no tracks found")
+	}
+
+	multi := multiDisc(tracks)
+	for i := range tracks {
+		if !ends[i] && i < len(tracks)-1 {
+			tracks[i].End = tracks[i+1].Start
+		}
+		tracks[i].Total = len(tracks)
+		if tracks[i].Disc == 0 && multi {
+			tracks[i].Disc = 1
+		}
+	}
+	numberDiscs(tracks)
+	return tracks, nil
+}
@@ -0,0 +1,276 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// fileNumberPrefix matches the track number a file name starts with, as in
+// "01 - Title", "1-01 - Title" or "03. Title".
+var fileNumberPrefix = regexp.MustCompile(`^(?:\d{1,2}-)?\d{1,4}\s*[-._)]*\s*`)
+
+// matchKey returns s reduced to its lowercase letters and digits, for file
+// names to be compared to titles whatever the punctuation and spaces.
+func matchKey(s string) string {
+	var b strings.Builder
+	for _, r := range strings.ToLower(s) {
+		if unicode.IsLetter(r) || unicode.IsDigit(r) {
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// editDistance returns the Levenshtein distance between a and b, in runes.
+func editDistance(a, b string) int {
+	ra, rb := []rune(a), []rune(b)
+	prev := make([]int, len(rb)+1)
+	cur := make([]int, len(rb)+1)
+	for k := range prev {
+		prev[k] = k
+	}
+
+	for i := 1; i <= len(ra); i++ {
+		cur[0] = i
+		for k := 1; k <= len(rb); k++ {
+			cost := 1
+			if ra[i-1] == rb[k-1] {
+				cost = 0
+			}
+			cur[k] = prev[k-1] + cost
+			if prev[k]+1 < cur[k] {
+				cur[k] = prev[k] + 1
+			}
+			if cur[k-1]+1 < cur[k] {
+				cur[k] = cur[k-1] + 1
+			}
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(rb)]
+}
+
+// nameScore returns how alike a file name and a title are, from 0 to 1 for
+// the same letters and digits.
+func nameScore(file, title string) float64 {
+	f := matchKey(fileNumberPrefix.ReplaceAllString(strings.TrimSuffix(filepath.Base(file), filepath.Ext(file)), ""))
+	best := 0.0
+	// Titles may have been written as ASCII
+	for _, t := range []string{matchKey(title), matchKey(sanitizeName(title, true))} {
+		if f == "" || t == "" {
+			continue
+		}
+
+		if f == t {
+			return 1
+		}
+
+		longest := len([]rune(f))
+		if n := len([]rune(t)); n > longest {
+			longest = n
+		}
+		score := 1 - float64(editDistance(f, t))/float64(longest)
+		if (strings.Contains(f, t) || strings.Contains(t, f)) && score < 0.9 && longest >= 4 {
+			// Extra words, say "(Remastered)", or a shortened title
+			score = 0.9
+		}
+		if score > best {
+			best = score
+		}
+	}
+	return best
+}
+
+// minNameScore is how alike a file name and a title have to be to match.
+const minNameScore = 0.75
+
+// tagDirFiles returns the audio files under dir, sorted by path.
+func tagDirFiles(dir string) ([]string, error) {
+	var files []string
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && watchAudioExts[strings.ToLower(filepath.Ext(path))] {
+			files = append(files, path)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %v: %v", dir, err)
+	}
+	sort.Strings(files)
+	return files, nil
+}
+
+// matchFiles matches the tracks to files, returning the file of each track,
+// "" for those it found none for. With match "name" the files are matched by
+// how alike their names are to the titles, best matches first, and with
+// "order" the nth file is that of the nth track. "auto" matches by name,
+// then gives the files left over to the tracks left over in order if there
+// are as many of each.
+func matchFiles(tracks Tracklist, files []string, match string) ([]string, error) {
+	matched := make([]string, len(tracks))
+	if match == "order" {
+		if len(files) != len(tracks) {
+			return nil, fmt.Errorf("found %d files for %d tracks, cannot match them by order", len(files), len(tracks))
+		}
+		copy(matched, files)
+		return matched, nil
+	}
+
+	type pair struct {
+		track, file int
+		score       float64
+	}
+	var pairs []pair
+	for i, t := range tracks {
+		for k, f := range files {
+			s := nameScore(f, t.Title)
+			if t.FileTitle != "" {
+				if fs := nameScore(f, t.FileTitle); fs > s {
+					s = fs
+				}
+			}
+			if s >= minNameScore {
+				pairs = append(pairs, pair{i, k, s})
+			}
+		}
+	}
+	sort.SliceStable(pairs, func(i, k int) bool { return pairs[i].score > pairs[k].score })
+
+	used := make(map[int]bool)
+	for _, p := range pairs {
+		if matched[p.track] == "" && !used[p.file] {
+			matched[p.track] = files[p.file]
+			used[p.file] = true
+		}
+	}
+
+	if match == "auto" {
+		var tracksLeft, filesLeft []int
+		for i := range tracks {
+			if matched[i] == "" {
+				tracksLeft = append(tracksLeft, i)
+			}
+		}
+		for k := range files {
+			if !used[k] {
+				filesLeft = append(filesLeft, k)
+			}
+		}
+
+		if len(tracksLeft) == len(filesLeft) {
+			for n, i := range tracksLeft {
+				matched[i] = files[filesLeft[n]]
+			}
+		}
+	}
+	return matched, nil
+}
+
+// tagDir tags the audio files already in opts.TagDir with the tracks, as
+// opts.TagMatch matches them, without the source or splitting anything.
+func tagDir(ctx context.Context, opts Options) error {
+	if opts.TagMatch != "auto" && opts.TagMatch != "name" && opts.TagMatch != "order" {
+		return withKind(ErrInvalidInput, fmt.Errorf("unknown match %v, must be auto, name or order", opts.TagMatch))
+	}
+
+	tracks, err := ReadTracklist(ctx, opts)
+	if err != nil {
+		return withKind(ErrParse, err)
+	}
+
+	if tracks[0].AlbumArtist == "" || tracks[0].Album == "" {
+		return withKind(ErrInvalidInput, fmt.Errorf("artist and album are required"))
+	}
+
+	if opts.TagsCSV != "" {
+		tags, err := readTagsCSV(opts.TagsCSV)
+		if err != nil {
+			return err
+		}
+
+		if err := applyTagsCSV(tracks, tags); err != nil {
+			return err
+		}
+	}
+
+	if opts.TitleTemplate != "" {
+		if err := applyTitleTemplate(tracks, opts.TitleTemplate, opts.Filename, opts.Vars); err != nil {
+			return err
+		}
+	}
+
+	files, err := tagDirFiles(opts.TagDir)
+	if err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	matched, err := matchFiles(tracks, files, opts.TagMatch)
+	if err != nil {
+		return withKind(ErrInvalidInput, err)
+	}
+
+	if opts.Lyrics != "" {
+		if err := fetchLyrics(ctx, opts, tracks); err != nil {
+			return err
+		}
+	}
+
+	var missing []string
+	used := make(map[string]bool)
+	var tagged Tracklist
+	for i, t := range tracks {
+		file := matched[i]
+		if file == "" {
+			missing = append(missing, fmt.Sprintf("%d %v", t.Number, t.Title))
+			continue
+		}
+		used[file] = true
+
+		o := opts
+		t.Output, t.Ext = file, strings.ToLower(filepath.Ext(file))
+		if o.Tagger, err = selectTagger(opts, t.Ext); err != nil {
+			return withKind(ErrInvalidInput, err)
+		}
+		if o.Tagger == "ffmpeg" {
+			return withKind(ErrInvalidInput, fmt.Errorf("cannot tag %v files, no tagger but ffmpeg supports them", t.Ext))
+		}
+
+		if opts.Cover != "" {
+			if t.Cover, err = findCover(file, opts.Cover); err != nil {
+				return err
+			}
+		}
+
+		opts.logf("tagging %v as track %d \"%v\"\n", file, t.Number, t.Title)
+		if err := tagTrack(ctx, o, t); err != nil {
+			return trackError{t, err}
+		}
+		tagged = append(tagged, t)
+	}
+
+	for _, f := range files {
+		if !used[f] {
+			opts.logf("warning: no track matches %v, not tagging it\n", f)
+		}
+	}
+
+	if opts.Lyrics == "lrc" || opts.Lyrics == "both" {
+		if err := writeLyricsFiles(opts, tagged); err != nil {
+			return err
+		}
+	}
+
+	if len(missing) > 0 {
+		return withKind(failedKind(len(missing), len(tracks)), fmt.Errorf("no file found for %d tracks:\n%v", len(missing), strings.Join(missing, "\n")))
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package avsplit
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Tagger writes the tags of the extracted tracks.
+type Tagger interface {
+	// Available reports whether the tagger can run, e.g. that the tool it
+	// runs is installed.
+	Available() bool
+
+	// Supports reports whether the tagger can tag output files with the
+	// extension.
+	Supports(ext string) bool
+
+	// Tag writes the tags of the track to its extracted file.
+	Tag(ctx context.Context, t Track, file string) error
+}
+
+// taggers are the taggers selectable by name in Options.Tagger.
+var taggers = map[string]Tagger{
+	"eyed3":  eyeD3Tagger{},
+	"native": nativeTagger{},
+	"ffmpeg": ffmpegTagger{},
+}
+
+// taggerFallbacks is the order taggers are tried in for "auto", or when the
+// selected one isn't available.
+var taggerFallbacks = []string{"native", "eyed3", "ffmpeg"}
+
+// selectTagger returns the name of the tagger to tag ext files with.
+func selectTagger(opts Options, ext string) (string, error) {
+	name := opts.Tagger
+	if name != "auto" {
+		tg, ok := taggers[name]
+		if !ok {
+			return "", fmt.Errorf("unknown tagger %v", strconv.Quote(name))
+		}
+
+		if tg.Available() && tg.Supports(ext) {
+			return name, nil
+		}
+
+		if tg.Available() {
+			// Chosen by the output format instead, say for eyed3 and AAC
+			// tracks stream copied from a video
+			name = "auto"
+		}
+	}
+
+	for _, fallback := range taggerFallbacks {
+		tg := taggers[fallback]
+		if tg.Available() && tg.Supports(ext) {
+			if name != "auto" {
+				opts.logf("warning: %v is not available, tagging with %v instead\n", name, fallback)
+			}
+			return fallback, nil
+		}
+	}
+
+	return "", fmt.Errorf("no tagger available for %v tracks", ext)
+}
+
+// tagTrack writes the track's tags to its output file with the selected
+// tagger, unless it can't tag the output format.
+func tagTrack(ctx context.Context, opts Options, t Track) error {
+	tg, ok := taggers[opts.Tagger]
+	if !ok {
+		return fmt.Errorf("unknown tagger %v", strconv.Quote(opts.Tagger))
+	}
+
+	if !tg.Supports(t.ext(opts.Filename)) {
+		return nil
+	}
+
+	return tg.Tag(ctx, t, t.outputFilename(opts.Filename))
+}
+
+// tagFamily returns the tag format ext files are tagged in, "id3",
+// "vorbis" or "mp4", or "" for a format ffmpeg tags in a way of its own.
+func tagFamily(ext string) string {
+	switch strings.ToLower(ext) {
+	case ".mp3", ".aif", ".aiff":
+		return "id3"
+	case ".flac", ".ogg", ".oga", ".opus":
+		return "vorbis"
+	case ".m4a", ".m4b", ".mp4", ".mov":
+		return "mp4"
+	}
+	return ""
+}
+
+// ffmpegTagNames are the names ffmpeg is given each tag by for its muxer to
+// write it into a tag family as the native tagger does, a frame ID3
+// defines, the Vorbis comment players read or an MP4 atom. Tags without a
+// name are left out, such as those MP4 has no atom for, which ffmpeg would
+// otherwise drop or write where no player looks.
+var ffmpegTagNames = map[string]map[string]string{
+	"id3": {
+		"title": "title", "artist": "artist", "album_artist": "album_artist",
+		"album": "album", "composer": "composer", "date": "date", "genre": "genre",
+		"comment": "comment", "lyrics": "lyrics", "publisher": "publisher",
+		"track": "track", "disc": "disc", "work": "TIT1", "isrc": "TSRC",
+		"catalognumber": "CATALOGNUMBER", "movementname": "MVNM", "movement": "MVIN",
+		"length": "TLEN",
+	},
+	"vorbis": {
+		"title": "TITLE", "artist": "ARTIST", "album_artist": "ALBUMARTIST",
+		"album": "ALBUM", "composer": "COMPOSER", "date": "DATE", "genre": "GENRE",
+		"comment": "COMMENT", "lyrics": "LYRICS", "publisher": "LABEL",
+		"track": "TRACKNUMBER", "tracktotal": "TRACKTOTAL", "disc": "DISCNUMBER",
+		"disctotal": "DISCTOTAL", "work": "WORK", "isrc": "ISRC",
+		"catalognumber": "CATALOGNUMBER", "movementname": "MOVEMENTNAME",
+		"movement": "MOVEMENT", "movementtotal": "MOVEMENTTOTAL",
+	},
+	"mp4": {
+		"title": "title", "artist": "artist", "album_artist": "album_artist",
+		"album": "album", "composer": "composer", "date": "date", "genre": "genre",
+		"comment": "comment", "lyrics": "lyrics", "track": "track", "disc": "disc",
+		"work": "grouping",
+	},
+}
+
+type eyeD3Tagger struct{}
+
+func (eyeD3Tagger) Available() bool {
+	_, err := exec.LookPath("eyed3")
+	return err == nil
+}
+
+func (eyeD3Tagger) Supports(ext string) bool {
+	return ext == ".mp3"
+}
+
+func (eyeD3Tagger) Tag(ctx context.Context, t Track, file string) error {
+	args := t.eyeD3Args(file)
+	if t.Lyrics != "" {
+		// eyed3 only reads lyrics from a file
+		f, err := os.CreateTemp("", "avsplit-lyrics-*.txt")
+		if err != nil {
+			return err
+		}
+		defer os.Remove(f.Name())
+
+		_, err = f.WriteString(t.Lyrics)
+		if cerr := f.Close(); err == nil {
+			err = cerr
+		}
+		if err != nil {
+			return err
+		}
+
+		lyrics := "--add-lyrics=" + eyeD3Colons.Replace(f.Name())
+		args = append(args[:len(args)-1:len(args)-1], lyrics, file)
+	}
+	return execCommand(ctx, "eyed3", args...)
+}
+
+// nativeTagger writes ID3v2.4 tags to mp3 files, Vorbis comments to FLAC, Ogg
+// and Opus files, and iTunes style atoms to MP4 files, without any external
+// tool.
+type nativeTagger struct{}
+
+func (nativeTagger) Available() bool {
+	return true
+}
+
+func (nativeTagger) Supports(ext string) bool {
+	switch ext {
+	case ".mp3", ".flac", ".ogg", ".oga", ".opus", ".m4a", ".m4b", ".mp4":
+		return true
+	}
+	return false
+}
+
+func (nativeTagger) Tag(ctx context.Context, t Track, file string) error {
+	switch strings.ToLower(filepath.Ext(file)) {
+	case ".flac":
+		return writeFLACTags(file, t.vorbisComments(), t.Cover)
+	case ".ogg", ".oga", ".opus":
+		return writeOggTags(file, t.vorbisComments(), t.Cover)
+	case ".m4a", ".m4b", ".mp4":
+		return writeMP4Tags(file, t)
+	}
+
+	frames, err := t.id3Frames()
+	if err != nil {
+		return err
+	}
+	return writeID3(file, frames)
+}
+
+// ffmpegTagger leaves the tracks with the tags written by -metadata while
+// they are extracted, see Track.Metadata, so it has nothing left to do.
+type ffmpegTagger struct{}
+
+// Available is always true, ffmpeg is checked up front by checkTools.
+func (ffmpegTagger) Available() bool {
+	return true
+}
+
+func (ffmpegTagger) Supports(ext string) bool {
+	return true
+}
+
+func (ffmpegTagger) Tag(ctx context.Context, t Track, file string) error {
+	return nil
+}
@@ -0,0 +1,184 @@
+package avsplit
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+var tagsCSVColumns = []string{"number", "disc", "title", "artist", "album", "composer", "year", "work", "movement"}
+
+// tagsCSVKey is the track a row of a tags CSV is for, disc being 0 when the
+// file has no disc column.
+type tagsCSVKey struct {
+	disc, number int
+}
+
+// readTagsCSV reads a CSV file with a header row and returns the non-empty
+// values of each row keyed by its track and column name.
+func readTagsCSV(filename string) (map[tagsCSVKey]map[string]string, error) {
+	f, err := os.Open(filename)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read tags csv file")
+	}
+	defer f.Close()
+
+	r := csv.NewReader(f)
+	r.TrimLeadingSpace = true
+
+	header, err := r.Read()
+	if err != nil {
+		return nil, fmt.Errorf("tags csv: cannot read header: %v", err)
+	}
+
+	var unknown []string
+	numberCol, discCol := -1, -1
+	for i, h := range header {
+		h = strings.ToLower(strings.TrimSpace(h))
+		header[i] = h
+
+		switch h {
+		case "number":
+			numberCol = i
+		case "disc":
+			discCol = i
+		}
+
+		known := false
+		for _, c := range tagsCSVColumns {
+			if h == c {
+				known = true
+				break
+			}
+		}
+		if !known {
+			unknown = append(unknown, h)
+		}
+	}
+
+	if len(unknown) > 0 {
+		return nil, fmt.Errorf("tags csv: unknown columns: %v", strings.Join(unknown, ", "))
+	}
+
+	if numberCol < 0 {
+		return nil, fmt.Errorf("tags csv: missing number column")
+	}
+
+	tags := make(map[tagsCSVKey]map[string]string)
+	for {
+		row, err := r.Read()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("tags csv: %v", err)
+		}
+
+		line, _ := r.FieldPos(numberCol)
+		var key tagsCSVKey
+		if key.number, err = strconv.Atoi(strings.TrimSpace(row[numberCol])); err != nil {
+			return nil, fmt.Errorf("tags csv: invalid track number on line %d", line)
+		}
+		if discCol >= 0 {
+			if key.disc, err = strconv.Atoi(strings.TrimSpace(row[discCol])); err != nil || key.disc < 1 {
+				return nil, fmt.Errorf("tags csv: invalid disc on line %d", line)
+			}
+		}
+		if _, ok := tags[key]; ok {
+			return nil, fmt.Errorf("tags csv: track %v repeated on line %d", key, line)
+		}
+
+		values := make(map[string]string)
+		for i, v := range row {
+			if v = strings.TrimSpace(v); v != "" && i != numberCol && i != discCol {
+				values[header[i]] = v
+			}
+		}
+		tags[key] = values
+	}
+
+	return tags, nil
+}
+
+// applyTagsCSV sets the tags of the rows to their tracks, by disc and number
+// when the file has a disc column and by number alone otherwise, which a
+// tracklist numbering each of its discs from 1 again can't be matched by.
+func applyTagsCSV(tracks []Track, tags map[tagsCSVKey]map[string]string) error {
+	byDisc := false
+	for k := range tags {
+		byDisc = k.disc != 0
+		break
+	}
+
+	// The tracks may be numbered on from -track-start
+	keys := make(map[tagsCSVKey]bool, len(tracks))
+	for i := range tracks {
+		k := tracks[i].tagsCSVKey(byDisc)
+		if keys[k] {
+			return fmt.Errorf("tags csv: more than one track %d, add a disc column", k.number)
+		}
+		keys[k] = true
+	}
+	for k := range tags {
+		if !keys[k] {
+			return fmt.Errorf("tags csv: no track %v", k)
+		}
+	}
+
+	for i := range tracks {
+		values, ok := tags[tracks[i].tagsCSVKey(byDisc)]
+		if !ok {
+			continue
+		}
+
+		for k, v := range values {
+			switch k {
+			case "title":
+				tracks[i].Title = v
+			case "artist":
+				tracks[i].Artist = v
+			case "album":
+				tracks[i].Album = v
+			case "composer":
+				tracks[i].Composer = v
+			case "work":
+				tracks[i].Work = v
+			case "movement":
+				m, err := strconv.Atoi(v)
+				if err != nil || m < 1 {
+					return fmt.Errorf("tags csv: invalid movement %v of track %d", v, tracks[i].Number)
+				}
+				tracks[i].Movement = m
+			case "year":
+				tracks[i].Year = v
+			}
+		}
+	}
+
+	// Works given in the file are numbered like those of the tracklist
+	numberMovements(tracks)
+	return nil
+}
+
+// tagsCSVKey returns the key of the track's row, with its disc when byDisc
+// is set, 1 for a tracklist without discs.
+func (t *Track) tagsCSVKey(byDisc bool) tagsCSVKey {
+	k := tagsCSVKey{number: t.Number}
+	if byDisc {
+		k.disc = t.Disc
+		if k.disc == 0 {
+			k.disc = 1
+		}
+	}
+	return k
+}
+
+func (k tagsCSVKey) String() string {
+	if k.disc == 0 {
+		return fmt.Sprintf("number %d", k.number)
+	}
+	return fmt.Sprintf("%d on disc %d", k.number, k.disc)
+}
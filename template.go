@@ -0,0 +1,176 @@
+package avsplit
+
+import (
+	"fmt"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+func execTemplate(tmpl *template.Template, t Track) (string, error) {
+	var b strings.Builder
+	if err := tmpl.Execute(&b, t); err != nil {
+		return "", err
+	}
+	return b.String(), nil
+}
+
+// applyDirTemplate renders the directory of each track from a template such
+// as "{{.AlbumArtist}}/{{.Year}} - {{.Album}}". Each segment is rendered on
+// its own and sanitized, so a slash inside a tag value can't add another
+// directory level.
+func applyDirTemplate(tracks []Track, dirTemplate string, ascii bool) error {
+	var segments []*template.Template
+	for i, s := range strings.Split(dirTemplate, "/") {
+		tmpl, err := template.New(fmt.Sprintf("dir-%d", i)).Parse(s)
+		if err != nil {
+			return fmt.Errorf("invalid dir-template: %v", err)
+		}
+		segments = append(segments, tmpl)
+	}
+
+	for i := range tracks {
+		var parts []string
+		for _, tmpl := range segments {
+			v, err := execTemplate(tmpl, tracks[i])
+			if err != nil {
+				return fmt.Errorf("invalid dir-template: %v", err)
+			}
+			parts = append(parts, sanitizeName(v, ascii))
+		}
+		tracks[i].Dir = filepath.Join(parts...)
+	}
+
+	return nil
+}
+
+var outputSpec = regexp.MustCompile(`^0?[0-9]*d$`)
+
+// outputFields returns the values of the template placeholders for the
+// track, with vars adding placeholders of the user's own.
+func (t *Track) outputFields(audioFile string, vars map[string]string) map[string]interface{} {
+	fields := map[string]interface{}{
+		"artist":      t.Artist,
+		"albumartist": t.AlbumArtist,
+		"album":       t.Album,
+		"title":       t.fileTitle(),
+		"track":       t.Number,
+		"total":       t.Total,
+		"year":        t.Year,
+		"composer":    t.Composer,
+		"work":        t.Work,
+		"movement":    t.Movement,
+		"movements":   t.Movements,
+		"genre":       t.Genre,
+		"label":       t.Label,
+		"catno":       t.CatalogNumber,
+		"isrc":        t.ISRC,
+		"disc":        t.Disc,
+		"disctotal":   t.DiscTotal,
+		"ext":         strings.TrimPrefix(t.ext(audioFile), "."),
+	}
+
+	// The track's own fields can't be replaced
+	for k, v := range vars {
+		if _, ok := fields[k]; !ok {
+			fields[k] = v
+		}
+	}
+	return fields
+}
+
+// renderOutputTemplate renders the path of a track from a template such as
+// "{artist}/{album}/{track:02d} - {title}.{ext}". Values are sanitized so a
+// slash in one doesn't add a directory level, and then so is each name in
+// the path.
+func renderOutputTemplate(tmpl string, fields map[string]interface{}, ascii bool) (string, error) {
+	out, err := expandTemplate("output-template", tmpl, fields, func(s string) string {
+		return sanitizeChars(s, ascii)
+	})
+	if err != nil {
+		return "", err
+	}
+	return sanitizePath(out, ascii), nil
+}
+
+// applyTitleTemplate renders the title of each track from a template such
+// as "{title} (Live at {venue}, {date})".
+func applyTitleTemplate(tracks []Track, tmpl, audioFile string, vars map[string]string) error {
+	for i := range tracks {
+		t := &tracks[i]
+		fields := t.outputFields(audioFile, vars)
+		fields["title"] = t.Title
+		title, err := expandTemplate("title-template", tmpl, fields, nil)
+		if err != nil {
+			return err
+		}
+
+		// The title the file is named after gets the same treatment
+		if t.FileTitle != "" {
+			fields["title"] = t.FileTitle
+			if t.FileTitle, err = expandTemplate("title-template", tmpl, fields, nil); err != nil {
+				return err
+			}
+		}
+		t.Title = title
+	}
+	return nil
+}
+
+// expandTemplate replaces the {name} placeholders of tmpl with the fields,
+// passing each value through clean if set. Numeric placeholders take an
+// optional printf style width, as in {track:02d}. Errors name the template
+// as flag.
+func expandTemplate(flag, tmpl string, fields map[string]interface{}, clean func(string) string) (string, error) {
+	var b strings.Builder
+	for {
+		i := strings.IndexByte(tmpl, '{')
+		if i < 0 {
+			b.WriteString(tmpl)
+			break
+		}
+
+		j := strings.IndexByte(tmpl[i:], '}')
+		if j < 0 {
+			return "", fmt.Errorf("invalid %v: unclosed {", flag)
+		}
+
+		b.WriteString(tmpl[:i])
+		name, spec := tmpl[i+1:i+j], ""
+		tmpl = tmpl[i+j+1:]
+
+		if k := strings.IndexByte(name, ':'); k >= 0 {
+			name, spec = name[:k], name[k+1:]
+		}
+
+		v, ok := fields[name]
+		if !ok {
+			return "", fmt.Errorf("invalid %v: unknown placeholder {%v}", flag, name)
+		}
+
+		var s string
+		switch v := v.(type) {
+		case int:
+			if spec == "" {
+				spec = "d"
+			}
+			if !outputSpec.MatchString(spec) {
+				return "", fmt.Errorf("invalid %v: bad format {%v:%v}", flag, name, spec)
+			}
+			s = fmt.Sprintf("%"+spec, v)
+		default:
+			if spec != "" {
+				return "", fmt.Errorf("invalid %v: {%v} takes no format", flag, name)
+			}
+			s = fmt.Sprint(v)
+		}
+
+		if clean != nil {
+			s = clean(s)
+		}
+		b.WriteString(s)
+	}
+
+	return b.String(), nil
+}
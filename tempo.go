@@ -0,0 +1,60 @@
+package avsplit
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+)
+
+// checkTempo checks a tempo factor and a pitch shift in semitones, 0 for
+// either leaving it alone.
+func checkTempo(tempo, pitch float64) error {
+	if tempo != 0 && (tempo < 0.25 || tempo > 4) {
+		return fmt.Errorf("tempo must be between 0.25 and 4, not %v", tempo)
+	}
+	if math.Abs(pitch) > 24 {
+		return fmt.Errorf("pitch must be between -24 and 24 semitones, not %v", pitch)
+	}
+	return nil
+}
+
+// tempoFilter returns the ffmpeg filter playing the audio tempo times as
+// fast and pitch semitones higher, each without changing the other. The
+// pitch is shifted by resampling, which needs the sample rate of the audio.
+// It returns "" when both are left alone.
+func tempoFilter(tempo, pitch float64, sampleRate string) (string, error) {
+	if err := checkTempo(tempo, pitch); err != nil {
+		return "", err
+	}
+	if tempo == 0 {
+		tempo = 1
+	}
+
+	var filters []string
+	if pitch != 0 {
+		rate, err := strconv.Atoi(strings.TrimSpace(sampleRate))
+		if err != nil || rate <= 0 {
+			return "", fmt.Errorf("pitch needs the sample rate of the audio file")
+		}
+
+		// Played faster by the pitch ratio, then slowed back down
+		ratio := math.Pow(2, pitch/12)
+		filters = append(filters, fmt.Sprintf("asetrate=%d,aresample=%d", int(math.Round(float64(rate)*ratio)), rate))
+		tempo /= ratio
+	}
+
+	// Older ffmpeg takes between 0.5 and 2 in one atempo, chained for more
+	for tempo > 2 {
+		filters = append(filters, "atempo=2")
+		tempo /= 2
+	}
+	for tempo < 0.5 {
+		filters = append(filters, "atempo=0.5")
+		tempo /= 0.5
+	}
+	if tempo = math.Round(tempo*1e6) / 1e6; tempo != 1 {
+		filters = append(filters, "atempo="+strconv.FormatFloat(tempo, 'f', -1, 64))
+	}
+	return strings.Join(filters, ","), nil
+}
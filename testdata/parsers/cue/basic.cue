@@ -0,0 +1,13 @@
+PERFORMER "The Band"
+TITLE "Live Album"
+FILE "live.flac" WAVE
+  TRACK 01 AUDIO
+    TITLE "Intro"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Song Two"
+    PERFORMER "Guest"
+    INDEX 01 03:10:00
+  TRACK 03 AUDIO
+    TITLE "Song Three"
+    INDEX 01 07:45:37
@@ -0,0 +1,10 @@
+FILE "album.wav" WAVE
+  TRACK 01 AUDIO
+    TITLE "One"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE "Two"
+    INDEX 01 03:10:00
+  TRACK 03 AUDIO
+    TITLE "Three"
+    INDEX 01 03:10:00
@@ -0,0 +1,15 @@
+PERFORMER "The Band"
+TITLE "Frames"
+FILE "disc.wav" WAVE
+  TRACK 01 AUDIO
+    TITLE "One Frame In"
+    INDEX 01 00:00:01
+  TRACK 02 AUDIO
+    TITLE "Two Frames"
+    INDEX 01 03:10:02
+  TRACK 03 AUDIO
+    TITLE "Last Frame"
+    INDEX 01 05:59:74
+  TRACK 04 AUDIO
+    TITLE "Long Disc"
+    INDEX 01 79:58:38
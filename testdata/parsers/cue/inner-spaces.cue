@@ -0,0 +1,10 @@
+PERFORMER "The  Band"
+TITLE "Live   at the Hall"
+FILE "live.wav" WAVE
+  TRACK 01 AUDIO
+    TITLE "Intro  (Reprise)"
+    PERFORMER "A  &  B"
+    INDEX 01 00:00:00
+  TRACK 02 AUDIO
+    TITLE  Unquoted  Title
+    INDEX 01 03:10:00
@@ -0,0 +1,11 @@
+PERFORMER "The Band"
+TITLE "Gapped"
+FILE "album.wav" WAVE
+  TRACK 01 AUDIO
+    TITLE "One"
+    INDEX 00 00:00:00
+    INDEX 01 00:02:00
+  TRACK 02 AUDIO
+    TITLE "Two"
+    INDEX 00 03:08:00
+    INDEX 01 03:10:00
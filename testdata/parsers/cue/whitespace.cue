@@ -0,0 +1,10 @@
+performer "The Band"
+title   "Spaced Out"
+FILE "a.flac" WAVE
+	TRACK 01 AUDIO
+		TITLE "One"
+		INDEX 01 00:00:00
+
+	TRACK 02 AUDIO
+		TITLE "Two"
+		INDEX   01   04:00:00
@@ -0,0 +1,81 @@
+package avsplit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+// cleanText undoes what editors, Windows ones especially, and copying from
+// web pages leave in a tracklist: a UTF-8 byte order mark, CRLF line
+// endings, and non-breaking, zero width or other odd spaces. It returns the
+// cleaned text and warnings naming what was found, with the lines for the
+// spaces, which look like plain ones but break the parsing otherwise.
+func cleanText(data []byte) (string, []string) {
+	text := string(data)
+	var warnings []string
+
+	if strings.HasPrefix(text, "\ufeff") {
+		text = strings.TrimPrefix(text, "\ufeff")
+		warnings = append(warnings, "stripped a UTF-8 byte order mark")
+	}
+
+	if strings.Contains(text, "\r") {
+		text = strings.ReplaceAll(text, "\r\n", "\n")
+		text = strings.ReplaceAll(text, "\r", "\n")
+		warnings = append(warnings, "read Windows line endings")
+	}
+
+	var spaces []string
+	lines := strings.Split(text, "\n")
+	for i, l := range lines {
+		cleaned := strings.Map(func(r rune) rune {
+			switch {
+			case r == '\u200b' || r == '\u200c' || r == '\u200d' || r == '\u2060' || r == '\ufeff':
+				// Zero width, dropped
+				return -1
+			case r != ' ' && r != '\t' && unicode.IsSpace(r):
+				return ' '
+			}
+			return r
+		}, l)
+
+		if cleaned != l {
+			lines[i] = cleaned
+			spaces = append(spaces, strconv.Itoa(i+1))
+		}
+	}
+
+	if len(spaces) > 0 {
+		if len(spaces) > 10 {
+			spaces = append(spaces[:10], "...")
+		}
+		warnings = append(warnings, fmt.Sprintf("replaced non-breaking or zero width spaces on line %v", strings.Join(spaces, ", ")))
+	}
+	return strings.Join(lines, "\n"), warnings
+}
+
+// readText reads a tracklist file like os.ReadFile, or stdin for "-",
+// cleaning it up with cleanText and logging what was found.
+func readText(opts Options, file string) ([]byte, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = io.ReadAll(os.Stdin)
+		file = "stdin"
+	} else {
+		data, err = os.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	text, warnings := cleanText(data)
+	for _, w := range warnings {
+		opts.logf("warning: %v: %v\n", file, w)
+	}
+	return []byte(text), nil
+}
@@ -0,0 +1,87 @@
+package avsplit
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// ioniceClasses maps the -ionice classes to those of ionice -c.
+var ioniceClasses = map[string]string{
+	"realtime":    "1",
+	"best-effort": "2",
+	"idle":        "3",
+}
+
+// ioniceArgs returns the ionice arguments for an I/O priority such as
+// "idle" or "best-effort:7", the class and an optional level from 0, the
+// highest, to 7.
+func ioniceArgs(s string) ([]string, error) {
+	class, level := s, ""
+	if i := strings.Index(s, ":"); i >= 0 {
+		class, level = s[:i], s[i+1:]
+	}
+
+	c, ok := ioniceClasses[class]
+	if !ok {
+		return nil, fmt.Errorf("unknown ionice class %v, must be idle, best-effort or realtime", class)
+	}
+
+	args := []string{"-c", c}
+	if level != "" {
+		n, err := strconv.Atoi(level)
+		if err != nil || n < 0 || n > 7 || class == "idle" {
+			return nil, fmt.Errorf("invalid ionice %v, the level of best-effort and realtime is 0 to 7", s)
+		}
+		args = append(args, "-n", level)
+	}
+	return args, nil
+}
+
+// checkThrottle checks the Nice, IONice and CPULimit of o.
+func checkThrottle(o Options) error {
+	if o.Nice < -20 || o.Nice > 19 {
+		return fmt.Errorf("nice must be between -20 and 19")
+	}
+
+	if o.CPULimit < 0 {
+		return fmt.Errorf("cpu-limit must not be negative")
+	}
+
+	if o.IONice != "" {
+		if _, err := ioniceArgs(o.IONice); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// throttle returns the command line to run ffmpeg at path with args as o
+// throttles it: with at most CPULimit threads, under ionice with IONice and
+// under nice with Nice.
+func (o Options) throttle(path string, args []string) (string, []string) {
+	if len(args) == 1 && args[0] == "-version" {
+		// Only checking the tool
+		return path, args
+	}
+
+	if o.CPULimit > 0 && len(args) > 0 {
+		// -threads limits the encoder, as an option of the output file
+		// given last
+		n := strconv.Itoa(o.CPULimit)
+		last := len(args) - 1
+		limited := append([]string{"-filter_threads", n}, args[:last]...)
+		args = append(limited, "-threads", n, args[last])
+	}
+
+	cmd := append([]string{path}, args...)
+	if o.IONice != "" {
+		if c, err := ioniceArgs(o.IONice); err == nil {
+			cmd = append(append([]string{"ionice"}, c...), cmd...)
+		}
+	}
+	if o.Nice != 0 {
+		cmd = append([]string{"nice", "-n", strconv.Itoa(o.Nice)}, cmd...)
+	}
+	return cmd[0], cmd[1:]
+}
@@ -0,0 +1,693 @@
+package avsplit
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+)
+
+// ReadTracklist reads the tracks from the source selected in opts and fills
+// in untitled tracks from the releases looked up or the auto-title template.
+func ReadTracklist(ctx context.Context, opts Options) (Tracklist, error) {
+	opts = opts.withDefaults()
+	ctx = opts.withOptions(ctx)
+
+	autoTitleText := opts.AutoTitle
+	if autoTitleText == "" && opts.DetectSilence {
+		autoTitleText = "Track {{.Number}}"
+	}
+
+	if autoTitleText == "" && opts.FromChapters {
+		autoTitleText = "Chapter {{.Number}}"
+	}
+
+	if autoTitleText == "" && opts.FLACCue {
+		autoTitleText = "Track {{.Number}}"
+	}
+
+	var autoTitle *template.Template
+	if autoTitleText != "" {
+		var err error
+		autoTitle, err = template.New("auto-title").Parse(autoTitleText)
+		if err != nil {
+			return nil, fmt.Errorf("invalid auto-title template: %v", err)
+		}
+	}
+
+	filters, err := parseTitleFilters(opts.TitleFilters)
+	if err != nil {
+		return nil, withKind(ErrInvalidInput, err)
+	}
+
+	if opts.AltTitles != "" && opts.AltTitles != "filenames" && opts.AltTitles != "tags" {
+		return nil, withKind(ErrInvalidInput, fmt.Errorf("unknown alt-titles %v, must be filenames or tags", opts.AltTitles))
+	}
+
+	// Each release fills in over the one before, the last also giving the
+	// tracks when nothing else does
+	var releases []*Release
+	lookup := func(p MetadataProvider, id string) error {
+		r, err := lookupRelease(ctx, opts, p, id)
+		if err != nil {
+			return err
+		}
+		releases = append(releases, r)
+		return nil
+	}
+	if opts.MBRelease != "" || opts.MBSearch {
+		if err := lookup(musicBrainzProvider, opts.MBRelease); err != nil {
+			return nil, err
+		}
+	}
+	if opts.DiscogsRelease != "" {
+		if err := lookup(discogsProvider, opts.DiscogsRelease); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Metadata != nil {
+		if err := lookup(opts.Metadata, opts.ReleaseID); err != nil {
+			return nil, err
+		}
+	}
+
+	var tracks Tracklist
+	if opts.Cue != "" {
+		tracks, err = readCue(opts)
+	} else if opts.Audacity != "" {
+		tracks, err = readAudacityLabels(opts)
+	} else if opts.DetectSilence {
+		tracks, err = detectSilence(ctx, opts)
+	} else if opts.FromChapters {
+		tracks, err = chapterTracks(ctx, opts)
+	} else if opts.FLACCue {
+		tracks, err = flacCueTracks(opts)
+	} else if opts.Timecodes != "" {
+		tracks, err = readTimecodes(opts)
+	} else if opts.FromURL != "" {
+		tracks, err = ytDlpTracks(opts)
+	} else if len(releases) > 0 {
+		tracks, err = releaseTracks(releases[len(releases)-1])
+	} else {
+		err = withKind(ErrInvalidInput, fmt.Errorf("no tracklist given: timecodes, a cue sheet, chapters or a release to look up"))
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := resolveFromEnd(ctx, opts, tracks); err != nil {
+		return nil, err
+	}
+
+	if len(filters) > 0 {
+		filterTitles(tracks, filters)
+	}
+
+	for _, r := range releases {
+		applyRelease(r, tracks, opts)
+	}
+
+	if opts.Discs != "" {
+		if err := splitDiscs(tracks, opts.Discs); err != nil {
+			return nil, err
+		}
+	}
+
+	// Release wide tags given in opts apply to every track, over anything
+	// found in the source, except the discs of a tracklist with several
+	multi := multiDisc(tracks)
+	for i := range tracks {
+		t := &tracks[i]
+		if opts.Year != "" {
+			t.Year = opts.Year
+		}
+		if opts.Genre != "" {
+			t.Genre = opts.Genre
+		}
+		if opts.Disc != 0 && !multi {
+			t.Disc = opts.Disc
+		}
+		if opts.DiscTotal != 0 && !multi {
+			t.DiscTotal = opts.DiscTotal
+		}
+		if opts.Comment != "" {
+			t.Comment = opts.Comment
+		}
+	}
+
+	if opts.SourceTags != "" {
+		if err := applySourceTags(ctx, opts, tracks); err != nil {
+			return nil, err
+		}
+	}
+
+	if opts.TrackStart > 1 {
+		// Carries on the numbering of an earlier part, say the disc before,
+		// and counts its tracks in the total
+		for i := range tracks {
+			tracks[i].Number += opts.TrackStart - 1
+			tracks[i].Total += opts.TrackStart - 1
+		}
+	}
+
+	if autoTitle != nil {
+		for i := range tracks {
+			if tracks[i].Title != "" {
+				continue
+			}
+
+			title, err := execTemplate(autoTitle, tracks[i])
+			if err != nil {
+				return nil, fmt.Errorf("invalid auto-title template: %v", err)
+			}
+			tracks[i].Title = title
+		}
+	}
+
+	if opts.Romanize {
+		romanizeTitles(tracks, opts)
+	}
+
+	return tracks, nil
+}
+
+func readTimecodes(opts Options) (Tracklist, error) {
+	name := opts.Timecodes
+	if name == "-" {
+		// Piped in, from the clipboard say
+		name = "stdin"
+	} else if _, err := os.Stat(opts.Timecodes); err != nil {
+		return nil, fmt.Errorf("timecodes file not found")
+	}
+
+	data, err := readText(opts, opts.Timecodes)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read timecodes file")
+	}
+
+	format := opts.TimecodesFormat
+	if format == "" && opts.YouTube {
+		format = "youtube"
+	}
+	if format == "" {
+		switch strings.ToLower(filepath.Ext(opts.Timecodes)) {
+		case ".yaml", ".yml":
+			format = "yaml"
+		case ".toml":
+			format = "toml"
+		}
+	}
+	if format == "" {
+		format = detectTimecodesFormat(data)
+		if format != "timecodes" {
+			opts.logf("reading %v as %v\n", name, timecodesFormats[format])
+		}
+	}
+
+	switch format {
+	case "timecodes":
+		return ParseTimecodes(bytes.NewReader(data), opts)
+	case "youtube":
+		opts.YouTube = true
+		return ParseTimecodes(bytes.NewReader(data), opts)
+	case "cue":
+		return parseCue(bytes.NewReader(data), opts)
+	case "chapters":
+		var result struct {
+			Chapters []probedChapter `json:"chapters"`
+		}
+		if err := json.Unmarshal(data, &result); err != nil {
+			return nil, fmt.Errorf("invalid chapters json: %v", err)
+		}
+		return chaptersToTracks(result.Chapters, opts)
+	case "audacity":
+		return parseAudacityLabels(bytes.NewReader(data), opts)
+	case "timeline":
+		return parseTimeline(data, opts)
+	case "yaml", "toml":
+		parse := parseYAMLTracklist
+		if format == "toml" {
+			parse = parseTOMLTracklist
+		}
+		s, err := parse(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		dir := "."
+		if opts.Timecodes != "-" {
+			dir = filepath.Dir(opts.Timecodes)
+		}
+		return structuredTracks(s, opts, dir)
+	}
+
+	return nil, fmt.Errorf("unknown timecodes format %v, must be timecodes, youtube, cue, chapters, audacity, timeline, yaml or toml", format)
+}
+
+// timecodesFormats describes the formats a timecodes file can be in.
+var timecodesFormats = map[string]string{
+	"timecodes": "a timecodes file",
+	"youtube":   "a YouTube description",
+	"cue":       "a CUE sheet",
+	"chapters":  "ffprobe chapters JSON",
+	"audacity":  "Audacity labels",
+	"timeline":  "a now playing timeline",
+	"yaml":      "a YAML tracklist",
+	"toml":      "a TOML tracklist",
+}
+
+var (
+	// "00:00 - Title", "00:00 – Title": a dash between the timecode and the
+	// title only separates them
+	titleSeparator = regexp.MustCompile(`^[-–—]+\s+`)
+	timecodeDashes = strings.NewReplacer("–", "-", "—", "-")
+
+	audacityLabelLine = regexp.MustCompile(`^\d+([.,]\d+)?\t\d+([.,]\d+)?(\t.*)?$`)
+	cueCommandLine    = regexp.MustCompile(`^(?i)(REM|PERFORMER|TITLE|FILE|TRACK|INDEX|CATALOG|SONGWRITER|FLAGS|ISRC|PREGAP|POSTGAP|CDTEXTFILE)\b`)
+)
+
+// detectTimecodesFormat guesses the format of a timecodes file from its
+// contents, a "tracks:" or "[[tracks]]" line making it a YAML or TOML
+// tracklist. Anything that isn't clearly another format is read as a
+// timecodes file, or a YouTube description when some lines don't start
+// with a timecode.
+func detectTimecodesFormat(data []byte) string {
+	text := strings.TrimPrefix(string(data), "\ufeff")
+	if strings.HasPrefix(strings.TrimSpace(text), "{") && strings.Contains(text, `"chapters"`) {
+		return "chapters"
+	}
+	if body := strings.TrimSpace(text); strings.HasPrefix(body, "[") && strings.HasSuffix(body, "]") || strings.HasPrefix(body, "{") && strings.Contains(body, `"events"`) {
+		return "timeline"
+	}
+
+	var lines []string
+	for _, l := range strings.Split(text, "\n") {
+		if l = strings.TrimRight(l, "\r"); strings.TrimSpace(l) != "" {
+			lines = append(lines, l)
+		}
+	}
+
+	cue, index, audacity, timecodes := 0, false, 0, 0
+	header := true
+	for _, l := range lines {
+		switch strings.TrimSpace(strings.Split(l, "#")[0]) {
+		case "tracks:":
+			return "yaml"
+		case "[[tracks]]":
+			return "toml"
+		}
+
+		if _, _, ok := parseHeaderLine(l); ok && header {
+			timecodes++
+			continue
+		}
+
+		if _, ok := parseDiscMarker(l); ok {
+			timecodes++
+			continue
+		}
+
+		if _, _, ok := parseClassicalMarker(l); ok {
+			timecodes++
+			continue
+		}
+
+		header = false
+		trimmed := strings.TrimSpace(l)
+		if cueCommandLine.MatchString(trimmed) {
+			cue++
+			index = index || strings.HasPrefix(strings.ToUpper(trimmed), "INDEX")
+		}
+
+		if audacityLabelLine.MatchString(l) || strings.HasPrefix(l, "\\") {
+			audacity++
+		}
+
+		first := timecodeDashes.Replace(strings.Fields(l)[0])
+		if i := strings.Index(first[1:], "-"); i >= 0 {
+			first = first[:i+1]
+		}
+		if _, err := normalizeTimecode(first); err == nil {
+			timecodes++
+		}
+	}
+
+	switch {
+	case index && cue == len(lines):
+		return "cue"
+	case audacity == len(lines) && len(lines) > 0:
+		return "audacity"
+	case timecodes < len(lines):
+		return "youtube"
+	}
+	return "timecodes"
+}
+
+// ParseTimecodes parses a timecodes file, one "HH:MM:SS Title" line per
+// track, each running up to the next line or to an end given as
+// "HH:MM:SS-HH:MM:SS Title". A "HH:MM:SS [gap]", "[skip]" or "--" line
+// starts a stretch that isn't a track, such as applause, and a timecode
+// starting with "-", such as "-00:04:30", counts back from the end of the
+// audio file, resolved by ReadTracklist. The file can start with header
+// lines such as "ARTIST: Miles Davis", "ALBUM: Kind of Blue" and "DATE:
+// 1959" giving the tags opts doesn't. A "# DISC 2" line puts the tracks
+// after it on disc 2, numbered from 1 again, and for classical music a
+// "# WORK Symphony No. 5" line makes the tracks up to the next one the
+// movements of that work, with "# COMPOSER Beethoven" setting their
+// composer. A title can end with directives for its track alone, such as
+// "@artist=Guest @format=flac" or "@skip" to leave it out, and with
+// opts.AltTitles be followed by a second one, as in "夜に駆ける | Yoru ni
+// Kakeru". Lines without a title are accepted when AutoTitle or a
+// MusicBrainz lookup fills the titles in later, and a single bare
+// timecode, trimming the recording to it, is the one track named after the
+// album. With opts.YouTube the file is a pasted YouTube description, its
+// lines without a timecode skipped. A byte order mark, Windows line
+// endings and odd spaces are cleaned up as the file is read.
+func ParseTimecodes(r io.Reader, opts Options) (Tracklist, error) {
+	opts = opts.withDefaults()
+	allowUntitled := opts.AutoTitle != "" || opts.looksUpRelease()
+
+	// Cleaned here too for callers that don't read the file with readText
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, err
+	}
+	text, warnings := cleanText(data)
+	for _, w := range warnings {
+		opts.logf("warning: tracklist: %v\n", w)
+	}
+
+	s := bufio.NewScanner(strings.NewReader(text))
+	// The scanner takes lines as long as its buffer, whatever the limit
+	size := 4096
+	if opts.MaxLineBytes < size {
+		size = opts.MaxLineBytes
+	}
+	s.Buffer(make([]byte, 0, size), opts.MaxLineBytes)
+
+	var timecodes [][]string
+	var lines, discs []int
+	var lineDirectives []map[string]string
+	var works, composers []string
+	header := make(map[string]string)
+	// The line of a first timecode without a title, the whole tracklist
+	// unless another follows
+	untitled := 0
+	disc := 0
+	work, composer := "", ""
+	line := 0
+	for s.Scan() {
+		line++
+		if strings.TrimSpace(s.Text()) == "" {
+			continue
+		}
+
+		if field, value, ok := parseHeaderLine(s.Text()); ok && len(timecodes) == 0 {
+			header[field] = value
+			continue
+		}
+
+		if n, ok := parseDiscMarker(s.Text()); ok {
+			disc = n
+			continue
+		}
+
+		if field, value, ok := parseClassicalMarker(s.Text()); ok {
+			if field == "work" {
+				work = value
+			} else {
+				composer = value
+			}
+			continue
+		}
+
+		// Split at the first space or tab, with any more of them
+		tc := []string{strings.TrimSpace(s.Text())}
+		if i := strings.IndexAny(tc[0], " \t"); i >= 0 {
+			tc = []string{tc[0][:i], tc[0][i+1:]}
+		}
+		if opts.YouTube {
+			timecode, title, ok := parseYouTubeLine(s.Text())
+			if !ok {
+				continue
+			}
+			tc = []string{timecode}
+			if title != "" {
+				tc = append(tc, title)
+			}
+		}
+
+		if untitled != 0 {
+			return nil, lineErrorf(untitled, "line %d: invalid format", untitled)
+		}
+
+		if len(tc) < 2 && allowUntitled {
+			// A bare timecode gets its title from the auto-title template
+			tc = append(tc, "")
+		} else if len(tc) < 2 && len(timecodes) == 0 {
+			untitled = line
+			tc = append(tc, "")
+		}
+
+		if len(tc) < 2 {
+			return nil, lineErrorf(line, "line %d: invalid format", line)
+		}
+
+		// A range "start-end", the dash isn't the first character. En and em
+		// dashes from word processors are dashes too
+		tc[0] = timecodeDashes.Replace(tc[0])
+		end := ""
+		if i := strings.Index(tc[0][1:], "-"); i >= 0 {
+			tc[0], end = tc[0][:i+1], tc[0][i+2:]
+		}
+
+		// Normalised so every track boundary reaches ffmpeg the same way
+		start, err := normalizeTimecode(tc[0])
+		if err != nil {
+			return nil, lineErrorf(line, "line %d: invalid timecode", line)
+		}
+		tc[0] = start
+		tc[1] = titleSeparator.ReplaceAllString(strings.TrimSpace(tc[1]), "")
+
+		title, directives, err := parseDirectives(tc[1])
+		alt := ""
+		if err != nil {
+			return nil, lineErrorf(line, "line %d: %v", line, err)
+		}
+		tc[1], alt = splitAltTitle(title, opts)
+
+		if end != "" {
+			end, err = normalizeTimecode(end)
+			if err != nil {
+				return nil, lineErrorf(line, "line %d: invalid timecode", line)
+			}
+		}
+
+		timecodes = append(timecodes, []string{tc[0], tc[1], end, alt})
+		lines = append(lines, line)
+		discs = append(discs, disc)
+		works = append(works, work)
+		composers = append(composers, composer)
+		lineDirectives = append(lineDirectives, directives)
+	}
+
+	if err := s.Err(); err != nil {
+		if err == bufio.ErrTooLong {
+			return nil, lineErrorf(line+1, "line %d is longer than %d bytes, see -max-line-bytes", line+1, opts.MaxLineBytes)
+		}
+		return nil, fmt.Errorf("cannot read timecodes file: %v", err)
+	}
+
+	// Gaps end the track before them like any other line, then drop out
+	var all Tracklist
+	for _, tc := range timecodes {
+		all = append(all, Track{Title: tc[1], Start: tc[0]})
+	}
+	all.SetEnds()
+
+	var tracks Tracklist
+	var directives []map[string]string
+	for i, t := range all {
+		// Skipped like a gap, the track before still ends here
+		if _, skip := lineDirectives[i]["skip"]; skip || isGapMarker(t.Title) {
+			continue
+		}
+
+		if timecodes[i][2] != "" {
+			t.End = timecodes[i][2]
+		}
+		setAltTitle(&t, timecodes[i][3], opts)
+
+		t.Number = len(tracks) + 1
+		t.Line = lines[i]
+		t.Disc = discs[i]
+		t.Work = works[i]
+		t.Composer = composers[i]
+		tracks = append(tracks, t)
+		directives = append(directives, lineDirectives[i])
+	}
+
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("no timecodes found")
+	}
+
+	// Tracks before the first disc marker are on disc 1
+	markers := multiDisc(tracks)
+	for i := range tracks {
+		tracks[i].Total = len(tracks)
+		if tracks[i].Disc == 0 && markers {
+			tracks[i].Disc = 1
+		}
+	}
+	numberDiscs(tracks)
+	applyHeader(tracks, header, opts)
+
+	if untitled != 0 {
+		if tracks[0].Album == "" {
+			return nil, lineErrorf(untitled, "line %d: invalid format, a single track without a title is named after the album, which isn't given", untitled)
+		}
+		tracks[0].Title = tracks[0].Album
+	}
+
+	if opts.VA {
+		for i := range tracks {
+			t := &tracks[i]
+			if t.AlbumArtist == "" {
+				t.AlbumArtist = variousArtists
+			}
+
+			if artist, title, ok := splitArtistTitle(t.Title); ok {
+				t.Artist, t.Title = artist, title
+			} else if t.Artist == "" {
+				t.Artist = t.AlbumArtist
+			}
+		}
+	}
+
+	// Over the tags from the options and titles
+	for i := range tracks {
+		applyDirectives(&tracks[i], directives[i])
+	}
+	numberMovements(tracks)
+
+	return tracks, nil
+}
+
+// WriteTimecodes writes the tracks as a timecodes file that ParseTimecodes
+// reads back, with an explicit end only where a track stops short of the
+// next one.
+func WriteTimecodes(w io.Writer, tracks Tracklist) error {
+	for i, t := range tracks {
+		start := t.Start
+		if t.End != "" && (i == len(tracks)-1 || tracks[i+1].Start != t.End) {
+			start += "-" + t.End
+		}
+
+		if _, err := fmt.Fprintf(w, "%v %v\n", start, t.Title); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeTimecode formats a timecode the way ffmpeg is given them, keeping
+// the "-" of one counted back from the end of the audio file.
+func normalizeTimecode(tc string) (string, error) {
+	sign := ""
+	if strings.HasPrefix(tc, "-") {
+		sign, tc = "-", tc[1:]
+	}
+
+	d, err := parseDuration(tc)
+	if err != nil {
+		return "", err
+	}
+	return sign + formatTimecode(d), nil
+}
+
+// resolveFromEnd turns the timecodes counted back from the end of the audio
+// file, such as "-00:04:30", into ones from its start.
+func resolveFromEnd(ctx context.Context, opts Options, tracks Tracklist) error {
+	fromEnd := false
+	for _, t := range tracks {
+		if strings.HasPrefix(t.Start, "-") || strings.HasPrefix(t.End, "-") {
+			fromEnd = true
+		}
+	}
+
+	if !fromEnd {
+		return nil
+	}
+
+	total, err := probeDuration(ctx, opts.Filename)
+	if err != nil {
+		return fmt.Errorf("timecodes from the end need the length of the audio file: %v", err)
+	}
+
+	resolve := func(tc string) (string, error) {
+		if !strings.HasPrefix(tc, "-") {
+			return tc, nil
+		}
+
+		d, err := parseDuration(tc[1:])
+		if err != nil {
+			return "", err
+		}
+
+		if d > total {
+			return "", fmt.Errorf("timecode %v is before the start of the audio file, which is %v long", tc, formatTimecode(total))
+		}
+		return formatTimecode(total - d), nil
+	}
+
+	for i := range tracks {
+		t := &tracks[i]
+		if t.Start, err = resolve(t.Start); err != nil {
+			return err
+		}
+		if t.End, err = resolve(t.End); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// gapMarkers are the titles that mark the start of a stretch that isn't a
+// track, such as applause or an intermission.
+var gapMarkers = []string{"[gap]", "[skip]", "--"}
+
+// isGapMarker reports whether a title marks the start of a stretch that
+// isn't a track.
+func isGapMarker(title string) bool {
+	for _, m := range gapMarkers {
+		if strings.EqualFold(title, m) {
+			return true
+		}
+	}
+	return false
+}
+
+// variousArtists is the album artist of compilations without one.
+const variousArtists = "Various Artists"
+
+// artistTitleSeparators split "Artist - Title" lines of a compilation.
+var artistTitleSeparators = []string{" - ", " – ", " — "}
+
+func splitArtistTitle(s string) (string, string, bool) {
+	for _, sep := range artistTitleSeparators {
+		if i := strings.Index(s, sep); i > 0 {
+			artist := strings.TrimSpace(s[:i])
+			title := strings.TrimSpace(s[i+len(sep):])
+			if artist != "" && title != "" {
+				return artist, title, true
+			}
+		}
+	}
+	return "", "", false
+}
@@ -0,0 +1,165 @@
+package avsplit
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// timelineEvent is a song change of a "now playing" timeline, as exported
+// by stream recorders and song recognition apps. When is a wall-clock time,
+// RFC 3339 or seconds or milliseconds since the epoch, unless the event
+// gives its Offset into the recording in seconds instead.
+type timelineEvent struct {
+	Time      json.RawMessage `json:"time"`
+	Timestamp json.RawMessage `json:"timestamp"`
+	Offset    *float64        `json:"offset"`
+	Title     string          `json:"title"`
+	Song      string          `json:"song"`
+	Artist    string          `json:"artist"`
+}
+
+// timeline is a timeline file, its events alone or with the time the
+// recording started.
+type timeline struct {
+	RecordingStart json.RawMessage `json:"recording_start"`
+	Events         []timelineEvent `json:"events"`
+}
+
+// parseTimelineTime parses a wall-clock time of a timeline, "" being none.
+func parseTimelineTime(raw json.RawMessage) (time.Time, error) {
+	s := strings.TrimSpace(string(raw))
+	if s == "" || s == "null" {
+		return time.Time{}, nil
+	}
+
+	if strings.HasPrefix(s, `"`) {
+		var v string
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return time.Time{}, err
+		}
+		t, err := time.Parse(time.RFC3339Nano, v)
+		if err != nil {
+			return time.Time{}, fmt.Errorf("invalid time %v, must be RFC 3339 or seconds since the epoch", v)
+		}
+		return t, nil
+	}
+
+	secs, err := strconv.ParseFloat(s, 64)
+	if err != nil || secs < 0 {
+		return time.Time{}, fmt.Errorf("invalid time %v, must be RFC 3339 or seconds since the epoch", s)
+	}
+	if secs > 1e11 {
+		// Milliseconds, seconds would be thousands of years from now
+		secs /= 1000
+	}
+	return time.Unix(0, int64(secs*float64(time.Second))).UTC(), nil
+}
+
+// parseTimeline reads a "now playing" timeline, a JSON list of song changes
+// or an object with them as "events" and optionally its "recording_start",
+// into the tracks. Each event starts a track at its offset from the start
+// of the recording: recording_start, or opts.RecordingStart, or without
+// either the first event. Consecutive events of the same song, as when a
+// recognition app heard it twice, are one track, and of the songs playing
+// before the recording started only the last is kept, from the start.
+func parseTimeline(data []byte, opts Options) (Tracklist, error) {
+	var tl timeline
+	data = bytes.TrimSpace(bytes.TrimPrefix(data, []byte("\ufeff")))
+	if bytes.HasPrefix(data, []byte("[")) {
+		if err := json.Unmarshal(data, &tl.Events); err != nil {
+			return nil, fmt.Errorf("invalid timeline json: %v", err)
+		}
+	} else if err := json.Unmarshal(data, &tl); err != nil {
+		return nil, fmt.Errorf("invalid timeline json: %v", err)
+	}
+
+	start, err := parseTimelineTime(tl.RecordingStart)
+	if err != nil {
+		return nil, fmt.Errorf("timeline: recording_start: %v", err)
+	}
+	if start.IsZero() {
+		start = opts.RecordingStart
+	}
+
+	var tracks Tracklist
+	var offsets []time.Duration
+	var last time.Duration
+	for i, e := range tl.Events {
+		title := strings.TrimSpace(e.Title)
+		if title == "" {
+			title = strings.TrimSpace(e.Song)
+		}
+		artist := strings.TrimSpace(e.Artist)
+		if title == "" {
+			return nil, fmt.Errorf("timeline: event %d has no title", i+1)
+		}
+
+		var offset time.Duration
+		if e.Offset != nil {
+			if *e.Offset < 0 {
+				return nil, fmt.Errorf("timeline: event %d is at a negative offset", i+1)
+			}
+			offset = time.Duration(*e.Offset * float64(time.Second))
+		} else {
+			raw := e.Time
+			if len(raw) == 0 {
+				raw = e.Timestamp
+			}
+			at, err := parseTimelineTime(raw)
+			if err != nil {
+				return nil, fmt.Errorf("timeline: event %d: %v", i+1, err)
+			}
+			if at.IsZero() {
+				return nil, fmt.Errorf("timeline: event %d has no time or offset", i+1)
+			}
+			if start.IsZero() {
+				start = at
+			}
+			offset = at.Sub(start)
+		}
+
+		if i > 0 && offset < last {
+			return nil, fmt.Errorf("timeline: event %d \"%v\" is before the one before it", i+1, title)
+		}
+		last = offset
+
+		if n := len(tracks); n > 0 && strings.EqualFold(tracks[n-1].Title, title) && strings.EqualFold(tracks[n-1].Artist, artist) {
+			// The same song again
+			continue
+		}
+
+		if offset < 0 {
+			// Playing when the recording started, unless a later song
+			// was as well
+			offset = 0
+		}
+		if n := len(tracks); n > 0 && offset == 0 && offsets[n-1] == 0 {
+			tracks, offsets = tracks[:n-1], offsets[:n-1]
+		}
+
+		tracks = append(tracks, Track{Title: title, Artist: artist})
+		offsets = append(offsets, offset)
+	}
+
+	if len(tracks) == 0 {
+		return nil, fmt.Errorf("no events found")
+	}
+
+	for i := range tracks {
+		t := &tracks[i]
+		t.Number = i + 1
+		t.Total = len(tracks)
+		t.Start = formatTimecode(offsets[i].Round(time.Millisecond))
+		if t.Artist == "" {
+			t.Artist = opts.Artist
+		}
+		t.AlbumArtist = opts.Artist
+		t.Album = opts.Album
+	}
+	tracks.SetEnds()
+	return tracks, nil
+}
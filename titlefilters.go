@@ -0,0 +1,152 @@
+package avsplit
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// timestampPattern matches a timecode, alone or in a pair of brackets.
+const timestampPattern = `(?:\d{1,3}(?::\d{1,2}){1,2}|\(\s*\d{1,3}(?::\d{1,2}){1,2}\s*\)|\[\s*\d{1,3}(?::\d{1,2}){1,2}\s*\])`
+
+var (
+	// "01. Title", "1 - Title", "#3 Title", "Track 03: Title", but not the
+	// number of "99 Luftballons"
+	titleNumberPrefix = regexp.MustCompile(`^(?:(?i:track)\s*\d{1,4}\s*[.):\-–—]?|#\d{1,4}\s*[.):\-–—]?|\d{1,4}\s*[.)\-–—])\s*`)
+
+	// "Title 03:45", "Title (3:45)", "Title - [1:02:03]"
+	titleTimestamp = regexp.MustCompile(`(?:\s*[-–—|~]?\s*` + timestampPattern + `)+\s*$`)
+
+	// "(Official Video)", "[Official Music Video]", "(Lyrics)", "(HD)"
+	titleVideoSuffix = regexp.MustCompile(`\s*[\[(](?i:(?:official\s+)?(?:music\s+|lyrics?\s+|hd\s+|4k\s+)?(?:video|audio|visuali[sz]er)|official|lyrics?|hd|hq|4k|audio only)[\])]\s*$`)
+)
+
+// titleFilters are the rules -title-filters chooses from to clean up pasted
+// titles, in the order they run.
+var titleFilters = []struct {
+	name  string
+	apply func(string) string
+}{
+	{"space", func(s string) string { return strings.Join(strings.Fields(s), " ") }},
+	{"number", func(s string) string {
+		if t := titleNumberPrefix.ReplaceAllString(s, ""); t != "" {
+			return t
+		}
+		return s
+	}},
+	{"timestamp", func(s string) string {
+		if t := titleTimestamp.ReplaceAllString(s, ""); t != "" {
+			return t
+		}
+		return s
+	}},
+	{"suffix", func(s string) string {
+		for {
+			t := titleVideoSuffix.ReplaceAllString(s, "")
+			if t == s || t == "" {
+				return s
+			}
+			s = t
+		}
+	}},
+	{"case", titleCase},
+}
+
+// parseTitleFilters returns the title filters a -title-filters list such
+// as "number,timestamp" enables. "all" enables every filter, and a name
+// starting with "-" disables one again, as in "all,-case".
+func parseTitleFilters(list string) (map[string]bool, error) {
+	known := make(map[string]bool)
+	var names []string
+	for _, f := range titleFilters {
+		known[f.name] = true
+		names = append(names, f.name)
+	}
+	sort.Strings(names)
+
+	enabled := make(map[string]bool)
+	for _, name := range strings.Split(list, ",") {
+		name = strings.ToLower(strings.TrimSpace(name))
+		on := !strings.HasPrefix(name, "-")
+		name = strings.TrimPrefix(name, "-")
+
+		switch {
+		case name == "":
+			continue
+		case name == "all":
+			for n := range known {
+				enabled[n] = on
+			}
+		case known[name]:
+			enabled[name] = on
+		default:
+			return nil, fmt.Errorf("unknown title filter %v, must be all or one of %v", name, strings.Join(names, ", "))
+		}
+	}
+	return enabled, nil
+}
+
+// filterTitles cleans up the titles of the tracks with the enabled filters.
+func filterTitles(tracks Tracklist, enabled map[string]bool) {
+	for i := range tracks {
+		title := tracks[i].Title
+		for _, f := range titleFilters {
+			if enabled[f.name] {
+				title = strings.TrimSpace(f.apply(title))
+			}
+		}
+		tracks[i].Title = title
+	}
+}
+
+// titleSmallWords stay lowercase in a title, unless they start or end it.
+var titleSmallWords = map[string]bool{
+	"a": true, "an": true, "and": true, "as": true, "at": true, "but": true,
+	"by": true, "for": true, "from": true, "in": true, "into": true,
+	"nor": true, "of": true, "on": true, "or": true, "the": true, "to": true,
+	"vs": true, "vs.": true, "with": true,
+}
+
+// titleCase capitalises the words of s, except small words such as "of" in
+// the middle of it. Words with capitals of their own, such as "AC/DC" or
+// "iPhone", are left alone unless the whole title is in capitals.
+func titleCase(s string) string {
+	shouting := strings.ToUpper(s) == s && strings.ToLower(s) != s
+	words := strings.Fields(s)
+	for i, w := range words {
+		if shouting {
+			w = strings.ToLower(w)
+		}
+		if w != strings.ToLower(w) {
+			continue
+		}
+
+		// A colon or bracket starts a new phrase, a small word there is
+		// capitalised too
+		first := i == 0 || strings.HasSuffix(words[i-1], ":") || strings.IndexAny(w[:1], "([\"'") == 0
+		last := i == len(words)-1
+		if titleSmallWords[w] && !first && !last {
+			words[i] = w
+			continue
+		}
+		words[i] = capitalize(w)
+	}
+	return strings.Join(words, " ")
+}
+
+// capitalize upper-cases the first letter of w, after any leading brackets
+// or quotes.
+func capitalize(w string) string {
+	for i, r := range w {
+		if unicode.IsLetter(r) {
+			return w[:i] + string(unicode.ToUpper(r)) + w[i+utf8.RuneLen(r):]
+		}
+		if unicode.IsDigit(r) {
+			break
+		}
+	}
+	return w
+}
@@ -0,0 +1,68 @@
+package avsplit
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// ffmpegFailure turns a failure recognised in what ffmpeg or ffprobe wrote to
+// stderr into a message saying what went wrong and how to fix it. The
+// submatches of the pattern fill in the message.
+type ffmpegFailure struct {
+	pattern *regexp.Regexp
+	message string
+}
+
+// ffmpegFailures are tried in order, the first match wins.
+var ffmpegFailures = []ffmpegFailure{
+	{regexp.MustCompile(`(?m)^(.*): No such file or directory$`), "%v not found, check the path"},
+	{regexp.MustCompile(`(?m)^(.*): Permission denied$`), "permission denied for %v, check it can be read and the output directory written"},
+	{regexp.MustCompile(`No space left on device`), "the disk is full, free some space or choose another -output-dir"},
+	{regexp.MustCompile(`Unknown encoder '([^']+)'`), "ffmpeg has no %v encoder, choose another with -codec or install an ffmpeg built with it"},
+	{regexp.MustCompile(`Encoder \(codec (\S+)\) not found`), "ffmpeg has no encoder for %v, choose another -format or -codec"},
+	{regexp.MustCompile(`(?i)codec (\S+) is not supported in|could not find tag for codec (\S+)|(\S+) codec not currently supported in container`), "%v audio cannot be copied into this format, choose another -format or re-encode with -encode"},
+	{regexp.MustCompile(`-to value smaller than -ss|Output file is empty, nothing was encoded`), "nothing to extract, check the track's timecodes are within the audio file"},
+	{regexp.MustCompile(`Invalid duration specification for \w+: (\S+)`), "invalid timecode %v"},
+	{regexp.MustCompile(`Invalid data found when processing input`), "the audio file is damaged or not a format ffmpeg reads"},
+	{regexp.MustCompile(`Server returned (\d{3}[^\n]*)`), "cannot fetch the audio file: %v, check the URL"},
+	{regexp.MustCompile(`Unrecognized option '([^']+)'`), "ffmpeg does not know the option -%v, it may be too old"},
+}
+
+// toolError returns the error for tool c failing with stderr. ffmpeg and
+// ffprobe failures are explained where they are recognised, otherwise the
+// last line of stderr is kept with a pointer to the rest.
+func toolError(c, stderr string) error {
+	stderr = strings.TrimSpace(stderr)
+	if c != "ffmpeg" && c != "ffprobe" {
+		return errors.New(stderr)
+	}
+
+	for _, f := range ffmpegFailures {
+		m := f.pattern.FindStringSubmatch(stderr)
+		if m == nil {
+			continue
+		}
+
+		if !strings.Contains(f.message, "%v") {
+			return errors.New(f.message)
+		}
+
+		arg := ""
+		for _, s := range m[1:] {
+			if s != "" {
+				arg = s
+				break
+			}
+		}
+		return fmt.Errorf(f.message, arg)
+	}
+
+	lines := strings.Split(stderr, "\n")
+	last := strings.TrimSpace(lines[len(lines)-1])
+	if len(lines) == 1 {
+		return fmt.Errorf("%v: %v", c, last)
+	}
+	return fmt.Errorf("%v: %v (see -verbose for the rest of its output)", c, last)
+}
@@ -0,0 +1,97 @@
+package avsplit
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// minToolVersion is the oldest ffmpeg and ffprobe release with every filter
+// and option used.
+var minToolVersion = [2]int{4, 0}
+
+// toolVersion matches the release in the first line of -version output,
+// such as "ffmpeg version 6.1.1" or "ffmpeg version n4.4". Builds from git
+// have no release number and are taken to be new enough.
+var toolVersion = regexp.MustCompile(`^\S+ version n?(\d+)\.(\d+)`)
+
+// toolPath returns the path to run the tool c at, as set in the options
+// carried by ctx.
+func toolPath(ctx context.Context, c string) string {
+	o, ok := ctx.Value(optionsKey{}).(Options)
+	if !ok {
+		return c
+	}
+
+	switch c {
+	case "ffmpeg":
+		return o.FFmpegPath
+	case "ffprobe":
+		return o.FFprobePath
+	}
+	return c
+}
+
+// checkTool checks that the tool at p runs and is recent enough.
+func checkTool(ctx context.Context, name, flag, p string) error {
+	var out bytes.Buffer
+	err := runCommand(ctx, p, []string{"-version"}, &out, nil)
+	if errors.Is(err, exec.ErrNotFound) || errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("%v not found at %v, install it or set -%v", name, p, flag)
+	}
+	if err != nil {
+		return fmt.Errorf("%v at %v does not run: %v", name, p, err)
+	}
+
+	m := toolVersion.FindStringSubmatch(out.String())
+	if m == nil {
+		return nil
+	}
+
+	major, _ := strconv.Atoi(m[1])
+	minor, _ := strconv.Atoi(m[2])
+	if major < minToolVersion[0] || (major == minToolVersion[0] && minor < minToolVersion[1]) {
+		return fmt.Errorf(
+			"%v at %v is version %v.%v, %v.%v or later is needed",
+			name, p, major, minor, minToolVersion[0], minToolVersion[1],
+		)
+	}
+	return nil
+}
+
+// checkTools checks up front that ffmpeg and ffprobe, and yt-dlp when it is
+// needed, can run, listing everything missing at once rather than failing
+// part way through a split.
+func checkTools(ctx context.Context, opts Options) error {
+	var problems []string
+	if err := checkTool(ctx, "ffmpeg", "ffmpeg-path", opts.FFmpegPath); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if err := checkTool(ctx, "ffprobe", "ffprobe-path", opts.FFprobePath); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if _, err := exec.LookPath("yt-dlp"); opts.FromURL != "" && err != nil {
+		problems = append(problems, "yt-dlp not found, install it to use -from-url")
+	}
+	if _, err := exec.LookPath("nice"); opts.Nice != 0 && err != nil {
+		problems = append(problems, "nice not found, install it to use -nice")
+	}
+	if _, err := exec.LookPath("ionice"); opts.IONice != "" && err != nil {
+		problems = append(problems, "ionice not found, install util-linux to use -ionice")
+	}
+
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("missing required tools:\n  %v", strings.Join(problems, "\n  "))
+	}
+	return nil
+}
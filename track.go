@@ -0,0 +1,504 @@
+package avsplit
+
+import (
+	"fmt"
+	"math"
+	"net/url"
+	"path"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// formatTimecode formats d as HH:MM:SS, with milliseconds when d isn't a
+// whole number of seconds. d is rounded to the nearest millisecond, so the
+// CD frames of a CUE sheet, 1/75 of a second each, land on the closest one.
+func formatTimecode(d time.Duration) string {
+	ms := d.Round(time.Millisecond).Milliseconds()
+	s := ms / 1000
+	v := fmt.Sprintf("%02d:%02d:%02d", s/3600, s/60%60, s%60)
+	if ms%1000 != 0 {
+		v += fmt.Sprintf(".%03d", ms%1000)
+	}
+	return v
+}
+
+var (
+	timecodeField   = regexp.MustCompile(`^\d+$`)
+	timecodeSeconds = regexp.MustCompile(`^\d+([.,]\d+)?$`)
+)
+
+// parseDuration parses a timecode of the form [[HH:]MM:]SS[.fff], such as
+// "1:02:03.450", "02:03" or "45.5". The decimal separator may be a comma,
+// as in "1:23:45,5". Each field must be plain digits, so what ParseFloat
+// would also take, such as "inf", "NaN" or "0x1p4", is not a timecode.
+func parseDuration(t string) (time.Duration, error) {
+	parts := strings.Split(strings.Trim(t, " "), ":")
+	if len(parts) > 3 || !timecodeSeconds.MatchString(parts[len(parts)-1]) {
+		return 0, fmt.Errorf("invalid timecode %v", t)
+	}
+	for _, p := range parts[:len(parts)-1] {
+		if !timecodeField.MatchString(p) {
+			return 0, fmt.Errorf("invalid timecode %v", t)
+		}
+	}
+
+	secs, err := strconv.ParseFloat(decimalPoint(parts[len(parts)-1]), 64)
+	if err != nil || secs >= math.MaxInt64/float64(time.Second) {
+		return 0, fmt.Errorf("invalid timecode %v", t)
+	}
+
+	if len(parts) > 1 && secs >= 60 {
+		return 0, fmt.Errorf("invalid timecode %v", t)
+	}
+
+	d := time.Duration(secs * float64(time.Second)).Round(time.Millisecond)
+
+	units := []time.Duration{time.Minute, time.Hour}
+	for i, p := range parts[:len(parts)-1] {
+		unit := units[len(parts)-2-i]
+
+		n, err := strconv.ParseInt(p, 10, 64)
+		if err != nil || n > int64((math.MaxInt64-d)/unit) {
+			return 0, fmt.Errorf("invalid timecode %v", t)
+		}
+
+		// Minutes may run past 59 in MM:SS form
+		if unit == time.Minute && len(parts) == 3 && n > 59 {
+			return 0, fmt.Errorf("invalid timecode %v", t)
+		}
+
+		d += time.Duration(n) * unit
+	}
+
+	return d, nil
+}
+
+// decimalPoint returns the seconds s with a decimal comma, as spreadsheets
+// and editors in much of Europe export them, made a decimal point.
+func decimalPoint(s string) string {
+	i := strings.IndexByte(s, ',')
+	if i <= 0 || i == len(s)-1 || strings.Count(s, ",") > 1 || strings.Contains(s, ".") {
+		return s
+	}
+	return s[:i] + "." + s[i+1:]
+}
+
+// duration returns the length of the track. The last track runs to the end
+// of the source, whose length is given by total.
+func (t *Track) duration(total time.Duration) (time.Duration, error) {
+	start, err := parseDuration(t.Start)
+	if err != nil {
+		return 0, err
+	}
+
+	end := total
+	if t.End != "" {
+		end, err = parseDuration(t.End)
+		if err != nil {
+			return 0, err
+		}
+	}
+
+	return end - start, nil
+}
+
+// setLengths sets the Length of each track from its start and end, total
+// being the length of the source and 0 when unknown. Tracks played at a
+// tempo other than 1 are as much shorter or longer.
+func setLengths(tracks []Track, total time.Duration, tempo float64) {
+	if tempo == 0 {
+		tempo = 1
+	}
+
+	for i := range tracks {
+		t := &tracks[i]
+		t.Length = 0
+		if t.End == "" && total <= 0 {
+			continue
+		}
+		if d, err := t.duration(total); err == nil && d > 0 {
+			t.Length = time.Duration(float64(d) / tempo).Round(time.Millisecond)
+		}
+	}
+}
+
+func isURL(s string) bool {
+	return strings.HasPrefix(s, "http://") || strings.HasPrefix(s, "https://")
+}
+
+// sourceExt returns the extension of the audio file, ignoring any query
+// string when the source is a URL.
+func sourceExt(audioFile string) string {
+	if isURL(audioFile) {
+		if u, err := url.Parse(audioFile); err == nil {
+			return path.Ext(u.Path)
+		}
+	}
+	return filepath.Ext(audioFile)
+}
+
+// ext returns the extension of the output file, which is the source's unless
+// an output format has been chosen.
+func (t *Track) ext(audioFile string) string {
+	if t.Ext != "" {
+		return t.Ext
+	}
+	return sourceExt(audioFile)
+}
+
+func (t *Track) outputFilename(audioFile string) string {
+	if t.Output != "" {
+		return t.Output
+	}
+
+	width := t.PadWidth
+	if width == 0 {
+		// As many digits as the total has, at least two
+		width = len(strconv.Itoa(t.Total))
+		if width < 2 {
+			width = 2
+		}
+	}
+	padFmt := "%0" + strconv.Itoa(width) + "d - %v%v"
+
+	if t.MultiDisc {
+		// Numbers restart on each disc, "1-01" and "2-01" keep them apart
+		padFmt = strconv.Itoa(t.Disc) + "-" + padFmt
+	}
+
+	v := fmt.Sprintf(
+		padFmt,
+		t.Number,
+		t.fileTitle(),
+		t.ext(audioFile),
+	)
+	return filepath.Join(t.dir(), sanitizeName(v, t.ASCII))
+}
+
+// fileTitle returns the title the track's output file is named after.
+func (t *Track) fileTitle() string {
+	if t.FileTitle != "" {
+		return t.FileTitle
+	}
+	return t.Title
+}
+
+// artists returns the artists of the track one by one for the multi-valued
+// ARTISTS tag, or nil when there is one or Artist has since been changed to
+// credit others.
+func (t *Track) artists() []string {
+	if len(t.Artists) < 2 {
+		return nil
+	}
+	for _, a := range t.Artists {
+		if !strings.Contains(t.Artist, a) {
+			return nil
+		}
+	}
+	return t.Artists
+}
+
+// dir returns the directory of the track's output file, AlbumArtist/Album
+// unless the track has its own Dir.
+func (t *Track) dir() string {
+	if t.Dir != "" {
+		return t.Dir
+	}
+	return filepath.Join(sanitizeName(t.AlbumArtist, t.ASCII), sanitizeName(t.Album, t.ASCII))
+}
+
+// ffmpegArgs returns the ffmpeg arguments that extract the track from
+// audioFile into its Output, overwrite being the Options.overwriteFlag.
+func (t *Track) ffmpegArgs(audioFile, overwrite string) []string {
+	args := []string{
+		"-nostdin",
+		overwrite,
+		"-loglevel",
+		"error",
+	}
+
+	switch {
+	case t.cutsOnSamples():
+		// Decoded from the start, audioFilter trims it to its samples
+	case t.End == "":
+		// We're on the last track so read to EOF
+		args = append(args, []string{
+			"-ss", t.Start}...)
+	default:
+		// Read from start to end
+		args = append(args, []string{
+			"-ss", t.Start, "-to", t.End}...)
+	}
+
+	args = append(args, t.InputArgs...)
+	args = append(args, []string{
+		"-i",
+		argPath(audioFile),
+	}...)
+
+	if t.Stream != "" {
+		if t.Video {
+			args = append(args, "-map", "0:v:0?")
+		}
+		args = append(args, "-map", "0:"+t.Stream)
+	}
+
+	if !t.Video {
+		args = append(args, "-vn")
+	}
+
+	if !t.Reencode {
+		args = append(args, "-c", "copy")
+	} else if t.Video {
+		// Only the audio is re-encoded
+		args = append(args, "-c:v", "copy")
+	}
+
+	if t.Codec != "" {
+		args = append(args, "-c:a", t.Codec)
+	}
+
+	if t.Bitrate != "" {
+		args = append(args, "-b:a", t.Bitrate)
+	}
+
+	if t.Quality != "" {
+		args = append(args, "-q:a", t.Quality)
+	}
+
+	if filter := t.audioFilter(); filter != "" {
+		args = append(args, "-af", filter)
+	}
+
+	if t.Metadata {
+		args = append(args, t.metadataArgs(t.ext(audioFile))...)
+	}
+
+	if t.Bitexact {
+		// No encoder version, creation time or random stream IDs
+		args = append(args, "-fflags", "+bitexact", "-flags:a", "+bitexact")
+		if t.Video {
+			args = append(args, "-flags:v", "+bitexact")
+		}
+	}
+
+	switch t.ext(audioFile) {
+	case ".wav":
+		// RF64 past the 4 GiB a WAV header can give the length of
+		args = append(args, "-rf64", "auto")
+	case ".aif", ".aiff":
+		if t.Metadata {
+			// The tags go in an ID3 chunk, AIFF has none of its own
+			args = append(args, "-write_id3v2", "1")
+		}
+	}
+
+	// Last, so they can override any of the above
+	args = append(args, t.ExtraArgs...)
+
+	if t.Output == "-" {
+		return append(args, pipeArgs(t.ext(audioFile))...)
+	}
+	return append(args, argPath(t.outputFilename(audioFile)))
+}
+
+// audioFilter returns the ffmpeg filter for the track's audio, its Filter
+// followed by any fades. Neither fade is longer than half the track.
+func (t *Track) audioFilter() string {
+	var filters []string
+	if t.cutsOnSamples() {
+		trim := fmt.Sprintf("atrim=start_sample=%d", t.StartSample)
+		if t.EndSample > 0 {
+			trim += fmt.Sprintf(":end_sample=%d", t.EndSample)
+		}
+		filters = append(filters, trim, "asetpts=PTS-STARTPTS")
+	}
+
+	if t.Filter != "" {
+		filters = append(filters, t.Filter)
+	}
+
+	length, err := t.duration(0)
+	if err != nil || t.End == "" {
+		// Without an end the track's length isn't known, so nothing caps
+		// its fade in or places its fade out
+		length = 0
+	}
+
+	fade := func(d time.Duration) time.Duration {
+		if length > 0 && d > length/2 {
+			return length / 2
+		}
+		return d
+	}
+
+	if t.FadeIn > 0 {
+		filters = append(filters, fmt.Sprintf("afade=t=in:st=0:d=%.3f", fade(t.FadeIn).Seconds()))
+	}
+
+	if t.FadeOut > 0 && length > 0 {
+		d := fade(t.FadeOut)
+		filters = append(filters, fmt.Sprintf("afade=t=out:st=%.3f:d=%.3f", (length-d).Seconds(), d.Seconds()))
+	}
+
+	// Last, the fades are placed in the file's own time
+	if t.Speed != "" {
+		filters = append(filters, t.Speed)
+	}
+
+	return strings.Join(filters, ",")
+}
+
+// metadataArgs returns the ffmpeg arguments that tag the track as it is
+// extracted, in place of the source's own tags, named for the tag family
+// of the output format ext as in ffmpegTagNames. Vorbis comments keep the
+// track and disc totals in fields of their own, ID3 and MP4 after a slash.
+func (t *Track) metadataArgs(ext string) []string {
+	family := tagFamily(ext)
+
+	meta := [][2]string{
+		{"title", t.Title},
+		{"artist", t.Artist},
+		{"album_artist", t.AlbumArtist},
+		{"album", t.Album},
+	}
+
+	if family == "vorbis" {
+		meta = append(meta,
+			[2]string{"track", strconv.Itoa(t.Number)},
+			[2]string{"tracktotal", strconv.Itoa(t.Total)},
+		)
+	} else {
+		meta = append(meta, [2]string{"track", fmt.Sprintf("%d/%d", t.Number, t.Total)})
+	}
+
+	meta = append(meta, [][2]string{
+		{"composer", t.Composer},
+		{"work", t.Work},
+		{"date", t.Year},
+		{"genre", t.Genre},
+		{"comment", t.Comment},
+		{"lyrics", t.Lyrics},
+		{"publisher", t.Label},
+		{"catalognumber", t.CatalogNumber},
+		{"isrc", t.ISRC},
+	}...)
+
+	if t.Length > 0 {
+		meta = append(meta, [2]string{"length", strconv.FormatInt(t.Length.Milliseconds(), 10)})
+	}
+
+	if family == "vorbis" {
+		if t.Disc != 0 {
+			meta = append(meta, [2]string{"disc", strconv.Itoa(t.Disc)})
+		}
+		if t.DiscTotal != 0 {
+			meta = append(meta, [2]string{"disctotal", strconv.Itoa(t.DiscTotal)})
+		}
+	} else if t.Disc != 0 {
+		disc := strconv.Itoa(t.Disc)
+		if t.DiscTotal != 0 {
+			disc += "/" + strconv.Itoa(t.DiscTotal)
+		}
+		meta = append(meta, [2]string{"disc", disc})
+	}
+
+	if t.Work != "" {
+		meta = append(meta, [2]string{"movementname", t.Title})
+		if family == "vorbis" {
+			meta = append(meta,
+				[2]string{"movement", strconv.Itoa(t.Movement)},
+				[2]string{"movementtotal", strconv.Itoa(t.Movements)},
+			)
+		} else {
+			meta = append(meta, [2]string{"movement", fmt.Sprintf("%d/%d", t.Movement, t.Movements)})
+		}
+	}
+
+	names := ffmpegTagNames[family]
+	args := []string{"-map_metadata", "-1"}
+	for _, m := range meta {
+		name := m[0]
+		if names != nil {
+			name = names[name]
+		}
+		if name != "" && m[1] != "" {
+			args = append(args, "-metadata", name+"="+m[1])
+		}
+	}
+	return args
+}
+
+// eyeD3Colons escapes the colons eyed3 splits comment and image arguments on,
+// as in a title or a Windows drive letter.
+var eyeD3Colons = strings.NewReplacer(":", "\\:")
+
+// eyeD3Args returns the eyed3 arguments to tag the track's output file. The
+// values are passed to eyed3 as they are, without a shell in between, so
+// they are never quoted.
+func (t *Track) eyeD3Args(file string) []string {
+	args := []string{
+		"--artist=" + t.Artist,
+		"--album-artist=" + t.AlbumArtist,
+		"--album=" + t.Album,
+		"--title=" + t.Title,
+		fmt.Sprintf("--track=%v", t.Number),
+		fmt.Sprintf("--track-total=%v", t.Total),
+		// eyed3 otherwise picks Latin-1 where it can
+		"--encoding=utf8",
+	}
+
+	if t.Composer != "" {
+		args = append(args, "--composer="+t.Composer)
+	}
+
+	if t.Work != "" {
+		// TIT1 holds the work, as iTunes reads it
+		args = append(args, "--text-frame=TIT1:"+eyeD3Colons.Replace(t.Work))
+	}
+
+	if t.Year != "" {
+		args = append(args, "--release-year="+t.Year)
+	}
+
+	if t.Genre != "" {
+		args = append(args, "--genre="+t.Genre)
+	}
+
+	if t.Disc != 0 {
+		args = append(args, fmt.Sprintf("--disc-num=%v", t.Disc))
+	}
+
+	if t.DiscTotal != 0 {
+		args = append(args, fmt.Sprintf("--disc-total=%v", t.DiscTotal))
+	}
+
+	if t.Comment != "" {
+		args = append(args, "--comment="+eyeD3Colons.Replace(t.Comment))
+	}
+
+	if t.Label != "" {
+		args = append(args, "--publisher="+t.Label)
+	}
+
+	if t.CatalogNumber != "" {
+		args = append(args, "--user-text-frame=CATALOGNUMBER:"+eyeD3Colons.Replace(t.CatalogNumber))
+	}
+
+	if t.ISRC != "" {
+		args = append(args, "--text-frame=TSRC:"+eyeD3Colons.Replace(t.ISRC))
+	}
+
+	if t.Length > 0 {
+		args = append(args, fmt.Sprintf("--text-frame=TLEN:%d", t.Length.Milliseconds()))
+	}
+
+	if t.Cover != "" {
+		args = append(args, "--add-image="+eyeD3Colons.Replace(t.Cover)+":FRONT_COVER")
+	}
+
+	return append(args, argPath(file))
+}
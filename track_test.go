@@ -0,0 +1,271 @@
+package avsplit
+
+import (
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEyeD3ArgsRaw(t *testing.T) {
+	tr := Track{
+		Number:      2,
+		Total:       10,
+		Title:       `Say "Hello"`,
+		Artist:      "Guns N' Roses",
+		AlbumArtist: "Guns N' Roses",
+		Album:       "Live: Era",
+		Comment:     "Part 1: Intro",
+		Cover:       `C:\Music\cover.jpg`,
+	}
+
+	got := tr.eyeD3Args(filepath.Join("out", "02 - Say 'Hello'.mp3"))
+	want := []string{
+		"--artist=Guns N' Roses",
+		"--album-artist=Guns N' Roses",
+		"--album=Live: Era",
+		`--title=Say "Hello"`,
+		"--track=2",
+		"--track-total=10",
+		"--encoding=utf8",
+		`--comment=Part 1\: Intro`,
+		`--add-image=C\:\Music\cover.jpg:FRONT_COVER`,
+		filepath.Join("out", "02 - Say 'Hello'.mp3"),
+	}
+
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("eyeD3Args() =\n%q\nwant\n%q", got, want)
+	}
+}
+
+func TestOutputFilenameSeparators(t *testing.T) {
+	tests := []struct {
+		name  string
+		track Track
+		want  string
+	}{
+		{
+			"slash in title",
+			Track{Number: 1, Total: 2, Title: "AC/DC - T.N.T.", AlbumArtist: "A", Album: "B", Ext: ".mp3"},
+			filepath.Join("A", "B", "01 - AC-DC - T.N.T..mp3"),
+		},
+		{
+			"backslash in title",
+			Track{Number: 1, Total: 2, Title: `AC\DC`, AlbumArtist: "A", Album: "B", Ext: ".mp3"},
+			filepath.Join("A", "B", "01 - AC-DC.mp3"),
+		},
+		{
+			"separators in album",
+			Track{Number: 3, Total: 3, Title: "C", AlbumArtist: "A/B", Album: `1\2`, Ext: ".flac"},
+			filepath.Join("A-B", "1-2", "03 - C.flac"),
+		},
+		{
+			"over 999 tracks",
+			Track{Number: 7, Total: 1200, Title: "C", AlbumArtist: "A", Album: "B", Ext: ".mp3"},
+			filepath.Join("A", "B", "0007 - C.mp3"),
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.track.outputFilename("in.mp3"); got != tt.want {
+				t.Errorf("outputFilename() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestFFmpegArgs(t *testing.T) {
+	head := []string{"-nostdin", "-y", "-loglevel", "error"}
+	tests := []struct {
+		name  string
+		track Track
+		want  []string
+	}{
+		{
+			"stream copy",
+			Track{Number: 1, Total: 2, Title: "A", Start: "00:00:00", End: "00:03:10", AlbumArtist: "X", Album: "Y", Ext: ".mp3"},
+			[]string{"-ss", "00:00:00", "-to", "00:03:10", "-i", "in.mp3", "-vn", "-c", "copy", filepath.Join("X", "Y", "01 - A.mp3")},
+		},
+		{
+			"last track reads to the end",
+			Track{Number: 2, Total: 2, Title: "B", Start: "00:03:10", AlbumArtist: "X", Album: "Y", Ext: ".mp3"},
+			[]string{"-ss", "00:03:10", "-i", "in.mp3", "-vn", "-c", "copy", filepath.Join("X", "Y", "02 - B.mp3")},
+		},
+		{
+			"re-encode",
+			Track{Number: 1, Total: 1, Title: "A", Start: "00:00:00", AlbumArtist: "X", Album: "Y", Ext: ".opus", Reencode: true, Codec: "libopus", Bitrate: "128k"},
+			[]string{"-ss", "00:00:00", "-i", "in.mp3", "-vn", "-c:a", "libopus", "-b:a", "128k", filepath.Join("X", "Y", "01 - A.opus")},
+		},
+		{
+			"video keeps its stream",
+			Track{Number: 1, Total: 1, Title: "A", Start: "00:00:00", AlbumArtist: "X", Album: "Y", Ext: ".mkv", Video: true, Reencode: true, Quality: "4"},
+			[]string{"-ss", "00:00:00", "-i", "in.mp3", "-c:v", "copy", "-q:a", "4", filepath.Join("X", "Y", "01 - A.mkv")},
+		},
+		{
+			"filter and fades",
+			Track{
+				Number: 2, Total: 3, Title: "B", Start: "00:01:00", End: "00:02:00", AlbumArtist: "X", Album: "Y", Ext: ".flac",
+				Reencode: true, Filter: "loudnorm", FadeIn: 2 * time.Second, FadeOut: 40 * time.Second,
+			},
+			[]string{
+				"-ss", "00:01:00", "-to", "00:02:00", "-i", "in.mp3", "-vn",
+				"-af", "loudnorm,afade=t=in:st=0:d=2.000,afade=t=out:st=30.000:d=30.000",
+				filepath.Join("X", "Y", "02 - B.flac"),
+			},
+		},
+		{
+			"metadata",
+			Track{Number: 1, Total: 1, Title: "A", Start: "00:00:00", Artist: "X", AlbumArtist: "X", Album: "Y", Disc: 2, Ext: ".mp3", Metadata: true},
+			[]string{
+				"-ss", "00:00:00", "-i", "in.mp3", "-vn", "-c", "copy", "-map_metadata", "-1",
+				"-metadata", "title=A", "-metadata", "artist=X", "-metadata", "album_artist=X", "-metadata", "album=Y",
+				"-metadata", "track=1/1", "-metadata", "disc=2",
+				filepath.Join("X", "Y", "01 - A.mp3"),
+			},
+		},
+		{
+			"vorbis comment metadata",
+			Track{Number: 1, Total: 2, Title: "A", Start: "00:00:00", Artist: "X", AlbumArtist: "X", Album: "Y", Disc: 1, DiscTotal: 2, Label: "Z", Ext: ".flac", Reencode: true, Metadata: true},
+			[]string{
+				"-ss", "00:00:00", "-i", "in.mp3", "-vn", "-map_metadata", "-1",
+				"-metadata", "TITLE=A", "-metadata", "ARTIST=X", "-metadata", "ALBUMARTIST=X", "-metadata", "ALBUM=Y",
+				"-metadata", "TRACKNUMBER=1", "-metadata", "TRACKTOTAL=2", "-metadata", "LABEL=Z",
+				"-metadata", "DISCNUMBER=1", "-metadata", "DISCTOTAL=2",
+				filepath.Join("X", "Y", "01 - A.flac"),
+			},
+		},
+		{
+			"stdout",
+			Track{Number: 1, Total: 1, Title: "A", Start: "00:00:00", Ext: ".m4a", Output: "-"},
+			[]string{"-ss", "00:00:00", "-i", "in.mp3", "-vn", "-c", "copy", "-f", "ipod", "-movflags", "frag_keyframe+empty_moov", "pipe:1"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			want := append(append([]string{}, head...), tt.want...)
+			if got := tt.track.ffmpegArgs("in.mp3", "-y"); !reflect.DeepEqual(got, want) {
+				t.Errorf("ffmpegArgs() =\n%q\nwant\n%q", got, want)
+			}
+		})
+	}
+}
+
+func TestArgPath(t *testing.T) {
+	dot := "." + string(filepath.Separator)
+	tests := []struct {
+		path, want string
+	}{
+		{"in.mp3", "in.mp3"},
+		{"-intro-.mp3", dot + "-intro-.mp3"},
+		{"--help", dot + "--help"},
+		{"-", dot + "-"},
+		{filepath.Join("-X", "Y", "01 - A.mp3"), dot + filepath.Join("-X", "Y", "01 - A.mp3")},
+		{filepath.Join("out", "-A.mp3"), filepath.Join("out", "-A.mp3")},
+		{"https://example.com/-live.mp3", "https://example.com/-live.mp3"},
+	}
+
+	for _, tt := range tests {
+		if got := argPath(tt.path); got != tt.want {
+			t.Errorf("argPath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"45.5", 45500 * time.Millisecond},
+		{"02:03", 123 * time.Second},
+		{"1:02:03.450", time.Hour + 2*time.Minute + 3450*time.Millisecond},
+		{"1:23:45,5", time.Hour + 23*time.Minute + 45500*time.Millisecond},
+		{"90:00", 90 * time.Minute},
+	}
+	for _, tt := range tests {
+		got, err := parseDuration(tt.in)
+		if err != nil || got != tt.want {
+			t.Errorf("parseDuration(%q) = %v, %v, want %v", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{
+		"NaN", "nan", "inf", "Infinity", "-inf", "1_0", "0x1p4", "1e3", "+5", "-5",
+		"1:-5", "1:+5", ".5", "5.", "1:60", "1:60:00", "1:2:3:4", "Infinity and beyond",
+		"99999999999999999999", "9999999999999:00:00",
+	} {
+		if got, err := parseDuration(in); err == nil {
+			t.Errorf("parseDuration(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestParseSeconds(t *testing.T) {
+	for in, want := range map[string]time.Duration{
+		"600.000000\n": 600 * time.Second,
+		"4000.5":       4000500 * time.Millisecond,
+		"-0.023000":    -23 * time.Millisecond,
+	} {
+		if got, err := parseSeconds(in); err != nil || got != want {
+			t.Errorf("parseSeconds(%q) = %v, %v, want %v", in, got, err, want)
+		}
+	}
+
+	for _, in := range []string{"N/A", "NaN", "inf", "Infinity", "1_0", "0x1p4", "1e3", "1e300", ""} {
+		if got, err := parseSeconds(in); err == nil {
+			t.Errorf("parseSeconds(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestDashedTitleArgs(t *testing.T) {
+	dot := "." + string(filepath.Separator)
+	tr := Track{
+		Number:      1,
+		Total:       1,
+		Title:       "-intro-",
+		Start:       "00:00:00",
+		Artist:      "-y",
+		AlbumArtist: "--help",
+		Album:       "-",
+		Comment:     "--version",
+		Ext:         ".mp3",
+		Metadata:    true,
+	}
+	out := dot + filepath.Join("--help", "-", "01 - -intro-.mp3")
+
+	wantFFmpeg := []string{
+		"-nostdin", "-y", "-loglevel", "error",
+		"-ss", "00:00:00", "-i", dot + "-live.mp3", "-vn", "-c", "copy", "-map_metadata", "-1",
+		"-metadata", "title=-intro-", "-metadata", "artist=-y", "-metadata", "album_artist=--help", "-metadata", "album=-",
+		"-metadata", "track=1/1", "-metadata", "comment=--version",
+		out,
+	}
+	if got := tr.ffmpegArgs("-live.mp3", "-y"); !reflect.DeepEqual(got, wantFFmpeg) {
+		t.Errorf("ffmpegArgs() =\n%q\nwant\n%q", got, wantFFmpeg)
+	}
+
+	wantEyeD3 := []string{
+		"--artist=-y",
+		"--album-artist=--help",
+		"--album=-",
+		"--title=-intro-",
+		"--track=1",
+		"--track-total=1",
+		"--encoding=utf8",
+		"--comment=--version",
+		out,
+	}
+	if got := tr.eyeD3Args(tr.outputFilename("-live.mp3")); !reflect.DeepEqual(got, wantEyeD3) {
+		t.Errorf("eyeD3Args() =\n%q\nwant\n%q", got, wantEyeD3)
+	}
+
+	// The output template can put a title at the start of the path
+	tr.Output = "-intro-.mp3"
+	args := tr.ffmpegArgs("in.mp3", "-y")
+	if got := args[len(args)-1]; got != dot+"-intro-.mp3" {
+		t.Errorf("ffmpegArgs() output = %q, want %q", got, dot+"-intro-.mp3")
+	}
+}
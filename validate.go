@@ -0,0 +1,111 @@
+package avsplit
+
+import (
+	"fmt"
+	"time"
+)
+
+// validateTracks rejects tracklists whose tracks are out of order, overlap or
+// start past the end of the source, whose length is total or 0 if unknown.
+// A track running past the end of the source is only a warning, ffmpeg stops
+// at the end.
+func validateTracks(tracks []Track, total time.Duration) ([]string, error) {
+	starts := make([]time.Duration, len(tracks))
+	for i, t := range tracks {
+		start, err := parseDuration(t.Start)
+		if err != nil {
+			return nil, err
+		}
+		starts[i] = start
+
+		// Checked first, out of order lines also make the previous track
+		// end before it starts
+		if i > 0 && start <= starts[i-1] {
+			return nil, lineErrorf(
+				t.Line, "track %d \"%v\" starts at %v, not after track %d at %v",
+				t.Number, t.Title, t.Start, tracks[i-1].Number, tracks[i-1].Start,
+			)
+		}
+	}
+
+	var warnings []string
+	for i, t := range tracks {
+		start := starts[i]
+
+		if i > 0 {
+			prev := tracks[i-1]
+			if prev.End != "" {
+				prevEnd, err := parseDuration(prev.End)
+				if err != nil {
+					return nil, err
+				}
+
+				if start < prevEnd {
+					return nil, lineErrorf(
+						t.Line, "track %d \"%v\" starts at %v, before track %d ends at %v",
+						t.Number, t.Title, t.Start, prev.Number, prev.End,
+					)
+				}
+			}
+		}
+
+		if total > 0 && start >= total {
+			return nil, lineErrorf(
+				t.Line, "track %d \"%v\" starts at %v, after the end of the audio file at %v",
+				t.Number, t.Title, t.Start, formatTimecode(total),
+			)
+		}
+
+		if t.End == "" {
+			continue
+		}
+
+		end, err := parseDuration(t.End)
+		if err != nil {
+			return nil, err
+		}
+
+		if end <= start {
+			return nil, lineErrorf(
+				t.Line, "track %d \"%v\" ends at %v, before it starts at %v",
+				t.Number, t.Title, t.End, t.Start,
+			)
+		}
+
+		if total > 0 && end > total {
+			warnings = append(warnings, fmt.Sprintf(
+				"track %d \"%v\" ends at %v, after the end of the audio file at %v",
+				t.Number, t.Title, t.End, formatTimecode(total),
+			))
+		}
+	}
+
+	return warnings, nil
+}
+
+// checkMinLength returns an error for the first track shorter than min, so
+// a mistyped timecode doesn't split off a track of a few seconds. The last
+// track is only checked when the length of the source, total, is known.
+func checkMinLength(tracks []Track, total, min time.Duration) error {
+	if min <= 0 {
+		return nil
+	}
+
+	for _, t := range tracks {
+		if t.End == "" && total <= 0 {
+			continue
+		}
+
+		d, err := t.duration(total)
+		if err != nil {
+			return err
+		}
+		if d < min {
+			return lineErrorf(
+				t.Line, "track %d \"%v\" is only %v long, shorter than the min-track-length of %v",
+				t.Number, t.Title, d, min,
+			)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,155 @@
+package avsplit
+
+import (
+	"bytes"
+	"context"
+	"crypto/md5"
+	"crypto/sha256"
+	"fmt"
+	"hash"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// lengthTolerance is how far the length of an extracted track may be from
+// the expected length. Stream copies cut on frame boundaries so they rarely
+// match exactly.
+const lengthTolerance = time.Second
+
+// checkLength returns an error unless file is as long as the track should
+// be, within lengthTolerance.
+func checkLength(ctx context.Context, opts Options, t Track, file string) error {
+	got, err := probeDuration(ctx, file)
+	if err != nil {
+		return err
+	}
+
+	var total time.Duration
+	if t.End == "" {
+		total, err = probeDuration(ctx, opts.Filename)
+		if err != nil {
+			return err
+		}
+	}
+
+	want, err := t.duration(total)
+	if err != nil {
+		return err
+	}
+
+	diff := got - want
+	if diff < 0 {
+		diff = -diff
+	}
+
+	if diff > lengthTolerance {
+		return fmt.Errorf("%v is %v long, expected %v", file, got, want)
+	}
+	return nil
+}
+
+// checkDecodes decodes the whole file and returns an error if ffmpeg reports
+// any problem with it.
+func checkDecodes(ctx context.Context, file string) error {
+	var stderr bytes.Buffer
+	err := runCommand(ctx, "ffmpeg", []string{"-nostdin", "-v", "error", "-i", argPath(file), "-f", "null", "-"}, nil, &stderr)
+	debugStderr(ctx, "ffmpeg", stderr)
+	if ctx.Err() != nil {
+		return ctx.Err()
+	}
+
+	if err != nil || stderr.Len() > 0 {
+		return fmt.Errorf("%v does not decode cleanly: %v", file, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+// checksumFiles are the manifests written for each checksum, in the format
+// of sha256sum and md5sum.
+var checksumFiles = map[string]string{
+	"md5":    "MD5SUMS",
+	"sha256": "SHA256SUMS",
+}
+
+func newChecksum(name string) hash.Hash {
+	if name == "md5" {
+		return md5.New()
+	}
+	return sha256.New()
+}
+
+func fileChecksum(name, file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	h := newChecksum(name)
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", h.Sum(nil)), nil
+}
+
+// verifyTracks checks that each extracted track decodes and has the expected
+// length, then writes a checksum manifest into each output directory.
+func verifyTracks(ctx context.Context, opts Options, tracks []Track) error {
+	if _, ok := checksumFiles[opts.Checksum]; !ok {
+		return fmt.Errorf("unknown checksum %v, must be md5 or sha256", opts.Checksum)
+	}
+
+	var problems []string
+	sums := make(map[string][]string)
+	for _, t := range tracks {
+		out := t.outputFilename(opts.Filename)
+		opts.logf("verifying track \"%v\"\n", out)
+
+		if err := checkDecodes(ctx, out); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			problems = append(problems, err.Error())
+			continue
+		}
+
+		if err := checkLength(ctx, opts, t, out); err != nil {
+			if ctx.Err() != nil {
+				return err
+			}
+			problems = append(problems, err.Error())
+			continue
+		}
+
+		sum, err := fileChecksum(opts.Checksum, out)
+		if err != nil {
+			return err
+		}
+
+		dir := filepath.Dir(out)
+		sums[dir] = append(sums[dir], fmt.Sprintf("%v  %v\n", sum, filepath.Base(out)))
+	}
+
+	dirs := make([]string, 0, len(sums))
+	for dir := range sums {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+
+	for _, dir := range dirs {
+		manifest := filepath.Join(dir, checksumFiles[opts.Checksum])
+		if err := opts.writeFile(manifest, []byte(strings.Join(sums[dir], ""))); err != nil {
+			return fmt.Errorf("cannot write checksum manifest: %v", err)
+		}
+	}
+
+	if len(problems) > 0 {
+		return fmt.Errorf("%d tracks failed verification:\n%v", len(problems), strings.Join(problems, "\n"))
+	}
+
+	return nil
+}
@@ -0,0 +1,75 @@
+package avsplit
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"time"
+)
+
+// vttText escapes a title as the text of a cue: on one line, as a blank
+// one would end the cue, and with "&", "<" and ">" as entities so neither
+// markup nor a "-->" is read into it.
+var vttText = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+func vttTimestamp(d time.Duration) string {
+	ms := d.Milliseconds()
+	return fmt.Sprintf(
+		"%02d:%02d:%02d.%03d",
+		ms/3600000,
+		ms/60000%60,
+		ms/1000%60,
+		ms%1000,
+	)
+}
+
+func writeVTT(filename string, tracks []Track, duration time.Duration) error {
+	f, err := os.Create(filename)
+	if err != nil {
+		return fmt.Errorf("cannot create vtt file")
+	}
+	defer f.Close()
+
+	if err := printVTT(f, tracks, duration); err != nil {
+		return err
+	}
+	return f.Close()
+}
+
+// printVTT writes the tracks to w as writeVTT does, as cues of a file
+// duration long.
+func printVTT(w io.Writer, tracks []Track, duration time.Duration) error {
+	if _, err := fmt.Fprint(w, "WEBVTT\n"); err != nil {
+		return err
+	}
+
+	for _, t := range tracks {
+		start, err := parseDuration(t.Start)
+		if err != nil {
+			return err
+		}
+
+		// The last track runs to the end of the source
+		end := duration
+		if t.End != "" {
+			end, err = parseDuration(t.End)
+			if err != nil {
+				return err
+			}
+		}
+
+		_, err = fmt.Fprintf(
+			w,
+			"\n%d\n%v --> %v\n%v\n",
+			t.Number,
+			vttTimestamp(start),
+			vttTimestamp(end),
+			vttText.Replace(strings.Join(strings.Fields(t.Title), " ")),
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,215 @@
+package avsplit
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// watchStateName is the state file Watch keeps in the watched directory by
+// default.
+const watchStateName = ".avsplit-watch.json"
+
+// watchAudioExts are the extensions of the files Watch splits.
+var watchAudioExts = map[string]bool{
+	".aac":  true,
+	".aiff": true,
+	".flac": true,
+	".m4a":  true,
+	".m4b":  true,
+	".mka":  true,
+	".mkv":  true,
+	".mp3":  true,
+	".mp4":  true,
+	".ogg":  true,
+	".opus": true,
+	".wav":  true,
+	".webm": true,
+	".wv":   true,
+}
+
+// watchedFile is what Watch remembers of an audio file, so it is split once
+// and again only when it changes.
+type watchedFile struct {
+	Size    int64     `json:"size"`
+	ModTime time.Time `json:"mtime"`
+	Split   time.Time `json:"split"`
+	Error   string    `json:"error,omitempty"`
+}
+
+type watchState struct {
+	Files map[string]watchedFile `json:"files"`
+}
+
+func readWatchState(path string) (watchState, error) {
+	state := watchState{Files: make(map[string]watchedFile)}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return state, nil
+	}
+	if err != nil {
+		return state, fmt.Errorf("cannot read watch state: %v", err)
+	}
+
+	if err := json.Unmarshal(b, &state); err != nil {
+		return state, fmt.Errorf("invalid watch state %v: %v", path, err)
+	}
+	if state.Files == nil {
+		state.Files = make(map[string]watchedFile)
+	}
+	return state, nil
+}
+
+func (s watchState) write(path string) error {
+	b, err := json.MarshalIndent(s, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	// Renamed into place so a crash never leaves half a state file
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, append(b, '\n'), 0644); err != nil {
+		return fmt.Errorf("cannot write watch state: %v", err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// watchTracklist returns the options to split audio with the tracklist next
+// to it, "show.txt" or "show.cue" for "show.mp3", or its embedded cuesheet
+// or chapters. It reports false when there is none, yet.
+func watchTracklist(ctx context.Context, opts Options, audio string) (Options, bool) {
+	base := strings.TrimSuffix(audio, filepath.Ext(audio))
+	opts.Filename, opts.Filenames = audio, nil
+
+	if _, err := os.Stat(base + ".cue"); err == nil {
+		opts.Cue = base + ".cue"
+		return opts, true
+	}
+
+	if _, err := os.Stat(base + ".txt"); err == nil {
+		opts.Timecodes = base + ".txt"
+		return opts, true
+	}
+
+	if strings.EqualFold(filepath.Ext(audio), ".flac") && hasFLACCueSheet(audio) {
+		opts.FLACCue = true
+		return opts, true
+	}
+
+	if chapters, err := probeChapters(ctx, audio); err == nil && len(chapters) > 0 {
+		opts.FromChapters = true
+		return opts, true
+	}
+	return opts, false
+}
+
+// Watch splits the audio files that appear in dir, each with its timecodes
+// or cue sheet of the same name or its chapters, until ctx is done. A file
+// is split once it has stopped growing, and opts.WatchState records the
+// files split so they aren't split again, even after a restart. The album
+// defaults to the file's name, for recordings of the same show.
+func Watch(ctx context.Context, dir string, opts Options) error {
+	opts = opts.withDefaults()
+	ctx = opts.withOptions(ctx)
+	if err := checkTools(ctx, opts); err != nil {
+		return err
+	}
+
+	if fi, err := os.Stat(dir); err != nil || !fi.IsDir() {
+		return fmt.Errorf("cannot watch %v, not a directory", dir)
+	}
+
+	statePath := opts.WatchState
+	if statePath == "" {
+		statePath = filepath.Join(dir, watchStateName)
+	}
+
+	state, err := readWatchState(statePath)
+	if err != nil {
+		return err
+	}
+
+	interval := opts.WatchInterval
+	if interval <= 0 {
+		interval = 30 * time.Second
+	}
+
+	opts.logf("watching %v every %v\n", dir, interval)
+
+	// Sizes seen on the last scan, a file still being written is left
+	// until the next one
+	seen := make(map[string]int64)
+	for {
+		entries, err := os.ReadDir(dir)
+		if err != nil {
+			return fmt.Errorf("cannot watch %v: %v", dir, err)
+		}
+
+		names := make([]string, 0, len(entries))
+		for _, e := range entries {
+			if !e.IsDir() && watchAudioExts[strings.ToLower(filepath.Ext(e.Name()))] {
+				names = append(names, e.Name())
+			}
+		}
+		sort.Strings(names)
+
+		for _, name := range names {
+			if ctx.Err() != nil {
+				return nil
+			}
+
+			fi, err := os.Stat(filepath.Join(dir, name))
+			if err != nil {
+				continue
+			}
+
+			done, ok := state.Files[name]
+			if ok && done.Size == fi.Size() && done.ModTime.Equal(fi.ModTime()) {
+				continue
+			}
+
+			size, ok := seen[name]
+			seen[name] = fi.Size()
+			if !ok || size != fi.Size() {
+				continue
+			}
+
+			fileOpts, ok := watchTracklist(ctx, opts, filepath.Join(dir, name))
+			if !ok {
+				continue
+			}
+
+			if fileOpts.Album == "" {
+				fileOpts.Album = strings.TrimSuffix(name, filepath.Ext(name))
+			}
+
+			opts.logf("splitting %v\n", name)
+			entry := watchedFile{Size: fi.Size(), ModTime: fi.ModTime(), Split: time.Now().UTC()}
+			if err := Split(ctx, fileOpts); err != nil {
+				if ctx.Err() != nil {
+					return nil
+				}
+
+				// Recorded too, it is tried again once the file changes
+				opts.logf("error: %v: %v\n", name, err)
+				entry.Error = err.Error()
+			}
+
+			state.Files[name] = entry
+			if err := state.write(statePath); err != nil {
+				return err
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-time.After(interval):
+		}
+	}
+}
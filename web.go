@@ -0,0 +1,210 @@
+package avsplit
+
+import (
+	"bytes"
+	"context"
+	_ "embed"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// webPage is the page Web serves, a form to drop an audio file on, paste a
+// tracklist into, check and retitle the tracks and follow the split.
+//
+//go:embed web.html
+var webPage []byte
+
+// webTrack is a track of the tracklist POST /preview returns.
+type webTrack struct {
+	Number int    `json:"number"`
+	Disc   int    `json:"disc,omitempty"`
+	Start  string `json:"start"`
+	End    string `json:"end,omitempty"`
+	Title  string `json:"title"`
+	Artist string `json:"artist,omitempty"`
+}
+
+// webServer adds the page and what it uses to the API of Serve.
+type webServer struct {
+	jobs *jobServer
+}
+
+// Web runs a web page on addr for splitting a file from a browser, until
+// ctx is done. It serves the API of Serve, and:
+//
+//	GET  /                  the page
+//	POST /preview           parse a tracklist as a job would, returning its tracks
+//	GET  /jobs/{id}/events  a WebSocket sending the job each time it changes
+//
+// The page uploads the audio file with the tracklist as a job, with the
+// titles edited in it, and follows the job's progress until its tracks can
+// be downloaded.
+func Web(ctx context.Context, addr string, opts Options) error {
+	opts = opts.withDefaults()
+	s, err := newJobServer(ctx, opts)
+	if err != nil {
+		return err
+	}
+	return listenAndServe(ctx, opts, addr, &webServer{jobs: s})
+}
+
+func (s *webServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	parts := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	switch {
+	case r.URL.Path == "/" && r.Method == http.MethodGet:
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		w.Write(webPage)
+	case r.URL.Path == "/preview" && r.Method == http.MethodPost:
+		s.preview(w, r)
+	case len(parts) == 3 && parts[0] == "jobs" && parts[2] == "events" && r.Method == http.MethodGet:
+		s.events(w, r, parts[1])
+	case r.URL.Path == "/" || r.URL.Path == "/preview" || (len(parts) == 3 && parts[0] == "jobs" && parts[2] == "events"):
+		httpError(w, http.StatusMethodNotAllowed, "method not allowed")
+	default:
+		s.jobs.ServeHTTP(w, r)
+	}
+}
+
+// preview parses the tracklist of the request, a job request without its
+// audio file, and returns its tracks with any warnings.
+func (s *webServer) preview(w http.ResponseWriter, r *http.Request) {
+	if !hasContentType(r, "application/json") {
+		httpError(w, http.StatusUnsupportedMediaType, "a preview is requested as application/json")
+		return
+	}
+
+	var req jobRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 32<<20)).Decode(&req); err != nil {
+		httpError(w, http.StatusBadRequest, fmt.Sprintf("invalid request: %v", err))
+		return
+	}
+	if req.Tracklist == "" {
+		httpError(w, http.StatusBadRequest, "tracklist is required")
+		return
+	}
+	if req.Source == "" {
+		// Only named for the output files, nothing reads it
+		req.Source = "audio"
+	}
+
+	dir, err := os.MkdirTemp("", "avsplit-web-")
+	if err != nil {
+		httpError(w, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	opts, err := s.jobs.jobOptions(req, dir)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	var log bytes.Buffer
+	opts.JSON, opts.Progress, opts.Quiet, opts.Log = false, false, false, &log
+	tracks, err := ReadTracklist(r.Context(), opts)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	warnings, err := validateTracks(tracks, 0)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	for _, l := range strings.Split(log.String(), "\n") {
+		if strings.HasPrefix(l, "warning: ") {
+			warnings = append(warnings, strings.TrimPrefix(l, "warning: "))
+		}
+	}
+
+	res := struct {
+		Tracks   []webTrack `json:"tracks"`
+		Warnings []string   `json:"warnings,omitempty"`
+	}{Warnings: warnings}
+	for _, t := range tracks {
+		res.Tracks = append(res.Tracks, webTrack{
+			Number: t.Number,
+			Disc:   t.Disc,
+			Start:  t.Start,
+			End:    t.End,
+			Title:  t.Title,
+			Artist: t.Artist,
+		})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// events sends the job id over a WebSocket, again each time it changes,
+// until it is finished or the page goes away.
+func (s *webServer) events(w http.ResponseWriter, r *http.Request, id string) {
+	s.jobs.mu.Lock()
+	_, ok := s.jobs.jobs[id]
+	s.jobs.mu.Unlock()
+	if !ok {
+		httpError(w, http.StatusNotFound, "no job "+id)
+		return
+	}
+
+	c, err := upgradeWebsocket(w, r)
+	if err != nil {
+		httpError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	defer c.close()
+
+	t := time.NewTicker(250 * time.Millisecond)
+	defer t.Stop()
+
+	var last []byte
+	for {
+		s.jobs.mu.Lock()
+		j := s.jobs.jobs[id]
+		b, err := json.Marshal(j)
+		finished := j.finished()
+		s.jobs.mu.Unlock()
+		if err != nil {
+			return
+		}
+
+		if !bytes.Equal(b, last) {
+			if err := c.writeText(b); err != nil {
+				return
+			}
+			last = b
+		}
+		if finished {
+			return
+		}
+
+		select {
+		case <-c.closed:
+			return
+		case <-s.jobs.ctx.Done():
+			return
+		case <-t.C:
+		}
+	}
+}
+
+// retitle returns a Review that gives the tracks the titles, leaving those
+// with an empty one as they are.
+func retitle(titles []string) func(Tracklist) (Tracklist, error) {
+	return func(tracks Tracklist) (Tracklist, error) {
+		if len(titles) != len(tracks) {
+			return nil, withKind(ErrInvalidInput, fmt.Errorf("%d titles given, the tracklist has %d tracks", len(titles), len(tracks)))
+		}
+
+		for i, title := range titles {
+			if title = strings.TrimSpace(title); title != "" {
+				tracks[i].Title = title
+			}
+		}
+		return tracks, nil
+	}
+}
@@ -0,0 +1,133 @@
+package avsplit
+
+import (
+	"bufio"
+	"crypto/sha1"
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strings"
+)
+
+// websocketGUID is what RFC 6455 appends to the client's key to accept it.
+const websocketGUID = "258EAFA5-E914-47DA-95CA-C5AB0DC85B11"
+
+// websocketConn is the server end of a WebSocket that only sends text
+// messages, enough to stream a job's progress to a browser. What the
+// browser sends is read and dropped, up to its close.
+type websocketConn struct {
+	conn   net.Conn
+	rw     *bufio.ReadWriter
+	closed chan struct{}
+}
+
+// upgradeWebsocket takes over the connection of r, a WebSocket handshake.
+// Browsers open WebSockets to any site, so one opened from a page of
+// another origin is refused.
+func upgradeWebsocket(w http.ResponseWriter, r *http.Request) (*websocketConn, error) {
+	if err := sameOrigin(r); err != nil {
+		return nil, err
+	}
+	if !strings.EqualFold(r.Header.Get("Upgrade"), "websocket") || !strings.Contains(strings.ToLower(r.Header.Get("Connection")), "upgrade") {
+		return nil, fmt.Errorf("websocket upgrade required")
+	}
+
+	key := r.Header.Get("Sec-WebSocket-Key")
+	if key == "" {
+		return nil, fmt.Errorf("websocket key missing")
+	}
+
+	h, ok := w.(http.Hijacker)
+	if !ok {
+		return nil, fmt.Errorf("websocket not supported")
+	}
+
+	conn, rw, err := h.Hijack()
+	if err != nil {
+		return nil, err
+	}
+
+	sum := sha1.Sum([]byte(key + websocketGUID))
+	fmt.Fprintf(rw, "HTTP/1.1 101 Switching Protocols\r\nUpgrade: websocket\r\nConnection: Upgrade\r\nSec-WebSocket-Accept: %v\r\n\r\n", base64.StdEncoding.EncodeToString(sum[:]))
+	if err := rw.Flush(); err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	c := &websocketConn{conn: conn, rw: rw, closed: make(chan struct{})}
+	go c.read()
+	return c, nil
+}
+
+// read drops the frames the browser sends until it closes the WebSocket or
+// the connection.
+func (c *websocketConn) read() {
+	defer close(c.closed)
+
+	for {
+		var head [2]byte
+		if _, err := io.ReadFull(c.rw, head[:]); err != nil {
+			return
+		}
+		if head[0]&0x0f == 0x8 {
+			return
+		}
+
+		n := uint64(head[1] & 0x7f)
+		switch n {
+		case 126:
+			var b [2]byte
+			if _, err := io.ReadFull(c.rw, b[:]); err != nil {
+				return
+			}
+			n = uint64(binary.BigEndian.Uint16(b[:]))
+		case 127:
+			var b [8]byte
+			if _, err := io.ReadFull(c.rw, b[:]); err != nil {
+				return
+			}
+			n = binary.BigEndian.Uint64(b[:])
+		}
+		if head[1]&0x80 != 0 {
+			// The mask key
+			n += 4
+		}
+
+		if _, err := io.CopyN(io.Discard, c.rw, int64(n)); err != nil {
+			return
+		}
+	}
+}
+
+// writeText sends b as a text message.
+func (c *websocketConn) writeText(b []byte) error {
+	head := []byte{0x81}
+	switch n := len(b); {
+	case n < 126:
+		head = append(head, byte(n))
+	case n <= 0xffff:
+		head = append(head, 126, byte(n>>8), byte(n))
+	default:
+		head = append(head, 127)
+		head = append(head, make([]byte, 8)...)
+		binary.BigEndian.PutUint64(head[2:], uint64(n))
+	}
+
+	if _, err := c.rw.Write(head); err != nil {
+		return err
+	}
+	if _, err := c.rw.Write(b); err != nil {
+		return err
+	}
+	return c.rw.Flush()
+}
+
+// close sends a close frame and closes the connection.
+func (c *websocketConn) close() error {
+	c.rw.Write([]byte{0x88, 0})
+	c.rw.Flush()
+	return c.conn.Close()
+}
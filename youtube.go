@@ -0,0 +1,39 @@
+package avsplit
+
+import (
+	"regexp"
+	"strings"
+)
+
+// youtubeTimecode matches a timecode of a YouTube description, optionally in
+// brackets and followed by the end of a "start - end" range.
+const youtubeTimecode = `[\[(]?(\d{1,3}(?::\d{1,2}){1,2})[\])]?(?:\s*[-–—~]\s*[\[(]?\d{1,3}(?::\d{1,2}){1,2}[\])]?)?`
+
+var (
+	// "1) 03:45 - Title", "• [12:34] Title", "00:00 Title"
+	youtubeLeading = regexp.MustCompile(`^(?:[-*•·–—>]+\s*)?(?:#?\d{1,4}[.)]\s+)?` + youtubeTimecode + `(?:\s+|$)(.*)$`)
+
+	// "Title 03:45", "Title - (03:45)"
+	youtubeTrailing = regexp.MustCompile(`^(?:[-*•·–—>]+\s*)?(?:#?\d{1,4}[.)]\s+)?(.*?)\s+` + youtubeTimecode + `$`)
+)
+
+// youtubeTitleTrim is stripped from both ends of a title, left over from the
+// separators between it and its timecode.
+const youtubeTitleTrim = " \t-–—:|~"
+
+// parseYouTubeLine finds the timecode and title of a line of a YouTube
+// description. Lines without a timecode, such as the rest of the description,
+// return false.
+func parseYouTubeLine(line string) (string, string, bool) {
+	line = strings.TrimSpace(line)
+
+	if m := youtubeLeading.FindStringSubmatch(line); m != nil {
+		return m[1], strings.Trim(m[2], youtubeTitleTrim), true
+	}
+
+	if m := youtubeTrailing.FindStringSubmatch(line); m != nil {
+		return m[2], strings.Trim(m[1], youtubeTitleTrim), true
+	}
+
+	return "", "", false
+}
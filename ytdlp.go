@@ -0,0 +1,158 @@
+package avsplit
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// ytDlpInfo is the part of yt-dlp's info JSON used for the tracks and tags.
+type ytDlpInfo struct {
+	Title       string `json:"title"`
+	Uploader    string `json:"uploader"`
+	Channel     string `json:"channel"`
+	Artist      string `json:"artist"`
+	Album       string `json:"album"`
+	UploadDate  string `json:"upload_date"`
+	ReleaseYear int    `json:"release_year"`
+	Description string `json:"description"`
+	Chapters    []struct {
+		StartTime float64 `json:"start_time"`
+		EndTime   float64 `json:"end_time"`
+		Title     string  `json:"title"`
+	} `json:"chapters"`
+}
+
+// ytDlpBase returns the path in the cache directory, without an
+// extension, that the audio and info JSON of opts.FromURL are written to.
+func ytDlpBase(opts Options) (string, error) {
+	dir, err := cacheDir(opts)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256([]byte(opts.FromURL))
+	return filepath.Join(dir, "yt-dlp-"+hex.EncodeToString(sum[:8])), nil
+}
+
+// fetchYtDlp downloads the best audio of opts.FromURL with yt-dlp along with
+// its info JSON, and returns the audio file. yt-dlp skips the download when
+// an earlier run already made it.
+func fetchYtDlp(ctx context.Context, opts Options) (string, error) {
+	base, err := ytDlpBase(opts)
+	if err != nil {
+		return "", err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(base), 0700); err != nil {
+		return "", err
+	}
+
+	opts.logf("fetching %v with yt-dlp\n", opts.FromURL)
+	out, err := commandOutput(
+		ctx,
+		"yt-dlp",
+		"--no-playlist",
+		"--no-progress",
+		"-f", "bestaudio/best",
+		"--write-info-json",
+		"-o", base+".%(ext)s",
+		"--print", "after_move:filepath",
+		"--no-simulate",
+		// The URL can't be taken for an option
+		"--",
+		opts.FromURL,
+	)
+	if err != nil {
+		return "", fmt.Errorf("yt-dlp: %v", strings.TrimSpace(err.Error()))
+	}
+
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	audioFile := strings.TrimSpace(lines[len(lines)-1])
+	if audioFile == "" {
+		return "", fmt.Errorf("yt-dlp did not report the downloaded file")
+	}
+	return audioFile, nil
+}
+
+func readYtDlpInfo(opts Options) (*ytDlpInfo, error) {
+	base, err := ytDlpBase(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	data, err := os.ReadFile(base + ".info.json")
+	if err != nil {
+		return nil, fmt.Errorf("cannot read the yt-dlp info of %v", opts.FromURL)
+	}
+
+	var info ytDlpInfo
+	if err := json.Unmarshal(data, &info); err != nil {
+		return nil, fmt.Errorf("invalid yt-dlp info of %v: %v", opts.FromURL, err)
+	}
+	return &info, nil
+}
+
+// ytDlpTags fills in the artist, album and year the options leave empty
+// from the video, preferring its music tags to its uploader and title.
+func ytDlpTags(info *ytDlpInfo, opts *Options) {
+	if opts.Artist == "" {
+		for _, a := range []string{info.Artist, info.Uploader, info.Channel} {
+			if a != "" {
+				opts.Artist = a
+				break
+			}
+		}
+	}
+
+	if opts.Album == "" {
+		opts.Album = info.Album
+		if opts.Album == "" {
+			opts.Album = info.Title
+		}
+	}
+
+	if opts.Year == "" {
+		if info.ReleaseYear != 0 {
+			opts.Year = strconv.Itoa(info.ReleaseYear)
+		} else if len(info.UploadDate) >= 4 {
+			opts.Year = info.UploadDate[:4]
+		}
+	}
+}
+
+// ytDlpTracks reads the tracks of the video fetched from opts.FromURL from
+// its chapters or, without any, the tracklist in its description.
+func ytDlpTracks(opts Options) (Tracklist, error) {
+	info, err := readYtDlpInfo(opts)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(info.Chapters) > 0 {
+		opts.logf("using the %d chapters of the video as the tracks\n", len(info.Chapters))
+
+		chapters := make([]probedChapter, len(info.Chapters))
+		for i, c := range info.Chapters {
+			chapters[i].StartTime = strconv.FormatFloat(c.StartTime, 'f', -1, 64)
+			chapters[i].EndTime = strconv.FormatFloat(c.EndTime, 'f', -1, 64)
+			chapters[i].Tags.Title = c.Title
+		}
+		return chaptersToTracks(chapters, opts)
+	}
+
+	opts.YouTube = true
+	tracks, err := ParseTimecodes(strings.NewReader(info.Description), opts)
+	if err != nil {
+		return nil, fmt.Errorf("no chapters or tracklist in the video's description, give the tracks with -timecodes")
+	}
+
+	opts.logf("using the tracklist in the video's description as the tracks\n")
+	return tracks, nil
+}